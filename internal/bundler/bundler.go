@@ -1828,7 +1828,11 @@ func applyOptionDefaults(options *config.Options) {
 
 type OnBundleCompile = func(options *config.Options, log logger.Log, fs fs.FS, files []graph.InputFile, entryPoints []graph.EntryPoint)
 
-func (b *Bundle) Compile(log logger.Log, options config.Options, onCompile OnBundleCompile) ([]graph.OutputFile, string) {
+// OnBundleLink is called after tree shaking, once for each group of entry points that are linked
+// together. The files and parts that will be in the output are marked with IsLive.
+type OnBundleLink = func(log logger.Log, files []graph.LinkerFile, entryPoints []graph.EntryPoint)
+
+func (b *Bundle) Compile(log logger.Log, options config.Options, onCompile OnBundleCompile, onLink OnBundleLink) ([]graph.OutputFile, string) {
 	start := time.Now()
 	if log.Debug {
 		log.AddDebug(nil, logger.Loc{}, "Started the compile phase")
@@ -1859,7 +1863,7 @@ func (b *Bundle) Compile(log logger.Log, options config.Options, onCompile OnBun
 	var resultGroups [][]graph.OutputFile
 	if options.CodeSplitting {
 		// If code splitting is enabled, link all entry points together
-		c := newLinkerContext(&options, log, b.fs, b.res, files, b.entryPoints, allReachableFiles, dataForSourceMaps)
+		c := newLinkerContext(&options, log, b.fs, b.res, files, b.entryPoints, allReachableFiles, dataForSourceMaps, onLink)
 		resultGroups = [][]graph.OutputFile{c.link()}
 	} else {
 		// Otherwise, link each entry point with the runtime file separately
@@ -1870,7 +1874,7 @@ func (b *Bundle) Compile(log logger.Log, options config.Options, onCompile OnBun
 			go func(i int, entryPoint graph.EntryPoint) {
 				entryPoints := []graph.EntryPoint{entryPoint}
 				reachableFiles := findReachableFiles(files, entryPoints)
-				c := newLinkerContext(&options, log, b.fs, b.res, files, entryPoints, reachableFiles, dataForSourceMaps)
+				c := newLinkerContext(&options, log, b.fs, b.res, files, entryPoints, reachableFiles, dataForSourceMaps, onLink)
 				resultGroups[i] = c.link()
 				waitGroup.Done()
 			}(i, entryPoint)
@@ -49,6 +49,9 @@ type linkerContext struct {
 	// is shared between threads and must be treated as immutable.
 	dataForSourceMaps func() []dataForSourceMap
 
+	// This is called with the graph after tree shaking, see OnBundleLink
+	onLink OnBundleLink
+
 	// The unique key prefix is a random string that is unique to every linking
 	// operation. It is used as a prefix for the unique keys assigned to every
 	// chunk. These unique keys are used to identify each chunk before the final
@@ -179,6 +182,7 @@ func newLinkerContext(
 	entryPoints []graph.EntryPoint,
 	reachableFiles []uint32,
 	dataForSourceMaps func() []dataForSourceMap,
+	onLink OnBundleLink,
 ) linkerContext {
 	log = wrappedLog(log)
 
@@ -188,6 +192,7 @@ func newLinkerContext(
 		fs:                fs,
 		res:               res,
 		dataForSourceMaps: dataForSourceMaps,
+		onLink:            onLink,
 		graph: graph.MakeLinkerGraph(
 			inputFiles,
 			reachableFiles,
@@ -255,6 +260,13 @@ func (c *linkerContext) link() []graph.OutputFile {
 
 	c.treeShakingAndCodeSplitting()
 
+	if c.onLink != nil {
+		c.onLink(c.log, c.graph.Files, c.graph.EntryPoints())
+		if c.log.HasErrors() {
+			return []graph.OutputFile{}
+		}
+	}
+
 	if c.options.Mode == config.ModePassThrough {
 		for _, entryPoint := range c.graph.EntryPoints() {
 			c.preventExportsFromBeingRenamed(entryPoint.SourceIndex)
@@ -307,6 +307,11 @@ type Arg struct {
 
 	// "constructor(public x: boolean) {}"
 	IsTypeScriptCtorField bool
+
+	// The range of the TypeScript type annotation "x: boolean", if there is one.
+	// The type itself is skipped by the parser, this is only used to find it in
+	// the source.
+	TSTypeRange logger.Range
 }
 
 type Fn struct {
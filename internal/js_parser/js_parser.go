@@ -2248,9 +2248,11 @@ func (p *parser) parseParenExpr(loc logger.Loc, opts parenExprOpts) js_ast.Expr
 	p.fnOrArrowDataParse.arrowArgErrors = &arrowArgErrors
 
 	// Scan over the comma-separated arguments or expressions
+	typeRanges := []logger.Range{}
 	for p.lexer.Token != js_lexer.TCloseParen {
 		itemLoc := p.lexer.Loc()
 		isSpread := p.lexer.Token == js_lexer.TDotDotDot
+		typeRange := logger.Range{}
 
 		if isSpread {
 			spreadRange = p.lexer.Range()
@@ -2272,7 +2274,9 @@ func (p *parser) parseParenExpr(loc logger.Loc, opts parenExprOpts) js_ast.Expr
 		if p.options.ts.Parse && p.lexer.Token == js_lexer.TColon {
 			typeColonRange = p.lexer.Range()
 			p.lexer.Next()
+			typeStart := p.lexer.Loc()
 			p.skipTypeScriptType(js_ast.LLowest)
+			typeRange = logger.Range{Loc: typeStart, Len: p.lexer.Loc().Start - typeStart.Start}
 		}
 
 		// There may be a "=" after the type (but not after an "as" cast)
@@ -2282,6 +2286,7 @@ func (p *parser) parseParenExpr(loc logger.Loc, opts parenExprOpts) js_ast.Expr
 		}
 
 		items = append(items, item)
+		typeRanges = append(typeRanges, typeRange)
 		if p.lexer.Token != js_lexer.TComma {
 			break
 		}
@@ -2315,7 +2320,7 @@ func (p *parser) parseParenExpr(loc logger.Loc, opts parenExprOpts) js_ast.Expr
 		}
 
 		// First, try converting the expressions to bindings
-		for _, item := range items {
+		for i, item := range items {
 			isSpread := false
 			if spread, ok := item.Data.(*js_ast.ESpread); ok {
 				item = spread.Value
@@ -2323,7 +2328,7 @@ func (p *parser) parseParenExpr(loc logger.Loc, opts parenExprOpts) js_ast.Expr
 			}
 			binding, initializer, log := p.convertExprToBindingAndInitializer(item, invalidLog, isSpread)
 			invalidLog = log
-			args = append(args, js_ast.Arg{Binding: binding, Default: initializer})
+			args = append(args, js_ast.Arg{Binding: binding, Default: initializer, TSTypeRange: typeRanges[i]})
 		}
 
 		// Avoid parsing TypeScript code like "a ? (1 + 2) : (3 + 4)" as an arrow
@@ -4570,6 +4575,7 @@ func (p *parser) parseFn(name *js_ast.LocRef, data fnOrArrowDataParse) (fn js_as
 		isIdentifier := p.lexer.Token == js_lexer.TIdentifier
 		text := p.lexer.Identifier
 		arg := p.parseBinding()
+		typeRange := logger.Range{}
 
 		if p.options.ts.Parse {
 			// Skip over TypeScript accessibility modifiers, which turn this argument
@@ -4601,7 +4607,9 @@ func (p *parser) parseFn(name *js_ast.LocRef, data fnOrArrowDataParse) (fn js_as
 			// "function foo(a: any) {}"
 			if p.lexer.Token == js_lexer.TColon {
 				p.lexer.Next()
+				typeStart := p.lexer.Loc()
 				p.skipTypeScriptType(js_ast.LLowest)
+				typeRange = logger.Range{Loc: typeStart, Len: p.lexer.Loc().Start - typeStart.Start}
 			}
 		}
 
@@ -4622,6 +4630,8 @@ func (p *parser) parseFn(name *js_ast.LocRef, data fnOrArrowDataParse) (fn js_as
 
 			// We need to track this because it affects code generation
 			IsTypeScriptCtorField: isTypeScriptCtorField,
+
+			TSTypeRange: typeRange,
 		})
 
 		if p.lexer.Token != js_lexer.TComma {
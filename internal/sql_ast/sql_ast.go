@@ -0,0 +1,465 @@
+package sql_ast
+
+import "github.com/evanw/esbuild/internal/logger"
+
+// The SQL AST covers the data manipulation statements a FlowState query can
+// contain (SELECT, VALUES, TABLE, INSERT, UPDATE and DELETE, optionally with a
+// WITH clause) in enough detail to know which tables and columns a query
+// touches and what shape its result has. The table and view definitions in a
+// schema file are kept too, but only with the details about their columns.
+// Other statements are only checked for balanced brackets by the parser and
+// are kept as "SOther".
+//
+// Unquoted identifiers are folded to lower case like PostgreSQL does, so
+// names can be compared directly.
+
+type AST struct {
+	Stmts []Stmt
+}
+
+type Name struct {
+	Loc  logger.Loc
+	Text string
+}
+
+type Stmt struct {
+	Loc  logger.Loc
+	Data S
+}
+
+// This interface is never called. Its purpose is to encode a variant type in
+// Go's type system.
+type S interface{ isStmt() }
+
+type SQuery struct {
+	Query *Query
+}
+
+type SInsert struct {
+	With       []CTE
+	Table      TableName
+	Columns    []Name // empty if no column list was given
+	Query      *Query // nil for "DEFAULT VALUES"
+	OnConflict *OnConflict
+	Returning  []SelectItem
+}
+
+type SUpdate struct {
+	With      []CTE
+	Fragments bool // see QSelect.Fragments
+	Table     TableName
+	Set       []Assignment
+	From      []TableExpr
+	Where     Expr // Data is nil if there is no WHERE clause
+	Returning []SelectItem
+}
+
+type SDelete struct {
+	With      []CTE
+	Fragments bool // see QSelect.Fragments
+	Table     TableName
+	Using     []TableExpr
+	Where     Expr // Data is nil if there is no WHERE clause
+	Returning []SelectItem
+}
+
+// SCreateTable is "CREATE TABLE", including "CREATE TABLE ... AS"
+type SCreateTable struct {
+	Table       TableName
+	Columns     []ColumnDef
+	Query       *Query // set for "CREATE TABLE ... AS"
+	IfNotExists bool
+}
+
+// SCreateView is "CREATE VIEW" or "CREATE MATERIALIZED VIEW"
+type SCreateView struct {
+	Table   TableName
+	Columns []Name // empty if no column list was given
+	Query   *Query
+}
+
+type SAlterTable struct {
+	Table   TableName
+	Actions []AlterAction
+}
+
+// SDropTable is "DROP TABLE", "DROP VIEW" or "DROP MATERIALIZED VIEW"
+type SDropTable struct {
+	Tables []TableName
+}
+
+// SOther is any other statement, e.g. "SET" or "CREATE INDEX"
+type SOther struct {
+	Keyword string
+}
+
+func (*SQuery) isStmt()       {}
+func (*SInsert) isStmt()      {}
+func (*SUpdate) isStmt()      {}
+func (*SDelete) isStmt()      {}
+func (*SCreateTable) isStmt() {}
+func (*SCreateView) isStmt()  {}
+func (*SAlterTable) isStmt()  {}
+func (*SDropTable) isStmt()   {}
+func (*SOther) isStmt()       {}
+
+type ColumnDef struct {
+	Name    Name
+	Type    Type
+	NotNull bool // also true for primary key columns
+}
+
+type AlterKind uint8
+
+const (
+	AlterOther AlterKind = iota // an action that doesn't change the columns, e.g. "ADD CONSTRAINT"
+	AlterAddColumn
+	AlterDropColumn
+	AlterRenameColumn
+	AlterRenameTable
+	AlterColumnType
+	AlterSetNotNull
+	AlterDropNotNull
+)
+
+type AlterAction struct {
+	Kind    AlterKind
+	Column  ColumnDef // only the name is set unless Kind is AlterAddColumn or AlterColumnType
+	NewName Name      // for AlterRenameColumn and AlterRenameTable
+}
+
+type CTE struct {
+	Name    Name
+	Columns []Name
+	Stmt    Stmt
+}
+
+type OnConflict struct {
+	DoNothing bool
+	Set       []Assignment
+	Where     Expr
+}
+
+// Assignment is "column = value" or "(a, b) = (value, value)" in a SET clause
+type Assignment struct {
+	Columns []Name
+	Value   Expr
+}
+
+// Query is a query expression, i.e. anything that produces rows
+type Query struct {
+	Loc     logger.Loc
+	With    []CTE
+	Body    Q
+	OrderBy []OrderItem
+	Limit   Expr
+	Offset  Expr
+}
+
+// This interface is never called. Its purpose is to encode a variant type in
+// Go's type system.
+type Q interface{ isQuery() }
+
+type QSelect struct {
+	// This is true if there are "%{}" slots between the clauses, which could
+	// contain anything including joins that add tables to the FROM clause
+	Fragments bool
+
+	Distinct bool
+	Items    []SelectItem
+	From     []TableExpr
+	Where    Expr // Data is nil if there is no WHERE clause
+	GroupBy  []Expr
+	Having   Expr
+}
+
+type QValues struct {
+	Rows [][]Expr
+}
+
+// QTable is "TABLE name", which is shorthand for "SELECT * FROM name"
+type QTable struct {
+	Table TableName
+}
+
+// QSetOp is UNION, INTERSECT or EXCEPT
+type QSetOp struct {
+	Op    string
+	All   bool
+	Left  *Query
+	Right *Query
+}
+
+// QParens is a parenthesized query with its own ORDER BY and LIMIT
+type QParens struct {
+	Query *Query
+}
+
+// QFragment is a query body that consists only of a "%{}" fragment slot
+type QFragment struct{}
+
+func (*QSelect) isQuery()   {}
+func (*QValues) isQuery()   {}
+func (*QTable) isQuery()    {}
+func (*QSetOp) isQuery()    {}
+func (*QParens) isQuery()   {}
+func (*QFragment) isQuery() {}
+
+type SelectItem struct {
+	Value Expr
+	Alias Name // Text is empty if there is no alias
+}
+
+type OrderItem struct {
+	Value Expr
+	Desc  bool
+}
+
+type TableName struct {
+	Loc    logger.Loc
+	Schema string // empty if unqualified
+	Name   string
+	Alias  Name
+	Only   bool
+}
+
+type TableExpr struct {
+	Loc  logger.Loc
+	Data T
+}
+
+// This interface is never called. Its purpose is to encode a variant type in
+// Go's type system.
+type T interface{ isTableExpr() }
+
+type TTable struct {
+	Table         TableName
+	ColumnAliases []Name
+}
+
+type TSubquery struct {
+	Query         *Query
+	Alias         Name
+	ColumnAliases []Name
+	Lateral       bool
+}
+
+// TFunction is a set returning function, e.g. "generate_series(1, 10) AS n"
+type TFunction struct {
+	Call          *ECall
+	Alias         Name
+	ColumnAliases []Name
+	ColumnTypes   []Type   // the types in a column definition list, e.g. "AS x(a int, b text)"
+	RowsFrom      []*ECall // the functions after Call in "ROWS FROM (f(), g())"
+	Lateral       bool
+}
+
+type TJoin struct {
+	Kind    string // "CROSS", "INNER", "LEFT", "RIGHT" or "FULL"
+	Natural bool
+	Left    TableExpr
+	Right   TableExpr
+	On      Expr
+	Using   []Name
+}
+
+// TFragment is a table expression that consists only of a "%{}" fragment slot
+type TFragment struct{}
+
+func (*TTable) isTableExpr()    {}
+func (*TSubquery) isTableExpr() {}
+func (*TFunction) isTableExpr() {}
+func (*TJoin) isTableExpr()     {}
+func (*TFragment) isTableExpr() {}
+
+type Expr struct {
+	Loc  logger.Loc
+	Data E
+}
+
+// This interface is never called. Its purpose is to encode a variant type in
+// Go's type system.
+type E interface{ isExpr() }
+
+// EColumn is a column reference such as "name", "users.name" or "users.*".
+// Path holds the qualifiers followed by the column name (if it's not a star).
+type EColumn struct {
+	Path []Name
+	Star bool
+}
+
+// Column returns the column name, or an empty string for a star
+func (e *EColumn) Column() string {
+	if e.Star {
+		return ""
+	}
+	return e.Path[len(e.Path)-1].Text
+}
+
+// Table returns the table qualifier, or an empty string if there is none
+func (e *EColumn) Table() string {
+	n := len(e.Path)
+	if !e.Star {
+		n--
+	}
+	if n == 0 {
+		return ""
+	}
+	return e.Path[n-1].Text
+}
+
+type ENumber struct{ Value string }
+
+type EString struct{ Value string }
+
+type EBoolean struct{ Value bool }
+
+type ENull struct{}
+
+type EDefault struct{}
+
+// EParam is a bound parameter such as "$1"
+type EParam struct{ Index int }
+
+// EServerVar is a server variable such as "${SESSION.user_id}"
+type EServerVar struct{ Name string }
+
+// EFragment is a "%{}" fragment slot
+type EFragment struct{}
+
+// EKeyword is a SQL value function that is written as a keyword, e.g. "CURRENT_TIMESTAMP"
+type EKeyword struct{ Name string }
+
+type EUnary struct {
+	Op    string
+	Value Expr
+}
+
+// EBinary includes the keyword operators "AND", "OR", "LIKE", "NOT LIKE", "ILIKE", "SIMILAR TO",
+// "IS DISTINCT FROM", "AT TIME ZONE" and "COLLATE" (with the collation as an EString)
+type EBinary struct {
+	Op    string
+	Left  Expr
+	Right Expr
+}
+
+// ESubqueryOp is "left op ANY (...)", "left op SOME (...)" or "left op ALL (...)"
+type ESubqueryOp struct {
+	Op         string
+	Quantifier string
+	Left       Expr
+	Right      Expr
+}
+
+// EIs is "value IS [NOT] NULL/TRUE/FALSE/UNKNOWN"
+type EIs struct {
+	Value Expr
+	Test  string
+	Not   bool
+}
+
+type EIn struct {
+	Value Expr
+	List  []Expr
+	Query *Query // set instead of List for "IN (SELECT ...)"
+	Not   bool
+}
+
+type EBetween struct {
+	Value Expr
+	Low   Expr
+	High  Expr
+	Not   bool
+}
+
+type ECall struct {
+	Name     []Name // optionally qualified with a schema
+	Args     []Expr
+	Star     bool // count(*)
+	Distinct bool
+	OrderBy  []OrderItem
+	Filter   Expr
+	Over     *Window
+}
+
+type Window struct {
+	Name        string
+	PartitionBy []Expr
+	OrderBy     []OrderItem
+}
+
+type ECast struct {
+	Value Expr
+	Type  Type
+}
+
+type Type struct {
+	Name  string
+	Array bool
+}
+
+type ECase struct {
+	Value Expr // Data is nil for a searched CASE
+	Whens []When
+	Else  Expr
+}
+
+type When struct {
+	Cond  Expr
+	Value Expr
+}
+
+type EExists struct {
+	Query *Query
+}
+
+type ESubquery struct {
+	Query *Query
+}
+
+type EArray struct {
+	Items []Expr
+	Query *Query // set instead of Items for "ARRAY(SELECT ...)"
+}
+
+type ERow struct {
+	Items []Expr
+}
+
+type EIndex struct {
+	Target Expr
+	Index  Expr
+	End    Expr // set for slices
+}
+
+// EField is a field selection from a composite value, e.g. "(item).name"
+type EField struct {
+	Target Expr
+	Name   Name
+}
+
+func (*EColumn) isExpr()     {}
+func (*ENumber) isExpr()     {}
+func (*EString) isExpr()     {}
+func (*EBoolean) isExpr()    {}
+func (*ENull) isExpr()       {}
+func (*EDefault) isExpr()    {}
+func (*EParam) isExpr()      {}
+func (*EServerVar) isExpr()  {}
+func (*EFragment) isExpr()   {}
+func (*EKeyword) isExpr()    {}
+func (*EUnary) isExpr()      {}
+func (*EBinary) isExpr()     {}
+func (*ESubqueryOp) isExpr() {}
+func (*EIs) isExpr()         {}
+func (*EIn) isExpr()         {}
+func (*EBetween) isExpr()    {}
+func (*ECall) isExpr()       {}
+func (*ECast) isExpr()       {}
+func (*ECase) isExpr()       {}
+func (*EExists) isExpr()     {}
+func (*ESubquery) isExpr()   {}
+func (*EArray) isExpr()      {}
+func (*ERow) isExpr()        {}
+func (*EIndex) isExpr()      {}
+func (*EField) isExpr()      {}
@@ -0,0 +1,476 @@
+package sql_lexer
+
+import (
+	"strings"
+
+	"github.com/evanw/esbuild/internal/logger"
+)
+
+// The lexer converts a compiled query to a stream of tokens. It follows the
+// PostgreSQL lexical rules, with the addition of the placeholders that the
+// FlowState compiler leaves in the query text:
+//
+//   $1, $2, ...     bound parameters
+//   ${SESSION.x}    server variables bound by the runtime
+//   %{}             a slot for a fragment that is merged in at runtime
+//
+// Like the CSS lexer, this runs to completion before the parser begins.
+// Comments are not returned as tokens. Like PostgreSQL, lexing stops at the
+// first error, which ends the token stream with a "TSyntaxError" token so
+// the parser knows the error has already been reported.
+
+type T uint8
+
+const (
+	TEndOfFile T = iota
+
+	TCloseBracket
+	TCloseParen
+	TColon
+	TComma
+	TDot
+	TFragment
+	TIdentifier
+	TNumber
+	TOpenBracket
+	TOpenParen
+	TOperator
+	TParam
+	TQuotedIdentifier
+	TSemicolon
+	TServerVar
+	TString
+	TSyntaxError
+)
+
+var tokenToString = []string{
+	"end of query",
+	"\"]\"",
+	"\")\"",
+	"\":\"",
+	"\",\"",
+	"\".\"",
+	"fragment",
+	"identifier",
+	"number",
+	"\"[\"",
+	"\"(\"",
+	"operator",
+	"parameter",
+	"quoted identifier",
+	"\";\"",
+	"server variable",
+	"string",
+	"syntax error",
+}
+
+func (t T) String() string {
+	return tokenToString[t]
+}
+
+type Token struct {
+	Range logger.Range
+	Kind  T
+}
+
+// Raw returns the text of the token as it appears in the query
+func (token Token) Raw(contents string) string {
+	return contents[token.Range.Loc.Start:token.Range.End()]
+}
+
+// DecodedText returns the name of an identifier (folded to lower case if it's not quoted as in
+// PostgreSQL), the contents of a string, or the raw text of any other token.
+func (token Token) DecodedText(contents string) string {
+	raw := token.Raw(contents)
+
+	switch token.Kind {
+	case TIdentifier:
+		return strings.ToLower(raw)
+
+	case TQuotedIdentifier:
+		if raw[0] != '"' {
+			raw = raw[2:] // U&"..."
+		}
+		return strings.Replace(raw[1:len(raw)-1], `""`, `"`, -1)
+
+	case TString:
+		if raw[0] == '$' {
+			// Dollar quoted string
+			tag := strings.IndexByte(raw[1:], '$') + 2
+			return raw[tag : len(raw)-tag]
+		}
+		quote := strings.IndexByte(raw, '\'')
+		return strings.Replace(raw[quote+1:len(raw)-1], `''`, `'`, -1)
+
+	case TServerVar:
+		return raw[2 : len(raw)-1]
+	}
+
+	return raw
+}
+
+type lexer struct {
+	log     logger.Log
+	source  logger.Source
+	start   int
+	current int
+	tokens  []Token
+}
+
+func Tokenize(log logger.Log, source logger.Source) []Token {
+	lexer := lexer{
+		log:    log,
+		source: source,
+	}
+	lexer.run()
+	return lexer.tokens
+}
+
+// RangeOfIdentifier returns the range of the quoted or unquoted identifier at loc
+func RangeOfIdentifier(source logger.Source, loc logger.Loc) logger.Range {
+	text := source.Contents[loc.Start:]
+	i := 0
+	if strings.HasPrefix(text, "\"") {
+		for i = 1; i < len(text); i++ {
+			if text[i] == '"' {
+				if i+1 < len(text) && text[i+1] == '"' {
+					i++
+					continue
+				}
+				i++
+				break
+			}
+		}
+	} else if len(text) > 0 && isIdentifierStart(text[0]) {
+		for i < len(text) && isIdentifierContinue(text[i]) {
+			i++
+		}
+	}
+	return logger.Range{Loc: loc, Len: int32(i)}
+}
+
+func (lexer *lexer) peek(offset int) byte {
+	if i := lexer.current + offset; i < len(lexer.source.Contents) {
+		return lexer.source.Contents[i]
+	}
+	return 0
+}
+
+func (lexer *lexer) add(kind T) {
+	lexer.tokens = append(lexer.tokens, Token{
+		Range: logger.Range{Loc: logger.Loc{Start: int32(lexer.start)}, Len: int32(lexer.current - lexer.start)},
+		Kind:  kind,
+	})
+}
+
+func (lexer *lexer) addError(text string) {
+	r := logger.Range{Loc: logger.Loc{Start: int32(lexer.start)}, Len: int32(lexer.current - lexer.start)}
+	lexer.log.AddRangeError(&lexer.source, r, text)
+	lexer.tokens = append(lexer.tokens, Token{Range: r, Kind: TSyntaxError})
+	lexer.current = len(lexer.source.Contents)
+}
+
+func (lexer *lexer) run() {
+	contents := lexer.source.Contents
+
+	for lexer.current < len(contents) {
+		lexer.start = lexer.current
+		c := contents[lexer.current]
+
+		switch {
+		case isWhitespace(c):
+			lexer.current++
+
+		case c == '-' && lexer.peek(1) == '-':
+			for lexer.current < len(contents) && contents[lexer.current] != '\n' {
+				lexer.current++
+			}
+
+		case c == '/' && lexer.peek(1) == '*':
+			lexer.consumeMultiLineComment()
+
+		case c == '(':
+			lexer.current++
+			lexer.add(TOpenParen)
+
+		case c == ')':
+			lexer.current++
+			lexer.add(TCloseParen)
+
+		case c == '[':
+			lexer.current++
+			lexer.add(TOpenBracket)
+
+		case c == ']':
+			lexer.current++
+			lexer.add(TCloseBracket)
+
+		case c == ',':
+			lexer.current++
+			lexer.add(TComma)
+
+		case c == ';':
+			lexer.current++
+			lexer.add(TSemicolon)
+
+		case c == ':':
+			if lexer.peek(1) == ':' {
+				lexer.current += 2
+				lexer.add(TOperator)
+			} else {
+				lexer.current++
+				lexer.add(TColon)
+			}
+
+		case c == '.':
+			if isDigit(lexer.peek(1)) {
+				lexer.consumeNumber()
+			} else {
+				lexer.current++
+				lexer.add(TDot)
+			}
+
+		case isDigit(c):
+			lexer.consumeNumber()
+
+		case c == '\'':
+			lexer.consumeString(false)
+
+		case (c == 'e' || c == 'E') && lexer.peek(1) == '\'':
+			lexer.current++
+			lexer.consumeString(true)
+
+		case (c == 'b' || c == 'B' || c == 'x' || c == 'X' || c == 'n' || c == 'N') && lexer.peek(1) == '\'':
+			lexer.current++
+			lexer.consumeString(false)
+
+		case (c == 'u' || c == 'U') && lexer.peek(1) == '&' && (lexer.peek(2) == '\'' || lexer.peek(2) == '"'):
+			lexer.current += 2
+			if contents[lexer.current] == '"' {
+				lexer.consumeQuotedIdentifier()
+			} else {
+				lexer.consumeString(false)
+			}
+
+		case c == '"':
+			lexer.consumeQuotedIdentifier()
+
+		case c == '$':
+			lexer.consumeDollar()
+
+		case c == '%' && lexer.peek(1) == '{' && lexer.peek(2) == '}':
+			lexer.current += 3
+			lexer.add(TFragment)
+
+		case isIdentifierStart(c):
+			for lexer.current < len(contents) && isIdentifierContinue(contents[lexer.current]) {
+				lexer.current++
+			}
+			lexer.add(TIdentifier)
+
+		case isOperatorChar(c):
+			lexer.consumeOperator()
+
+		default:
+			lexer.current++
+			lexer.addError("syntax error at or near \"" + contents[lexer.start:lexer.current] + "\"")
+		}
+	}
+}
+
+func (lexer *lexer) consumeMultiLineComment() {
+	// Comments nest in PostgreSQL
+	contents := lexer.source.Contents
+	depth := 0
+	for lexer.current < len(contents) {
+		if contents[lexer.current] == '/' && lexer.peek(1) == '*' {
+			depth++
+			lexer.current += 2
+		} else if contents[lexer.current] == '*' && lexer.peek(1) == '/' {
+			depth--
+			lexer.current += 2
+			if depth == 0 {
+				return
+			}
+		} else {
+			lexer.current++
+		}
+	}
+	lexer.current = lexer.start + 2
+	lexer.addError("unterminated /* comment")
+	lexer.current = len(contents)
+}
+
+func (lexer *lexer) consumeNumber() {
+	contents := lexer.source.Contents
+	for lexer.current < len(contents) && isDigit(contents[lexer.current]) {
+		lexer.current++
+	}
+	if lexer.current < len(contents) && contents[lexer.current] == '.' && lexer.peek(1) != '.' {
+		lexer.current++
+		for lexer.current < len(contents) && isDigit(contents[lexer.current]) {
+			lexer.current++
+		}
+	}
+	if c := lexer.peek(0); c == 'e' || c == 'E' {
+		next := lexer.peek(1)
+		if isDigit(next) || ((next == '+' || next == '-') && isDigit(lexer.peek(2))) {
+			lexer.current += 2
+			for lexer.current < len(contents) && isDigit(contents[lexer.current]) {
+				lexer.current++
+			}
+		}
+	}
+	if c := lexer.peek(0); isIdentifierStart(c) {
+		for lexer.current < len(contents) && isIdentifierContinue(contents[lexer.current]) {
+			lexer.current++
+		}
+		lexer.addError("trailing junk after numeric literal at or near \"" + contents[lexer.start:lexer.current] + "\"")
+		return
+	}
+	lexer.add(TNumber)
+}
+
+func (lexer *lexer) consumeString(backslashEscapes bool) {
+	contents := lexer.source.Contents
+	lexer.current++ // skip the opening quote
+	for lexer.current < len(contents) {
+		c := contents[lexer.current]
+		lexer.current++
+		if c == '\\' && backslashEscapes {
+			lexer.current++
+		} else if c == '\'' {
+			if lexer.peek(0) != '\'' {
+				lexer.add(TString)
+				return
+			}
+			lexer.current++
+		}
+	}
+	lexer.current = len(contents)
+	lexer.addError("unterminated quoted string at or near \"" + contents[lexer.start:] + "\"")
+}
+
+func (lexer *lexer) consumeQuotedIdentifier() {
+	contents := lexer.source.Contents
+	lexer.current++ // skip the opening quote
+	open := lexer.current
+	for lexer.current < len(contents) {
+		c := contents[lexer.current]
+		lexer.current++
+		if c == '"' {
+			if lexer.peek(0) != '"' {
+				if lexer.current == open+1 {
+					lexer.addError("zero-length delimited identifier at or near \"\"\"\"")
+					return
+				}
+				lexer.add(TQuotedIdentifier)
+				return
+			}
+			lexer.current++
+		}
+	}
+	lexer.current = len(contents)
+	lexer.addError("unterminated quoted identifier at or near \"" + contents[lexer.start:] + "\"")
+}
+
+func (lexer *lexer) consumeDollar() {
+	contents := lexer.source.Contents
+	next := lexer.peek(1)
+
+	switch {
+	case isDigit(next):
+		lexer.current++
+		for lexer.current < len(contents) && isDigit(contents[lexer.current]) {
+			lexer.current++
+		}
+		lexer.add(TParam)
+
+	case next == '{':
+		end := strings.IndexByte(contents[lexer.current:], '}')
+		if end < 0 {
+			lexer.current = len(contents)
+			lexer.addError("unterminated server variable at or near \"" + contents[lexer.start:] + "\"")
+			return
+		}
+		lexer.current += end + 1
+		lexer.add(TServerVar)
+
+	case next == '$' || isIdentifierStart(next):
+		// Dollar quoted string: $tag$ ... $tag$
+		lexer.current++
+		for lexer.current < len(contents) && contents[lexer.current] != '$' && isIdentifierContinue(contents[lexer.current]) {
+			lexer.current++
+		}
+		if lexer.peek(0) != '$' {
+			lexer.addError("syntax error at or near \"" + contents[lexer.start:lexer.current] + "\"")
+			return
+		}
+		lexer.current++
+		tag := contents[lexer.start:lexer.current]
+		end := strings.Index(contents[lexer.current:], tag)
+		if end < 0 {
+			lexer.current = len(contents)
+			lexer.addError("unterminated dollar-quoted string at or near \"" + contents[lexer.start:] + "\"")
+			return
+		}
+		lexer.current += end + len(tag)
+		lexer.add(TString)
+
+	default:
+		lexer.current++
+		lexer.addError("syntax error at or near \"$\"")
+	}
+}
+
+func (lexer *lexer) consumeOperator() {
+	// PostgreSQL operators are a sequence of operator characters, with the exception that
+	// "--" and "/*" start a comment, and a multi-character operator can't end in "+" or "-"
+	// unless it contains one of "~!@#%^&|`?" (so "=-1" is "=" followed by "-1").
+	contents := lexer.source.Contents
+	for lexer.current < len(contents) && isOperatorChar(contents[lexer.current]) {
+		if lexer.current > lexer.start {
+			if c := contents[lexer.current]; (c == '-' && lexer.peek(1) == '-') || (c == '/' && lexer.peek(1) == '*') {
+				break
+			}
+		}
+		lexer.current++
+	}
+
+	op := contents[lexer.start:lexer.current]
+	if len(op) > 1 && !strings.ContainsAny(op, "~!@#%^&|`?") {
+		for len(op) > 1 && (op[len(op)-1] == '+' || op[len(op)-1] == '-') {
+			op = op[:len(op)-1]
+		}
+		lexer.current = lexer.start + len(op)
+	}
+	lexer.add(TOperator)
+}
+
+func isWhitespace(c byte) bool {
+	switch c {
+	case ' ', '\t', '\n', '\r', '\f', '\v':
+		return true
+	}
+	return false
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentifierStart(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '_' || c >= 0x80
+}
+
+func isIdentifierContinue(c byte) bool {
+	return isIdentifierStart(c) || isDigit(c) || c == '$'
+}
+
+func isOperatorChar(c byte) bool {
+	switch c {
+	case '+', '-', '*', '/', '<', '>', '=', '~', '!', '@', '#', '%', '^', '&', '|', '`', '?':
+		return true
+	}
+	return false
+}
@@ -0,0 +1,118 @@
+package sql_lexer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/evanw/esbuild/internal/logger"
+	"github.com/evanw/esbuild/internal/test"
+)
+
+func lexToken(contents string) (T, string) {
+	log := logger.NewDeferLog()
+	tokens := Tokenize(log, test.SourceForTest(contents))
+	if len(tokens) > 0 {
+		t := tokens[0]
+		return t.Kind, t.DecodedText(contents)
+	}
+	return TEndOfFile, ""
+}
+
+func lexTokens(contents string) string {
+	log := logger.NewDeferLog()
+	var raw []string
+	for _, t := range Tokenize(log, test.SourceForTest(contents)) {
+		raw = append(raw, t.Raw(contents))
+	}
+	return strings.Join(raw, " ")
+}
+
+func lexerError(contents string) string {
+	log := logger.NewDeferLog()
+	Tokenize(log, test.SourceForTest(contents))
+	text := ""
+	for _, msg := range log.Done() {
+		text += msg.String(logger.OutputOptions{}, logger.TerminalInfo{})
+	}
+	return text
+}
+
+func TestTokens(t *testing.T) {
+	expected := []struct {
+		contents string
+		token    T
+		text     string
+	}{
+		{"", TEndOfFile, ""},
+		{"-- comment", TEndOfFile, ""},
+		{"/* a /* nested */ comment */", TEndOfFile, ""},
+		{"]", TCloseBracket, "]"},
+		{")", TCloseParen, ")"},
+		{":", TColon, ":"},
+		{",", TComma, ","},
+		{".", TDot, "."},
+		{"%{}", TFragment, "%{}"},
+		{"SELECT", TIdentifier, "select"},
+		{"users_2$", TIdentifier, "users_2$"},
+		{"123", TNumber, "123"},
+		{"1.5e-3", TNumber, "1.5e-3"},
+		{".5", TNumber, ".5"},
+		{"[", TOpenBracket, "["},
+		{"(", TOpenParen, "("},
+		{"::", TOperator, "::"},
+		{"<>", TOperator, "<>"},
+		{"?|", TOperator, "?|"},
+		{"$1", TParam, "$1"},
+		{"\"User Name\"", TQuotedIdentifier, "User Name"},
+		{"\"a\"\"b\"", TQuotedIdentifier, "a\"b"},
+		{";", TSemicolon, ";"},
+		{"${SESSION.user_id}", TServerVar, "SESSION.user_id"},
+		{"'it''s'", TString, "it's"},
+		{"E'\\''", TString, "\\'"},
+		{"$$it's$$", TString, "it's"},
+		{"$tag$a $$ b$tag$", TString, "a $$ b"},
+	}
+
+	for _, it := range expected {
+		contents := it.contents
+		token := it.token
+		text := it.text
+		t.Run(contents, func(t *testing.T) {
+			kind, decoded := lexToken(contents)
+			test.AssertEqual(t, kind, token)
+			test.AssertEqual(t, decoded, text)
+		})
+	}
+}
+
+func TestOperators(t *testing.T) {
+	test.AssertEqual(t, lexTokens("a=-1"), "a = - 1")
+	test.AssertEqual(t, lexTokens("a<-1"), "a < - 1")
+	test.AssertEqual(t, lexTokens("a@-1"), "a @- 1")
+	test.AssertEqual(t, lexTokens("a*--comment\n1"), "a * 1")
+	test.AssertEqual(t, lexTokens("a::text"), "a :: text")
+	test.AssertEqual(t, lexTokens("a[1:2]"), "a [ 1 : 2 ]")
+	test.AssertEqual(t, lexTokens("o.%{} %{}"), "o . %{} %{}")
+}
+
+func TestErrors(t *testing.T) {
+	test.AssertEqual(t, lexerError("'abc"), "<stdin>: error: unterminated quoted string at or near \"'abc\"\n")
+	test.AssertEqual(t, lexerError("\"abc"), "<stdin>: error: unterminated quoted identifier at or near \"\"abc\"\n")
+	test.AssertEqual(t, lexerError("\"\""), "<stdin>: error: zero-length delimited identifier at or near \"\"\"\"\n")
+	test.AssertEqual(t, lexerError("/* abc"), "<stdin>: error: unterminated /* comment\n")
+	test.AssertEqual(t, lexerError("$a$ abc"), "<stdin>: error: unterminated dollar-quoted string at or near \"$a$ abc\"\n")
+	test.AssertEqual(t, lexerError("${SESSION.id"), "<stdin>: error: unterminated server variable at or near \"${SESSION.id\"\n")
+	test.AssertEqual(t, lexerError("123abc"), "<stdin>: error: trailing junk after numeric literal at or near \"123abc\"\n")
+	test.AssertEqual(t, lexerError("a \\ b"), "<stdin>: error: syntax error at or near \"\\\"\n")
+
+	// Lexing stops at the first error
+	test.AssertEqual(t, lexerError("'a \\ b"), "<stdin>: error: unterminated quoted string at or near \"'a \\ b\"\n")
+	test.AssertEqual(t, lexTokens("a \\ b"), "a \\")
+}
+
+func TestRangeOfIdentifier(t *testing.T) {
+	source := test.SourceForTest("SELECT \"a \"\"b\"\" c\", users_2 FROM t")
+	test.AssertEqual(t, RangeOfIdentifier(source, logger.Loc{Start: 7}).Len, int32(11))
+	test.AssertEqual(t, RangeOfIdentifier(source, logger.Loc{Start: 20}).Len, int32(7))
+	test.AssertEqual(t, RangeOfIdentifier(source, logger.Loc{Start: 19}).Len, int32(0))
+}
@@ -0,0 +1,2331 @@
+package sql_parser
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/evanw/esbuild/internal/logger"
+	"github.com/evanw/esbuild/internal/sql_ast"
+	"github.com/evanw/esbuild/internal/sql_lexer"
+)
+
+// This parser checks queries against the PostgreSQL grammar. It's a
+// recursive descent parser with precedence climbing for expressions, and it
+// reports the first syntax error using the same wording as PostgreSQL.
+//
+// A "%{}" fragment slot can stand for almost any text, so the parser accepts
+// it anywhere an expression, table or clause can appear. If the query can't
+// be parsed directly after a fragment slot, the rest of that statement is
+// skipped without reporting an error since the fragment may complete it.
+
+type parser struct {
+	log      logger.Log
+	source   logger.Source
+	tokens   []sql_lexer.Token
+	index    int
+	end      int
+	hasError bool
+}
+
+type Options struct {
+	// These "$n" parameters are bound to SQL text at runtime instead of to a
+	// value (e.g. with "sql.p(name)"), so they're treated like "%{}" slots
+	FragmentParams []int
+}
+
+// parsePanic unwinds the parser to the enclosing statement
+type parsePanic struct{}
+
+func Parse(log logger.Log, source logger.Source, options Options) sql_ast.AST {
+	p := parser{
+		log:    log,
+		source: source,
+		tokens: sql_lexer.Tokenize(log, source),
+	}
+	p.end = len(p.tokens)
+
+	if len(options.FragmentParams) != 0 {
+		for i, t := range p.tokens {
+			if t.Kind == sql_lexer.TParam {
+				index, _ := strconv.Atoi(t.Raw(source.Contents)[1:])
+				for _, param := range options.FragmentParams {
+					if index == param {
+						p.tokens[i].Kind = sql_lexer.TFragment
+						break
+					}
+				}
+			}
+		}
+	}
+
+	return sql_ast.AST{Stmts: p.parseStmts()}
+}
+
+func (p *parser) advance() {
+	if p.index < p.end {
+		p.index++
+	}
+}
+
+func (p *parser) at(index int) sql_lexer.Token {
+	if index < p.end {
+		return p.tokens[index]
+	}
+	return sql_lexer.Token{
+		Kind:  sql_lexer.TEndOfFile,
+		Range: logger.Range{Loc: logger.Loc{Start: int32(len(p.source.Contents))}},
+	}
+}
+
+func (p *parser) current() sql_lexer.Token {
+	return p.at(p.index)
+}
+
+func (p *parser) next() sql_lexer.Token {
+	return p.at(p.index + 1)
+}
+
+func (p *parser) loc() logger.Loc {
+	return p.current().Range.Loc
+}
+
+func (p *parser) raw() string {
+	return p.current().Raw(p.source.Contents)
+}
+
+func (p *parser) decoded() string {
+	return p.current().DecodedText(p.source.Contents)
+}
+
+func (p *parser) peek(kind sql_lexer.T) bool {
+	return kind == p.current().Kind
+}
+
+func (p *parser) eat(kind sql_lexer.T) bool {
+	if p.peek(kind) {
+		p.advance()
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(kind sql_lexer.T) {
+	if !p.eat(kind) {
+		p.unexpected()
+	}
+}
+
+// isKeywordAt returns true if the token at index is the unquoted keyword (which must be lower case)
+func (p *parser) isKeywordAt(index int, keyword string) bool {
+	t := p.at(index)
+	return t.Kind == sql_lexer.TIdentifier && int(t.Range.Len) == len(keyword) &&
+		strings.EqualFold(t.Raw(p.source.Contents), keyword)
+}
+
+func (p *parser) isKeyword(keyword string) bool {
+	return p.isKeywordAt(p.index, keyword)
+}
+
+func (p *parser) eatKeyword(keyword string) bool {
+	if p.isKeyword(keyword) {
+		p.advance()
+		return true
+	}
+	return false
+}
+
+func (p *parser) expectKeyword(keyword string) {
+	if !p.eatKeyword(keyword) {
+		p.unexpected()
+	}
+}
+
+func (p *parser) isOperator(op string) bool {
+	return p.peek(sql_lexer.TOperator) && p.raw() == op
+}
+
+// keyword returns the current token in lower case if it's an unquoted identifier, otherwise an empty string
+func (p *parser) keyword() string {
+	if p.peek(sql_lexer.TIdentifier) {
+		return p.decoded()
+	}
+	return ""
+}
+
+func (p *parser) unexpected() {
+	t := p.current()
+	switch {
+	case t.Kind == sql_lexer.TSyntaxError:
+		// The lexer already reported this
+		p.hasError = true
+
+	case t.Kind == sql_lexer.TFragment || (p.index > 0 && p.at(p.index-1).Kind == sql_lexer.TFragment):
+		// The fragment may make this valid, so we can't tell
+
+	case t.Kind == sql_lexer.TEndOfFile:
+		p.log.AddRangeError(&p.source, logger.Range{Loc: t.Range.Loc}, "syntax error at end of input")
+		p.hasError = true
+
+	default:
+		p.log.AddRangeError(&p.source, t.Range, "syntax error at or near \""+p.raw()+"\"")
+		p.hasError = true
+	}
+	panic(parsePanic{})
+}
+
+// eatFragments skips any fragment slots in a position where a clause could start
+// and returns true if there were any
+func (p *parser) eatFragments() bool {
+	found := false
+	for p.eat(sql_lexer.TFragment) {
+		found = true
+	}
+	return found
+}
+
+func (p *parser) parseStmts() []sql_ast.Stmt {
+	stmts := []sql_ast.Stmt{}
+	for {
+		for p.eat(sql_lexer.TSemicolon) {
+		}
+		if p.peek(sql_lexer.TEndOfFile) {
+			return stmts
+		}
+
+		stmt, ok := p.parseStmtOrSkip()
+		if p.hasError {
+			return stmts
+		}
+		if ok {
+			stmts = append(stmts, stmt)
+		}
+	}
+}
+
+// parseStmtOrSkip parses one statement. If it can't be parsed because of a fragment slot,
+// it skips to the end of the statement and returns false.
+func (p *parser) parseStmtOrSkip() (stmt sql_ast.Stmt, ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, isParsePanic := r.(parsePanic); !isParsePanic {
+				panic(r)
+			}
+			ok = false
+			for !p.peek(sql_lexer.TSemicolon) && !p.peek(sql_lexer.TEndOfFile) && !p.peek(sql_lexer.TSyntaxError) {
+				p.advance()
+			}
+			if p.peek(sql_lexer.TSyntaxError) {
+				p.hasError = true
+			}
+		}
+	}()
+
+	stmt = p.parseStmt()
+	p.eatFragments()
+	if !p.peek(sql_lexer.TSemicolon) && !p.peek(sql_lexer.TEndOfFile) {
+		p.unexpected()
+	}
+	return stmt, true
+}
+
+// These are the other PostgreSQL commands, which are accepted if their brackets are balanced.
+// "CREATE", "ALTER" and "DROP" are only parsed in detail for tables and views.
+var otherStmtKeywords = map[string]bool{
+	"abort": true, "alter": true, "analyze": true, "begin": true, "call": true,
+	"checkpoint": true, "close": true, "cluster": true, "comment": true, "commit": true,
+	"copy": true, "create": true, "deallocate": true, "declare": true, "discard": true,
+	"do": true, "drop": true, "end": true, "execute": true, "explain": true,
+	"fetch": true, "grant": true, "import": true, "listen": true, "load": true,
+	"lock": true, "merge": true, "move": true, "notify": true, "prepare": true,
+	"reassign": true, "refresh": true, "reindex": true, "release": true, "reset": true,
+	"revoke": true, "rollback": true, "savepoint": true, "security": true, "set": true,
+	"show": true, "start": true, "truncate": true, "unlisten": true, "vacuum": true,
+}
+
+func (p *parser) parseStmt() sql_ast.Stmt {
+	loc := p.loc()
+
+	switch p.keyword() {
+	case "with":
+		with := p.parseWith()
+		start := p.index
+		stmt := p.parseStmt()
+		switch s := stmt.Data.(type) {
+		case *sql_ast.SQuery:
+			s.Query.With = with
+			s.Query.Loc = loc
+		case *sql_ast.SInsert:
+			s.With = with
+		case *sql_ast.SUpdate:
+			s.With = with
+		case *sql_ast.SDelete:
+			s.With = with
+		default:
+			// Only queries, INSERT, UPDATE and DELETE can have a WITH clause
+			p.index = start
+			p.unexpected()
+		}
+		stmt.Loc = loc
+		return stmt
+
+	case "select", "values", "table":
+		return sql_ast.Stmt{Loc: loc, Data: &sql_ast.SQuery{Query: p.parseQuery()}}
+
+	case "insert":
+		return sql_ast.Stmt{Loc: loc, Data: p.parseInsert()}
+
+	case "update":
+		return sql_ast.Stmt{Loc: loc, Data: p.parseUpdate()}
+
+	case "delete":
+		return sql_ast.Stmt{Loc: loc, Data: p.parseDelete()}
+
+	case "create":
+		return sql_ast.Stmt{Loc: loc, Data: p.parseCreate()}
+
+	case "alter":
+		return sql_ast.Stmt{Loc: loc, Data: p.parseAlter()}
+
+	case "drop":
+		return sql_ast.Stmt{Loc: loc, Data: p.parseDrop()}
+
+	case "":
+		if p.peek(sql_lexer.TOpenParen) || p.peek(sql_lexer.TFragment) {
+			return sql_ast.Stmt{Loc: loc, Data: &sql_ast.SQuery{Query: p.parseQuery()}}
+		}
+
+	default:
+		if keyword := p.keyword(); otherStmtKeywords[keyword] {
+			p.parseOther()
+			return sql_ast.Stmt{Loc: loc, Data: &sql_ast.SOther{Keyword: keyword}}
+		}
+	}
+
+	p.unexpected()
+	return sql_ast.Stmt{}
+}
+
+// parseOther skips over a statement that isn't parsed in detail, checking that the brackets are balanced
+func (p *parser) parseOther() {
+	var stack []sql_lexer.T
+	for {
+		switch p.current().Kind {
+		case sql_lexer.TSemicolon, sql_lexer.TEndOfFile:
+			if len(stack) != 0 {
+				p.unexpected()
+			}
+			return
+
+		case sql_lexer.TOpenParen:
+			stack = append(stack, sql_lexer.TCloseParen)
+
+		case sql_lexer.TOpenBracket:
+			stack = append(stack, sql_lexer.TCloseBracket)
+
+		case sql_lexer.TCloseParen, sql_lexer.TCloseBracket:
+			if len(stack) == 0 || stack[len(stack)-1] != p.current().Kind {
+				p.unexpected()
+			}
+			stack = stack[:len(stack)-1]
+
+		case sql_lexer.TSyntaxError:
+			p.unexpected()
+		}
+		p.advance()
+	}
+}
+
+func (p *parser) parseWith() []sql_ast.CTE {
+	p.expectKeyword("with")
+	p.eatKeyword("recursive")
+	var ctes []sql_ast.CTE
+	for {
+		cte := sql_ast.CTE{Name: p.parseColId()}
+		if p.peek(sql_lexer.TOpenParen) {
+			cte.Columns = p.parseNameList()
+		}
+		p.expectKeyword("as")
+		if p.eatKeyword("not") {
+			p.expectKeyword("materialized")
+		} else {
+			p.eatKeyword("materialized")
+		}
+		p.expect(sql_lexer.TOpenParen)
+		cte.Stmt = p.parsePreparableStmt()
+		p.expect(sql_lexer.TCloseParen)
+		ctes = append(ctes, cte)
+		if !p.eat(sql_lexer.TComma) {
+			return ctes
+		}
+	}
+}
+
+// parsePreparableStmt parses a statement that can appear in a WITH clause
+func (p *parser) parsePreparableStmt() sql_ast.Stmt {
+	switch p.keyword() {
+	case "select", "values", "table", "with", "insert", "update", "delete":
+		return p.parseStmt()
+	}
+	if p.peek(sql_lexer.TOpenParen) {
+		return p.parseStmt()
+	}
+	p.unexpected()
+	return sql_ast.Stmt{}
+}
+
+// isQueryStart returns true if the tokens starting at index begin a query, possibly in parentheses
+func (p *parser) isQueryStart(index int) bool {
+	for p.at(index).Kind == sql_lexer.TOpenParen {
+		index++
+	}
+	return p.isKeywordAt(index, "select") || p.isKeywordAt(index, "values") ||
+		p.isKeywordAt(index, "with") || p.isKeywordAt(index, "table")
+}
+
+func (p *parser) parseQuery() *sql_ast.Query {
+	q := &sql_ast.Query{Loc: p.loc()}
+	if p.isKeyword("with") {
+		q.With = p.parseWith()
+	}
+	q.Body = p.parseSetOps(p.parseQueryPrimary(), 0)
+	p.parseQueryTail(q)
+	return q
+}
+
+func setOpLevel(keyword string) int {
+	switch keyword {
+	case "union", "except":
+		return 1
+	case "intersect":
+		return 2
+	}
+	return 0
+}
+
+func (p *parser) parseSetOps(left sql_ast.Q, level int) sql_ast.Q {
+	for {
+		p.eatFragments()
+		op := p.keyword()
+		opLevel := setOpLevel(op)
+		if opLevel <= level {
+			return left
+		}
+		loc := p.loc()
+		p.advance()
+		all := p.eatKeyword("all")
+		if !all {
+			p.eatKeyword("distinct")
+		}
+		right := p.parseSetOps(p.parseQueryPrimary(), opLevel)
+		left = &sql_ast.QSetOp{
+			Op:    strings.ToUpper(op),
+			All:   all,
+			Left:  &sql_ast.Query{Loc: loc, Body: left},
+			Right: &sql_ast.Query{Loc: loc, Body: right},
+		}
+	}
+}
+
+func (p *parser) parseQueryPrimary() sql_ast.Q {
+	switch p.keyword() {
+	case "select":
+		return p.parseSelect()
+
+	case "values":
+		p.advance()
+		values := &sql_ast.QValues{}
+		for {
+			values.Rows = append(values.Rows, p.parseParenExprList())
+			if !p.eat(sql_lexer.TComma) {
+				return values
+			}
+		}
+
+	case "table":
+		p.advance()
+		return &sql_ast.QTable{Table: p.parseTableName(false)}
+	}
+
+	if p.eat(sql_lexer.TOpenParen) {
+		q := p.parseQuery()
+		p.expect(sql_lexer.TCloseParen)
+		return &sql_ast.QParens{Query: q}
+	}
+	if p.eat(sql_lexer.TFragment) {
+		return &sql_ast.QFragment{}
+	}
+
+	p.unexpected()
+	return nil
+}
+
+func (p *parser) parseQueryTail(q *sql_ast.Query) {
+	p.eatFragments()
+	if p.eatKeyword("order") {
+		p.expectKeyword("by")
+		q.OrderBy = p.parseOrderItems()
+	}
+
+	for {
+		p.eatFragments()
+		switch p.keyword() {
+		case "limit":
+			p.advance()
+			if !p.eatKeyword("all") {
+				q.Limit = p.parseExpr()
+			}
+
+		case "offset":
+			p.advance()
+			q.Offset = p.parseExpr()
+			if !p.eatKeyword("row") {
+				p.eatKeyword("rows")
+			}
+
+		case "fetch":
+			p.advance()
+			if !p.eatKeyword("first") {
+				p.expectKeyword("next")
+			}
+			if !p.isKeyword("row") && !p.isKeyword("rows") {
+				q.Limit = p.parseExpr()
+			}
+			if !p.eatKeyword("row") {
+				p.expectKeyword("rows")
+			}
+			if p.eatKeyword("with") {
+				p.expectKeyword("ties")
+			} else {
+				p.expectKeyword("only")
+			}
+
+		case "for":
+			// Locking clause
+			p.advance()
+			switch {
+			case p.eatKeyword("update"), p.eatKeyword("share"):
+			case p.eatKeyword("no"):
+				p.expectKeyword("key")
+				p.expectKeyword("update")
+			case p.eatKeyword("key"):
+				p.expectKeyword("share")
+			default:
+				p.unexpected()
+			}
+			if p.eatKeyword("of") {
+				for {
+					p.parseTableName(false)
+					if !p.eat(sql_lexer.TComma) {
+						break
+					}
+				}
+			}
+			if p.eatKeyword("skip") {
+				p.expectKeyword("locked")
+			} else {
+				p.eatKeyword("nowait")
+			}
+
+		default:
+			return
+		}
+	}
+}
+
+func (p *parser) parseOrderItems() []sql_ast.OrderItem {
+	var items []sql_ast.OrderItem
+	for {
+		item := sql_ast.OrderItem{Value: p.parseExpr()}
+		if p.eatKeyword("desc") {
+			item.Desc = true
+		} else if p.eatKeyword("using") {
+			if !p.peek(sql_lexer.TOperator) {
+				p.unexpected()
+			}
+			p.advance()
+		} else {
+			p.eatKeyword("asc")
+		}
+		if p.eatKeyword("nulls") {
+			if !p.eatKeyword("first") {
+				p.expectKeyword("last")
+			}
+		}
+		items = append(items, item)
+		if !p.eat(sql_lexer.TComma) {
+			return items
+		}
+	}
+}
+
+// These keywords end an empty select list
+var selectListEnd = map[string]bool{
+	"except": true, "fetch": true, "for": true, "from": true, "group": true,
+	"having": true, "intersect": true, "into": true, "limit": true, "offset": true,
+	"order": true, "union": true, "where": true, "window": true,
+}
+
+func (p *parser) parseSelect() *sql_ast.QSelect {
+	p.expectKeyword("select")
+	s := &sql_ast.QSelect{}
+	if p.eatKeyword("distinct") {
+		s.Distinct = true
+		if p.eatKeyword("on") {
+			p.parseParenExprList()
+		}
+	} else {
+		p.eatKeyword("all")
+	}
+
+	switch {
+	case p.peek(sql_lexer.TEndOfFile), p.peek(sql_lexer.TSemicolon), p.peek(sql_lexer.TCloseParen), selectListEnd[p.keyword()]:
+		// PostgreSQL allows an empty select list
+	default:
+		s.Items = p.parseSelectItems()
+	}
+
+	if p.eatFragments() {
+		s.Fragments = true
+	}
+	if p.eatKeyword("into") {
+		if !p.eatKeyword("temporary") && !p.eatKeyword("temp") {
+			p.eatKeyword("unlogged")
+		}
+		p.eatKeyword("table")
+		p.parseTableName(false)
+	}
+
+	if p.eatFragments() {
+		s.Fragments = true
+	}
+	if p.eatKeyword("from") {
+		s.From = p.parseFromList()
+	}
+
+	if p.eatFragments() {
+		s.Fragments = true
+	}
+	if p.eatKeyword("where") {
+		s.Where = p.parseExpr()
+	}
+
+	if p.eatFragments() {
+		s.Fragments = true
+	}
+	if p.eatKeyword("group") {
+		p.expectKeyword("by")
+		if !p.eatKeyword("all") {
+			p.eatKeyword("distinct")
+		}
+		s.GroupBy = p.parseGroupingList()
+	}
+
+	if p.eatFragments() {
+		s.Fragments = true
+	}
+	if p.eatKeyword("having") {
+		s.Having = p.parseExpr()
+	}
+
+	if p.eatFragments() {
+		s.Fragments = true
+	}
+	if p.eatKeyword("window") {
+		for {
+			p.parseColId()
+			p.expectKeyword("as")
+			p.parseWindowSpec()
+			if !p.eat(sql_lexer.TComma) {
+				break
+			}
+		}
+	}
+
+	if p.eatFragments() {
+		s.Fragments = true
+	}
+	return s
+}
+
+func (p *parser) parseGroupingList() []sql_ast.Expr {
+	var items []sql_ast.Expr
+	for {
+		if p.isKeyword("grouping") && p.isKeywordAt(p.index+1, "sets") {
+			p.advance()
+			p.advance()
+			p.expect(sql_lexer.TOpenParen)
+			items = append(items, p.parseGroupingList()...)
+			p.expect(sql_lexer.TCloseParen)
+		} else if p.peek(sql_lexer.TOpenParen) && p.next().Kind == sql_lexer.TCloseParen {
+			// The empty grouping set
+			p.advance()
+			p.advance()
+		} else {
+			items = append(items, p.parseExpr())
+		}
+		if !p.eat(sql_lexer.TComma) {
+			return items
+		}
+	}
+}
+
+func (p *parser) parseSelectItems() []sql_ast.SelectItem {
+	var items []sql_ast.SelectItem
+	for {
+		var item sql_ast.SelectItem
+		if p.isOperator("*") {
+			item.Value = sql_ast.Expr{Loc: p.loc(), Data: &sql_ast.EColumn{Star: true}}
+			p.advance()
+		} else {
+			item.Value = p.parseExpr()
+			item.Alias = p.parseAlias(nil)
+		}
+		items = append(items, item)
+		if !p.eat(sql_lexer.TComma) {
+			return items
+		}
+	}
+}
+
+// parseAlias parses an optional alias. Without "AS", the alias can't be a reserved keyword or one
+// of the keywords in notAlias.
+func (p *parser) parseAlias(notAlias map[string]bool) sql_ast.Name {
+	if p.eatKeyword("as") {
+		return p.parseColLabel()
+	}
+	switch p.current().Kind {
+	case sql_lexer.TQuotedIdentifier:
+		return p.parseColLabel()
+	case sql_lexer.TIdentifier:
+		if keyword := p.keyword(); !reservedKeywords[keyword] && !typeFuncNameKeywords[keyword] && !notAlias[keyword] {
+			return p.parseColLabel()
+		}
+	}
+	return sql_ast.Name{}
+}
+
+func (p *parser) parseFromList() []sql_ast.TableExpr {
+	var tables []sql_ast.TableExpr
+	for {
+		tables = append(tables, p.parseTableExpr())
+		if !p.eat(sql_lexer.TComma) {
+			return tables
+		}
+	}
+}
+
+func (p *parser) parseTableExpr() sql_ast.TableExpr {
+	left := p.parseTablePrimary()
+	for {
+		loc := p.loc()
+		natural := p.eatKeyword("natural")
+		var kind string
+		switch {
+		case p.eatKeyword("cross"):
+			p.expectKeyword("join")
+			kind = "CROSS"
+		case p.eatKeyword("join"):
+			kind = "INNER"
+		case p.eatKeyword("inner"):
+			p.expectKeyword("join")
+			kind = "INNER"
+		case p.isKeyword("left"), p.isKeyword("right"), p.isKeyword("full"):
+			kind = strings.ToUpper(p.keyword())
+			p.advance()
+			p.eatKeyword("outer")
+			p.expectKeyword("join")
+		default:
+			if natural {
+				p.unexpected()
+			}
+			return left
+		}
+
+		join := &sql_ast.TJoin{Kind: kind, Natural: natural, Left: left, Right: p.parseTablePrimary()}
+		if kind != "CROSS" && !natural {
+			if p.eatKeyword("on") {
+				join.On = p.parseExpr()
+			} else if p.eatKeyword("using") {
+				join.Using = p.parseNameList()
+				if p.eatKeyword("as") {
+					p.parseColId()
+				}
+			} else {
+				p.unexpected()
+			}
+		}
+		left = sql_ast.TableExpr{Loc: loc, Data: join}
+	}
+}
+
+func (p *parser) parseTablePrimary() sql_ast.TableExpr {
+	loc := p.loc()
+	if p.eat(sql_lexer.TFragment) {
+		return sql_ast.TableExpr{Loc: loc, Data: &sql_ast.TFragment{}}
+	}
+
+	lateral := p.eatKeyword("lateral")
+	if p.peek(sql_lexer.TOpenParen) {
+		if p.isQueryStart(p.index + 1) {
+			p.advance()
+			sub := &sql_ast.TSubquery{Query: p.parseQuery(), Lateral: lateral}
+			p.expect(sql_lexer.TCloseParen)
+			sub.Alias = p.parseAlias(nil)
+			if sub.Alias.Text != "" && p.peek(sql_lexer.TOpenParen) {
+				sub.ColumnAliases = p.parseNameList()
+			}
+			return sql_ast.TableExpr{Loc: loc, Data: sub}
+		}
+		if lateral {
+			p.unexpected()
+		}
+
+		// A parenthesized join
+		p.advance()
+		table := p.parseTableExpr()
+		p.expect(sql_lexer.TCloseParen)
+		if alias := p.parseAlias(nil); alias.Text != "" && p.peek(sql_lexer.TOpenParen) {
+			p.parseNameList()
+		}
+		return table
+	}
+
+	if !lateral && p.isKeyword("only") {
+		table := p.parseTableName(true)
+		return sql_ast.TableExpr{Loc: loc, Data: &sql_ast.TTable{Table: table}}
+	}
+
+	// This is either a table or a function call
+	var fn *sql_ast.TFunction
+	var name []sql_ast.Name
+	if p.isKeyword("rows") && p.isKeywordAt(p.index+1, "from") && p.at(p.index+2).Kind == sql_lexer.TOpenParen {
+		fn = p.parseRowsFrom(lateral)
+	} else if name = p.parseQualifiedName(); p.peek(sql_lexer.TOpenParen) {
+		fn = &sql_ast.TFunction{Call: p.parseCall(name), Lateral: lateral}
+	}
+	if fn != nil {
+		if p.eatKeyword("with") {
+			p.expectKeyword("ordinality")
+		}
+		if p.isKeyword("as") && p.next().Kind == sql_lexer.TOpenParen {
+			// A column definition list without an alias, e.g. "json_to_record($1) AS (a int)"
+			p.advance()
+		} else {
+			fn.Alias = p.parseAlias(nil)
+		}
+		if p.peek(sql_lexer.TOpenParen) {
+			fn.ColumnAliases, fn.ColumnTypes = p.parseColumnDefList()
+		}
+		return sql_ast.TableExpr{Loc: loc, Data: fn}
+	}
+	if lateral {
+		p.unexpected()
+	}
+
+	table := &sql_ast.TTable{Table: p.tableNameFromPath(loc, name)}
+	if p.isOperator("*") {
+		// Include descendant tables, which is the default anyway
+		p.advance()
+	}
+	table.Table.Alias = p.parseAlias(nil)
+	if table.Table.Alias.Text != "" && p.peek(sql_lexer.TOpenParen) {
+		table.ColumnAliases = p.parseNameList()
+	}
+	if p.eatKeyword("tablesample") {
+		p.parseQualifiedName()
+		p.parseParenExprList()
+		if p.eatKeyword("repeatable") {
+			p.parseParenExprList()
+		}
+	}
+	return sql_ast.TableExpr{Loc: loc, Data: table}
+}
+
+// parseRowsFrom parses "ROWS FROM (f(...) [AS (coldefs)], ...)", the calls after the first are in RowsFrom
+func (p *parser) parseRowsFrom(lateral bool) *sql_ast.TFunction {
+	p.advance()
+	p.advance()
+	p.expect(sql_lexer.TOpenParen)
+	fn := &sql_ast.TFunction{Lateral: lateral}
+	for {
+		call := p.parseCall(p.parseQualifiedName())
+		if fn.Call == nil {
+			fn.Call = call
+		} else {
+			fn.RowsFrom = append(fn.RowsFrom, call)
+		}
+		if p.eatKeyword("as") {
+			p.parseColumnDefList()
+		}
+		if !p.eat(sql_lexer.TComma) {
+			break
+		}
+	}
+	p.expect(sql_lexer.TCloseParen)
+	return fn
+}
+
+// parseTableName parses a table name for INSERT, UPDATE, DELETE and TABLE
+func (p *parser) parseTableName(allowOnly bool) sql_ast.TableName {
+	loc := p.loc()
+	only := allowOnly && p.eatKeyword("only")
+	table := p.tableNameFromPath(loc, p.parseQualifiedName())
+	table.Only = only
+	if only && p.isOperator("*") {
+		p.unexpected()
+	} else if p.isOperator("*") {
+		p.advance()
+	}
+	return table
+}
+
+func (p *parser) tableNameFromPath(loc logger.Loc, path []sql_ast.Name) sql_ast.TableName {
+	if len(path) > 3 {
+		p.index-- // report the last name
+		p.unexpected()
+	}
+	table := sql_ast.TableName{Loc: loc, Name: path[len(path)-1].Text}
+	if len(path) > 1 {
+		table.Schema = path[len(path)-2].Text
+	}
+	return table
+}
+
+func (p *parser) parseQualifiedName() []sql_ast.Name {
+	path := []sql_ast.Name{p.parseColId()}
+	for p.eat(sql_lexer.TDot) {
+		path = append(path, p.parseColLabel())
+	}
+	return path
+}
+
+// parseColId parses a name that can't be a reserved keyword
+func (p *parser) parseColId() sql_ast.Name {
+	if keyword := p.keyword(); reservedKeywords[keyword] || typeFuncNameKeywords[keyword] {
+		p.unexpected()
+	}
+	return p.parseColLabel()
+}
+
+// parseColLabel parses any name, including reserved keywords
+func (p *parser) parseColLabel() sql_ast.Name {
+	switch p.current().Kind {
+	case sql_lexer.TIdentifier, sql_lexer.TQuotedIdentifier:
+		name := sql_ast.Name{Loc: p.loc(), Text: p.decoded()}
+		p.advance()
+		return name
+	}
+	p.unexpected()
+	return sql_ast.Name{}
+}
+
+// parseColumnDefList parses the column aliases of a function in FROM, which can have types for a
+// function returning record, e.g. "json_to_recordset($1) AS x(a int, b text)". The types are only
+// returned if every column has one.
+func (p *parser) parseColumnDefList() (names []sql_ast.Name, types []sql_ast.Type) {
+	p.expect(sql_lexer.TOpenParen)
+	typed := true
+	for {
+		names = append(names, p.parseColId())
+		if p.peek(sql_lexer.TComma) || p.peek(sql_lexer.TCloseParen) {
+			typed = false
+		} else {
+			types = append(types, p.parseType())
+			if p.eatKeyword("collate") {
+				p.parseQualifiedName()
+			}
+		}
+		if !p.eat(sql_lexer.TComma) {
+			break
+		}
+	}
+	p.expect(sql_lexer.TCloseParen)
+	if !typed {
+		types = nil
+	}
+	return
+}
+
+func (p *parser) parseNameList() []sql_ast.Name {
+	p.expect(sql_lexer.TOpenParen)
+	var names []sql_ast.Name
+	for {
+		names = append(names, p.parseColId())
+		if !p.eat(sql_lexer.TComma) {
+			break
+		}
+	}
+	p.expect(sql_lexer.TCloseParen)
+	return names
+}
+
+func (p *parser) parseInsert() *sql_ast.SInsert {
+	p.expectKeyword("insert")
+	p.expectKeyword("into")
+	insert := &sql_ast.SInsert{Table: p.parseTableName(false)}
+	if p.eatKeyword("as") {
+		insert.Table.Alias = p.parseColId()
+	}
+
+	if p.peek(sql_lexer.TOpenParen) && !p.isQueryStart(p.index+1) {
+		p.advance()
+		for {
+			insert.Columns = append(insert.Columns, p.parseColId())
+			p.skipIndirection()
+			if !p.eat(sql_lexer.TComma) {
+				break
+			}
+		}
+		p.expect(sql_lexer.TCloseParen)
+	}
+
+	if p.eatKeyword("overriding") {
+		if !p.eatKeyword("system") {
+			p.expectKeyword("user")
+		}
+		p.expectKeyword("value")
+	}
+
+	if p.eatKeyword("default") {
+		p.expectKeyword("values")
+	} else {
+		insert.Query = p.parseQuery()
+	}
+
+	p.eatFragments()
+	if p.eatKeyword("on") {
+		p.expectKeyword("conflict")
+		insert.OnConflict = &sql_ast.OnConflict{}
+		if p.eatKeyword("on") {
+			p.expectKeyword("constraint")
+			p.parseColId()
+		} else if p.peek(sql_lexer.TOpenParen) {
+			p.parseParenExprList()
+			if p.eatKeyword("where") {
+				p.parseExpr()
+			}
+		}
+		p.expectKeyword("do")
+		if p.eatKeyword("nothing") {
+			insert.OnConflict.DoNothing = true
+		} else {
+			p.expectKeyword("update")
+			p.expectKeyword("set")
+			insert.OnConflict.Set = p.parseAssignments()
+			if p.eatKeyword("where") {
+				insert.OnConflict.Where = p.parseExpr()
+			}
+		}
+	}
+
+	p.eatFragments()
+	if p.eatKeyword("returning") {
+		insert.Returning = p.parseSelectItems()
+	}
+	return insert
+}
+
+// skipIndirection skips the field selections and subscripts on an assignment target
+func (p *parser) skipIndirection() {
+	for {
+		if p.eat(sql_lexer.TDot) {
+			p.parseColLabel()
+		} else if p.eat(sql_lexer.TOpenBracket) {
+			p.parseExpr()
+			if p.eat(sql_lexer.TColon) {
+				p.parseExpr()
+			}
+			p.expect(sql_lexer.TCloseBracket)
+		} else {
+			return
+		}
+	}
+}
+
+var notUpdateAlias = map[string]bool{"set": true}
+
+func (p *parser) parseUpdate() *sql_ast.SUpdate {
+	p.expectKeyword("update")
+	update := &sql_ast.SUpdate{Table: p.parseTableName(true)}
+	update.Table.Alias = p.parseAlias(notUpdateAlias)
+
+	p.expectKeyword("set")
+	update.Set = p.parseAssignments()
+
+	if p.eatFragments() {
+		update.Fragments = true
+	}
+	if p.eatKeyword("from") {
+		update.From = p.parseFromList()
+	}
+
+	if p.eatFragments() {
+		update.Fragments = true
+	}
+	if p.eatKeyword("where") {
+		update.Where = p.parseWhereOrCurrentOf()
+	}
+
+	if p.eatFragments() {
+		update.Fragments = true
+	}
+	if p.eatKeyword("returning") {
+		update.Returning = p.parseSelectItems()
+	}
+	return update
+}
+
+func (p *parser) parseAssignments() []sql_ast.Assignment {
+	var assignments []sql_ast.Assignment
+	for {
+		var assignment sql_ast.Assignment
+		if p.eat(sql_lexer.TFragment) {
+			// The fragment contains one or more assignments
+			assignment.Value = sql_ast.Expr{Loc: p.at(p.index - 1).Range.Loc, Data: &sql_ast.EFragment{}}
+		} else {
+			if p.eat(sql_lexer.TOpenParen) {
+				for {
+					assignment.Columns = append(assignment.Columns, p.parseColId())
+					p.skipIndirection()
+					if !p.eat(sql_lexer.TComma) {
+						break
+					}
+				}
+				p.expect(sql_lexer.TCloseParen)
+			} else {
+				assignment.Columns = []sql_ast.Name{p.parseColId()}
+				p.skipIndirection()
+			}
+			if !p.isOperator("=") {
+				p.unexpected()
+			}
+			p.advance()
+			assignment.Value = p.parseExpr()
+		}
+		assignments = append(assignments, assignment)
+		if !p.eat(sql_lexer.TComma) {
+			return assignments
+		}
+	}
+}
+
+func (p *parser) parseWhereOrCurrentOf() sql_ast.Expr {
+	if p.isKeyword("current") && p.isKeywordAt(p.index+1, "of") {
+		loc := p.loc()
+		p.advance()
+		p.advance()
+		p.parseColId()
+		return sql_ast.Expr{Loc: loc, Data: &sql_ast.EKeyword{Name: "CURRENT OF"}}
+	}
+	return p.parseExpr()
+}
+
+func (p *parser) parseDelete() *sql_ast.SDelete {
+	p.expectKeyword("delete")
+	p.expectKeyword("from")
+	del := &sql_ast.SDelete{Table: p.parseTableName(true)}
+	del.Table.Alias = p.parseAlias(nil)
+
+	if p.eatFragments() {
+		del.Fragments = true
+	}
+	if p.eatKeyword("using") {
+		del.Using = p.parseFromList()
+	}
+
+	if p.eatFragments() {
+		del.Fragments = true
+	}
+	if p.eatKeyword("where") {
+		del.Where = p.parseWhereOrCurrentOf()
+	}
+
+	if p.eatFragments() {
+		del.Fragments = true
+	}
+	if p.eatKeyword("returning") {
+		del.Returning = p.parseSelectItems()
+	}
+	return del
+}
+
+func (p *parser) parseCreate() sql_ast.S {
+	p.expectKeyword("create")
+	if p.eatKeyword("or") {
+		p.expectKeyword("replace")
+	}
+	for p.eatKeyword("global") || p.eatKeyword("local") || p.eatKeyword("temporary") ||
+		p.eatKeyword("temp") || p.eatKeyword("unlogged") || p.eatKeyword("recursive") {
+	}
+
+	switch {
+	case p.eatKeyword("table"):
+		return p.parseCreateTable()
+
+	case p.eatKeyword("view"):
+		return p.parseCreateView()
+
+	case p.isKeyword("materialized") && p.isKeywordAt(p.index+1, "view"):
+		p.advance()
+		p.advance()
+		return p.parseCreateView()
+	}
+
+	p.parseOther()
+	return &sql_ast.SOther{Keyword: "create"}
+}
+
+// These keywords start a table constraint in "CREATE TABLE" and "ALTER TABLE ... ADD"
+var tableConstraintKeywords = map[string]bool{
+	"check": true, "constraint": true, "exclude": true, "foreign": true, "like": true,
+	"primary": true, "unique": true,
+}
+
+func (p *parser) parseCreateTable() *sql_ast.SCreateTable {
+	create := &sql_ast.SCreateTable{}
+	if p.eatKeyword("if") {
+		p.expectKeyword("not")
+		p.expectKeyword("exists")
+		create.IfNotExists = true
+	}
+	create.Table = p.parseTableName(false)
+
+	if p.eat(sql_lexer.TOpenParen) {
+		for !p.peek(sql_lexer.TCloseParen) {
+			if tableConstraintKeywords[p.keyword()] {
+				// A primary key makes its columns "NOT NULL"
+				if p.eatKeyword("constraint") {
+					p.parseColId()
+				}
+				if p.isKeyword("primary") && p.isKeywordAt(p.index+1, "key") {
+					p.advance()
+					p.advance()
+					for _, name := range p.parseNameList() {
+						for i := range create.Columns {
+							if create.Columns[i].Name.Text == name.Text {
+								create.Columns[i].NotNull = true
+							}
+						}
+					}
+				}
+				p.skipListItem()
+			} else {
+				column := sql_ast.ColumnDef{Name: p.parseColId(), Type: p.parseType()}
+				column.NotNull = p.skipListItem()
+				create.Columns = append(create.Columns, column)
+			}
+			if !p.eat(sql_lexer.TComma) {
+				break
+			}
+		}
+		p.expect(sql_lexer.TCloseParen)
+	}
+
+	if p.eatKeyword("as") {
+		create.Query = p.parseQuery()
+	}
+
+	// Skip storage options such as "INHERITS (...)", "PARTITION BY ..." or "WITH NO DATA"
+	p.parseOther()
+	return create
+}
+
+func (p *parser) parseCreateView() *sql_ast.SCreateView {
+	view := &sql_ast.SCreateView{}
+	if p.eatKeyword("if") {
+		p.expectKeyword("not")
+		p.expectKeyword("exists")
+	}
+	view.Table = p.parseTableName(false)
+	if p.peek(sql_lexer.TOpenParen) {
+		view.Columns = p.parseNameList()
+	}
+	if p.eatKeyword("with") {
+		// View options, e.g. "WITH (security_barrier = true)"
+		p.parseParenExprList()
+	}
+	p.expectKeyword("as")
+	view.Query = p.parseQuery()
+
+	// Skip "WITH CHECK OPTION" or "WITH NO DATA"
+	p.parseOther()
+	return view
+}
+
+// skipListItem skips to the next comma or to the end of the enclosing list or statement, checking
+// that the brackets are balanced. When skipping the constraints after a column definition, it
+// returns true if they make the column "NOT NULL", which is also the case for a primary key.
+func (p *parser) skipListItem() (notNull bool) {
+	depth := 0
+	for {
+		switch p.current().Kind {
+		case sql_lexer.TOpenParen, sql_lexer.TOpenBracket:
+			depth++
+
+		case sql_lexer.TCloseParen, sql_lexer.TCloseBracket:
+			if depth == 0 {
+				return notNull
+			}
+			depth--
+
+		case sql_lexer.TComma:
+			if depth == 0 {
+				return notNull
+			}
+
+		case sql_lexer.TSemicolon, sql_lexer.TEndOfFile, sql_lexer.TSyntaxError:
+			if depth != 0 {
+				p.unexpected()
+			}
+			return notNull
+
+		case sql_lexer.TIdentifier:
+			if depth == 0 {
+				if (p.isKeyword("not") && p.isKeywordAt(p.index+1, "null")) ||
+					(p.isKeyword("primary") && p.isKeywordAt(p.index+1, "key")) {
+					notNull = true
+				}
+			}
+		}
+		p.advance()
+	}
+}
+
+func (p *parser) parseAlter() sql_ast.S {
+	p.expectKeyword("alter")
+	if !p.eatKeyword("table") {
+		p.parseOther()
+		return &sql_ast.SOther{Keyword: "alter"}
+	}
+
+	alter := &sql_ast.SAlterTable{}
+	if p.eatKeyword("if") {
+		p.expectKeyword("exists")
+	}
+	alter.Table = p.parseTableName(true)
+	if p.isKeyword("all") || p.isKeyword("attach") || p.isKeyword("detach") ||
+		(p.isKeyword("set") && p.isKeywordAt(p.index+1, "schema")) {
+		// These don't change the columns of this table
+		p.parseOther()
+		return alter
+	}
+
+	for {
+		alter.Actions = append(alter.Actions, p.parseAlterAction())
+		if !p.eat(sql_lexer.TComma) {
+			return alter
+		}
+	}
+}
+
+func (p *parser) parseAlterAction() sql_ast.AlterAction {
+	switch {
+	case p.eatKeyword("add"):
+		p.eatKeyword("column")
+		if tableConstraintKeywords[p.keyword()] {
+			break
+		}
+		if p.eatKeyword("if") {
+			p.expectKeyword("not")
+			p.expectKeyword("exists")
+		}
+		column := sql_ast.ColumnDef{Name: p.parseColId(), Type: p.parseType()}
+		column.NotNull = p.skipListItem()
+		return sql_ast.AlterAction{Kind: sql_ast.AlterAddColumn, Column: column}
+
+	case p.eatKeyword("drop"):
+		if p.isKeyword("constraint") {
+			break
+		}
+		p.eatKeyword("column")
+		if p.eatKeyword("if") {
+			p.expectKeyword("exists")
+		}
+		action := sql_ast.AlterAction{Kind: sql_ast.AlterDropColumn, Column: sql_ast.ColumnDef{Name: p.parseColId()}}
+		p.skipListItem()
+		return action
+
+	case p.eatKeyword("rename"):
+		if p.eatKeyword("to") {
+			return sql_ast.AlterAction{Kind: sql_ast.AlterRenameTable, NewName: p.parseColId()}
+		}
+		if p.isKeyword("constraint") {
+			break
+		}
+		p.eatKeyword("column")
+		action := sql_ast.AlterAction{Kind: sql_ast.AlterRenameColumn, Column: sql_ast.ColumnDef{Name: p.parseColId()}}
+		p.expectKeyword("to")
+		action.NewName = p.parseColId()
+		return action
+
+	case p.eatKeyword("alter"):
+		p.eatKeyword("column")
+		action := sql_ast.AlterAction{Column: sql_ast.ColumnDef{Name: p.parseColId()}}
+		switch {
+		case p.isKeyword("type"), (p.isKeyword("set") && p.isKeywordAt(p.index+1, "data")):
+			if p.eatKeyword("set") {
+				p.advance()
+			}
+			p.expectKeyword("type")
+			action.Kind = sql_ast.AlterColumnType
+			action.Column.Type = p.parseType()
+
+		case (p.isKeyword("set") || p.isKeyword("drop")) && p.isKeywordAt(p.index+1, "not") && p.isKeywordAt(p.index+2, "null"):
+			action.Kind = sql_ast.AlterSetNotNull
+			if p.isKeyword("drop") {
+				action.Kind = sql_ast.AlterDropNotNull
+			}
+			p.advance()
+			p.advance()
+			p.advance()
+		}
+		p.skipListItem()
+		return action
+	}
+
+	p.skipListItem()
+	return sql_ast.AlterAction{Kind: sql_ast.AlterOther}
+}
+
+func (p *parser) parseDrop() sql_ast.S {
+	p.expectKeyword("drop")
+	if !p.eatKeyword("table") && !p.eatKeyword("view") {
+		if !p.isKeyword("materialized") || !p.isKeywordAt(p.index+1, "view") {
+			p.parseOther()
+			return &sql_ast.SOther{Keyword: "drop"}
+		}
+		p.advance()
+		p.advance()
+	}
+
+	drop := &sql_ast.SDropTable{}
+	if p.eatKeyword("if") {
+		p.expectKeyword("exists")
+	}
+	for {
+		drop.Tables = append(drop.Tables, p.parseTableName(false))
+		if !p.eat(sql_lexer.TComma) {
+			break
+		}
+	}
+	if !p.eatKeyword("cascade") {
+		p.eatKeyword("restrict")
+	}
+	return drop
+}
+
+func (p *parser) parseParenExprList() []sql_ast.Expr {
+	p.expect(sql_lexer.TOpenParen)
+	items := p.parseExprList()
+	p.expect(sql_lexer.TCloseParen)
+	return items
+}
+
+func (p *parser) parseExprList() []sql_ast.Expr {
+	var items []sql_ast.Expr
+	for {
+		items = append(items, p.parseExpr())
+		if !p.eat(sql_lexer.TComma) {
+			return items
+		}
+	}
+}
+
+func (p *parser) parseWindowSpec() *sql_ast.Window {
+	window := &sql_ast.Window{}
+	p.expect(sql_lexer.TOpenParen)
+	if p.peek(sql_lexer.TIdentifier) && !p.isKeyword("partition") && !p.isKeyword("order") &&
+		!p.isKeyword("rows") && !p.isKeyword("range") && !p.isKeyword("groups") {
+		window.Name = p.parseColId().Text
+	}
+	if p.eatKeyword("partition") {
+		p.expectKeyword("by")
+		window.PartitionBy = p.parseExprList()
+	}
+	if p.eatKeyword("order") {
+		p.expectKeyword("by")
+		window.OrderBy = p.parseOrderItems()
+	}
+	if p.eatKeyword("rows") || p.eatKeyword("range") || p.eatKeyword("groups") {
+		if p.eatKeyword("between") {
+			p.parseFrameBound()
+			p.expectKeyword("and")
+		}
+		p.parseFrameBound()
+		if p.eatKeyword("exclude") {
+			switch {
+			case p.eatKeyword("current"):
+				p.expectKeyword("row")
+			case p.eatKeyword("no"):
+				p.expectKeyword("others")
+			case p.eatKeyword("group"), p.eatKeyword("ties"):
+			default:
+				p.unexpected()
+			}
+		}
+	}
+	p.expect(sql_lexer.TCloseParen)
+	return window
+}
+
+func (p *parser) parseFrameBound() {
+	switch {
+	case p.eatKeyword("unbounded"):
+	case p.eatKeyword("current"):
+		p.expectKeyword("row")
+		return
+	default:
+		p.parseExprLevel(levelAnd)
+	}
+	if !p.eatKeyword("preceding") {
+		p.expectKeyword("following")
+	}
+}
+
+type level uint8
+
+// These are the PostgreSQL operator precedence levels from lowest to highest
+const (
+	levelLowest level = iota
+	levelOr
+	levelAnd
+	levelNot
+	levelIs
+	levelCompare
+	levelIn // BETWEEN, IN, LIKE, ILIKE and SIMILAR
+	levelOp // any other operator
+	levelAdd
+	levelMultiply
+	levelExponent
+	levelAtTimeZone
+	levelCollate
+	levelPrefix
+	levelSubscript
+	levelCast
+)
+
+func (p *parser) parseExpr() sql_ast.Expr {
+	return p.parseExprLevel(levelLowest)
+}
+
+func (p *parser) parseExprLevel(level level) sql_ast.Expr {
+	loc := p.loc()
+	var expr sql_ast.Expr
+
+	if p.peek(sql_lexer.TOperator) {
+		op := p.raw()
+		if binaryOnlyOperators[op] {
+			p.unexpected()
+		}
+		p.advance()
+		if op == "+" || op == "-" {
+			expr = sql_ast.Expr{Loc: loc, Data: &sql_ast.EUnary{Op: op, Value: p.parseExprLevel(levelPrefix)}}
+		} else {
+			expr = sql_ast.Expr{Loc: loc, Data: &sql_ast.EUnary{Op: op, Value: p.parseExprLevel(levelOp)}}
+		}
+	} else if p.isQualifiedOperator() {
+		op := p.parseQualifiedOperator()
+		expr = sql_ast.Expr{Loc: loc, Data: &sql_ast.EUnary{Op: op, Value: p.parseExprLevel(levelOp)}}
+	} else if p.eatKeyword("not") {
+		expr = sql_ast.Expr{Loc: loc, Data: &sql_ast.EUnary{Op: "NOT", Value: p.parseExprLevel(levelNot)}}
+	} else {
+		expr = p.parsePrimary()
+	}
+
+	return p.parseSuffix(expr, level)
+}
+
+// isQualifiedOperator returns true at an operator qualified with a schema, e.g. "OPERATOR(pg_catalog.+)"
+func (p *parser) isQualifiedOperator() bool {
+	return p.isKeyword("operator") && p.next().Kind == sql_lexer.TOpenParen
+}
+
+func (p *parser) parseQualifiedOperator() string {
+	p.advance()
+	p.expect(sql_lexer.TOpenParen)
+	var path []string
+	for p.peek(sql_lexer.TIdentifier) || p.peek(sql_lexer.TQuotedIdentifier) {
+		path = append(path, p.decoded())
+		p.advance()
+		p.expect(sql_lexer.TDot)
+	}
+	if !p.peek(sql_lexer.TOperator) {
+		p.unexpected()
+	}
+	path = append(path, p.raw())
+	p.advance()
+	p.expect(sql_lexer.TCloseParen)
+	return "OPERATOR(" + strings.Join(path, ".") + ")"
+}
+
+// These operators can't be used as prefix operators
+var binaryOnlyOperators = map[string]bool{
+	"*": true, "/": true, "%": true, "^": true, "=": true, "<": true, ">": true, "<=": true,
+	">=": true, "<>": true, "!=": true, "::": true, "=>": true, "||": true,
+}
+
+func binaryOpLevel(op string) level {
+	switch op {
+	case "=", "<", ">", "<=", ">=", "<>", "!=":
+		return levelCompare
+	case "+", "-":
+		return levelAdd
+	case "*", "/", "%":
+		return levelMultiply
+	case "^":
+		return levelExponent
+	}
+	return levelOp
+}
+
+func (p *parser) parseSuffix(left sql_ast.Expr, level level) sql_ast.Expr {
+	for {
+		loc := left.Loc
+
+		switch p.current().Kind {
+		case sql_lexer.TOperator:
+			op := p.raw()
+			if op == "::" {
+				if level >= levelCast {
+					return left
+				}
+				p.advance()
+				left = sql_ast.Expr{Loc: loc, Data: &sql_ast.ECast{Value: left, Type: p.parseType()}}
+				continue
+			}
+
+			opLevel := binaryOpLevel(op)
+			if level >= opLevel {
+				return left
+			}
+			p.advance()
+
+			// "op ANY (...)", "op SOME (...)" and "op ALL (...)"
+			if quantifier := p.keyword(); (quantifier == "any" || quantifier == "some" || quantifier == "all") &&
+				p.next().Kind == sql_lexer.TOpenParen {
+				p.advance()
+				left = sql_ast.Expr{Loc: loc, Data: &sql_ast.ESubqueryOp{
+					Op:         op,
+					Quantifier: strings.ToUpper(quantifier),
+					Left:       left,
+					Right:      p.parseParenExprOrQuery(),
+				}}
+				continue
+			}
+
+			left = sql_ast.Expr{Loc: loc, Data: &sql_ast.EBinary{Op: op, Left: left, Right: p.parseExprLevel(opLevel)}}
+
+		case sql_lexer.TOpenBracket:
+			if level >= levelSubscript {
+				return left
+			}
+			p.advance()
+			index := &sql_ast.EIndex{Target: left}
+			if !p.peek(sql_lexer.TColon) {
+				index.Index = p.parseExpr()
+			}
+			if p.eat(sql_lexer.TColon) && !p.peek(sql_lexer.TCloseBracket) {
+				index.End = p.parseExpr()
+			}
+			p.expect(sql_lexer.TCloseBracket)
+			left = sql_ast.Expr{Loc: loc, Data: index}
+
+		case sql_lexer.TIdentifier:
+			keyword := p.keyword()
+			not := false
+			if keyword == "not" {
+				switch next := strings.ToLower(p.next().Raw(p.source.Contents)); next {
+				case "in", "like", "ilike", "between", "similar":
+					if p.next().Kind != sql_lexer.TIdentifier {
+						return left
+					}
+					keyword = next
+					not = true
+				default:
+					return left
+				}
+			}
+
+			switch keyword {
+			case "and", "or":
+				opLevel := levelAnd
+				if keyword == "or" {
+					opLevel = levelOr
+				}
+				if level >= opLevel {
+					return left
+				}
+				p.advance()
+				left = sql_ast.Expr{Loc: loc, Data: &sql_ast.EBinary{Op: strings.ToUpper(keyword), Left: left, Right: p.parseExprLevel(opLevel)}}
+
+			case "is":
+				if level >= levelIs {
+					return left
+				}
+				p.advance()
+				not := p.eatKeyword("not")
+				switch test := p.keyword(); test {
+				case "null", "true", "false", "unknown", "document":
+					p.advance()
+					left = sql_ast.Expr{Loc: loc, Data: &sql_ast.EIs{Value: left, Test: strings.ToUpper(test), Not: not}}
+				case "json":
+					// "IS JSON [VALUE | SCALAR | ARRAY | OBJECT] [{WITH | WITHOUT} UNIQUE [KEYS]]"
+					p.advance()
+					is := &sql_ast.EIs{Value: left, Test: "JSON", Not: not}
+					switch kind := p.keyword(); kind {
+					case "value", "scalar", "array", "object":
+						p.advance()
+						is.Test += " " + strings.ToUpper(kind)
+					}
+					if (p.isKeyword("with") || p.isKeyword("without")) && p.isKeywordAt(p.index+1, "unique") {
+						p.advance()
+						p.advance()
+						p.eatKeyword("keys")
+					}
+					left = sql_ast.Expr{Loc: loc, Data: is}
+				case "nfc", "nfd", "nfkc", "nfkd", "normalized":
+					// "IS [NFC | NFD | NFKC | NFKD] NORMALIZED"
+					p.advance()
+					if test != "normalized" {
+						p.expectKeyword("normalized")
+						test += " normalized"
+					}
+					left = sql_ast.Expr{Loc: loc, Data: &sql_ast.EIs{Value: left, Test: strings.ToUpper(test), Not: not}}
+				case "distinct":
+					p.advance()
+					p.expectKeyword("from")
+					op := "IS DISTINCT FROM"
+					if not {
+						op = "IS NOT DISTINCT FROM"
+					}
+					left = sql_ast.Expr{Loc: loc, Data: &sql_ast.EBinary{Op: op, Left: left, Right: p.parseExprLevel(levelIs)}}
+				default:
+					p.unexpected()
+				}
+
+			case "operator":
+				if level >= levelOp || !p.isQualifiedOperator() {
+					return left
+				}
+				op := p.parseQualifiedOperator()
+				left = sql_ast.Expr{Loc: loc, Data: &sql_ast.EBinary{Op: op, Left: left, Right: p.parseExprLevel(levelOp)}}
+
+			case "isnull", "notnull":
+				if level >= levelIs {
+					return left
+				}
+				p.advance()
+				left = sql_ast.Expr{Loc: loc, Data: &sql_ast.EIs{Value: left, Test: "NULL", Not: keyword == "notnull"}}
+
+			case "in":
+				if level >= levelIn {
+					return left
+				}
+				if not {
+					p.advance()
+				}
+				p.advance()
+				in := &sql_ast.EIn{Value: left, Not: not}
+				if p.eat(sql_lexer.TFragment) {
+					in.List = []sql_ast.Expr{{Loc: p.at(p.index - 1).Range.Loc, Data: &sql_ast.EFragment{}}}
+				} else if p.isQueryStart(p.index+1) && p.peek(sql_lexer.TOpenParen) {
+					p.advance()
+					in.Query = p.parseQuery()
+					p.expect(sql_lexer.TCloseParen)
+				} else {
+					in.List = p.parseParenExprList()
+				}
+				left = sql_ast.Expr{Loc: loc, Data: in}
+
+			case "like", "ilike", "similar":
+				if level >= levelIn {
+					return left
+				}
+				if not {
+					p.advance()
+				}
+				p.advance()
+				op := strings.ToUpper(keyword)
+				if keyword == "similar" {
+					p.expectKeyword("to")
+					op = "SIMILAR TO"
+				}
+				if not {
+					op = "NOT " + op
+				}
+				// "LIKE ANY (...)" and "LIKE ALL (...)"
+				if quantifier := p.keyword(); (quantifier == "any" || quantifier == "some" || quantifier == "all") &&
+					p.next().Kind == sql_lexer.TOpenParen {
+					p.advance()
+					left = sql_ast.Expr{Loc: loc, Data: &sql_ast.ESubqueryOp{
+						Op:         op,
+						Quantifier: strings.ToUpper(quantifier),
+						Left:       left,
+						Right:      p.parseParenExprOrQuery(),
+					}}
+					continue
+				}
+				right := p.parseExprLevel(levelIn)
+				if p.eatKeyword("escape") {
+					p.parseExprLevel(levelIn)
+				}
+				left = sql_ast.Expr{Loc: loc, Data: &sql_ast.EBinary{Op: op, Left: left, Right: right}}
+
+			case "between":
+				if level >= levelIn {
+					return left
+				}
+				if not {
+					p.advance()
+				}
+				p.advance()
+				if !p.eatKeyword("symmetric") {
+					p.eatKeyword("asymmetric")
+				}
+				between := &sql_ast.EBetween{Value: left, Not: not}
+				between.Low = p.parseExprLevel(levelIn)
+				p.expectKeyword("and")
+				between.High = p.parseExprLevel(levelIn)
+				left = sql_ast.Expr{Loc: loc, Data: between}
+
+			case "at":
+				if level >= levelAtTimeZone || !p.isKeywordAt(p.index+1, "time") {
+					return left
+				}
+				p.advance()
+				p.advance()
+				p.expectKeyword("zone")
+				left = sql_ast.Expr{Loc: loc, Data: &sql_ast.EBinary{Op: "AT TIME ZONE", Left: left, Right: p.parseExprLevel(levelAtTimeZone)}}
+
+			case "collate":
+				if level >= levelCollate {
+					return left
+				}
+				p.advance()
+				var collation []string
+				for _, name := range p.parseQualifiedName() {
+					collation = append(collation, name.Text)
+				}
+				right := sql_ast.Expr{Loc: loc, Data: &sql_ast.EString{Value: strings.Join(collation, ".")}}
+				left = sql_ast.Expr{Loc: loc, Data: &sql_ast.EBinary{Op: "COLLATE", Left: left, Right: right}}
+
+			default:
+				return left
+			}
+
+		default:
+			return left
+		}
+	}
+}
+
+// parseParenExprOrQuery parses "(expr)" or "(query)", which is the right side of ANY, SOME and ALL
+func (p *parser) parseParenExprOrQuery() sql_ast.Expr {
+	loc := p.loc()
+	p.expect(sql_lexer.TOpenParen)
+	var expr sql_ast.Expr
+	if p.isQueryStart(p.index) {
+		expr = sql_ast.Expr{Loc: loc, Data: &sql_ast.ESubquery{Query: p.parseQuery()}}
+	} else {
+		expr = p.parseExpr()
+	}
+	p.expect(sql_lexer.TCloseParen)
+	return expr
+}
+
+// These keywords are values that are written without parentheses
+var keywordValues = map[string]bool{
+	"current_catalog": true, "current_date": true, "current_role": true, "current_schema": true,
+	"current_time": true, "current_timestamp": true, "current_user": true, "localtime": true,
+	"localtimestamp": true, "session_user": true, "user": true,
+}
+
+// These functions have arguments separated by keywords
+var specialArgFunctions = map[string]bool{
+	"extract": true, "overlay": true, "position": true, "substring": true, "trim": true,
+}
+
+func (p *parser) parsePrimary() sql_ast.Expr {
+	loc := p.loc()
+	t := p.current()
+
+	switch t.Kind {
+	case sql_lexer.TNumber:
+		p.advance()
+		return sql_ast.Expr{Loc: loc, Data: &sql_ast.ENumber{Value: t.Raw(p.source.Contents)}}
+
+	case sql_lexer.TString:
+		p.advance()
+		value := t.DecodedText(p.source.Contents)
+
+		// String constants separated by whitespace with a newline are concatenated, e.g. 'a'\n'b'
+		for p.peek(sql_lexer.TString) {
+			between := p.source.Contents[t.Range.End():p.current().Range.Loc.Start]
+			if !strings.Contains(between, "\n") || strings.TrimSpace(between) != "" {
+				break
+			}
+			t = p.current()
+			p.advance()
+			value += t.DecodedText(p.source.Contents)
+		}
+		return sql_ast.Expr{Loc: loc, Data: &sql_ast.EString{Value: value}}
+
+	case sql_lexer.TParam:
+		p.advance()
+		index, _ := strconv.Atoi(t.Raw(p.source.Contents)[1:])
+		return sql_ast.Expr{Loc: loc, Data: &sql_ast.EParam{Index: index}}
+
+	case sql_lexer.TServerVar:
+		p.advance()
+		return sql_ast.Expr{Loc: loc, Data: &sql_ast.EServerVar{Name: t.DecodedText(p.source.Contents)}}
+
+	case sql_lexer.TFragment:
+		p.advance()
+		return sql_ast.Expr{Loc: loc, Data: &sql_ast.EFragment{}}
+
+	case sql_lexer.TOpenParen:
+		if p.isKeywordAt(p.index+1, "select") || p.isKeywordAt(p.index+1, "values") || p.isKeywordAt(p.index+1, "with") {
+			p.advance()
+			q := p.parseQuery()
+			p.expect(sql_lexer.TCloseParen)
+			return p.parseFieldSelection(sql_ast.Expr{Loc: loc, Data: &sql_ast.ESubquery{Query: q}})
+		}
+		p.advance()
+		expr := p.parseExpr()
+		if p.peek(sql_lexer.TComma) {
+			row := &sql_ast.ERow{Items: []sql_ast.Expr{expr}}
+			for p.eat(sql_lexer.TComma) {
+				row.Items = append(row.Items, p.parseExpr())
+			}
+			expr = sql_ast.Expr{Loc: loc, Data: row}
+		}
+		p.expect(sql_lexer.TCloseParen)
+		return p.parseFieldSelection(expr)
+
+	case sql_lexer.TIdentifier:
+		keyword := p.keyword()
+		switch keyword {
+		case "null":
+			p.advance()
+			return sql_ast.Expr{Loc: loc, Data: &sql_ast.ENull{}}
+
+		case "true", "false":
+			p.advance()
+			return sql_ast.Expr{Loc: loc, Data: &sql_ast.EBoolean{Value: keyword == "true"}}
+
+		case "default":
+			p.advance()
+			return sql_ast.Expr{Loc: loc, Data: &sql_ast.EDefault{}}
+
+		case "case":
+			return p.parseCase()
+
+		case "cast":
+			p.advance()
+			p.expect(sql_lexer.TOpenParen)
+			value := p.parseExpr()
+			p.expectKeyword("as")
+			cast := &sql_ast.ECast{Value: value, Type: p.parseType()}
+			p.expect(sql_lexer.TCloseParen)
+			return sql_ast.Expr{Loc: loc, Data: cast}
+
+		case "exists":
+			p.advance()
+			p.expect(sql_lexer.TOpenParen)
+			exists := &sql_ast.EExists{Query: p.parseQuery()}
+			p.expect(sql_lexer.TCloseParen)
+			return sql_ast.Expr{Loc: loc, Data: exists}
+
+		case "array":
+			p.advance()
+			if p.eat(sql_lexer.TOpenParen) {
+				array := &sql_ast.EArray{Query: p.parseQuery()}
+				p.expect(sql_lexer.TCloseParen)
+				return sql_ast.Expr{Loc: loc, Data: array}
+			}
+			return p.parseArrayItems()
+
+		case "row":
+			if p.next().Kind == sql_lexer.TOpenParen {
+				p.advance()
+				p.advance()
+				row := &sql_ast.ERow{}
+				if !p.peek(sql_lexer.TCloseParen) {
+					row.Items = p.parseExprList()
+				}
+				p.expect(sql_lexer.TCloseParen)
+				return sql_ast.Expr{Loc: loc, Data: row}
+			}
+
+		case "interval":
+			// An interval literal such as "INTERVAL '1 day'" or "INTERVAL '1' DAY"
+			if p.next().Kind == sql_lexer.TString {
+				p.advance()
+				value := sql_ast.Expr{Loc: p.loc(), Data: &sql_ast.EString{Value: p.decoded()}}
+				p.advance()
+				for intervalFields[p.keyword()] {
+					p.advance()
+				}
+				return sql_ast.Expr{Loc: loc, Data: &sql_ast.ECast{Value: value, Type: sql_ast.Type{Name: "interval"}}}
+			}
+		}
+
+		if keywordValues[keyword] {
+			p.advance()
+			if p.eat(sql_lexer.TOpenParen) {
+				// A precision, e.g. "CURRENT_TIMESTAMP(3)"
+				p.parseExpr()
+				p.expect(sql_lexer.TCloseParen)
+			}
+			return sql_ast.Expr{Loc: loc, Data: &sql_ast.EKeyword{Name: strings.ToUpper(keyword)}}
+		}
+
+		if p.next().Kind == sql_lexer.TString && !reservedKeywords[keyword] {
+			// A typed literal such as "DATE '2021-01-01'"
+			p.advance()
+			value := sql_ast.Expr{Loc: p.loc(), Data: &sql_ast.EString{Value: p.decoded()}}
+			p.advance()
+			return sql_ast.Expr{Loc: loc, Data: &sql_ast.ECast{Value: value, Type: sql_ast.Type{Name: keyword}}}
+		}
+
+		if reservedKeywords[keyword] || (typeFuncNameKeywords[keyword] && p.next().Kind != sql_lexer.TOpenParen) {
+			p.unexpected()
+		}
+
+	case sql_lexer.TQuotedIdentifier:
+
+	default:
+		p.unexpected()
+	}
+
+	// A column reference or a function call
+	path := []sql_ast.Name{p.parseColLabel()}
+	for p.eat(sql_lexer.TDot) {
+		if p.isOperator("*") {
+			p.advance()
+			return sql_ast.Expr{Loc: loc, Data: &sql_ast.EColumn{Path: path, Star: true}}
+		}
+		if p.eat(sql_lexer.TFragment) {
+			// The name is in a fragment, e.g. "orders.%{}"
+			return sql_ast.Expr{Loc: loc, Data: &sql_ast.EFragment{}}
+		}
+		path = append(path, p.parseColLabel())
+	}
+	if p.peek(sql_lexer.TOpenParen) {
+		return sql_ast.Expr{Loc: loc, Data: p.parseCall(path)}
+	}
+	return sql_ast.Expr{Loc: loc, Data: &sql_ast.EColumn{Path: path}}
+}
+
+var intervalFields = map[string]bool{
+	"year": true, "month": true, "day": true, "hour": true, "minute": true, "second": true, "to": true,
+}
+
+// parseFieldSelection parses the optional field selection after a parenthesized expression, e.g. "(item).name"
+func (p *parser) parseFieldSelection(expr sql_ast.Expr) sql_ast.Expr {
+	for p.eat(sql_lexer.TDot) {
+		if p.isOperator("*") {
+			p.advance()
+			expr = sql_ast.Expr{Loc: expr.Loc, Data: &sql_ast.EField{Target: expr, Name: sql_ast.Name{Loc: p.loc(), Text: "*"}}}
+			continue
+		}
+		expr = sql_ast.Expr{Loc: expr.Loc, Data: &sql_ast.EField{Target: expr, Name: p.parseColLabel()}}
+	}
+	return expr
+}
+
+func (p *parser) parseArrayItems() sql_ast.Expr {
+	loc := p.loc()
+	p.expect(sql_lexer.TOpenBracket)
+	array := &sql_ast.EArray{}
+	if !p.peek(sql_lexer.TCloseBracket) {
+		for {
+			if p.peek(sql_lexer.TOpenBracket) {
+				array.Items = append(array.Items, p.parseArrayItems())
+			} else {
+				array.Items = append(array.Items, p.parseExpr())
+			}
+			if !p.eat(sql_lexer.TComma) {
+				break
+			}
+		}
+	}
+	p.expect(sql_lexer.TCloseBracket)
+	return sql_ast.Expr{Loc: loc, Data: array}
+}
+
+func (p *parser) parseCase() sql_ast.Expr {
+	loc := p.loc()
+	p.expectKeyword("case")
+	c := &sql_ast.ECase{}
+	if !p.isKeyword("when") {
+		c.Value = p.parseExpr()
+	}
+	for p.eatKeyword("when") {
+		var when sql_ast.When
+		when.Cond = p.parseExpr()
+		p.expectKeyword("then")
+		when.Value = p.parseExpr()
+		c.Whens = append(c.Whens, when)
+	}
+	if len(c.Whens) == 0 {
+		p.unexpected()
+	}
+	if p.eatKeyword("else") {
+		c.Else = p.parseExpr()
+	}
+	p.expectKeyword("end")
+	return sql_ast.Expr{Loc: loc, Data: c}
+}
+
+func (p *parser) parseCall(name []sql_ast.Name) *sql_ast.ECall {
+	call := &sql_ast.ECall{Name: name}
+	p.expect(sql_lexer.TOpenParen)
+
+	switch {
+	case p.eat(sql_lexer.TCloseParen):
+
+	case p.isOperator("*"):
+		p.advance()
+		call.Star = true
+		p.expect(sql_lexer.TCloseParen)
+
+	case len(name) == 1 && specialArgFunctions[name[0].Text]:
+		// e.g. "EXTRACT(YEAR FROM created_at)" or "TRIM(BOTH 'x' FROM name)"
+		if name[0].Text == "trim" {
+			if !p.eatKeyword("both") && !p.eatKeyword("leading") {
+				p.eatKeyword("trailing")
+			}
+			p.eatKeyword("from")
+		}
+		for {
+			if len(call.Args) != 0 {
+				if p.eat(sql_lexer.TCloseParen) {
+					break
+				}
+				if !p.eat(sql_lexer.TComma) && !p.eatKeyword("from") && !p.eatKeyword("for") && !p.eatKeyword("in") &&
+					!p.eatKeyword("placing") && !p.eatKeyword("similar") && !p.eatKeyword("escape") {
+					p.unexpected()
+				}
+			}
+			if name[0].Text == "extract" && len(call.Args) == 0 && p.peek(sql_lexer.TIdentifier) {
+				// The field name
+				call.Args = append(call.Args, sql_ast.Expr{Loc: p.loc(), Data: &sql_ast.EString{Value: p.decoded()}})
+				p.advance()
+				continue
+			}
+			call.Args = append(call.Args, p.parseExprLevel(levelIn))
+		}
+
+	case len(name) == 1 && xmlFunctions[name[0].Text]:
+		// e.g. "XMLELEMENT(NAME foo, XMLATTRIBUTES(a AS b), c)" or "XMLSERIALIZE(CONTENT x AS text)"
+		p.parseXMLArgs(call)
+
+	default:
+		if p.eatKeyword("distinct") {
+			call.Distinct = true
+		} else {
+			p.eatKeyword("all")
+		}
+		for {
+			p.eatKeyword("variadic")
+			if p.peek(sql_lexer.TIdentifier) && p.next().Kind == sql_lexer.TOperator && p.next().Raw(p.source.Contents) == "=>" {
+				// A named argument
+				p.advance()
+				p.advance()
+			}
+			call.Args = append(call.Args, p.parseExpr())
+			if !p.eat(sql_lexer.TComma) {
+				break
+			}
+		}
+		if p.eatKeyword("order") {
+			p.expectKeyword("by")
+			call.OrderBy = p.parseOrderItems()
+		}
+		p.expect(sql_lexer.TCloseParen)
+	}
+
+	if p.isKeyword("within") && p.isKeywordAt(p.index+1, "group") {
+		p.advance()
+		p.advance()
+		p.expect(sql_lexer.TOpenParen)
+		p.expectKeyword("order")
+		p.expectKeyword("by")
+		call.OrderBy = p.parseOrderItems()
+		p.expect(sql_lexer.TCloseParen)
+	}
+
+	if p.isKeyword("filter") && p.next().Kind == sql_lexer.TOpenParen {
+		p.advance()
+		p.advance()
+		p.expectKeyword("where")
+		call.Filter = p.parseExpr()
+		p.expect(sql_lexer.TCloseParen)
+	}
+
+	if p.eatKeyword("over") {
+		if p.peek(sql_lexer.TOpenParen) {
+			call.Over = p.parseWindowSpec()
+		} else {
+			call.Over = &sql_ast.Window{Name: p.parseColId().Text}
+		}
+	}
+	return call
+}
+
+// These functions have arguments with keywords, they're parsed by parseXMLArgs
+var xmlFunctions = map[string]bool{
+	"xmlattributes": true, "xmlelement": true, "xmlexists": true, "xmlforest": true,
+	"xmlparse": true, "xmlpi": true, "xmlroot": true, "xmlserialize": true,
+}
+
+// parseXMLArgs parses the arguments of a call to one of the xmlFunctions, after the open paren.
+// The element names and labels aren't expressions, so only the values are added to the arguments.
+func (p *parser) parseXMLArgs(call *sql_ast.ECall) {
+	fn := call.Name[0].Text
+	for first := true; ; first = false {
+		switch {
+		case first && (fn == "xmlelement" || fn == "xmlpi") && p.isKeyword("name") &&
+			(p.next().Kind == sql_lexer.TIdentifier || p.next().Kind == sql_lexer.TQuotedIdentifier):
+			p.advance()
+			p.parseColLabel()
+
+		case fn == "xmlroot" && p.eatKeyword("version"):
+			if p.isKeyword("no") && p.isKeywordAt(p.index+1, "value") {
+				p.advance()
+				p.advance()
+			} else {
+				call.Args = append(call.Args, p.parseExpr())
+			}
+
+		case fn == "xmlroot" && p.eatKeyword("standalone"):
+			if !p.eatKeyword("yes") {
+				p.expectKeyword("no")
+				p.eatKeyword("value")
+			}
+
+		default:
+			if first && (fn == "xmlparse" || fn == "xmlserialize") && !p.eatKeyword("document") {
+				p.expectKeyword("content")
+			}
+			call.Args = append(call.Args, p.parseExpr())
+			if fn == "xmlexists" && p.eatKeyword("passing") {
+				p.eatXMLPassingMechanism()
+				call.Args = append(call.Args, p.parseExpr())
+				p.eatXMLPassingMechanism()
+			}
+			if p.eatKeyword("as") {
+				if fn == "xmlserialize" {
+					p.parseType()
+				} else {
+					p.parseColLabel()
+				}
+			}
+		}
+		if !p.eat(sql_lexer.TComma) {
+			break
+		}
+	}
+	p.expect(sql_lexer.TCloseParen)
+}
+
+// eatXMLPassingMechanism skips "BY REF" or "BY VALUE" in XMLEXISTS
+func (p *parser) eatXMLPassingMechanism() {
+	if p.isKeyword("by") && (p.isKeywordAt(p.index+1, "ref") || p.isKeywordAt(p.index+1, "value")) {
+		p.advance()
+		p.advance()
+	}
+}
+
+func (p *parser) parseType() sql_ast.Type {
+	var ty sql_ast.Type
+	var names []string
+	for {
+		names = append(names, p.parseColLabel().Text)
+		if !p.eat(sql_lexer.TDot) {
+			break
+		}
+	}
+	ty.Name = strings.Join(names, ".")
+
+	switch ty.Name {
+	case "double":
+		p.expectKeyword("precision")
+		ty.Name = "double precision"
+	case "character", "char", "bit", "national":
+		if ty.Name == "national" {
+			if !p.eatKeyword("character") {
+				p.expectKeyword("char")
+			}
+			ty.Name = "character"
+		}
+		if p.eatKeyword("varying") {
+			ty.Name += " varying"
+		}
+	}
+
+	if p.eat(sql_lexer.TOpenParen) {
+		// Type modifiers, e.g. "numeric(10, 2)"
+		p.parseExprList()
+		p.expect(sql_lexer.TCloseParen)
+	}
+
+	switch ty.Name {
+	case "timestamp", "time":
+		if (p.isKeyword("with") || p.isKeyword("without")) && p.isKeywordAt(p.index+1, "time") {
+			if p.keyword() == "with" {
+				ty.Name += " with time zone"
+			}
+			p.advance()
+			p.advance()
+			p.expectKeyword("zone")
+		}
+	case "interval":
+		for intervalFields[p.keyword()] {
+			p.advance()
+		}
+	}
+
+	for {
+		if p.eat(sql_lexer.TOpenBracket) {
+			if p.peek(sql_lexer.TNumber) {
+				p.advance()
+			}
+			p.expect(sql_lexer.TCloseBracket)
+			ty.Array = true
+		} else if p.eatKeyword("array") {
+			ty.Array = true
+		} else {
+			return ty
+		}
+	}
+}
+
+// These are the keywords that PostgreSQL reserves completely
+var reservedKeywords = map[string]bool{
+	"all": true, "analyse": true, "analyze": true, "and": true, "any": true, "array": true,
+	"as": true, "asc": true, "asymmetric": true, "both": true, "case": true, "cast": true,
+	"check": true, "collate": true, "column": true, "constraint": true, "create": true,
+	"current_catalog": true, "current_date": true, "current_role": true, "current_time": true,
+	"current_timestamp": true, "current_user": true, "default": true, "deferrable": true,
+	"desc": true, "distinct": true, "do": true, "else": true, "end": true, "except": true,
+	"false": true, "fetch": true, "for": true, "foreign": true, "from": true, "grant": true,
+	"group": true, "having": true, "in": true, "initially": true, "intersect": true,
+	"into": true, "lateral": true, "leading": true, "limit": true, "localtime": true,
+	"localtimestamp": true, "not": true, "null": true, "offset": true, "on": true,
+	"only": true, "or": true, "order": true, "placing": true, "primary": true,
+	"references": true, "returning": true, "select": true, "session_user": true, "some": true,
+	"symmetric": true, "table": true, "then": true, "to": true, "trailing": true, "true": true,
+	"union": true, "unique": true, "user": true, "using": true, "variadic": true, "when": true,
+	"where": true, "window": true, "with": true,
+}
+
+// These keywords can only be used as function or type names
+var typeFuncNameKeywords = map[string]bool{
+	"authorization": true, "binary": true, "collation": true, "concurrently": true,
+	"cross": true, "current_schema": true, "freeze": true, "full": true, "ilike": true,
+	"inner": true, "is": true, "isnull": true, "join": true, "left": true, "like": true,
+	"natural": true, "notnull": true, "outer": true, "overlaps": true, "right": true,
+	"similar": true, "tablesample": true, "verbose": true,
+}
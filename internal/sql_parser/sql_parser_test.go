@@ -0,0 +1,272 @@
+package sql_parser
+
+import (
+	"testing"
+
+	"github.com/evanw/esbuild/internal/logger"
+	"github.com/evanw/esbuild/internal/sql_ast"
+	"github.com/evanw/esbuild/internal/test"
+)
+
+func parse(t *testing.T, contents string) (sql_ast.AST, string) {
+	t.Helper()
+	return parseWithOptions(t, contents, Options{})
+}
+
+func parseWithOptions(t *testing.T, contents string, options Options) (sql_ast.AST, string) {
+	t.Helper()
+	log := logger.NewDeferLog()
+	tree := Parse(log, test.SourceForTest(contents), options)
+	text := ""
+	for _, msg := range log.Done() {
+		text += msg.String(logger.OutputOptions{}, logger.TerminalInfo{})
+	}
+	return tree, text
+}
+
+func expectParseError(t *testing.T, contents string, expected string) {
+	t.Helper()
+	t.Run(contents, func(t *testing.T) {
+		t.Helper()
+		_, text := parse(t, contents)
+		test.AssertEqual(t, text, expected)
+	})
+}
+
+func expectValid(t *testing.T, contents string) {
+	t.Helper()
+	expectParseError(t, contents, "")
+}
+
+func TestSelect(t *testing.T) {
+	expectValid(t, "SELECT 1")
+	expectValid(t, "select * from users")
+	expectValid(t, "SELECT u.*, o.id AS order_id, o.total total FROM users u JOIN orders AS o ON o.user_id = u.id")
+	expectValid(t, "SELECT DISTINCT ON (a) a, b FROM t ORDER BY a, b DESC NULLS LAST LIMIT 10 OFFSET 5")
+	expectValid(t, "SELECT count(*), sum(DISTINCT x) FILTER (WHERE x > 0) FROM t GROUP BY y HAVING count(*) > 1")
+	expectValid(t, "SELECT row_number() OVER (PARTITION BY a ORDER BY b ROWS BETWEEN UNBOUNDED PRECEDING AND CURRENT ROW) FROM t")
+	expectValid(t, "SELECT a FROM t1 UNION ALL SELECT b FROM t2 INTERSECT SELECT c FROM t3 ORDER BY 1")
+	expectValid(t, "(SELECT 1) UNION (SELECT 2 LIMIT 1)")
+	expectValid(t, "SELECT * FROM (SELECT 1 AS x) AS sub(y), LATERAL (SELECT y) l")
+	expectValid(t, "SELECT * FROM generate_series(1, 10) WITH ORDINALITY AS g(n, i)")
+	expectValid(t, "SELECT * FROM json_to_recordset($1) AS x(a int, b text), json_to_record($2) AS (c numeric(10, 2)[])")
+	expectValid(t, "SELECT * FROM a LEFT OUTER JOIN b USING (id) CROSS JOIN c NATURAL JOIN d")
+	expectValid(t, "SELECT * FROM ROWS FROM (f(), g()) AS x, ROWS FROM (json_to_record($1) AS (a int), generate_series(1, 2)) WITH ORDINALITY AS y(a, n, i)")
+	expectValid(t, "SELECT * FROM rows")
+	expectValid(t, "SELECT * FROM (a JOIN b ON a.id = b.id)")
+	expectValid(t, "WITH RECURSIVE t(n) AS (VALUES (1) UNION ALL SELECT n + 1 FROM t WHERE n < 100) SELECT sum(n) FROM t")
+	expectValid(t, "VALUES (1, 'a'), (2, 'b')")
+	expectValid(t, "TABLE users")
+	expectValid(t, "SELECT FROM users")
+	expectValid(t, "SELECT * FROM users FOR UPDATE SKIP LOCKED")
+	expectValid(t, "SELECT * FROM users FETCH FIRST 10 ROWS ONLY")
+	expectValid(t, "select 1; select 2;")
+	expectValid(t, "")
+	expectValid(t, "-- just a comment")
+	expectValid(t, "SELECT \"Select\" FROM \"from\"")
+
+	expectParseError(t, "SELECT * FORM users", "<stdin>: error: syntax error at or near \"FORM\"\n")
+	expectParseError(t, "SELECT id FORM users", "<stdin>: error: syntax error at or near \"users\"\n")
+	expectParseError(t, "SELECT * FROM", "<stdin>: error: syntax error at end of input\n")
+	expectParseError(t, "SELEC * FROM users", "<stdin>: error: syntax error at or near \"SELEC\"\n")
+	expectParseError(t, "SELECT * FROM users WHERE", "<stdin>: error: syntax error at end of input\n")
+	expectParseError(t, "SELECT a, FROM users", "<stdin>: error: syntax error at or near \"FROM\"\n")
+	expectParseError(t, "SELECT * FROM users WHERE (id = 1", "<stdin>: error: syntax error at end of input\n")
+	expectParseError(t, "SELECT * FROM users WHERE id = 1)", "<stdin>: error: syntax error at or near \")\"\n")
+	expectParseError(t, "SELECT * FROM users u JOIN orders o", "<stdin>: error: syntax error at end of input\n")
+	expectParseError(t, "SELECT * FROM select", "<stdin>: error: syntax error at or near \"select\"\n")
+	expectParseError(t, "select 1; selct 2", "<stdin>: error: syntax error at or near \"selct\"\n")
+	expectParseError(t, "SELECT 'abc", "<stdin>: error: unterminated quoted string at or near \"'abc\"\n")
+}
+
+func TestExpressions(t *testing.T) {
+	expectValid(t, "SELECT -1 + 2 * 3 ^ 4 % 5 / 6 - +7")
+	expectValid(t, "SELECT a IS NOT NULL AND b IS DISTINCT FROM c OR NOT d IS TRUE")
+	expectValid(t, "SELECT a NOT IN (1, 2) AND b IN (SELECT id FROM t) AND c NOT BETWEEN 1 AND 2")
+	expectValid(t, "SELECT name LIKE 'a%' ESCAPE '!', name NOT ILIKE $1, name SIMILAR TO '%(b|d)%'")
+	expectValid(t, "SELECT CASE WHEN a THEN 1 WHEN b THEN 2 ELSE 3 END, CASE x WHEN 1 THEN 'one' END")
+	expectValid(t, "SELECT CAST(a AS numeric(10, 2)), b::text[], c::double precision, d::timestamp with time zone")
+	expectValid(t, "SELECT ARRAY[1, 2], ARRAY[[1], [2]], ARRAY(SELECT 1), a[1], a[1:2], (a).b, ROW(1, 2), (1, 2)")
+	expectValid(t, "SELECT EXISTS (SELECT 1), (SELECT 1) + 1, a = ANY($1), a <> ALL (SELECT b FROM t)")
+	expectValid(t, "SELECT EXTRACT(YEAR FROM d), POSITION('a' IN s), SUBSTRING(s FROM 1 FOR 2), TRIM(BOTH ' ' FROM s)")
+	expectValid(t, "SELECT CURRENT_TIMESTAMP, now() AT TIME ZONE 'UTC', DATE '2021-01-01', INTERVAL '1 day'")
+	expectValid(t, "SELECT data->>'name', data @> '{}'::jsonb, tags && $2, a || b, a COLLATE \"C\"")
+	expectValid(t, "SELECT string_agg(name, ',' ORDER BY name), percentile_cont(0.5) WITHIN GROUP (ORDER BY x)")
+	expectValid(t, "SELECT left(name, 3), coalesce(a, b), greatest(1, 2), make_interval(days => 1)")
+	expectValid(t, "SELECT $1, ${SESSION.user_id}, $$dollar$$, E'\\n', x'1F'")
+	expectValid(t, "SELECT * FROM t WHERE ${SESSION.roles}::jsonb ? role")
+	expectValid(t, "SELECT a LIKE ANY (ARRAY['a%', 'b%']), a NOT ILIKE ALL ($1), a LIKE SOME (SELECT p FROM t)")
+	expectValid(t, "SELECT 'a'\n'b', 'a'  \n  'b'\n'c'")
+	expectValid(t, "SELECT a IS JSON, a IS NOT JSON OBJECT, a IS JSON ARRAY WITH UNIQUE KEYS, a IS JSON WITHOUT UNIQUE")
+	expectValid(t, "SELECT a IS NORMALIZED, a IS NOT NFKC NORMALIZED, a IS DOCUMENT")
+	expectValid(t, "SELECT 1 OPERATOR(pg_catalog.+) 2, a OPERATOR(\"my schema\".===) b, OPERATOR(pg_catalog.-) 1")
+	expectValid(t, "SELECT xmlelement(name foo), xmlelement(NAME \"foo\", xmlattributes(a AS b, c), 'content', d)")
+	expectValid(t, "SELECT xmlforest(a, b AS c), xmlpi(name php, 'echo'), xmlparse(DOCUMENT $1), xmlserialize(CONTENT x AS text)")
+	expectValid(t, "SELECT xmlroot(x, VERSION '1.0', STANDALONE YES), xmlroot(x, VERSION NO VALUE), xmlexists('//a' PASSING BY REF x)")
+	expectValid(t, "SELECT xmlforest(name, email) FROM users")
+
+	expectParseError(t, "SELECT 1 +", "<stdin>: error: syntax error at end of input\n")
+	expectParseError(t, "SELECT * 2", "<stdin>: error: syntax error at or near \"2\"\n")
+	expectParseError(t, "SELECT a = = b", "<stdin>: error: syntax error at or near \"=\"\n")
+	expectParseError(t, "SELECT CASE END", "<stdin>: error: syntax error at or near \"END\"\n")
+	expectParseError(t, "SELECT a BETWEEN 1", "<stdin>: error: syntax error at end of input\n")
+	expectParseError(t, "SELECT a IS 1", "<stdin>: error: syntax error at or near \"1\"\n")
+	expectParseError(t, "SELECT 'a' 'b'", "<stdin>: error: syntax error at or near \"'b'\"\n")
+	expectParseError(t, "SELECT a IS NFC", "<stdin>: error: syntax error at end of input\n")
+	expectParseError(t, "SELECT count(*", "<stdin>: error: syntax error at end of input\n")
+	expectParseError(t, "SELECT 1 OPERATOR(pg_catalog) 2", "<stdin>: error: syntax error at or near \")\"\n")
+	expectParseError(t, "SELECT xmlserialize(x AS text)", "<stdin>: error: syntax error at or near \"x\"\n")
+}
+
+func TestInsertUpdateDelete(t *testing.T) {
+	expectValid(t, "INSERT INTO users (name, email) VALUES ($1, $2) RETURNING id")
+	expectValid(t, "INSERT INTO users SELECT * FROM old_users")
+	expectValid(t, "INSERT INTO users (SELECT * FROM old_users)")
+	expectValid(t, "INSERT INTO users DEFAULT VALUES")
+	expectValid(t, "INSERT INTO counts AS c (k, n) VALUES ('a', 1) ON CONFLICT (k) DO UPDATE SET n = c.n + excluded.n WHERE c.n < 10")
+	expectValid(t, "INSERT INTO t VALUES (1) ON CONFLICT ON CONSTRAINT t_pkey DO NOTHING")
+	expectValid(t, "UPDATE users u SET name = $1, (a, b) = (1, DEFAULT) FROM orders o WHERE o.user_id = u.id RETURNING *")
+	expectValid(t, "UPDATE ONLY users SET tags[1] = 'x'")
+	expectValid(t, "DELETE FROM users USING orders WHERE orders.user_id = users.id")
+	expectValid(t, "WITH moved AS (DELETE FROM a RETURNING *) INSERT INTO b SELECT * FROM moved")
+
+	expectParseError(t, "INSERT users VALUES (1)", "<stdin>: error: syntax error at or near \"users\"\n")
+	expectParseError(t, "INSERT INTO users (name VALUES (1)", "<stdin>: error: syntax error at or near \"VALUES\"\n")
+	expectParseError(t, "UPDATE users name = 1", "<stdin>: error: syntax error at or near \"=\"\n")
+	expectParseError(t, "UPDATE users SET name", "<stdin>: error: syntax error at end of input\n")
+	expectParseError(t, "DELETE users", "<stdin>: error: syntax error at or near \"users\"\n")
+	expectParseError(t, "WITH x AS (SELECT 1) CREATE TABLE t (id int)", "<stdin>: error: syntax error at or near \"CREATE\"\n")
+	expectParseError(t, "WITH x AS (SELECT 1) DROP TABLE t", "<stdin>: error: syntax error at or near \"DROP\"\n")
+	expectParseError(t, "WITH x AS (SELECT 1) VACUUM", "<stdin>: error: syntax error at or near \"VACUUM\"\n")
+}
+
+func TestOtherStatements(t *testing.T) {
+	expectValid(t, "ALTER TABLE distributors RENAME COLUMN address TO city")
+	expectValid(t, "CREATE TABLE t (id int PRIMARY KEY, name text[])")
+	expectValid(t, "SET search_path TO app; SELECT 1")
+
+	expectParseError(t, "CREATE TABLE t (id int", "<stdin>: error: syntax error at end of input\n")
+	expectParseError(t, "CREATE TABLE t (id int])", "<stdin>: error: syntax error at or near \"]\"\n")
+	expectParseError(t, "FROB TABLE t", "<stdin>: error: syntax error at or near \"FROB\"\n")
+}
+
+func TestFragments(t *testing.T) {
+	expectValid(t, "SELECT * FROM orders AS o WHERE %{} ORDER BY o.%{} %{}")
+	expectValid(t, "update foo set bar = $1 where %{}")
+	expectValid(t, "SELECT nested1 FROM %{} %{}")
+	expectValid(t, "SELECT * FROM users %{}")
+	expectValid(t, "SELECT * FROM users WHERE id IN %{}")
+	expectValid(t, "SELECT %{} FROM users")
+	expectValid(t, "%{}")
+	expectValid(t, "SELECT * FROM users WHERE %{} AND deleted_at IS NULL")
+	expectValid(t, "UPDATE users SET %{} WHERE id = $1")
+
+	// The fragment could complete anything that follows it
+	expectValid(t, "SELECT * FROM users WHERE id %{} 1")
+	expectValid(t, "SELECT * FROM users WHERE %{}) AND x")
+
+	// Params bound to sql.p() are fragment slots too
+	_, text := parseWithOptions(t, "SELECT * FROM orders ORDER BY created_at $1 LIMIT $2", Options{FragmentParams: []int{1}})
+	test.AssertEqual(t, text, "")
+	_, text = parseWithOptions(t, "SELECT * FROM orders ORDER BY created_at $2 LIMIT $1", Options{FragmentParams: []int{1}})
+	test.AssertEqual(t, text, "<stdin>: error: syntax error at or near \"$2\"\n")
+
+	// But errors in other statements are still found
+	expectParseError(t, "SELECT * FROM users WHERE id %{} 1; SELECT * FORM users", "<stdin>: error: syntax error at or near \"FORM\"\n")
+	expectParseError(t, "SELECT * FORM users WHERE %{}", "<stdin>: error: syntax error at or near \"FORM\"\n")
+}
+
+func TestAST(t *testing.T) {
+	tree, text := parse(t, "INSERT INTO app.users (name, \"Email\") VALUES ($1, $2), ($3, DEFAULT)")
+	test.AssertEqual(t, text, "")
+	insert := tree.Stmts[0].Data.(*sql_ast.SInsert)
+	test.AssertEqual(t, insert.Table.Schema, "app")
+	test.AssertEqual(t, insert.Table.Name, "users")
+	test.AssertEqual(t, len(insert.Columns), 2)
+	test.AssertEqual(t, insert.Columns[1].Text, "Email")
+	values := insert.Query.Body.(*sql_ast.QValues)
+	test.AssertEqual(t, len(values.Rows), 2)
+	test.AssertEqual(t, values.Rows[1][0].Data.(*sql_ast.EParam).Index, 3)
+
+	tree, text = parse(t, "DELETE FROM Users")
+	test.AssertEqual(t, text, "")
+	del := tree.Stmts[0].Data.(*sql_ast.SDelete)
+	test.AssertEqual(t, del.Table.Name, "users")
+	test.AssertEqual(t, del.Where.Data, nil)
+
+	tree, text = parse(t, "SELECT u.name, o.* FROM users u, orders o WHERE u.id = o.user_id")
+	test.AssertEqual(t, text, "")
+	s := tree.Stmts[0].Data.(*sql_ast.SQuery).Query.Body.(*sql_ast.QSelect)
+	test.AssertEqual(t, len(s.Items), 2)
+	column := s.Items[0].Value.Data.(*sql_ast.EColumn)
+	test.AssertEqual(t, column.Table(), "u")
+	test.AssertEqual(t, column.Column(), "name")
+	star := s.Items[1].Value.Data.(*sql_ast.EColumn)
+	test.AssertEqual(t, star.Star, true)
+	test.AssertEqual(t, star.Table(), "o")
+	test.AssertEqual(t, s.From[0].Data.(*sql_ast.TTable).Table.Alias.Text, "u")
+	where := s.Where.Data.(*sql_ast.EBinary)
+	test.AssertEqual(t, where.Op, "=")
+
+	tree, text = parse(t, "SELECT 'a'\n  'b' FROM json_to_recordset($1) AS x(a int, b text)")
+	test.AssertEqual(t, text, "")
+	s = tree.Stmts[0].Data.(*sql_ast.SQuery).Query.Body.(*sql_ast.QSelect)
+	test.AssertEqual(t, s.Items[0].Value.Data.(*sql_ast.EString).Value, "ab")
+	fn := s.From[0].Data.(*sql_ast.TFunction)
+	test.AssertEqual(t, fn.Alias.Text, "x")
+	test.AssertEqual(t, fn.ColumnAliases[1].Text, "b")
+	test.AssertEqual(t, fn.ColumnTypes[1], sql_ast.Type{Name: "text"})
+
+	tree, text = parse(t, "SELECT a OR b AND c")
+	test.AssertEqual(t, text, "")
+	or := tree.Stmts[0].Data.(*sql_ast.SQuery).Query.Body.(*sql_ast.QSelect).Items[0].Value.Data.(*sql_ast.EBinary)
+	test.AssertEqual(t, or.Op, "OR")
+	test.AssertEqual(t, or.Right.Data.(*sql_ast.EBinary).Op, "AND")
+}
+
+func TestDDL(t *testing.T) {
+	expectValid(t, "CREATE TABLE IF NOT EXISTS app.users (id serial PRIMARY KEY, email varchar(255) NOT NULL UNIQUE, created_at timestamp with time zone DEFAULT now())")
+	expectValid(t, "CREATE TABLE t (a int, b int, CONSTRAINT t_pkey PRIMARY KEY (a, b), CHECK (a > b), FOREIGN KEY (b) REFERENCES u (id) ON DELETE CASCADE)")
+	expectValid(t, "CREATE TEMP TABLE t AS SELECT 1 AS x WITH NO DATA")
+	expectValid(t, "CREATE OR REPLACE VIEW v (a, b) WITH (security_barrier = true) AS SELECT 1, 2 WITH CHECK OPTION")
+	expectValid(t, "CREATE MATERIALIZED VIEW v AS SELECT * FROM users")
+	expectValid(t, "CREATE INDEX ON users (lower(email))")
+	expectValid(t, "ALTER TABLE ONLY users ADD COLUMN age int NOT NULL DEFAULT 0, DROP COLUMN IF EXISTS nickname CASCADE, ADD CONSTRAINT c UNIQUE (age)")
+	expectValid(t, "ALTER TABLE users ALTER COLUMN age SET DATA TYPE bigint USING age::bigint, ALTER age DROP NOT NULL")
+	expectValid(t, "ALTER TABLE users RENAME TO members")
+	expectValid(t, "ALTER TABLE users SET SCHEMA app")
+	expectValid(t, "ALTER SEQUENCE s RESTART")
+	expectValid(t, "DROP TABLE IF EXISTS a, b CASCADE")
+	expectValid(t, "DROP MATERIALIZED VIEW v")
+	expectValid(t, "DROP INDEX i")
+
+	expectParseError(t, "CREATE TABLE t (id int NOT NULL", "<stdin>: error: syntax error at end of input\n")
+	expectParseError(t, "CREATE VIEW v SELECT 1", "<stdin>: error: syntax error at or near \"SELECT\"\n")
+	expectParseError(t, "ALTER TABLE t RENAME a b", "<stdin>: error: syntax error at or near \"b\"\n")
+	expectParseError(t, "DROP TABLE t u", "<stdin>: error: syntax error at or near \"u\"\n")
+
+	tree, text := parse(t, "CREATE TABLE Users (id int, \"Name\" text[] NOT NULL, PRIMARY KEY (id))")
+	test.AssertEqual(t, text, "")
+	create := tree.Stmts[0].Data.(*sql_ast.SCreateTable)
+	test.AssertEqual(t, create.Table.Name, "users")
+	test.AssertEqual(t, len(create.Columns), 2)
+	test.AssertEqual(t, create.Columns[0].NotNull, true)
+	test.AssertEqual(t, create.Columns[1].Name.Text, "Name")
+	test.AssertEqual(t, create.Columns[1].Type, sql_ast.Type{Name: "text", Array: true})
+	test.AssertEqual(t, create.Columns[1].NotNull, true)
+
+	tree, text = parse(t, "ALTER TABLE users RENAME COLUMN name TO full_name, ADD email text, ALTER id TYPE bigint")
+	test.AssertEqual(t, text, "")
+	alter := tree.Stmts[0].Data.(*sql_ast.SAlterTable)
+	test.AssertEqual(t, len(alter.Actions), 3)
+	test.AssertEqual(t, alter.Actions[0].Kind, sql_ast.AlterRenameColumn)
+	test.AssertEqual(t, alter.Actions[0].NewName.Text, "full_name")
+	test.AssertEqual(t, alter.Actions[1].Kind, sql_ast.AlterAddColumn)
+	test.AssertEqual(t, alter.Actions[1].Column.Type.Name, "text")
+	test.AssertEqual(t, alter.Actions[2].Kind, sql_ast.AlterColumnType)
+	test.AssertEqual(t, alter.Actions[2].Column.Type.Name, "bigint")
+
+	tree, text = parse(t, "SELECT * FROM users %{} WHERE o.total > 1")
+	test.AssertEqual(t, text, "")
+	test.AssertEqual(t, tree.Stmts[0].Data.(*sql_ast.SQuery).Query.Body.(*sql_ast.QSelect).Fragments, true)
+}
@@ -287,6 +287,7 @@ type BuildOptions struct {
 	Watch *WatchMode
 
 	OnBundleCompile bundler.OnBundleCompile
+	OnBundleLink    bundler.OnBundleLink
 }
 
 type EntryPoint struct {
@@ -653,6 +653,7 @@ type internalBuildResult struct {
 	result    BuildResult
 	options   config.Options
 	watchData fs.WatchData
+	resolver  resolver.Resolver
 }
 
 func buildImpl(buildOpts BuildOptions) internalBuildResult {
@@ -909,7 +910,7 @@ func rebuildImpl(
 		// Stop now if there were errors
 		if !log.HasErrors() {
 			// Compile the bundle
-			results, metafile := bundle.Compile(log, options, buildOpts.OnBundleCompile)
+			results, metafile := bundle.Compile(log, options, buildOpts.OnBundleCompile, buildOpts.OnBundleLink)
 			metafileJSON = metafile
 
 			// Stop now if there were errors
@@ -1019,6 +1020,7 @@ func rebuildImpl(
 		result:    result,
 		options:   options,
 		watchData: watchData,
+		resolver:  resolver,
 	}
 }
 
@@ -1281,7 +1283,7 @@ func transformImpl(input string, transformOpts TransformOptions) TransformResult
 		// Stop now if there were errors
 		if !log.HasErrors() {
 			// Compile the bundle
-			results, _ = bundle.Compile(log, options, nil)
+			results, _ = bundle.Compile(log, options, nil, nil)
 		}
 	}
 
@@ -1,9 +1,8 @@
 package api
 
 import (
-	log_ "log"
-	"os"
 	"path"
+	"sync"
 
 	"github.com/evanw/esbuild/internal/cache"
 	"github.com/evanw/esbuild/internal/config"
@@ -12,12 +11,7 @@ import (
 	"github.com/evanw/esbuild/internal/logger"
 )
 
-var f, _ = os.Open("/dev/null");
-var log = log_.New(f /*os.Stderr*/, "", log_.Lshortfile)
-
 func BuildFlowState(opts *SQLJoyOptions) BuildResult {
-	log.Println("configuring build")
-
 	// Lifted from api_impl.go buildImpl
 	logOptions := logger.OutputOptions{
 		IncludeSource: true,
@@ -25,6 +19,10 @@ func BuildFlowState(opts *SQLJoyOptions) BuildResult {
 		Color:         validateColor(opts.Client.Color),
 		LogLevel:      validateLogLevel(opts.Client.LogLevel),
 	}
+	if opts.Verbose {
+		// Compiler tracing is logged at the debug level
+		logOptions.LogLevel = logger.LevelDebug
+	}
 	loggerInstance := logger.NewStderrLog(logOptions)
 
 	var f fs.FS
@@ -42,20 +40,68 @@ func BuildFlowState(opts *SQLJoyOptions) BuildResult {
 		}
 	}
 
-	log.Println("loading plugins")
+	trace(loggerInstance, traceBuild, nil, logger.Loc{}, "loading plugins")
 	oldAbsWorkingDir := opts.Client.AbsWorkingDir
 	plugins := loadPlugins(&opts.Client, f, loggerInstance)
 	if opts.Client.AbsWorkingDir != oldAbsWorkingDir {
 		panic("Mutating \"AbsWorkingDir\" is not allowed")
 	}
+
+	// The sources in the client source map include the query text, which production leaves out of the
+	// client. The mappings are kept, so stack traces can still be mapped back to the source.
+	if opts.Production {
+		opts.Client.SourcesContent = SourcesContentExclude
+	}
+
+	// The cache set is shared between the client and server builds, and between
+	// rebuilds in watch mode, so that unchanged files are not parsed again.
 	caches := cache.MakeCacheSet()
 
-	buildClient := func() BuildResult {
-		log.Println("building client bundle")
-		value := rebuildImpl(f, opts.Client, caches, plugins, logOptions, loggerInstance, true)
-		return value.result
+	// Rebuilds can be triggered by both the watcher and by calling Rebuild,
+	// the compiler doesn't support running more than one build at a time.
+	var mutex sync.Mutex
+	value := rebuildFlowState(opts, f, caches, plugins, logOptions, loggerInstance)
+	result := value.result
+
+	if !opts.Watch && !opts.Incremental {
+		return result
+	}
+
+	var watch *watcher
+	rebuild := func() internalBuildResult {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return rebuildFlowState(opts, f, caches, plugins, logOptions, logger.NewStderrLog(logOptions))
+	}
+
+	if opts.Incremental {
+		result.Rebuild = func() BuildResult {
+			value := rebuild()
+			if watch != nil {
+				watch.setWatchData(value.watchData)
+			}
+			return value.result
+		}
+	}
+
+	if opts.Watch {
+		watch = &watcher{
+			data:     value.watchData,
+			resolver: value.resolver,
+			rebuild: func() fs.WatchData {
+				return rebuild().watchData
+			},
+		}
+		watch.start(opts.Client.LogLevel, opts.Client.Color, WatchMode{})
+		result.Stop = watch.stop
 	}
 
+	return result
+}
+
+// rebuildFlowState runs a single client build, FlowState compiler pass and server build.
+// It's called once for a normal build, and again for every change in watch mode.
+func rebuildFlowState(opts *SQLJoyOptions, f fs.FS, caches *cache.CacheSet, plugins []config.Plugin, logOptions logger.OutputOptions, loggerInstance logger.Log) internalBuildResult {
 	compiler := NewFlowStateCompiler(opts, logOptions, loggerInstance, f, caches)
 	output := make([]OutputFile, 0, 3)
 
@@ -63,7 +109,7 @@ func BuildFlowState(opts *SQLJoyOptions) BuildResult {
 		if len(entryPoints) == 0 {
 			panic("no entry point defined")
 		}
-		log.Println("creating whitelist and client bundle")
+		trace(loggerInstance, traceBuild, nil, logger.Loc{}, "creating whitelists and client bundle")
 
 		baseDir := path.Dir(files[entryPoints[0].SourceIndex].Source.KeyPath.Text)
 		outDir := options.AbsOutputDir
@@ -73,7 +119,7 @@ func BuildFlowState(opts *SQLJoyOptions) BuildResult {
 				outDir = baseDir
 			}
 		}
-		compiler.CompileClient(outDir, baseDir, files)
+		compiler.CompileClient(outDir, baseDir, files, entryPoints)
 
 		if len(compiler.clientWhitelistFile.Contents) > 2 {
 			output = append(output, compiler.clientWhitelistFile)
@@ -81,19 +127,48 @@ func BuildFlowState(opts *SQLJoyOptions) BuildResult {
 		if len(compiler.serverWhitelistFile.Contents) > 2 {
 			output = append(output, compiler.serverWhitelistFile)
 		}
+		if len(compiler.typesFile.Contents) != 0 {
+			output = append(output, compiler.typesFile)
+		}
+		if len(compiler.diffFile.Contents) != 0 {
+			output = append(output, compiler.diffFile)
+		}
+		if len(compiler.reportFile.Contents) != 0 {
+			output = append(output, compiler.reportFile)
+		}
 	}
 
-	clientResult := buildClient()
-	if len(clientResult.Errors) != 0 {
-		return clientResult
+	// Check that none of the server only code found by the compiler made it into the client bundle
+	opts.Client.OnBundleLink = compiler.verifyClientBundle
+
+	trace(loggerInstance, traceBuild, nil, logger.Loc{}, "building client bundle")
+	clientValue := rebuildImpl(f, opts.Client, caches, plugins, logOptions, loggerInstance, true)
+	value := internalBuildResult{
+		result:   clientValue.result,
+		options:  clientValue.options,
+		resolver: clientValue.resolver,
+	}
+	if len(clientValue.result.Errors) != 0 {
+		if opts.Watch {
+			value.watchData = f.WatchData()
+		}
+		return value
 	}
 
 	serverResult := compiler.CompileServer()
 
-	output = append(output, clientResult.OutputFiles...)
+	output = append(output, clientValue.result.OutputFiles...)
 	output = append(output, serverResult.OutputFiles...)
-	return BuildResult{
+	value.result = BuildResult{
 		Errors: serverResult.Errors,
+		Warnings: serverResult.Warnings,
 		OutputFiles: output,
 	}
+
+	// Both the client and server builds read through f, so this covers every
+	// file that either bundle depends on.
+	if opts.Watch {
+		value.watchData = f.WatchData()
+	}
+	return value
 }
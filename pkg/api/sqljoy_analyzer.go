@@ -3,6 +3,7 @@ package api
 import (
 	"github.com/evanw/esbuild/internal/graph"
 	"github.com/evanw/esbuild/internal/js_ast"
+	"github.com/evanw/esbuild/internal/js_lexer"
 	"github.com/evanw/esbuild/internal/logger"
 )
 
@@ -10,9 +11,24 @@ type queryExecution struct {
 	call *js_ast.ECall
 	queries queriesByWhitelistOrder
 	isServer bool
+	fn *localFunction // the server function executing the query, nil on the client
+}
+
+// partExpr is an expression and the top level part containing it
+type partExpr struct {
+	part *js_ast.Part
+	expr *js_ast.Expr
+}
+
+// propertyCall is a call to a property of an identifier, which may call a server method directly, e.g.: Api.getUser(ctx, id)
+type propertyCall struct {
+	part *js_ast.Part
+	call *js_ast.ECall
+	fn   js_ast.E // the outermost function containing the call in its statement, if any
 }
 
 type serverCall struct {
+	part *js_ast.Part
 	parent *js_ast.Expr
 	call *js_ast.ECall
 	fsInstance *js_ast.EDot
@@ -26,16 +42,32 @@ type localFunction struct {
 	fnExpr *js_ast.EFunction
 	local *js_ast.SLocal
 	decl *js_ast.Decl
+	method string // the name of the method if it's a static class method or an object literal method
+	value *js_ast.Expr // the property value of a method
+	loc logger.Loc // the location of the name of the function or method, the generated server entry maps to it
+	isExport bool
+	isDefaultExport bool
+}
+
+// functionParam is a parameter of a function declared in the file
+type functionParam struct {
+	fn js_ast.Ref // InvalidRef for a function expression that's called immediately
+	index int
+	defaultValue *js_ast.Expr
+	iife *js_ast.ECall // the call of a function expression that's called immediately
+	loc logger.Loc
 }
 
 type queryUsage struct {
 	call *js_ast.ECall
 	sourceIndex uint32
 	isServer bool
+	fn *localFunction // see queryExecution
 }
 
 type queryPart struct {
 	ref js_ast.Ref
+	part *js_ast.Part
 	parent *js_ast.Expr
 	template *js_ast.ETemplate
 	calls []queryUsage
@@ -59,8 +91,40 @@ type FlowStateAnalyzer struct {
 	serverCalls []serverCall
 	serverFunctions map[js_ast.Ref]*localFunction
 	serverFunctionsByCtxVar map[js_ast.Ref]*localFunction
+	// the server functions that are methods, by the class or object and the method name
+	serverMethods map[js_ast.Ref]map[string]*localFunction
+	// the values returned by the functions declared in this file, which may be queries
+	// e.g.: function makeQuery() { return sql`...` }
+	returnValues map[js_ast.Ref][]*js_ast.Expr
+	// variables initialized with the result of a function call, e.g.: foo = makeQuery()
+	callResults map[js_ast.Ref]*js_ast.Expr
+	// the parameters of the functions declared in this file, which may be passed queries
+	params map[js_ast.Ref]functionParam
+	// all function calls in this file, a parameter is traced through the calls of its function
+	calls []*js_ast.ECall
+	// identifiers used as a value other than as the target of a call or in a simple assignment,
+	// a function used like this may be called from somewhere that can't be traced
+	escapedRefs map[js_ast.Ref]bool
+	// the expressions using the identifiers in escapedRefs
+	escapedUses []partExpr
+	// the property accesses on identifiers that aren't called, e.g.: window.leak = Api.getUser
+	escapedPropertyUses []partExpr
+	// the calls to the properties of identifiers, a server method called like this is included in the client bundle
+	propertyCalls []propertyCall
+	// variables initialized with an alias, ternary condition, logical expression or array literal,
+	// which may select a server function, e.g.: action = isAdmin ? deleteUser : archiveUser
+	values map[js_ast.Ref]partExpr
+	// variables initialized with a plain string built at runtime, which may be SQL, see traceStringSQL
+	stringValues map[js_ast.Ref]*js_ast.Expr
+	// the first parameter of a callback passed to an array method, and the array it iterates
+	// e.g.: f in [a, b].map(f => f(fs.beginTx()))
+	iteratorParams map[js_ast.Ref]partExpr
+	// the number of times each identifier is used in this file
+	identifierUses map[js_ast.Ref]uint32
 	queries []queryPart
 	mergeImportRef js_ast.Ref
+	// part is the top level part containing the statement being visited
+	part *js_ast.Part
 }
 
 func NewFlowStateAnalyzer(compiler *FlowStateCompiler, file *graph.InputFile) *FlowStateAnalyzer {
@@ -76,6 +140,15 @@ func NewFlowStateAnalyzer(compiler *FlowStateCompiler, file *graph.InputFile) *F
 			aliases: map[js_ast.Ref]js_ast.Ref{},
 			serverFunctions: map[js_ast.Ref]*localFunction{},
 			serverFunctionsByCtxVar: map[js_ast.Ref]*localFunction{},
+			serverMethods: map[js_ast.Ref]map[string]*localFunction{},
+			returnValues: map[js_ast.Ref][]*js_ast.Expr{},
+			callResults: map[js_ast.Ref]*js_ast.Expr{},
+			params: map[js_ast.Ref]functionParam{},
+			escapedRefs: map[js_ast.Ref]bool{},
+			values: map[js_ast.Ref]partExpr{},
+			stringValues: map[js_ast.Ref]*js_ast.Expr{},
+			iteratorParams: map[js_ast.Ref]partExpr{},
+			identifierUses: map[js_ast.Ref]uint32{},
 			mergeImportRef: js_ast.InvalidRef,
 		}
 	}
@@ -90,10 +163,33 @@ func (a *FlowStateAnalyzer) VisitStmt(stmt *js_ast.Stmt, part *js_ast.Part) Stmt
 
 	// Since we're looking for a function call expression, we only need concern
 	// ourselves with statement types that can contain a function call.
+	if part != nil {
+		a.part = part
+	}
 	switch s := stmt.Data.(type) {
 	case *js_ast.SFunction:
-		if part != nil {
-			a.recordServerFunction(part, stmt, s)
+		if part != nil && s.Fn.Name != nil {
+			a.recordServerFunction(part, stmt, s, s.Fn.Name.Ref, s.IsExport, false)
+		}
+		if s.Fn.Name != nil {
+			a.recordParams(s.Fn.Name.Ref, s.Fn.Args, s.Fn.HasRestArg, nil)
+			if !s.Fn.IsAsync && !s.Fn.IsGenerator {
+				a.recordReturnValues(s.Fn.Name.Ref, s.Fn.Body.Stmts)
+			}
+		}
+	case *js_ast.SClass:
+		if part != nil && s.Class.Name != nil {
+			a.recordServerMethods(part, stmt, s.Class.Name.Ref, s.Class.Properties, true, s.IsExport, false)
+		}
+	case *js_ast.SExportDefault:
+		// The function or class statement is visited next without a part, so it's recorded here
+		if part != nil && s.Value.Stmt != nil {
+			switch d := s.Value.Stmt.Data.(type) {
+			case *js_ast.SFunction:
+				a.recordServerFunction(part, stmt, d, s.DefaultName.Ref, true, true)
+			case *js_ast.SClass:
+				a.recordServerMethods(part, stmt, s.DefaultName.Ref, d.Class.Properties, true, true, true)
+			}
 		}
 	case *js_ast.SExportFrom:
 		// We need to create a bridge from the exported Ref to the exported Ref in the file it references
@@ -115,20 +211,71 @@ func (a *FlowStateAnalyzer) VisitStmt(stmt *js_ast.Stmt, part *js_ast.Part) Stmt
 }
 
 func (a *FlowStateAnalyzer) Visit(stmt *js_ast.Stmt, expr *js_ast.Expr, decl *js_ast.Decl, parents []*js_ast.Expr, part *js_ast.Part) ExprVisitor {
+	if decl != nil && len(parents) == 0 {
+		a.recordValue(decl, expr)
+	}
 	switch e := expr.Data.(type)  {
 		case *js_ast.ECall:
 			a.recordFlowStateCall(expr, e)
+			a.calls = append(a.calls, e)
+			if dot, ok := e.Target.Data.(*js_ast.EDot); ok && arrayIteratorMethods[dot.Name] && len(e.Args) != 0 {
+				a.recordIteratorParam(&dot.Target, &e.Args[0])
+			}
+			if decl != nil && len(parents) == 0 {
+				a.recordCallResult(decl, expr)
+			}
+			switch fn := e.Target.Data.(type) {
+			case *js_ast.EArrow:
+				a.recordParams(js_ast.InvalidRef, fn.Args, fn.HasRestArg, e)
+			case *js_ast.EFunction:
+				a.recordParams(js_ast.InvalidRef, fn.Fn.Args, fn.Fn.HasRestArg, e)
+			}
 		case *js_ast.EArrow:
-			if part != nil && decl != nil {
+			if part != nil && decl != nil && len(parents) == 0 {
 				a.recordServerFunctionVar(part, stmt, stmt.Data.(*js_ast.SLocal), decl, expr, e, nil)
 			}
+			if decl != nil && len(parents) == 0 {
+				if identifier, ok := decl.Binding.Data.(*js_ast.BIdentifier); ok {
+					a.recordParams(identifier.Ref, e.Args, e.HasRestArg, nil)
+					if !e.IsAsync {
+						a.recordReturnValues(identifier.Ref, e.Body.Stmts)
+					}
+				}
+			}
 		case *js_ast.EFunction:
-			if part != nil && decl != nil {
+			if part != nil && decl != nil && len(parents) == 0 {
 				a.recordServerFunctionVar(part, stmt, stmt.Data.(*js_ast.SLocal), decl, expr, nil, e)
 			}
+			if decl != nil && len(parents) == 0 {
+				if identifier, ok := decl.Binding.Data.(*js_ast.BIdentifier); ok {
+					a.recordParams(identifier.Ref, e.Fn.Args, e.Fn.HasRestArg, nil)
+					if !e.Fn.IsAsync && !e.Fn.IsGenerator {
+						a.recordReturnValues(identifier.Ref, e.Fn.Body.Stmts)
+					}
+				}
+			}
+		case *js_ast.EObject:
+			if part != nil && decl != nil && len(parents) == 0 {
+				// Like recordServerFunctionVar, only exported objects can have server methods
+				identifier, ok := decl.Binding.Data.(*js_ast.BIdentifier)
+				if ok && stmt.Data.(*js_ast.SLocal).IsExport {
+					a.recordServerMethods(part, stmt, identifier.Ref, e.Properties, false, true, false)
+				}
+			}
+		case *js_ast.EClass:
+			// The bundler converts top level class statements to let Api = class {...}
+			if part != nil && decl != nil && len(parents) == 0 {
+				if identifier, ok := decl.Binding.Data.(*js_ast.BIdentifier); ok {
+					a.recordServerMethods(part, stmt, identifier.Ref, e.Class.Properties, true, stmt.Data.(*js_ast.SLocal).IsExport, false)
+				}
+			}
 		case *js_ast.ETemplate:
 			a.recordSQLTemplate(stmt, decl, parents, expr)
 		case *js_ast.EIdentifier:
+			if part == nil || !stmtIsExport(stmt.Data) || len(parents) != 0 {
+				// Exporting the identifier itself isn't a use, the uses in the importing modules are counted there
+				a.identifierUses[e.Ref]++
+			}
 			if part != nil && stmtIsExport(stmt.Data) {
 				a.recordExport(stmt.Data, decl, parents, e)
 			} else if decl != nil && (len(parents) == 0 || isTargetOfIndexOrDot(parents, e)) {
@@ -137,13 +284,21 @@ func (a *FlowStateAnalyzer) Visit(stmt *js_ast.Stmt, expr *js_ast.Expr, decl *js
 				// if e is the target (root) of an index or dot expression that is the right hand side expression
 				// e.g. foo = e[key] or foo = e.prop. See isTargetOfIndexOrDot for more details.
 				a.recordAlias(decl, e.Ref)
+			} else if !isCallTarget(parents, e) {
+				a.escapedRefs[e.Ref] = true
+				a.escapedUses = append(a.escapedUses, partExpr{part: a.part, expr: expr})
 			}
+			a.recordEscapedProperty(parents, e)
 		case *js_ast.EImportIdentifier:
 			if part != nil && stmtIsExport(stmt.Data) {
 				a.recordExport(stmt.Data, decl, parents, e)
 			} else if decl != nil && (len(parents) == 0 || isTargetOfIndexOrDot(parents, e)) {
 				a.recordAlias(decl, e.Ref)
+			} else if !isCallTarget(parents, e) {
+				a.escapedRefs[e.Ref] = true
+				a.escapedUses = append(a.escapedUses, partExpr{part: a.part, expr: expr})
 			}
+			a.recordEscapedProperty(parents, e)
 		case *js_ast.EBinary:
 			switch e.Op {
 			case js_ast.BinOpAssign:
@@ -173,7 +328,7 @@ func (a *FlowStateAnalyzer) recordExport(stmt js_ast.S, decl *js_ast.Decl, paren
 			}
 		}
 	default:
-		log.Printf("recordExport: %T stmt not handled yet\n", s)
+		a.compiler.trace(traceScan, nil, logger.Loc{}, "%s: exports from %T statements aren't traced", a.file.Source.PrettyPath, s)
 	}
 
 	if expRef == js_ast.InvalidRef {
@@ -213,29 +368,221 @@ func (a *FlowStateAnalyzer) recordExport(stmt js_ast.S, decl *js_ast.Decl, paren
 		}
 	}
 
-	log.Printf("map export %v -> %s", expRef, identName)
+	a.compiler.trace(traceScan, nil, logger.Loc{}, "%s: export %s refers to %s", a.file.Source.PrettyPath, a.ast.Symbols[expRef.InnerIndex].OriginalName, identName)
 	a.exports[expRef] = identifier
 }
 
 func (a *FlowStateAnalyzer) recordAlias(decl *js_ast.Decl, ref js_ast.Ref) {
 	if identifier, ok := decl.Binding.Data.(*js_ast.BIdentifier); ok {
-		log.Printf("alias %s -> %s\n", a.ast.Symbols[identifier.Ref.InnerIndex].OriginalName, a.ast.Symbols[ref.InnerIndex].OriginalName)
+		a.trace(traceScan, decl.Binding.Loc, "%s is an alias of %s", a.ast.Symbols[identifier.Ref.InnerIndex].OriginalName, a.compiler.refName(ref))
 		a.aliases[identifier.Ref] = ref
 	}
 }
 
-func (a *FlowStateAnalyzer) recordServerFunction(part *js_ast.Part, stmt *js_ast.Stmt, f *js_ast.SFunction) {
+// recordValue records the value of a variable if it can select a server function, so calls
+// through the variable can be traced to the server functions, or if it's a plain string that may be SQL
+func (a *FlowStateAnalyzer) recordValue(decl *js_ast.Decl, expr *js_ast.Expr) {
+	identifier, ok := decl.Binding.Data.(*js_ast.BIdentifier)
+	if !ok {
+		return
+	}
+	if isStringSQL(expr) {
+		a.stringValues[identifier.Ref] = expr
+		return
+	}
+	switch e := expr.Data.(type) {
+	case *js_ast.EIdentifier, *js_ast.EImportIdentifier, *js_ast.EDot, *js_ast.EIf, *js_ast.EArray:
+	case *js_ast.EBinary:
+		if e.Op != js_ast.BinOpLogicalOr && e.Op != js_ast.BinOpLogicalAnd && e.Op != js_ast.BinOpNullishCoalescing {
+			return
+		}
+	default:
+		return
+	}
+	a.values[identifier.Ref] = partExpr{part: a.part, expr: expr}
+}
+
+// arrayIteratorMethods are the array methods that call their callback with each element of the array
+var arrayIteratorMethods = map[string]bool{
+	"every":     true,
+	"filter":    true,
+	"find":      true,
+	"findIndex": true,
+	"flatMap":   true,
+	"forEach":   true,
+	"map":       true,
+	"some":      true,
+}
+
+// recordIteratorParam records the first parameter of callback, which is passed the elements of array
+func (a *FlowStateAnalyzer) recordIteratorParam(array *js_ast.Expr, callback *js_ast.Expr) {
+	var args []js_ast.Arg
+	switch fn := callback.Data.(type) {
+	case *js_ast.EArrow:
+		args = fn.Args
+	case *js_ast.EFunction:
+		args = fn.Fn.Args
+	}
+	if len(args) == 0 {
+		return
+	}
+	if identifier, ok := args[0].Binding.Data.(*js_ast.BIdentifier); ok {
+		a.iteratorParams[identifier.Ref] = partExpr{part: a.part, expr: array}
+	}
+}
+
+func (a *FlowStateAnalyzer) recordCallResult(decl *js_ast.Decl, call *js_ast.Expr) {
+	if identifier, ok := decl.Binding.Data.(*js_ast.BIdentifier); ok {
+		a.trace(traceScan, decl.Binding.Loc, "%s is the result of a call", a.ast.Symbols[identifier.Ref.InnerIndex].OriginalName)
+		a.callResults[identifier.Ref] = call
+	}
+}
+
+// recordParams records the parameters of a function, so a parameter can be traced to the
+// queries passed to it at the call sites of the function. Only simple identifier parameters
+// are recorded, not destructuring patterns or rest parameters.
+func (a *FlowStateAnalyzer) recordParams(fnRef js_ast.Ref, args []js_ast.Arg, hasRestArg bool, iife *js_ast.ECall) {
+	if hasRestArg {
+		args = args[:len(args)-1]
+	}
+	for i, arg := range args {
+		if identifier, ok := arg.Binding.Data.(*js_ast.BIdentifier); ok {
+			a.params[identifier.Ref] = functionParam{fn: fnRef, index: i, defaultValue: arg.Default, iife: iife, loc: arg.Binding.Loc}
+		}
+	}
+}
+
+// recordReturnValues records the values returned by the function body stmts, so a call to
+// the function can be traced to the queries it returns. A return statement without a value
+// is recorded as nil, which can't be traced to a query. Async functions and generators are
+// skipped, since calling them doesn't return the value of their return statements.
+func (a *FlowStateAnalyzer) recordReturnValues(fnRef js_ast.Ref, stmts []js_ast.Stmt) {
+	var values []*js_ast.Expr
+	var visit func(stmts []js_ast.Stmt)
+	visitStmt := func(stmt *js_ast.Stmt) {
+		visit([]js_ast.Stmt{*stmt})
+	}
+	visit = func(stmts []js_ast.Stmt) {
+		for i := range stmts {
+			// Nested functions are not visited, their return statements belong to them
+			switch s := stmts[i].Data.(type) {
+			case *js_ast.SReturn:
+				values = append(values, s.Value)
+			case *js_ast.SBlock:
+				visit(s.Stmts)
+			case *js_ast.SLabel:
+				visitStmt(&s.Stmt)
+			case *js_ast.SIf:
+				visitStmt(&s.Yes)
+				if s.No != nil {
+					visitStmt(s.No)
+				}
+			case *js_ast.SFor:
+				visitStmt(&s.Body)
+			case *js_ast.SForIn:
+				visitStmt(&s.Body)
+			case *js_ast.SForOf:
+				visitStmt(&s.Body)
+			case *js_ast.SDoWhile:
+				visitStmt(&s.Body)
+			case *js_ast.SWhile:
+				visitStmt(&s.Body)
+			case *js_ast.SWith:
+				visitStmt(&s.Body)
+			case *js_ast.STry:
+				visit(s.Body)
+				if s.Catch != nil {
+					visit(s.Catch.Body)
+				}
+				if s.Finally != nil {
+					visit(s.Finally.Stmts)
+				}
+			case *js_ast.SSwitch:
+				for _, kase := range s.Cases {
+					visit(kase.Body)
+				}
+			}
+		}
+	}
+	visit(stmts)
+
+	if len(values) != 0 {
+		a.returnValues[fnRef] = values
+	}
+}
+
+func (a *FlowStateAnalyzer) recordServerFunction(part *js_ast.Part, stmt *js_ast.Stmt, f *js_ast.SFunction, ref js_ast.Ref, isExport, isDefaultExport bool) {
 	if len(f.Fn.Args) == 0 {
 		// A server function requires a context argument
 		return
 	}
-	fun := &localFunction{part: part, stmt: stmt, fnStmt: f}
-	a.serverFunctions[f.Fn.Name.Ref] = fun
+	fun := &localFunction{part: part, stmt: stmt, fnStmt: f, loc: stmt.Loc, isExport: isExport, isDefaultExport: isDefaultExport}
+	if f.Fn.Name != nil {
+		fun.loc = f.Fn.Name.Loc
+	}
+	a.serverFunctions[ref] = fun
 	if ctx, ok := f.Fn.Args[0].Binding.Data.(*js_ast.BIdentifier); ok {
 		a.serverFunctionsByCtxVar[ctx.Ref] = fun
 	}
 }
 
+// recordServerMethods records the static methods of a class, or the methods of an object literal,
+// that could be server functions. They're called through the class or object, e.g. Api.getUser(fs.beginTx(), id)
+func (a *FlowStateAnalyzer) recordServerMethods(part *js_ast.Part, stmt *js_ast.Stmt, ref js_ast.Ref, props []js_ast.Property, isClass, isExport, isDefaultExport bool) {
+	for i := range props {
+		prop := &props[i]
+		if prop.IsComputed || prop.Value == nil || (isClass && (!prop.IsStatic || !prop.IsMethod)) {
+			continue
+		}
+		key, ok := prop.Key.Data.(*js_ast.EString)
+		if !ok {
+			continue
+		}
+
+		fun := &localFunction{
+			part: part,
+			stmt: stmt,
+			method: js_lexer.UTF16ToString(key.Value),
+			value: prop.Value,
+			loc: prop.Key.Loc,
+			isExport: isExport,
+			isDefaultExport: isDefaultExport,
+		}
+		var args []js_ast.Arg
+		switch fn := prop.Value.Data.(type) {
+		case *js_ast.EFunction:
+			fun.fnExpr = fn
+			args = fn.Fn.Args
+		case *js_ast.EArrow:
+			fun.fnArrow = fn
+			args = fn.Args
+		}
+		if len(args) == 0 {
+			// A server function requires a context argument
+			continue
+		}
+
+		if a.serverMethods[ref] == nil {
+			a.serverMethods[ref] = map[string]*localFunction{}
+		}
+		a.serverMethods[ref][fun.method] = fun
+		if ctx, ok := args[0].Binding.Data.(*js_ast.BIdentifier); ok {
+			a.serverFunctionsByCtxVar[ctx.Ref] = fun
+		}
+	}
+}
+
+// serverFunctionPart returns the top level part declaring the server function, or the class or object of a server method
+func (a *FlowStateAnalyzer) serverFunctionPart(ref js_ast.Ref) *js_ast.Part {
+	if f := a.serverFunctions[ref]; f != nil {
+		return f.part
+	}
+	for _, f := range a.serverMethods[ref] {
+		return f.part
+	}
+	return nil
+}
+
 func (a *FlowStateAnalyzer) recordServerFunctionVar(part *js_ast.Part, stmt *js_ast.Stmt, local *js_ast.SLocal, decl *js_ast.Decl, expr *js_ast.Expr, arrowFn *js_ast.EArrow, fnExpr *js_ast.EFunction) {
 	if !local.IsExport {
 		return
@@ -260,6 +607,8 @@ func (a *FlowStateAnalyzer) recordServerFunctionVar(part *js_ast.Part, stmt *js_
 			fnExpr: fnExpr,
 			local: local,
 			decl: decl,
+			loc: decl.Binding.Loc,
+			isExport: local.IsExport,
 		}
 
 		if ctx, ok := args[0].Binding.Data.(*js_ast.BIdentifier); ok {
@@ -310,7 +659,7 @@ func (a *FlowStateAnalyzer) recordSQLTemplate(stmt *js_ast.Stmt, decl *js_ast.De
 		return false
 	}
 
-	log.Printf("found sql template starting with %s\n", template.HeadRaw)
+	a.trace(traceQuery, expr.Loc, "found sql template starting with %q", template.HeadRaw)
 
 	// Find the base expression, the root expression containing the query literal
 	var assign interface{} = stmt.Data
@@ -333,25 +682,35 @@ CheckParents:
 				break CheckParents
 			}
 		case *js_ast.ECall:
-			var iface interface{} = template
-			// If the template is inlined directly into a executeQuery expression, record it here
-			if i == start && e.Args[0].Data == iface {
-				// Invent a reference and add it to inlineTemplates map using the template pointer as the key
-				highestInner := uint32(0x00ffffff)
-				for _, r := range a.inlineTemplates {
-					if r.InnerIndex > highestInner {
-						highestInner = r.InnerIndex
-					}
-				}
-				highestInner++
-				ref = js_ast.Ref{SourceIndex: a.file.Source.Index, InnerIndex: highestInner}
-				a.inlineTemplates[template] = ref
-				log.Printf("template is inline in call expr, tagging with ref %v\n", ref)
+			// If the template is inlined directly into a executeQuery expression, or passed
+			// to a function that may pass it on to executeQuery, record it here. It may be
+			// a branch of a ternary or logical expression that is the argument.
+			var arg js_ast.E = template
+			if i < start {
+				arg = parents[i+1].Data
+			}
+			if isCallArg(e, arg) && isConditionalChain(parents[i+1:]) {
+				ref = a.recordInlineTemplate(template)
+				a.trace(traceQuery, expr.Loc, "sql template is inline in a call")
 				break CheckParents
 			}
 		}
 	}
 
+	if ref == js_ast.InvalidRef && isDefaultArg(stmt, parents, template) {
+		// The template is the default value of a function parameter, which is traced like
+		// the queries passed to the parameter, e.g.: function run(query = sql`...`) {}
+		ref = a.recordInlineTemplate(template)
+		a.trace(traceQuery, expr.Loc, "sql template is the default value of a parameter")
+	}
+
+	if _, ok := assign.(*js_ast.SReturn); ok && ref == js_ast.InvalidRef && isConditionalChain(parents) {
+		// The template is returned from a function, calls to the function are traced to it
+		// through the returned expression, e.g.: function makeQuery() { return sql`...` }
+		ref = a.recordInlineTemplate(template)
+		a.trace(traceQuery, expr.Loc, "sql template is returned from a function")
+	}
+
 	if ref == js_ast.InvalidRef {
 		// Now look for the base expression in the assignment statement to get the identifier for it
 		// ref is now the identifier reference for the (expression) containing the query literal
@@ -371,9 +730,10 @@ CheckParents:
 		return false
 	}
 
-	log.Printf("add query %q with ref %v\n", template.HeadRaw, ref)
+	a.trace(traceQuery, expr.Loc, "found query %s", a.compiler.refName(ref))
 	a.queries = append(a.queries, queryPart{
 		ref:           ref,
+		part:          a.part,
 		parent:        expr,
 		template:      template,
 		definedSource: &a.file.Source,
@@ -383,6 +743,38 @@ CheckParents:
 	return true
 }
 
+// recordInlineTemplate invents a reference for a template that isn't assigned to anything
+// and adds it to the inlineTemplates map using the template pointer as the key
+func (a *FlowStateAnalyzer) recordInlineTemplate(template *js_ast.ETemplate) js_ast.Ref {
+	highestInner := uint32(0x00ffffff)
+	for _, r := range a.inlineTemplates {
+		if r.InnerIndex > highestInner {
+			highestInner = r.InnerIndex
+		}
+	}
+	highestInner++
+	ref := js_ast.Ref{SourceIndex: a.file.Source.Index, InnerIndex: highestInner}
+	a.inlineTemplates[template] = ref
+	return ref
+}
+
+// isConditionalChain returns true if parents consists only of ternary conditions
+// and binary && or || operations
+func isConditionalChain(parents []*js_ast.Expr) bool {
+	for _, parent := range parents {
+		switch e := parent.Data.(type) {
+		case *js_ast.EIf:
+		case *js_ast.EBinary:
+			if e.Op != js_ast.BinOpLogicalAnd && e.Op != js_ast.BinOpLogicalOr {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
 const queryExecuteMethodName = "executeQuery"
 const beginTransactionMethodName = "beginTx"
 const serverCallMethodName = "serverCall"
@@ -416,6 +808,7 @@ func (a *FlowStateAnalyzer) recordFlowStateCall(parent *js_ast.Expr, call *js_as
 	case *js_ast.EDot:
 		// Calling a property access expression - could be either a server or query call
 		isServer := false
+		var fn *localFunction
 		if target.Name == queryExecuteMethodName {
 			// This is a possible query execution, we'll verify them after we've visited all files
 
@@ -423,19 +816,22 @@ func (a *FlowStateAnalyzer) recordFlowStateCall(parent *js_ast.Expr, call *js_as
 			// that has been recorded as a possible server call, then this is a server query execution.
 			switch lhs := target.Target.Data.(type) {
 			case *js_ast.EIdentifier:
-				f := a.serverFunctionsByCtxVar[lhs.Ref]
-				isServer = f != nil
+				fn = a.serverFunctionsByCtxVar[lhs.Ref]
+				isServer = fn != nil
 				if isServer {
-					log.Println("found server queryExecute")
+					a.trace(traceQuery, call.Target.Loc, "found executeQuery in server function")
 				} else {
-					log.Println("found client queryExecute")
+					a.trace(traceQuery, call.Target.Loc, "found executeQuery in client code")
 				}
 			}
-			a.queryExecutions = append(a.queryExecutions, queryExecution{call: call, isServer: isServer})
+			a.queryExecutions = append(a.queryExecutions, queryExecution{call: call, isServer: isServer, fn: fn})
 			return true
 		} else {
 			return a.recordServerCall(parent, call)
 		}
+	default:
+		// Any other target is an error if it's a server call, since it can't be traced to a server function
+		return a.recordServerCall(parent, call)
 	}
 	return false
 }
@@ -453,8 +849,8 @@ func (a *FlowStateAnalyzer) recordServerCall(parent *js_ast.Expr, call *js_ast.E
 			}
 
 			// We have a function call where the first argument is xxx.beginTx(). That's a server call.
-			log.Println("record server call")
-			a.serverCalls = append(a.serverCalls, serverCall{parent: parent, call: call, fsInstance: dot})
+			a.trace(traceServerCall, call.Target.Loc, "found server call")
+			a.serverCalls = append(a.serverCalls, serverCall{part: a.part, parent: parent, call: call, fsInstance: dot})
 		}
 	}
 	return false
@@ -471,6 +867,79 @@ func stmtIsExport(stmt js_ast.S) bool {
 	}
 }
 
+// isDefaultArg returns true if the template is the default value of a parameter of the function
+// that is its parent, or of the function statement if it has no parent
+func isDefaultArg(stmt *js_ast.Stmt, parents []*js_ast.Expr, template *js_ast.ETemplate) bool {
+	var args []js_ast.Arg
+	if len(parents) == 0 {
+		if s, ok := stmt.Data.(*js_ast.SFunction); ok {
+			args = s.Fn.Args
+		}
+	} else {
+		switch e := parents[len(parents)-1].Data.(type) {
+		case *js_ast.EArrow:
+			args = e.Args
+		case *js_ast.EFunction:
+			args = e.Fn.Args
+		}
+	}
+	for _, arg := range args {
+		if arg.Default != nil && arg.Default.Data == template {
+			return true
+		}
+	}
+	return false
+}
+
+func isCallArg(call *js_ast.ECall, expr js_ast.E) bool {
+	for _, arg := range call.Args {
+		if arg.Data == expr {
+			return true
+		}
+	}
+	return false
+}
+
+// recordEscapedProperty records the property access on the identifier if it's used as a value, or the call if it's called
+func (a *FlowStateAnalyzer) recordEscapedProperty(parents []*js_ast.Expr, identifier js_ast.E) {
+	if len(parents) == 0 {
+		return
+	}
+	access := parents[len(parents)-1]
+	switch e := access.Data.(type) {
+	case *js_ast.EDot:
+		if e.Target.Data != identifier {
+			return
+		}
+	case *js_ast.EIndex:
+		if e.Target.Data != identifier {
+			return
+		}
+	default:
+		return
+	}
+	if !isCallTarget(parents[:len(parents)-1], access.Data) {
+		a.escapedPropertyUses = append(a.escapedPropertyUses, partExpr{part: a.part, expr: access})
+		return
+	}
+	call := propertyCall{part: a.part, call: parents[len(parents)-2].Data.(*js_ast.ECall)}
+	for i := 0; i < len(parents) && call.fn == nil; i++ {
+		switch parents[i].Data.(type) {
+		case *js_ast.EFunction, *js_ast.EArrow:
+			call.fn = parents[i].Data
+		}
+	}
+	a.propertyCalls = append(a.propertyCalls, call)
+}
+
+func isCallTarget(parents []*js_ast.Expr, identifier js_ast.E) bool {
+	if len(parents) == 0 {
+		return false
+	}
+	call, ok := parents[len(parents)-1].Data.(*js_ast.ECall)
+	return ok && call.Target.Data == identifier
+}
+
 func isTargetOfIndexOrDot(parents []*js_ast.Expr, identifier js_ast.E) bool {
 	if len(parents) != 1 {
 		return false
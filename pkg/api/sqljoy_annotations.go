@@ -0,0 +1,154 @@
+package api
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// queryPolicy is the access policy of a query, the runtime enforces it for the query id
+type queryPolicy struct {
+	Roles []string `json:"roles"`
+}
+
+// queryLimits are the limits the backend enforces for the query id
+type queryLimits struct {
+	MaxRows        int        `json:"maxRows,omitempty"`
+	TimeoutMs      int64      `json:"timeoutMs,omitempty"`
+	RatePerSession *queryRate `json:"ratePerSession,omitempty"`
+}
+
+// queryRate allows Count executions of the query in every PeriodMs
+type queryRate struct {
+	Count    int   `json:"count"`
+	PeriodMs int64 `json:"periodMs"`
+}
+
+var ratePeriods = map[string]time.Duration{
+	"s": time.Second, "sec": time.Second, "second": time.Second,
+	"m": time.Minute, "min": time.Minute, "minute": time.Minute,
+	"h": time.Hour, "hour": time.Hour,
+	"d": 24 * time.Hour, "day": 24 * time.Hour,
+}
+
+var reAnnotation = regexp.MustCompile(`^--\s*@(\w+)(.*)$`)
+
+// parseAnnotations reads the "-- @name value" comments at the start of the query text, e.g.
+//
+//	sql`-- @roles admin, support
+//	SELECT * FROM users WHERE id = ${id}`
+//
+// The annotations are:
+//
+//	-- @roles admin, support  the roles allowed to run the query, see queryPolicy
+//	-- @maxRows 100           the most rows the query may return
+//	-- @timeout 5s            how long the query may run for
+//	-- @rate 10/min           how often a session may run the query, the period can also be a duration like 30s
+//
+// Other comments are ignored, the annotations end at the first line that isn't a comment.
+func (q *query) parseAnnotations(c *FlowStateCompiler) {
+	if q.annotationsParsed {
+		return // the query is used by more than one executeQuery call
+	}
+	q.annotationsParsed = true
+
+	for _, line := range strings.Split(q.QueryText, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "--") {
+			break
+		}
+		match := reAnnotation.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+
+		name, value := match[1], strings.TrimSpace(match[2])
+		switch name {
+		case "roles":
+			roles := strings.FieldsFunc(value, func(r rune) bool { return r == ',' || unicode.IsSpace(r) })
+			if len(roles) == 0 {
+				c.log.AddError(q.definedSource, q.parent.Loc, "@roles requires at least one role")
+				continue
+			}
+			if q.Policy == nil {
+				q.Policy = &queryPolicy{}
+			}
+			q.Policy.Roles = append(q.Policy.Roles, roles...)
+		case "maxRows", "timeout", "rate":
+			if q.Limits == nil {
+				q.Limits = &queryLimits{}
+			}
+			if err := q.Limits.set(name, value); err != nil {
+				c.log.AddError(q.definedSource, q.parent.Loc, fmt.Sprintf("invalid @%s annotation: %s", name, err.Error()))
+			}
+		default:
+			c.log.AddError(q.definedSource, q.parent.Loc, fmt.Sprintf("unknown query annotation @%s", name))
+		}
+	}
+}
+
+func (limits *queryLimits) set(name, value string) error {
+	switch name {
+	case "maxRows":
+		if limits.MaxRows != 0 {
+			return fmt.Errorf("duplicate annotation")
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("%q is not a positive integer", value)
+		}
+		limits.MaxRows = n
+
+	case "timeout":
+		if limits.TimeoutMs != 0 {
+			return fmt.Errorf("duplicate annotation")
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil || d < time.Millisecond {
+			return fmt.Errorf("%q is not a duration of at least 1ms, e.g. 5s", value)
+		}
+		limits.TimeoutMs = d.Milliseconds()
+
+	case "rate":
+		if limits.RatePerSession != nil {
+			return fmt.Errorf("duplicate annotation")
+		}
+		rate, err := parseRate(value)
+		if err != nil {
+			return err
+		}
+		limits.RatePerSession = rate
+	}
+	return nil
+}
+
+// parseRate parses count/period, where period is a unit (s, min, h, day...) or a duration (30s)
+func parseRate(value string) (*queryRate, error) {
+	slash := strings.IndexByte(value, '/')
+	if slash < 0 {
+		return nil, fmt.Errorf("%q is not a rate, e.g. 10/min", value)
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(value[:slash]))
+	if err != nil || count <= 0 {
+		return nil, fmt.Errorf("%q is not a positive integer", strings.TrimSpace(value[:slash]))
+	}
+
+	unit := strings.TrimSpace(value[slash+1:])
+	period, ok := ratePeriods[strings.TrimSuffix(unit, "s")]
+	if !ok {
+		period, ok = ratePeriods[unit]
+	}
+	if !ok {
+		period, err = time.ParseDuration(unit)
+		if err != nil || period < time.Millisecond {
+			return nil, fmt.Errorf("%q is not a period, e.g. min or 30s", unit)
+		}
+	}
+	return &queryRate{Count: count, PeriodMs: period.Milliseconds()}, nil
+}
@@ -0,0 +1,535 @@
+package api
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/evanw/esbuild/internal/js_ast"
+	"github.com/evanw/esbuild/internal/js_lexer"
+	"github.com/evanw/esbuild/internal/js_printer"
+	"github.com/evanw/esbuild/internal/logger"
+)
+
+// argType is a JSON compatible TypeScript type of a server function parameter,
+// which is checked at runtime before the server function is called
+type argType struct {
+	kind    argTypeKind
+	literal string     // the JS source of a literal type
+	elem    *argType   // the element type of an array, or the value type of a record
+	items   []*argType // the types of a tuple, or the alternatives of a union
+	props   []argProp  // the properties of an object
+}
+
+type argTypeKind uint8
+
+const (
+	argAny argTypeKind = iota // any and unknown aren't checked
+	argString
+	argNumber
+	argBoolean
+	argNull
+	argUndefined
+	argLiteral
+	argArray
+	argTuple
+	argObject
+	argRecord
+	argUnion
+)
+
+type argProp struct {
+	name     string
+	optional bool
+	t        *argType
+}
+
+// parseArgType parses the source of a TypeScript type annotation.
+// It returns an error for types that can't be checked, like named types and functions.
+func parseArgType(source string) (t *argType, err error) {
+	p := argTypeParser{tokens: tokenizeArgType(source)}
+	defer func() {
+		if r := recover(); r != nil {
+			if parseErr, ok := r.(argTypeError); ok {
+				t, err = nil, parseErr
+				return
+			}
+			panic(r)
+		}
+	}()
+	t = p.parseUnion()
+	if p.peek() != "" {
+		p.fail("unexpected %q", p.peek())
+	}
+	return t, nil
+}
+
+type argTypeError struct{ msg string }
+
+func (e argTypeError) Error() string { return e.msg }
+
+type argTypeParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *argTypeParser) fail(format string, args ...interface{}) {
+	panic(argTypeError{fmt.Sprintf(format, args...)})
+}
+
+func (p *argTypeParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *argTypeParser) next() string {
+	token := p.peek()
+	if token == "" {
+		p.fail("unexpected end of type")
+	}
+	p.pos++
+	return token
+}
+
+func (p *argTypeParser) expect(token string) {
+	if next := p.next(); next != token {
+		p.fail("expected %q but found %q", token, next)
+	}
+}
+
+func (p *argTypeParser) parseUnion() *argType {
+	if p.peek() == "|" {
+		p.next()
+	}
+	t := p.parsePostfix()
+	if p.peek() != "|" {
+		return t
+	}
+	union := &argType{kind: argUnion, items: []*argType{t}}
+	for p.peek() == "|" {
+		p.next()
+		union.items = append(union.items, p.parsePostfix())
+	}
+	return union
+}
+
+func (p *argTypeParser) parsePostfix() *argType {
+	if p.peek() == "readonly" {
+		p.next()
+	}
+	t := p.parsePrimary()
+	for p.peek() == "[" {
+		p.next()
+		p.expect("]")
+		t = &argType{kind: argArray, elem: t}
+	}
+	if p.peek() == "&" {
+		p.fail("intersection types aren't supported")
+	}
+	return t
+}
+
+func (p *argTypeParser) parsePrimary() *argType {
+	token := p.next()
+	switch token {
+	case "(":
+		t := p.parseUnion()
+		p.expect(")")
+		return t
+	case "{":
+		return p.parseObject()
+	case "[":
+		tuple := &argType{kind: argTuple}
+		for p.peek() != "]" {
+			tuple.items = append(tuple.items, p.parseUnion())
+			if p.peek() != "," {
+				break
+			}
+			p.next()
+		}
+		p.expect("]")
+		return tuple
+	case "any", "unknown":
+		return &argType{kind: argAny}
+	case "string":
+		return &argType{kind: argString}
+	case "number":
+		return &argType{kind: argNumber}
+	case "boolean":
+		return &argType{kind: argBoolean}
+	case "null":
+		return &argType{kind: argNull}
+	case "undefined", "void":
+		return &argType{kind: argUndefined}
+	case "true", "false":
+		return &argType{kind: argLiteral, literal: token}
+	case "Array", "ReadonlyArray":
+		p.expect("<")
+		t := &argType{kind: argArray, elem: p.parseUnion()}
+		p.expect(">")
+		return t
+	case "Record":
+		p.expect("<")
+		if key := p.next(); key != "string" {
+			p.fail("only Record<string, T> is supported")
+		}
+		p.expect(",")
+		t := &argType{kind: argRecord, elem: p.parseUnion()}
+		p.expect(">")
+		return t
+	}
+
+	switch c := token[0]; {
+	case c == '"' || c == '\'':
+		return &argType{kind: argLiteral, literal: string(js_printer.QuoteForJSON(unquoteArgTypeString(token), false))}
+	case c == '-' || (c >= '0' && c <= '9'):
+		return &argType{kind: argLiteral, literal: token}
+	case js_lexer.IsIdentifier(token):
+		p.fail("type %s can't be checked, only JSON compatible types declared inline are supported", token)
+	}
+	p.fail("unexpected %q", token)
+	return nil
+}
+
+func (p *argTypeParser) parseObject() *argType {
+	object := &argType{kind: argObject}
+	for p.peek() != "}" {
+		if p.peek() == "readonly" && p.pos+1 < len(p.tokens) && p.tokens[p.pos+1] != ":" && p.tokens[p.pos+1] != "?" {
+			p.next()
+		}
+
+		// An index signature, e.g.: { [key: string]: number }
+		if p.peek() == "[" {
+			p.next()
+			p.next() // the name of the key
+			p.expect(":")
+			if key := p.next(); key != "string" {
+				p.fail("only string index signatures are supported")
+			}
+			p.expect("]")
+			p.expect(":")
+			if len(object.props) != 0 || object.elem != nil {
+				p.fail("an index signature can't be mixed with other properties")
+			}
+			object.kind = argRecord
+			object.elem = p.parseUnion()
+		} else {
+			name := p.next()
+			if name[0] == '"' || name[0] == '\'' {
+				name = unquoteArgTypeString(name)
+			} else if !js_lexer.IsIdentifier(name) && (name[0] < '0' || name[0] > '9') {
+				p.fail("unexpected %q", name)
+			}
+			prop := argProp{name: name}
+			if p.peek() == "?" {
+				p.next()
+				prop.optional = true
+			}
+			if p.peek() == "(" {
+				p.fail("methods can't be checked")
+			}
+			p.expect(":")
+			prop.t = p.parseUnion()
+			if object.kind == argRecord {
+				p.fail("an index signature can't be mixed with other properties")
+			}
+			object.props = append(object.props, prop)
+		}
+
+		if p.peek() != ";" && p.peek() != "," {
+			break
+		}
+		p.next()
+	}
+	p.expect("}")
+	return object
+}
+
+// tokenizeArgType splits the source of a type into identifiers, numbers, strings and punctuation.
+// Comments are skipped, "=>" is kept as one token so function types are reported as unexpected.
+func tokenizeArgType(source string) []string {
+	var tokens []string
+	for i := 0; i < len(source); {
+		c := source[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case strings.HasPrefix(source[i:], "//"):
+			end := strings.IndexByte(source[i:], '\n')
+			if end == -1 {
+				return tokens
+			}
+			i += end
+		case strings.HasPrefix(source[i:], "/*"):
+			end := strings.Index(source[i+2:], "*/")
+			if end == -1 {
+				return tokens
+			}
+			i += end + 4
+		case c == '"' || c == '\'':
+			end := i + 1
+			for end < len(source) && source[end] != c {
+				if source[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end < len(source) {
+				end++
+			}
+			tokens = append(tokens, source[i:end])
+			i = end
+		case strings.HasPrefix(source[i:], "=>"):
+			tokens = append(tokens, "=>")
+			i += 2
+		case c == '-' || (c >= '0' && c <= '9') || c == '_' || c == '$' || c >= 0x80 || (c|0x20 >= 'a' && c|0x20 <= 'z'):
+			end := i + 1
+			for end < len(source) {
+				c := source[end]
+				if c == '.' || c == '_' || c == '$' || c >= 0x80 || (c >= '0' && c <= '9') || (c|0x20 >= 'a' && c|0x20 <= 'z') {
+					end++
+				} else {
+					break
+				}
+			}
+			tokens = append(tokens, source[i:end])
+			i = end
+		default:
+			tokens = append(tokens, source[i:i+1])
+			i++
+		}
+	}
+	return tokens
+}
+
+func unquoteArgTypeString(token string) string {
+	token = token[1 : len(token)-1]
+	if !strings.Contains(token, "\\") {
+		return token
+	}
+	var sb strings.Builder
+	for i := 0; i < len(token); i++ {
+		if token[i] == '\\' && i+1 < len(token) {
+			i++
+		}
+		sb.WriteByte(token[i])
+	}
+	return sb.String()
+}
+
+// check returns a JS expression that's true if value has the type, or "" if any value has the type.
+// depth is used to name the variables of nested checks.
+func (t *argType) check(value string, depth int) string {
+	switch t.kind {
+	case argString:
+		return fmt.Sprintf(`typeof %s === "string"`, value)
+	case argNumber:
+		return fmt.Sprintf(`typeof %s === "number"`, value)
+	case argBoolean:
+		return fmt.Sprintf(`typeof %s === "boolean"`, value)
+	case argNull:
+		return fmt.Sprintf(`%s === null`, value)
+	case argUndefined:
+		return fmt.Sprintf(`%s === undefined`, value)
+	case argLiteral:
+		return fmt.Sprintf(`%s === %s`, value, t.literal)
+	case argArray:
+		v := fmt.Sprintf("v%d", depth)
+		if elem := t.elem.check(v, depth+1); elem != "" {
+			return fmt.Sprintf(`Array.isArray(%s) && %s.every((%s) => %s)`, value, value, v, elem)
+		}
+		return fmt.Sprintf(`Array.isArray(%s)`, value)
+	case argTuple:
+		checks := []string{fmt.Sprintf(`Array.isArray(%s) && %s.length === %d`, value, value, len(t.items))}
+		for i, item := range t.items {
+			if check := item.check(fmt.Sprintf("%s[%d]", value, i), depth); check != "" {
+				checks = append(checks, parenthesize(check))
+			}
+		}
+		return strings.Join(checks, " && ")
+	case argObject:
+		checks := []string{isPlainObject(value)}
+		for _, prop := range t.props {
+			propValue := value + "." + prop.name
+			if !js_lexer.IsIdentifier(prop.name) {
+				propValue = fmt.Sprintf("%s[%s]", value, js_printer.QuoteForJSON(prop.name, false))
+			}
+			check := prop.t.check(propValue, depth)
+			if check == "" {
+				if !prop.optional {
+					checks = append(checks, fmt.Sprintf("%s in %s", js_printer.QuoteForJSON(prop.name, false), value))
+				}
+				continue
+			}
+			if prop.optional {
+				check = fmt.Sprintf("%s === undefined || %s", propValue, parenthesize(check))
+			}
+			checks = append(checks, parenthesize(check))
+		}
+		return strings.Join(checks, " && ")
+	case argRecord:
+		v := fmt.Sprintf("v%d", depth)
+		if elem := t.elem.check(v, depth+1); elem != "" {
+			return fmt.Sprintf(`%s && Object.values(%s).every((%s) => %s)`, isPlainObject(value), value, v, elem)
+		}
+		return isPlainObject(value)
+	case argUnion:
+		checks := make([]string, 0, len(t.items))
+		for _, item := range t.items {
+			check := item.check(value, depth)
+			if check == "" {
+				return ""
+			}
+			checks = append(checks, parenthesize(check))
+		}
+		return strings.Join(checks, " || ")
+	}
+	return ""
+}
+
+func isPlainObject(value string) string {
+	return fmt.Sprintf(`typeof %s === "object" && %s !== null && !Array.isArray(%s)`, value, value, value)
+}
+
+// parenthesize wraps a check in parentheses if it's made of more than one comparison
+func parenthesize(check string) string {
+	if strings.Contains(check, "&&") || strings.Contains(check, "||") {
+		return "(" + check + ")"
+	}
+	return check
+}
+
+// argValidator checks the arguments of a server call against the TypeScript types of the server function's parameters
+type argValidator struct {
+	source  *logger.Source // where the server function is declared
+	loc     logger.Loc
+	maxArgs int // -1 if the function has a rest parameter
+	checks  []argCheck
+}
+
+type argCheck struct {
+	check    string // the JS expression that's true if the argument is valid
+	name     string
+	typeText string
+	loc      logger.Loc // the location of the type annotation
+}
+
+// argValidator returns the validator for the arguments of a call to the server function named name.
+// The first parameter, the context, isn't passed by the client. It returns nil if there's nothing to check.
+// The parameters with types that can't be checked are reported with the unchecked-arg lint rule.
+func (c *FlowStateCompiler) argValidator(definer *FlowStateAnalyzer, f *localFunction, name string) *argValidator {
+	var args []js_ast.Arg
+	var hasRestArg bool
+	switch {
+	case f.fnStmt != nil:
+		args, hasRestArg = f.fnStmt.Fn.Args, f.fnStmt.Fn.HasRestArg
+	case f.fnExpr != nil:
+		args, hasRestArg = f.fnExpr.Fn.Args, f.fnExpr.Fn.HasRestArg
+	case f.fnArrow != nil:
+		args, hasRestArg = f.fnArrow.Args, f.fnArrow.HasRestArg
+	}
+	if len(args) < 2 {
+		return nil
+	}
+
+	contents := definer.file.Source.Contents
+	validator := &argValidator{source: &definer.file.Source, loc: f.loc, maxArgs: len(args) - 1}
+	if hasRestArg {
+		validator.maxArgs = -1
+	}
+	isTyped := false
+	for i, arg := range args[1:] {
+		if arg.TSTypeRange.Len == 0 {
+			continue
+		}
+		source := contents[arg.TSTypeRange.Loc.Start:arg.TSTypeRange.End()]
+		t, err := parseArgType(source)
+		if err != nil {
+			c.trace(traceValidator, &definer.file.Source, arg.TSTypeRange.Loc, "not checking argument %s of the server function: %s", argName(definer, arg, i), err.Error())
+			c.lint(lintUncheckedArg, &definer.file.Source, arg.TSTypeRange.Loc, fmt.Sprintf(
+				"argument %s of server function %s isn't validated, its type can't be checked at runtime: only JSON compatible types declared inline are supported",
+				argName(definer, arg, i), name))
+			continue
+		}
+		isTyped = true
+
+		name := argName(definer, arg, i)
+		value := fmt.Sprintf("args[%d]", i)
+		isRest := hasRestArg && i == len(args)-2
+		if isRest {
+			value = fmt.Sprintf("args.slice(%d)", i)
+		}
+		check := t.check(value, 0)
+		if check == "" {
+			continue
+		}
+		if !isRest && (arg.Default != nil || isOptionalParam(contents, arg.TSTypeRange.Loc.Start)) {
+			check = fmt.Sprintf("%s === undefined || %s", value, parenthesize(check))
+		}
+		validator.checks = append(validator.checks, argCheck{
+			check:    check,
+			name:     name,
+			typeText: strings.TrimSpace(source),
+			loc:      arg.TSTypeRange.Loc,
+		})
+	}
+	if !isTyped {
+		return nil
+	}
+	c.trace(traceValidator, &definer.file.Source, f.loc, "generated an argument validator with %d checks", len(validator.checks))
+	return validator
+}
+
+// argName returns the name of the parameter for error messages, or its position if it's destructured
+func argName(definer *FlowStateAnalyzer, arg js_ast.Arg, i int) string {
+	if identifier, ok := arg.Binding.Data.(*js_ast.BIdentifier); ok {
+		return definer.ast.Symbols[identifier.Ref.InnerIndex].OriginalName
+	}
+	return fmt.Sprintf("%d", i+1)
+}
+
+// isOptionalParam returns true if the type annotation starting at typeStart is for an optional parameter, e.g.: (id?: string)
+func isOptionalParam(contents string, typeStart int32) bool {
+	before := strings.TrimRight(contents[:typeStart], " \t\r\n")
+	before = strings.TrimSuffix(before, ":")
+	before = strings.TrimRight(before, " \t\r\n")
+	return strings.HasSuffix(before, "?")
+}
+
+// writeArgValidators adds the argument validators of the server functions to the server entry point,
+// keyed by the hashes of the server functions like the functions map
+func writeArgValidators(sb *strings.Builder, argValidators map[string]*argValidator, entryMap *serverEntryMap) {
+	hashes := make([]string, 0, len(argValidators))
+	for hash, validator := range argValidators {
+		if validator != nil {
+			hashes = append(hashes, hash)
+		}
+	}
+	sort.Strings(hashes)
+
+	sb.WriteString("\nexport const argValidators = {\n")
+	for i, hash := range hashes {
+		validator := argValidators[hash]
+		if i != 0 {
+			sb.WriteString(",\n")
+		}
+		entryMap.add(sb, validator.source, validator.loc)
+		fmt.Fprintf(sb, "\t\"%s\": (args) => {\n", hash)
+		if validator.maxArgs != -1 {
+			entryMap.add(sb, validator.source, validator.loc)
+			fmt.Fprintf(sb, "\t\tif (args.length > %d) throw new TypeError(\"expected at most %d arguments\");\n", validator.maxArgs, validator.maxArgs)
+		}
+		for _, check := range validator.checks {
+			entryMap.add(sb, validator.source, check.loc)
+			fmt.Fprintf(sb, "\t\tif (!(%s)) _invalidArg(%s, %s);\n", check.check,
+				js_printer.QuoteForJSON(check.name, false), js_printer.QuoteForJSON(check.typeText, false))
+		}
+		sb.WriteString("\t}")
+	}
+	sb.WriteString("\n};\n")
+	if len(hashes) != 0 {
+		sb.WriteString("\nfunction _invalidArg(name, type) {\n\tthrow new TypeError(\"invalid argument \" + name + \", expected \" + type);\n}\n")
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"path"
+	"regexp"
 	"sort"
 	"strings"
 	"sync"
@@ -30,7 +31,9 @@ func (a importsByModule) Len() int           { return len(a) }
 func (a importsByModule) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
 func (a importsByModule) Less(i, j int) bool { return a[i].module < a[j].module }
 
-func writeImports(sb *strings.Builder, functions map[string]importsByName) importsByModule {
+// writeImports imports the functions into the server entry, as aliases starting with aliasPrefix.
+// The validators and the server functions are imported into the same file, so they need different prefixes.
+func writeImports(sb *strings.Builder, functions map[string]importsByName, aliasPrefix string, entryMap *serverEntryMap) importsByModule {
 	orderedFuncs := make(importsByModule, len(functions))
 	i := 0
 	for module, imports := range functions {
@@ -48,9 +51,10 @@ func writeImports(sb *strings.Builder, functions map[string]importsByName) impor
 			if j != 0 {
 				sb.WriteString(", ")
 			}
+			entryMap.add(sb, imp.source, imp.loc)
 			sb.WriteString(imp.name)
 			sb.WriteString(" as ")
-			imp.alias = fmt.Sprintf("_%d", i)
+			imp.alias = fmt.Sprintf("%s%d", aliasPrefix, i)
 			sb.WriteString(imp.alias)
 			i++
 		}
@@ -62,12 +66,12 @@ func writeImports(sb *strings.Builder, functions map[string]importsByName) impor
 	return orderedFuncs
 }
 
-func createFunctionMapEntryPoint(sb *strings.Builder, functions map[string]importsByName) string {
+func createFunctionMapEntryPoint(sb *strings.Builder, functions map[string]importsByName, entryMap *serverEntryMap) string {
 	// Create an entry point file where we import all the functions
 	// Save them into an object where the keys are the hashes, and then export that
 	// object as the name "functions".
 
-	orderedFuncs := writeImports(sb, functions)
+	orderedFuncs := writeImports(sb, functions, "_", entryMap)
 
 	sb.WriteString("\nexport const functions = {\n")
 	i := 0
@@ -76,10 +80,19 @@ func createFunctionMapEntryPoint(sb *strings.Builder, functions map[string]impor
 			if i != 0 {
 				sb.WriteString(",\n")
 			}
+			entryMap.add(sb, imp.source, imp.loc)
 			sb.WriteString("\t\"")
 			sb.WriteString(imp.hash)
 			sb.WriteString(`": `)
 			sb.WriteString(imp.alias)
+			if imp.method != "" {
+				// Bind the method so it can use this, like it could when called as a method
+				if js_lexer.IsIdentifier(imp.method) {
+					fmt.Fprintf(sb, ".%s.bind(%s)", imp.method, imp.alias)
+				} else {
+					fmt.Fprintf(sb, "[%q].bind(%s)", imp.method, imp.alias)
+				}
+			}
 			i++
 		}
 	}
@@ -89,15 +102,23 @@ func createFunctionMapEntryPoint(sb *strings.Builder, functions map[string]impor
 
 type importedName struct {
 	name string
+	method string // the static method or object method called on the imported name, if any
 	hash string
 	alias string
+	source *logger.Source // where the function is declared, for the source map of the server entry
+	loc logger.Loc
 }
 
 type importsByName []importedName
 
 func (a importsByName) Len() int           { return len(a) }
 func (a importsByName) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
-func (a importsByName) Less(i, j int) bool { return a[i].name < a[j].name }
+func (a importsByName) Less(i, j int) bool {
+	if a[i].name == a[j].name {
+		return a[i].method < a[j].method
+	}
+	return a[i].name < a[j].name
+}
 
 func newImport(module, name string) importedName {
 	var digest [sha256.Size]byte
@@ -114,14 +135,11 @@ func newImport(module, name string) importedName {
 	}
 }
 
-type undoReplaceExpr struct {
-	expr *js_ast.Expr
-	data js_ast.E
-}
-
-type undoReplaceStmt struct {
-	stmt *js_ast.Stmt
-	data js_ast.S
+func newMethodImport(module, name, method string) importedName {
+	imp := newImport(module, name+"."+method)
+	imp.name = name
+	imp.method = method
+	return imp
 }
 
 type FlowStateCompiler struct {
@@ -131,17 +149,32 @@ type FlowStateCompiler struct {
 	fs              fs.FS
 	caches          *cache.CacheSet
 	files           []graph.InputFile
+	entryPoints     []graph.EntryPoint
 	baseDir         string
 	outDir          string
 	wg              sync.WaitGroup
 	analyzers       []*FlowStateAnalyzer
-	undoReplaceExpr []undoReplaceExpr
-	undoReplaceStmt []undoReplaceStmt
-	undoRemoveParts []*js_ast.Part
+	clientEdits     astEdits
+	serverEdits     astEdits
 	serverFile      string
+	serverEntryMap  serverEntryMap // maps the server entry to the declarations of the functions it imports
 	clientWhitelistFile   OutputFile
 	serverWhitelistFile   OutputFile
-	debug           bool
+	typesFile       OutputFile
+	diffFile        OutputFile
+	reportFile      OutputFile
+	schema          *dbSchema // nil if there's no schema file
+	serverVars      map[string][]string // the server variable namespaces and their declared keys
+	tracing         map[js_ast.Ref]bool // the functions and params being traced, to stop at recursive calls
+	callSites       map[js_ast.Ref][]callSite // the calls of each function, built when first needed
+	escapedFunctions map[js_ast.Ref]bool // functions used as values, which may have untraceable calls
+	importedRefs    map[js_ast.Ref][]moduleRef // the imports of each original ref, in the modules importing it
+	exportedFunctions map[js_ast.Ref]uint32 // functions exported from an entry point, by the source index of the entry point
+	reportedParams  map[js_ast.Ref]bool // the params of exported functions that were reported by findQueryForParam
+	tracingExecution bool // the query passed to executeQuery is being traced, rather than a value interpolated in a query
+	serverOnly      serverOnlyCode // the code that must not be in the client bundle
+	serverOnlyGlobs []*regexp.Regexp // opts.ServerOnly
+	clientOnlyGlobs []*regexp.Regexp // opts.ClientOnly
 }
 
 func NewFlowStateCompiler(opts *SQLJoyOptions, logOptions logger.OutputOptions, log logger.Log, fs fs.FS, caches *cache.CacheSet) *FlowStateCompiler {
@@ -152,14 +185,20 @@ func NewFlowStateCompiler(opts *SQLJoyOptions, logOptions logger.OutputOptions,
 		fs: fs,
 		caches: caches,
 		wg:          sync.WaitGroup{},
-		debug: true,
+		clientEdits: newAstEdits(),
+		serverEdits: newAstEdits(),
+		tracing: map[js_ast.Ref]bool{},
+		reportedParams: map[js_ast.Ref]bool{},
+		serverOnlyGlobs: globsToRegexps(opts.ServerOnly),
+		clientOnlyGlobs: globsToRegexps(opts.ClientOnly),
 	}
 }
 
-func (c *FlowStateCompiler) CompileClient(outDir, baseDir string, files []graph.InputFile) {
+func (c *FlowStateCompiler) CompileClient(outDir, baseDir string, files []graph.InputFile, entryPoints []graph.EntryPoint) {
 	c.outDir = outDir
 	c.baseDir = baseDir
 	c.files = files
+	c.entryPoints = entryPoints
 	analyzers := make([]*FlowStateAnalyzer, len(files))
 	for i := range files {
 		file := &files[i]
@@ -205,30 +244,18 @@ func (c *FlowStateCompiler) CompileClient(outDir, baseDir string, files []graph.
 	//  - create <server> and <validators> virtual modules as entry points for the server bundle
 
 	c.generateOutputs()
+
+	// The files slice is the one the client bundle is linked from, swap in the edited clones
+	c.clientEdits.applyTo(files)
 }
 
 func (c *FlowStateCompiler) CompileServer() BuildResult {
-	for _, undo := range c.undoReplaceExpr {
-		undo.expr.Data = undo.data
-	}
-	for _, undo := range c.undoReplaceStmt {
-		undo.stmt.Data = undo.data
+	contents := c.serverFile
+	if c.opts.Server.Sourcemap != SourceMapNone {
+		contents += c.serverEntryMap.sourceMappingURL(c.fs, c.serverOutDir())
 	}
-
-	// Undo analyzer.addImports and server function part.ForceRemove
-	for _, analyzer := range c.analyzers {
-		if analyzer == nil {
-			continue
-		}
-		for _, f := range analyzer.serverFunctions {
-			if f.part != nil {
-				f.part.IsDead = false
-			}
-		}
-	}
-
 	c.opts.Server.Stdin = &StdinOptions{
-		Contents: c.serverFile,
+		Contents: contents,
 		ResolveDir: c.baseDir,
 	}
 
@@ -236,42 +263,73 @@ func (c *FlowStateCompiler) CompileServer() BuildResult {
 		if len(entryPoints) == 0 {
 			panic("no entry point defined")
 		}
-		log.Println("creating server bundle")
+		c.trace(traceBuild, nil, logger.Loc{}, "creating server bundle")
+		c.serverEdits.applyTo(files)
 	}
+	c.opts.Server.OnBundleLink = c.verifyServerBundle
 
 	value := rebuildImpl(c.fs, c.opts.Server, c.caches, nil, c.logOptions, c.log, true)
 	return value.result
 }
 
+// serverOutDir returns the directory the server bundle is written to, like esbuild resolves it
+func (c *FlowStateCompiler) serverOutDir() string {
+	if c.opts.Server.Outdir != "" {
+		return c.absPath(c.opts.Server.Outdir)
+	}
+	return c.fs.Dir(c.absPath(c.opts.Server.Outfile))
+}
+
+func (c *FlowStateCompiler) absPath(path string) string {
+	if c.fs.IsAbs(path) {
+		return path
+	}
+	return c.fs.Join(c.fs.Cwd(), path)
+}
+
 func (c *FlowStateCompiler) visitFile(analyzer *FlowStateAnalyzer) {
-	log.Printf("scan: %s\n", path.Base(analyzer.file.Source.KeyPath.Text))
+	c.trace(traceScan, nil, logger.Loc{}, "scanning %s", analyzer.file.Source.PrettyPath)
 	WalkAst(analyzer, analyzer, analyzer.ast)
 	c.wg.Done()
 }
 
 func (c *FlowStateCompiler) findImportForFunctionIdentifier(analyzer *FlowStateAnalyzer, fIdent *js_ast.Expr, calls map[string]importsByName) (*js_ast.Symbol, importedName) {
 	ast := analyzer.ast
-	ref, _ := getRefForIdentifierOrPropertyAccess(nil, fIdent)
+	ref, prop := getRefForIdentifierOrPropertyAccess(nil, fIdent)
 	symbol := &ast.Symbols[ref.InnerIndex]
 	imported, isImport := ast.NamedImports[ref]
 	var imp importedName
 	if isImport {
 		module := ast.ImportRecords[imported.ImportRecordIndex].Path.Text
-		imp = newImport(module, imported.Alias)
+		switch {
+		case prop == "":
+			imp = newImport(module, imported.Alias)
+		case imported.AliasIsStar:
+			imp = newImport(module, prop)
+		default:
+			// A method of an imported class or object
+			imp = newMethodImport(module, imported.Alias, prop)
+		}
+		imp.source, imp.loc = &analyzer.file.Source, fIdent.Loc
+		if definer, f, _ := c.findServerFunction(analyzer, fIdent); f != nil {
+			imp.source, imp.loc = &definer.file.Source, f.loc
+		}
 		calls[module] = append(calls[module], imp)
 	} else {
-		// Must be a local function
-		if f := analyzer.serverFunctions[ref]; f != nil {
-			if f.fnStmt != nil {
-				if !f.fnStmt.IsExport {
-					c.log.AddError(&analyzer.file.Source, fIdent.Loc, fmt.Sprintf("function %s must be exported", symbol.OriginalName))
-				}
-			}	else if f.local != nil {
-				if !f.local.IsExport {
-					c.log.AddError(&analyzer.file.Source, fIdent.Loc, fmt.Sprintf("function %s must be exported", symbol.OriginalName))
-				}
-			} else {
-				panic("serverFunction instance missing required field")
+		// Must be a local function, or a method of a local class or object
+		f := analyzer.serverFunctions[ref]
+		if prop != "" {
+			if method := analyzer.serverMethods[ref][prop]; method != nil {
+				f = method
+			}
+		}
+		if f != nil {
+			name := symbol.OriginalName
+			if f.method != "" {
+				name += "." + f.method
+			}
+			if !f.isExport {
+				c.log.AddError(&analyzer.file.Source, fIdent.Loc, fmt.Sprintf("function %s must be exported", name))
 			}
 			// visitor.file.Source.KeyPath.Text
 			relPath, ok := c.fs.Rel(c.baseDir, analyzer.file.Source.KeyPath.Text)
@@ -280,7 +338,16 @@ func (c *FlowStateCompiler) findImportForFunctionIdentifier(analyzer *FlowStateA
 			} else {
 				relPath = analyzer.file.Source.KeyPath.Text
 			}
-			imp = newImport(relPath, symbol.OriginalName)
+			exportName := symbol.OriginalName
+			if f.isDefaultExport {
+				exportName = "default"
+			}
+			if f.method != "" {
+				imp = newMethodImport(relPath, exportName, f.method)
+			} else {
+				imp = newImport(relPath, exportName)
+			}
+			imp.source, imp.loc = &analyzer.file.Source, f.loc
 			calls[relPath] = append(calls[relPath], imp)
 		} else {
 			c.log.AddError(&analyzer.file.Source, fIdent.Loc, fmt.Sprintf("server call %s must refer to a top level exportable function", symbol.OriginalName))
@@ -290,7 +357,8 @@ func (c *FlowStateCompiler) findImportForFunctionIdentifier(analyzer *FlowStateA
 	return symbol, imp
 }
 
-func (c *FlowStateCompiler) generateServerFile(validators map[string]importsByName, validatorsByQuery map[string][]string) {
+// generateServerFile returns the imports of the server functions called by the client, by module
+func (c *FlowStateCompiler) generateServerFile(validators map[string]importsByName, validatorsByQuery map[string][]string) map[string]importsByName {
 	var validatorsReady sync.WaitGroup
 	validatorsReady.Add(1)
 	var sb *strings.Builder
@@ -300,6 +368,9 @@ func (c *FlowStateCompiler) generateServerFile(validators map[string]importsByNa
 	}()
 
 	calls := map[string]importsByName{}
+	serverFunctions := map[js_ast.Ref]bool{} // the functions called as server functions, not including methods
+	serverMethods := map[js_ast.Ref]map[string]bool{} // the methods called as server functions, by class or object
+	argValidators := map[string]*argValidator{} // the argument validators by server function hash
 
 	for _, visitor := range c.analyzers {
 		if visitor == nil {
@@ -307,11 +378,57 @@ func (c *FlowStateCompiler) generateServerFile(validators map[string]importsByNa
 		}
 
 		for _, serverCall := range visitor.serverCalls {
-			symbol, imp := c.findImportForFunctionIdentifier(visitor, &serverCall.call.Target, calls)
-			if symbol == nil {
+			targets, ok := c.resolveServerCallTargets(visitor, serverCall.part, &serverCall.call.Target, nil)
+			if !ok {
+				c.log.AddError(&visitor.file.Source, serverCall.call.Target.Loc,
+					"server call can't be traced to a server function, it must be called directly or through a variable, condition or array of server functions")
 				continue
 			}
 
+			// Replace the target function with a string literal containing the base64 encoded hash of the (module, name).
+			// If the function is selected through a variable, the server functions it selects from are replaced instead,
+			// and the variable is passed in place of the hash.
+			var fnHash js_ast.Expr
+			for _, target := range targets {
+				symbol, imp := c.findImportForFunctionIdentifier(target.analyzer, target.expr, calls)
+				if symbol == nil {
+					continue
+				}
+				if definer, f, ref := c.findServerFunction(target.analyzer, target.expr); f != nil {
+					if f.method == "" {
+						serverFunctions[ref] = true
+					} else {
+						if serverMethods[ref] == nil {
+							serverMethods[ref] = map[string]bool{}
+						}
+						serverMethods[ref][f.method] = true
+					}
+					if _, ok := argValidators[imp.hash]; !ok {
+						name := definer.ast.Symbols[ref.InnerIndex].OriginalName
+						if f.method != "" {
+							name += "." + f.method
+						}
+						argValidators[imp.hash] = c.argValidator(definer, f, name)
+					}
+				}
+
+				hash := &js_ast.EString{Value: js_lexer.StringToUTF16(imp.hash)}
+				if target.expr == &serverCall.call.Target {
+					fnHash = js_ast.Expr{Data: hash}
+				} else if c.replaceExpr(target.part, target.expr, target.expr.Data, hash, targetClient) {
+					c.trace(traceServerCall, &target.analyzer.file.Source, target.expr.Loc, "replaced server function %s selected by a server call with its hash %s", imp.name, imp.hash)
+				} else {
+					continue // already replaced for another call
+				}
+				c.removeServerFunctionUse(target.analyzer, target.expr, symbol)
+			}
+			if fnHash.Data == nil {
+				if len(targets) == 1 && targets[0].expr == &serverCall.call.Target {
+					continue // the error was already reported
+				}
+				fnHash = serverCall.call.Target
+			}
+
 			// Transform the call from foo(xxx.beginTx(), ...args) to xxx.serverCall("hash", ...args)
 			newTarget := *serverCall.fsInstance
 			newTarget.Name = serverCallMethodName
@@ -319,48 +436,250 @@ func (c *FlowStateCompiler) generateServerFile(validators map[string]importsByNa
 				Target:                 js_ast.Expr{
 					Data: &newTarget,
 				},
-				Args:                   append([]js_ast.Expr{
-					// Replace the target function with a string literal containing the base64 encoded hash of the (module, name)
-					{Data: &js_ast.EString{
-						Value:          js_lexer.StringToUTF16(imp.hash),
-					}}},
-					serverCall.call.Args[1:]...),
-			}
-
-			log.Printf("replacing server call %s, alias %s\n", imp.name, imp.alias)
-			c.replaceExpr(serverCall.parent, serverCall.call, newCall, true)
-
-			// Decrease the symbol use count by one
-			if symbol.UseCountEstimate != 0 {
-				symbol.UseCountEstimate--
-				if symbol.UseCountEstimate == 0 {
-					// Remove this function from the client bundle (we restore it when building the server bundle later)
-					ref, prop := getRefForIdentifierOrPropertyAccess(visitor, &serverCall.call.Target)
-					ref = c.findOriginalRef(visitor, ref, prop)
-					if ref != js_ast.InvalidRef {
-						analyzer := c.analyzers[ref.SourceIndex]
-						if analyzer != nil {
-							if f := analyzer.serverFunctions[ref]; f != nil {
-								if f.part != nil {
-									f.part.IsDead = true
-								}
-							}
-						}
-					}
-				}
+				Args:                   append([]js_ast.Expr{fnHash}, serverCall.call.Args[1:]...),
 			}
+
+			c.trace(traceServerCall, &visitor.file.Source, serverCall.call.Target.Loc, "rewrote server call to %s", serverCallMethodName)
+			c.replaceExpr(serverCall.part, serverCall.parent, serverCall.call, newCall, targetClient)
 		}
 	}
 
+	c.removeServerMethods(serverFunctions, serverMethods)
+	c.checkEscapedServerFunctions(serverFunctions, serverMethods)
+	c.findServerOnlyCode(serverFunctions, serverMethods)
+
 	validatorsReady.Wait()
-	c.serverFile = createFunctionMapEntryPoint(sb, calls)
+	createFunctionMapEntryPoint(sb, calls, &c.serverEntryMap)
+	writeArgValidators(sb, argValidators, &c.serverEntryMap)
+	c.serverFile = sb.String()
+	return calls
+}
+
+// serverCallTarget is a reference to the server function called by a server call
+type serverCallTarget struct {
+	analyzer *FlowStateAnalyzer
+	part     *js_ast.Part
+	expr     *js_ast.Expr
+}
+
+// resolveServerCallTargets finds the server functions the target of a server call may refer to. Like
+// findQueryForExpr, it follows variables through aliases, ternary conditions and logical expressions,
+// and also through array literals iterated with an array method, e.g.: [a, b].map(f => f(fs.beginTx())).
+// It returns false if any of the possible targets can't be traced.
+func (c *FlowStateCompiler) resolveServerCallTargets(analyzer *FlowStateAnalyzer, part *js_ast.Part, expr *js_ast.Expr, targets []serverCallTarget) ([]serverCallTarget, bool) {
+	ok := true
+	switch e := expr.Data.(type) {
+	case *js_ast.EIf:
+		targets, ok = c.resolveServerCallTargets(analyzer, part, &e.Yes, targets)
+		if ok {
+			targets, ok = c.resolveServerCallTargets(analyzer, part, &e.No, targets)
+		}
+		return targets, ok
+	case *js_ast.EBinary:
+		switch e.Op {
+		case js_ast.BinOpLogicalOr, js_ast.BinOpNullishCoalescing:
+			targets, ok = c.resolveServerCallTargets(analyzer, part, &e.Left, targets)
+			if ok {
+				targets, ok = c.resolveServerCallTargets(analyzer, part, &e.Right, targets)
+			}
+			return targets, ok
+		case js_ast.BinOpLogicalAnd:
+			// The left side is the condition
+			return c.resolveServerCallTargets(analyzer, part, &e.Right, targets)
+		}
+	case *js_ast.EArray:
+		for i := range e.Items {
+			if _, isSpread := e.Items[i].Data.(*js_ast.ESpread); isSpread {
+				return nil, false
+			}
+			if targets, ok = c.resolveServerCallTargets(analyzer, part, &e.Items[i], targets); !ok {
+				return nil, false
+			}
+		}
+		return targets, true
+	case *js_ast.EIdentifier, *js_ast.EImportIdentifier, *js_ast.EDot, *js_ast.EIndex:
+		ref, prop := getRefForIdentifierOrPropertyAccess(analyzer, expr)
+		if ref == js_ast.InvalidRef {
+			return nil, false
+		}
+		if prop == "" && !c.tracing[ref] {
+			value, isValue := analyzer.values[ref]
+			if !isValue {
+				value, isValue = analyzer.iteratorParams[ref]
+			}
+			if isValue {
+				c.tracing[ref] = true
+				defer delete(c.tracing, ref)
+				return c.resolveServerCallTargets(analyzer, value.part, value.expr, targets)
+			}
+		}
+		// This must be a server function, findImportForFunctionIdentifier reports it if it isn't
+		return append(targets, serverCallTarget{analyzer: analyzer, part: part, expr: expr}), true
+	}
+	return nil, false
+}
+
+// removeServerFunctionUse decrements the use count of the server function referred to by expr,
+// and removes the server function from the client bundle if no module uses it any more.
+// The bodies of server methods are removed by removeServerMethods.
+func (c *FlowStateCompiler) removeServerFunctionUse(analyzer *FlowStateAnalyzer, expr *js_ast.Expr, symbol *js_ast.Symbol) {
+	ref, prop := getRefForIdentifierOrPropertyAccess(analyzer, expr)
+	if _, ok := c.clientEdits.useCounts[ref]; !ok && analyzer.serverMethods[ref] != nil {
+		// The bundler adds uses of a class name when it converts the class statement to a variable,
+		// so count the references to the class or object that are actually in the code
+		c.clientEdits.useCounts[ref] = analyzer.identifierUses[ref]
+	}
+	if symbol.UseCountEstimate == 0 || c.clientEdits.decUseCount(ref, symbol) != 0 {
+		return
+	}
+
+	// Remove this function from the client bundle (the server bundle still has it)
+	ref = c.findOriginalRef(analyzer, ref, prop)
+	if ref != js_ast.InvalidRef && !c.isUsedOnClient(ref) {
+		if definer := c.analyzers[ref.SourceIndex]; definer != nil {
+			if part := definer.serverFunctionPart(ref); part != nil {
+				c.clientEdits.deadParts[part] = true
+			}
+		}
+	}
+}
+
+// isUsedOnClient returns true if the module declaring ref, or any module importing it, still uses it
+// in the client bundle. A class or object with server methods can be used for its other properties.
+func (c *FlowStateCompiler) isUsedOnClient(ref js_ast.Ref) bool {
+	if definer := c.analyzers[ref.SourceIndex]; definer != nil && c.clientUseCount(definer, ref) != 0 {
+		return true
+	}
+	c.indexCallSites()
+	for _, imp := range c.importedRefs[ref] {
+		if c.clientUseCount(imp.analyzer, imp.ref) != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// clientUseCount returns the number of uses of ref in the module that are left in the client bundle
+func (c *FlowStateCompiler) clientUseCount(analyzer *FlowStateAnalyzer, ref js_ast.Ref) uint32 {
+	if count, ok := c.clientEdits.useCounts[ref]; ok {
+		return count
+	}
+	if analyzer.serverMethods[ref] != nil {
+		return analyzer.identifierUses[ref] // see removeServerFunctionUse
+	}
+	return analyzer.ast.Symbols[ref.InnerIndex].UseCountEstimate
+}
+
+// removeServerMethods removes the bodies of the methods called as server functions from the client bundle,
+// unless the client also calls the method directly, e.g. Api.getUser(window.ctx, id). Then the method is left
+// in the client bundle, and verifyClientBundle reports it like a server function the client still uses.
+func (c *FlowStateCompiler) removeServerMethods(serverFunctions map[js_ast.Ref]bool, serverMethods map[js_ast.Ref]map[string]bool) {
+	// The calls in server functions and in the bodies of server methods are only on the server
+	serverParts := map[*js_ast.Part]bool{}
+	for ref := range serverFunctions {
+		serverParts[c.analyzers[ref.SourceIndex].serverFunctions[ref].part] = true
+	}
+	serverBodies := map[js_ast.E]bool{}
+	for ref, methods := range serverMethods {
+		for method := range methods {
+			serverBodies[c.analyzers[ref.SourceIndex].serverMethods[ref][method].value.Data] = true
+		}
+	}
+
+	clientCalls := map[js_ast.Ref]map[string]bool{}
+	for _, analyzer := range c.analyzers {
+		if analyzer == nil {
+			continue
+		}
+		serverCalls := make(map[*js_ast.ECall]bool, len(analyzer.serverCalls))
+		for _, serverCall := range analyzer.serverCalls {
+			serverCalls[serverCall.call] = true
+		}
+		for _, use := range analyzer.propertyCalls {
+			if serverCalls[use.call] || serverParts[use.part] || serverBodies[use.fn] {
+				continue
+			}
+			ref, prop := getRefForIdentifierOrPropertyAccess(analyzer, &use.call.Target)
+			if ref == js_ast.InvalidRef || prop == "" {
+				continue
+			}
+			if ref = c.findOriginalRef(analyzer, ref, ""); serverMethods[ref][prop] {
+				if clientCalls[ref] == nil {
+					clientCalls[ref] = map[string]bool{}
+				}
+				clientCalls[ref][prop] = true
+			}
+		}
+	}
+
+	for ref, methods := range serverMethods {
+		for method := range methods {
+			if !clientCalls[ref][method] {
+				f := c.analyzers[ref.SourceIndex].serverMethods[ref][method]
+				c.clientEdits.replaceExpr(f.part, f.value, emptyFunction(c.clientEdits.exprData(f.value)))
+			}
+		}
+	}
+}
+
+// emptyFunction returns a copy of a method's function without its arguments and body
+func emptyFunction(fn js_ast.E) js_ast.E {
+	switch f := fn.(type) {
+	case *js_ast.EFunction:
+		return &js_ast.EFunction{Fn: js_ast.Fn{Name: f.Fn.Name, IsAsync: f.Fn.IsAsync, Body: js_ast.FnBody{Loc: f.Fn.Body.Loc}}}
+	case *js_ast.EArrow:
+		return &js_ast.EArrow{IsAsync: f.IsAsync, Body: js_ast.FnBody{Loc: f.Body.Loc}}
+	}
+	return fn
+}
+
+// checkEscapedServerFunctions reports the references to server functions on the client that aren't
+// calls, and weren't replaced because they couldn't be traced to a server call. These could call the
+// server function from anywhere, and they keep the server code in the client bundle.
+// The same goes for the property accesses naming a method that's called as a server function.
+func (c *FlowStateCompiler) checkEscapedServerFunctions(serverFunctions map[js_ast.Ref]bool, serverMethods map[js_ast.Ref]map[string]bool) {
+	// References in the server functions themselves are only on the server
+	serverParts := map[*js_ast.Part]bool{}
+	for ref := range serverFunctions {
+		serverParts[c.analyzers[ref.SourceIndex].serverFunctions[ref].part] = true
+	}
+
+	for _, analyzer := range c.analyzers {
+		if analyzer == nil {
+			continue
+		}
+		for _, use := range analyzer.escapedUses {
+			if serverParts[use.part] || c.clientEdits.deadParts[use.part] || c.clientEdits.exprData(use.expr) != use.expr.Data {
+				continue
+			}
+			ref, _ := getRefForIdentifierOrPropertyAccess(analyzer, use.expr)
+			if ref == js_ast.InvalidRef || !serverFunctions[c.findOriginalRef(analyzer, ref, "")] {
+				continue
+			}
+			c.log.AddError(&analyzer.file.Source, use.expr.Loc, fmt.Sprintf(
+				"server function %s is used in a way that can't be traced to a server call, which would include it in the client bundle",
+				analyzer.ast.Symbols[ref.InnerIndex].OriginalName))
+		}
+		for _, use := range analyzer.escapedPropertyUses {
+			if serverParts[use.part] || c.clientEdits.deadParts[use.part] || c.clientEdits.exprData(use.expr) != use.expr.Data {
+				continue
+			}
+			ref, prop := getRefForIdentifierOrPropertyAccess(analyzer, use.expr)
+			if ref == js_ast.InvalidRef || prop == "" || !serverMethods[c.findOriginalRef(analyzer, ref, "")][prop] {
+				continue
+			}
+			c.log.AddError(&analyzer.file.Source, use.expr.Loc, fmt.Sprintf(
+				"server function %s.%s is used in a way that can't be traced to a server call, which would include it in the client bundle",
+				analyzer.ast.Symbols[ref.InnerIndex].OriginalName, prop))
+		}
+	}
 }
 
 func (c *FlowStateCompiler) writeValidators(validators map[string]importsByName, validatorsByQuery map[string][]string) *strings.Builder {
 	sb := &strings.Builder{}
 
-	orderedFuncs := writeImports(sb, validators)
-	aliasesByHash := map[string]string{}
+	orderedFuncs := writeImports(sb, validators, "_v", &c.serverEntryMap)
+	importsByHash := map[string]importedName{}
 
 	// Order the queries by hash so it will be deterministic
 	orderedQueries := make([]string, len(validatorsByQuery))
@@ -371,7 +690,7 @@ func (c *FlowStateCompiler) writeValidators(validators map[string]importsByName,
 
 	for _, mod := range orderedFuncs {
 		for _, imp := range mod.imports {
-			aliasesByHash[imp.hash] = imp.alias
+			importsByHash[imp.hash] = imp
 		}
 	}
 
@@ -389,8 +708,10 @@ func (c *FlowStateCompiler) writeValidators(validators map[string]importsByName,
 		sb.WriteString(queryHash)
 		sb.WriteString("\": (e, s) => {\n")
 		for _, validatorHash := range validatorHashes {
+			imp := importsByHash[validatorHash]
+			c.serverEntryMap.add(sb, imp.source, imp.loc)
 			sb.WriteString("\t\t")
-			sb.WriteString(aliasesByHash[validatorHash])
+			sb.WriteString(imp.alias)
 			sb.WriteString("(e, s);\n")
 		}
 		sb.WriteString("\t}")
@@ -408,6 +729,8 @@ func (c *FlowStateCompiler) generateOutputs() {
 
 
 	allQueries := map[js_ast.Ref]queriesByWhitelistOrder{}
+	c.schema = c.loadSchema()
+	c.serverVars = c.loadServerVars()
 
 	var keys []js_ast.Ref
 	for _, visitor := range c.analyzers {
@@ -417,6 +740,7 @@ func (c *FlowStateCompiler) generateOutputs() {
 
 		for _, qp := range visitor.queries {
 			keys = append(keys, qp.ref)
+			c.lintStringSQLParams(qp.definedSource, qp.template)
 			q, err := newQuery(qp)
 			if err != nil {
 				c.log.AddError(qp.definedSource, qp.parent.Loc, err.Error())
@@ -434,11 +758,15 @@ func (c *FlowStateCompiler) generateOutputs() {
 		// Find the queries for each queryExecute call, and gather the usages
 		for i := range analyzer.queryExecutions {
 			queryExec := &analyzer.queryExecutions[i]
+			c.tracingExecution = true
 			queryExec.queries = c.findQueryForExpr(analyzer, allQueries, &queryExec.call.Args[0])
+			c.tracingExecution = false
 
 			if len(queryExec.queries) == 0 {
 				// Not a supported query execution expression, issue an error
-				c.log.AddError(&analyzer.file.Source, queryExec.call.Args[0].Loc, "could not identify query for first argument to executeQuery")
+				if c.lintStringSQL(&analyzer.file.Source, &queryExec.call.Args[0], "query", "use the sql`` template tag with ${} params instead") != LintError {
+					c.log.AddError(&analyzer.file.Source, queryExec.call.Args[0].Loc, "could not identify query for first argument to executeQuery")
+				}
 				continue
 			}
 
@@ -447,7 +775,7 @@ func (c *FlowStateCompiler) generateOutputs() {
 					c.log.AddError(&analyzer.file.Source, queryExec.call.Args[0].Loc, "cannot use a query part (created with sql.p``) as a query: use sql`${part}` instead")
 					continue
 				}
-				q.calls = append(q.calls, queryUsage{sourceIndex: uint32(sourceIndex), isServer: queryExec.isServer, call: queryExec.call})
+				q.calls = append(q.calls, queryUsage{sourceIndex: uint32(sourceIndex), isServer: queryExec.isServer, fn: queryExec.fn, call: queryExec.call})
 			}
 		}
 	}
@@ -469,7 +797,17 @@ func (c *FlowStateCompiler) generateOutputs() {
 				}
 
 				c.replaceQuery(analyzer, q)
+				if c.opts.TypeScript {
+					q.checkLateBoundParams(c, &analyzer.file.Source, queryExec.call)
+				}
 				q.Type = getQueryType(q.QueryText)
+				q.parseAnnotations(c)
+				if q.checkSyntax(c) {
+					q.lintSQL(c)
+					if c.schema != nil {
+						q.checkSchema(c, c.schema)
+					}
+				}
 
 				// Match the validator identifiers up to the defined server functions
 				if len(queryExec.call.Args) > 2 {
@@ -496,19 +834,34 @@ func (c *FlowStateCompiler) generateOutputs() {
 	serverWhitelist := make(queriesByWhitelistOrder, 0, len(allQueries))
 	for _, queries := range allQueries {
 		for _, q := range queries {
+			if q.QueryText == "" && q.ClientReferences == 0 && q.ServerReferences == 0 && len(q.calls) == 0 {
+				// Never executed, or part of an executed query, so it wasn't compiled by replaceQuery
+				if q.isFragment {
+					c.lint(lintUnusedFragment, q.definedSource, q.parent.Loc, "fragment is unused")
+				} else {
+					c.lint(lintUnusedQuery, q.definedSource, q.parent.Loc, "query is unused")
+				}
+			}
 			if q.isFragment {
 				// If the fragment was completely inlined, replace it with undefined
 				if q.inlinedClientCount == q.ClientReferences {
-					c.replaceExpr(q.parent, q.template, &js_ast.EUndefined{}, q.inlinedServerCount != q.ServerReferences)
+					targets := targetBoth
+					if q.inlinedServerCount != q.ServerReferences {
+						targets = targetClient
+					}
+					c.replaceExpr(q.part, q.parent, q.template, &js_ast.EUndefined{}, targets)
 				} else if q.inlinedServerCount == q.ServerReferences {
 					// Used on the client, but not the server
-
-					c.undoReplaceExpr = append(c.undoReplaceExpr, undoReplaceExpr{q.parent, &js_ast.EUndefined{}})
+					c.serverEdits.replaceExpr(q.part, q.parent, &js_ast.EUndefined{})
 				}
 				continue
 			}
 
+			q.buildFragmentTables(c)
 			if q.ClientReferences != 0 {
+				if c.opts.StrictPolicies && q.Policy == nil {
+					c.log.AddError(q.definedSource, q.parent.Loc, "client query has no access policy, add a -- @roles comment to the query (strictPolicies is enabled)")
+				}
 				clientWhitelist = append(clientWhitelist, q)
 			} else if q.ServerReferences != 0 {
 				serverWhitelist = append(serverWhitelist, q)
@@ -520,6 +873,14 @@ func (c *FlowStateCompiler) generateOutputs() {
 		return
 	}
 
+	var previous []*whitelistEntry
+	if c.opts.PreviousBuild != "" {
+		previous = c.loadPreviousWhitelists()
+		if c.log.HasErrors() {
+			return
+		}
+	}
+
 	c.wg.Add(2)
 
 	go func(wl queriesByWhitelistOrder) {
@@ -532,8 +893,21 @@ func (c *FlowStateCompiler) generateOutputs() {
 		c.wg.Done()
 	}(serverWhitelist)
 
-	c.generateServerFile(validators, validatorsByQuery)
+	calls := c.generateServerFile(validators, validatorsByQuery)
 	c.wg.Wait()
+
+	if c.opts.TypeScript {
+		// The client whitelist was sorted by outputWhitelist
+		c.outputTypes(&c.typesFile, "client-queries.d.ts", clientWhitelist)
+	}
+
+	if c.opts.PreviousBuild != "" {
+		c.diffWhitelists(previous, clientWhitelist, serverWhitelist)
+	}
+
+	if c.opts.Report {
+		c.outputReport(allQueries, calls, validators, validatorsByQuery)
+	}
 }
 
 func (c *FlowStateCompiler) outputWhitelist(whitelistFile *OutputFile, fileName string, whitelistQueries queriesByWhitelistOrder) {
@@ -564,7 +938,7 @@ func (c *FlowStateCompiler) outputWhitelist(whitelistFile *OutputFile, fileName
 //
 // let query = {
 //   "query": "b06bc30a53ac5d3feb624e536c86394ccb9ac5fc8da9bd239ef48724138e9fc1",
-//	 "text": "SELECT foo, bar FROM baz WHERE foo > $0", // debug only
+//	 "text": "SELECT foo, bar FROM baz WHERE foo > $0", // not in the client bundle in production
 //   "type": "select",
 //   "params": {
 //     "$0": n + 1
@@ -614,6 +988,12 @@ func (c *FlowStateCompiler) replaceQuery(visitor *FlowStateAnalyzer, q *query) {
 		switch v.ty {
 		case queryVarTypeVar:
 			text = append(text, fmt.Sprintf("$%d", i))
+			if analyzer, call := c.sqlPartCall(expr); call != nil {
+				q.fragmentParams = append(q.fragmentParams, i)
+				if len(call.Args) != 0 {
+					c.lintStringSQL(&analyzer.file.Source, &call.Args[0], "query part", "use sql.p`` with ${} params instead")
+				}
+			}
 		case queryVarTypeParam:
 			// late-bound parameter (at executeQuery call)
 			expr = &js_ast.Expr{Data: &js_ast.EUndefined{}, Loc: loc}
@@ -626,11 +1006,14 @@ func (c *FlowStateCompiler) replaceQuery(visitor *FlowStateAnalyzer, q *query) {
 		case queryVarTypeServer:
 			q.IsPublic = false
 			text = append(text, fmt.Sprintf("${%s}", v.name))
+			q.addServerVars(v.name)
+			c.checkServerVar(q, v.name)
 			continue
 		}
 		if name == "" {
 			name = text[len(text)-1]
 		}
+		q.params = append(q.params, queryParam{name: name, lateBound: v.ty == queryVarTypeParam})
 		params.Properties = append(params.Properties, newProp(name, expr))
 		i++
 	}
@@ -639,54 +1022,63 @@ func (c *FlowStateCompiler) replaceQuery(visitor *FlowStateAnalyzer, q *query) {
 		text = append(text, q.parts[len(q.parts)-1])
 	}
 	q.QueryText = strings.Join(text, "")
-	if c.debug || q.ServerReferences != 0 {
-		props = append(props, newProp("text", &js_ast.Expr{Data: &js_ast.EString{Value: js_lexer.StringToUTF16(q.QueryText)}, Loc: loc}))
-	}
-	props = append(props, newProp("params", &js_ast.Expr{Data: params, Loc: loc}))
-
-	queryObj := &js_ast.EObject{
-		Properties:   props,
-		IsSingleLine: true,
-	}
+	textProp := newProp("text", &js_ast.Expr{Data: &js_ast.EString{Value: js_lexer.StringToUTF16(q.QueryText)}, Loc: loc})
+	paramsProp := newProp("params", &js_ast.Expr{Data: params, Loc: loc})
 
+	// The server needs the text of the queries it executes. In production the client doesn't get the text,
+	// which would reveal the schema, the server looks the query up in the whitelist by its id instead.
 	q.Fragments = queryFragments
-	var newExpr js_ast.E = queryObj
-	if len(fragments) == 0 {
-		c.replaceExpr(q.parent, q.template, queryObj, false)
-	} else {
+	newQueryExpr := func(withText bool) js_ast.E {
+		queryObj := &js_ast.EObject{
+			Properties:   append([]js_ast.Property{}, props...),
+			IsSingleLine: true,
+		}
+		if withText {
+			queryObj.Properties = append(queryObj.Properties, textProp)
+		}
+		queryObj.Properties = append(queryObj.Properties, paramsProp)
+		if len(fragments) == 0 {
+			return queryObj
+		}
+
 		// Wrap as sql.merge(queryObj, fragments...)
 		sql := *q.template.Tag
 		if q.isFragment {
 			sql = q.template.Tag.Data.(*js_ast.EDot).Target
 		}
-		call := &js_ast.ECall{
+		return &js_ast.ECall{
 			Target: js_ast.Expr{Data: &js_ast.EDot{Target: sql, Name: "merge"}, Loc: loc},
 			Args:   append([]js_ast.Expr{{Data: queryObj, Loc: loc}}, fragments...),
 		}
-		c.replaceExpr(q.parent, q.template, call, false)
-		newExpr = call
+	}
+	newExpr := newQueryExpr(!c.opts.Production || q.ServerReferences != 0)
+	if c.opts.Production && q.ServerReferences != 0 {
+		c.replaceExpr(q.part, q.parent, q.template, newExpr, targetServer)
+		newExpr = newQueryExpr(false)
+		c.replaceExpr(q.part, q.parent, q.template, newExpr, targetClient)
+	} else {
+		c.replaceExpr(q.part, q.parent, q.template, newExpr, targetBoth)
 	}
 
 	if q.ClientReferences == 0 {
 		if q.ServerReferences != 0 {
 			// This query is only used by the server, remove it in the client build
-			log.Printf("replaced server-only query starting with %q with undefined in client build\n", q.template.HeadRaw)
-			c.replaceExpr(q.parent, newExpr, &js_ast.EUndefined{}, true)
+			c.trace(traceQuery, q.definedSource, q.parent.Loc, "removed server only query %s from the client build", q.Hash)
+			c.replaceExpr(q.part, q.parent, newExpr, &js_ast.EUndefined{}, targetClient)
 		} else {
 			if !q.isInlined() {
-				text := "query is unused"
 				if q.isFragment {
-					text = "fragment is unused"
+					c.lint(lintUnusedFragment, q.definedSource, q.parent.Loc, "fragment is unused")
+				} else {
+					c.lint(lintUnusedQuery, q.definedSource, q.parent.Loc, "query is unused")
 				}
-				c.log.AddWarning(q.definedSource, q.parent.Loc, text)
 			}
 		}
 	} else if q.ServerReferences == 0 {
 		// This query is used on the client, but not the server.
 		// We want to remove it for the server build, but leave it as-is for the client build
-		// We can do this by adding an "undo" entry that removes it when run before the server build.
-		log.Printf("replaced client-only query starting with %q with undefined in server build\n", q.template.HeadRaw)
-		c.undoReplaceExpr = append(c.undoReplaceExpr, undoReplaceExpr{q.parent, &js_ast.EUndefined{}})
+		c.trace(traceQuery, q.definedSource, q.parent.Loc, "removed client only query %s from the server build", q.Hash)
+		c.serverEdits.replaceExpr(q.part, q.parent, &js_ast.EUndefined{})
 	}
 
 	for _, fragments := range queryFragments {
@@ -696,6 +1088,7 @@ func (c *FlowStateCompiler) replaceQuery(visitor *FlowStateAnalyzer, q *query) {
 				private = false // if one fragment is public, the whole group is
 			}
 			c.replaceQuery(c.analyzers[fragment.ref.SourceIndex], fragment)
+			q.addServerVars(fragment.ServerVars...)
 		}
 		// If any group of fragments is private, the query is private
 		if q.IsPublic && private {
@@ -704,8 +1097,99 @@ func (c *FlowStateCompiler) replaceQuery(visitor *FlowStateAnalyzer, q *query) {
 	}
 }
 
+// loadServerVars returns the server variable namespaces and their declared keys.
+// The configured namespaces extend the defaults, so that a %{SESSION.x} placeholder can never
+// become a param supplied by the client, but SESSION and ENV can be configured with declared keys.
+func (c *FlowStateCompiler) loadServerVars() map[string][]string {
+	if c.opts.ServerVars == nil {
+		return defaultServerVars
+	}
+	serverVars := make(map[string][]string, len(defaultServerVars)+len(c.opts.ServerVars))
+	for namespace, keys := range defaultServerVars {
+		serverVars[namespace] = keys
+	}
+	for namespace, keys := range c.opts.ServerVars {
+		serverVars[namespace] = keys
+	}
+	return serverVars
+}
+
+// checkServerVar reports an error if the server var's namespace isn't configured,
+// or if it declares keys and its key isn't one of them
+func (c *FlowStateCompiler) checkServerVar(q *query, name string) {
+	dot := strings.IndexByte(name, '.')
+	namespace, key := name[:dot], name[dot+1:]
+	keys, ok := c.serverVars[namespace]
+	if !ok {
+		namespaces := make([]string, 0, len(c.serverVars))
+		for namespace := range c.serverVars {
+			namespaces = append(namespaces, namespace)
+		}
+		sort.Strings(namespaces)
+		c.log.AddError(q.definedSource, q.parent.Loc, fmt.Sprintf("unknown server variable namespace %s in %%{%s}: the namespaces are %s",
+			namespace, name, strings.Join(namespaces, ", ")))
+		return
+	}
+	if keys == nil || containsString(keys, key) {
+		return
+	}
+
+	declared := "declares no keys"
+	if len(keys) != 0 {
+		declared = "declares " + strings.Join(keys, ", ")
+	}
+	c.log.AddError(q.definedSource, q.parent.Loc, fmt.Sprintf("unknown server variable %s: %s %s", name, namespace, declared))
+}
+
+// sqlPartCall returns the call if expr is a call to sql.p(), which creates a query part from a string at runtime,
+// and the analyzer of the file it's in
+func (c *FlowStateCompiler) sqlPartCall(expr *js_ast.Expr) (*FlowStateAnalyzer, *js_ast.ECall) {
+	call, ok := expr.Data.(*js_ast.ECall)
+	if !ok {
+		return nil, nil
+	}
+	dot, ok := call.Target.Data.(*js_ast.EDot)
+	if !ok || dot.Name != templatePart {
+		return nil, nil
+	}
+	ref, prop := getRefForIdentifierOrPropertyAccess(nil, &dot.Target)
+	if ref == js_ast.InvalidRef || prop != "" || c.analyzers[ref.SourceIndex] == nil {
+		return nil, nil
+	}
+	analyzer := c.analyzers[ref.SourceIndex]
+	if analyzer.ast.Symbols[ref.InnerIndex].OriginalName != sqlTemplateTag {
+		return nil, nil
+	}
+	return analyzer, call
+}
+
+// findServerFunction finds the declaration of the server function or server method an identifier or property access refers to.
+// It returns the analyzer of the file declaring it, and the original ref of the function, or of the class or object of the method.
+func (c *FlowStateCompiler) findServerFunction(analyzer *FlowStateAnalyzer, expr *js_ast.Expr) (*FlowStateAnalyzer, *localFunction, js_ast.Ref) {
+	ref, prop := getRefForIdentifierOrPropertyAccess(analyzer, expr)
+	if ref = c.findOriginalRef(analyzer, ref, prop); ref == js_ast.InvalidRef {
+		return nil, nil, ref
+	}
+	definer := c.analyzers[ref.SourceIndex]
+	if definer == nil {
+		return nil, nil, ref
+	}
+	if f := definer.serverFunctions[ref]; f != nil {
+		return definer, f, ref
+	}
+	if f := definer.serverMethods[ref][prop]; f != nil {
+		return definer, f, ref
+	}
+	return nil, nil, ref
+}
+
 func (c *FlowStateCompiler) findOriginalRef(analyzer *FlowStateAnalyzer, ref js_ast.Ref, prop string) js_ast.Ref {
-	log.Printf("findOriginalRef(%d, %v, %q)\n", analyzer.file.Source.Index, ref, prop)
+	start, startProp := ref, prop
+	defer func() {
+		if ref != start || startProp != "" {
+			c.trace(traceResolve, nil, logger.Loc{}, "resolved %s%s to %s", c.refName(start), propSuffix(startProp), c.refName(ref))
+		}
+	}()
 	for ref != js_ast.InvalidRef && analyzer != nil {
 		queryImport, isImported := analyzer.ast.NamedImports[ref]
 		if isImported {
@@ -739,12 +1223,11 @@ func (c *FlowStateCompiler) findOriginalRef(analyzer *FlowStateAnalyzer, ref js_
 					}
 				}
 			} else {
-				log.Printf("can't find export for %s in %d", queryImport.Alias, exporter.file.Source.Index)
+				c.trace(traceResolve, nil, logger.Loc{}, "can't find export %s in %s", queryImport.Alias, exporter.file.Source.PrettyPath)
 				break
 			}
 			analyzer = exporter
 		} else {
-			log.Printf("checking aliases and exports in %d\n", ref.SourceIndex)
 			analyzer := c.analyzers[ref.SourceIndex]
 			if analyzer == nil {
 				break
@@ -755,7 +1238,6 @@ func (c *FlowStateCompiler) findOriginalRef(analyzer *FlowStateAnalyzer, ref js_
 				ref = aliasedRef
 				analyzer = c.analyzers[ref.SourceIndex]
 			} else if prop != "" {
-				log.Printf("looking for exported namespace %v in %d\n", ref, ref.SourceIndex)
 				if ns, ok := c.analyzers[ref.SourceIndex].exportedNamespaces[ref]; ok {
 					analyzer = c.analyzers[ns]
 					if analyzer == nil {
@@ -813,8 +1295,8 @@ func (c *FlowStateCompiler) findQueryForExpr(analyzer *FlowStateAnalyzer, allQue
 		// If expression is a ternary condition or logical expression, call findQueryForExpr for each subexpression and combine the results
 		var q1, q2 []*query
 		switch e := expr.Data.(type) {
-		//case *js_ast.ECall:
-		//	e.Target
+		case *js_ast.ECall:
+			return c.findQueryForCall(analyzer, allQueries, e)
 		case *js_ast.EIf:
 			q1 = c.findQueryForExpr(analyzer, allQueries, &e.Yes)
 			q2 = c.findQueryForExpr(analyzer, allQueries, &e.No)
@@ -825,72 +1307,270 @@ func (c *FlowStateCompiler) findQueryForExpr(analyzer *FlowStateAnalyzer, allQue
 				q2 = c.findQueryForExpr(analyzer, allQueries, &e.Right)
 			}
 		}
-		if len(q2) == 0 {
-			return q1
-		} else if len(q1) == 0 {
-			return q2
-		} else {
-			// Mergesort q1 and q2 into a single array. This is overkill to optimize this, but it was fun!
-			a := make(queriesByWhitelistOrder, len(q1)+len(q2))
-			i := 0
-			j := 0
-			for k := range a {
-				if i < len(q1) {
-					if j < len(q2) {
-						// Add both elements to the array, compare them using a.Less
-						// And if they're in the wrong order, Swap them with.
-						// But only advance the source array for the element we keep, and only advance
-						// the length of a by 1.
-						a[k] = q1[i]
-						a[k+1] = q2[j]
-						if a.Less(k+1, k) {
-							a.Swap(k+1, k)
-							j++
-						} else {
-							i++
-						}
-					} else {
-						a[k] = q1[i]
-						i++
-					}
-				} else {
-					a[k] = q2[j]
-					j++
-				}
-			}
-			return a
-		}
+		return mergeQueries(q1, q2)
 	}
 	if analyzer == nil {
 		analyzer = c.analyzers[queryRef.SourceIndex]
 	}
 	queryRef = c.findOriginalRef(analyzer, queryRef, prop)
 	a := allQueries[queryRef]
-	log.Printf("%d queries found for original ref %v\n", len(a), queryRef)
+	if len(a) == 0 && prop == "" {
+		if definer := c.analyzers[queryRef.SourceIndex]; definer != nil {
+			// The variable may be initialized with a call to a function that returns queries
+			if call, ok := definer.callResults[queryRef]; ok {
+				return c.findQueryForExpr(definer, allQueries, call)
+			}
+			// Or it may be a function parameter that's passed queries
+			if param, ok := definer.params[queryRef]; ok {
+				return c.findQueryForParam(definer, allQueries, queryRef, param)
+			}
+		}
+	}
+	c.trace(traceQuery, nil, logger.Loc{}, "%d queries found for %s%s", len(a), c.refName(queryRef), propSuffix(prop))
 	sort.Sort(a)
 	return a
 }
 
-func (c *FlowStateCompiler) replaceExpr(expr *js_ast.Expr, old, new js_ast.E, undo bool) bool {
-	if expr.Data != old {
+// findQueryForCall finds the queries returned by a call to a local or imported function.
+// Every value the function returns must be a query, or a combination of queries with
+// ternary conditions and logical expressions, otherwise nil is returned.
+func (c *FlowStateCompiler) findQueryForCall(analyzer *FlowStateAnalyzer, allQueries map[js_ast.Ref]queriesByWhitelistOrder, call *js_ast.ECall) queriesByWhitelistOrder {
+	fnRef, prop := getRefForIdentifierOrPropertyAccess(analyzer, &call.Target)
+	if fnRef == js_ast.InvalidRef {
+		return nil
+	}
+	if analyzer == nil {
+		analyzer = c.analyzers[fnRef.SourceIndex]
+	}
+	fnRef = c.findOriginalRef(analyzer, fnRef, prop)
+	definer := c.analyzers[fnRef.SourceIndex]
+	if definer == nil || c.tracing[fnRef] {
+		// A recursive call returns the same queries as the outer call
+		return nil
+	}
+	values, ok := definer.returnValues[fnRef]
+	if !ok {
+		return nil
+	}
+
+	c.tracing[fnRef] = true
+	defer delete(c.tracing, fnRef)
+
+	var queries queriesByWhitelistOrder
+	for _, value := range values {
+		if c.isRecursiveCall(definer, value) {
+			continue // it returns the queries of the other return statements
+		}
+		q := c.findQueryForExpr(definer, allQueries, value)
+		if len(q) == 0 {
+			return nil
+		}
+		queries = mergeQueries(queries, q)
+	}
+
+	return dedupeQueries(queries)
+}
+
+// isRecursiveCall returns true if expr calls a function whose return values are being traced
+func (c *FlowStateCompiler) isRecursiveCall(analyzer *FlowStateAnalyzer, expr *js_ast.Expr) bool {
+	call, ok := expr.Data.(*js_ast.ECall)
+	if !ok {
 		return false
 	}
+	ref, prop := getRefForIdentifierOrPropertyAccess(analyzer, &call.Target)
+	return ref != js_ast.InvalidRef && c.tracing[c.findOriginalRef(analyzer, ref, prop)]
+}
+
+type callSite struct {
+	analyzer *FlowStateAnalyzer
+	call     *js_ast.ECall
+}
+
+// moduleRef is a ref and the analyzer of the module it belongs to
+type moduleRef struct {
+	analyzer *FlowStateAnalyzer
+	ref      js_ast.Ref
+}
 
-	expr.Data = new
-	if undo {
-		c.undoReplaceExpr = append(c.undoReplaceExpr, undoReplaceExpr{expr, old})
+// findQueryForParam finds the queries passed to a function parameter at every call site of its
+// function, across all modules. If the queries passed at any call site can't be identified, or
+// the function is used as a value and may be called from anywhere, nil is returned.
+func (c *FlowStateCompiler) findQueryForParam(definer *FlowStateAnalyzer, allQueries map[js_ast.Ref]queriesByWhitelistOrder, paramRef js_ast.Ref, param functionParam) queriesByWhitelistOrder {
+	if c.tracing[paramRef] {
+		// A recursive call passes on the queries passed to the outer call
+		return nil
 	}
-	return true
+
+	var sites []callSite
+	if param.iife != nil {
+		sites = []callSite{{analyzer: definer, call: param.iife}}
+	} else {
+		c.indexCallSites()
+		if c.escapedFunctions[param.fn] {
+			c.trace(traceQuery, nil, logger.Loc{}, "function %s is used as a value, can't trace the queries passed to it", c.refName(param.fn))
+			return nil
+		}
+		sites = c.callSites[param.fn]
+
+		// Code outside the bundle can call a function exported from an entry point, with queries that
+		// aren't in the whitelist. Only the queries passed to it inside the bundle are compiled. The other
+		// params, like the values interpolated in a query, can be anything, so they aren't reported.
+		if entry, ok := c.exportedFunctions[param.fn]; ok && c.tracingExecution && !c.reportedParams[paramRef] {
+			c.reportedParams[paramRef] = true
+			c.log.AddWarning(&definer.file.Source, param.loc, fmt.Sprintf(
+				"only the queries passed to %s inside the bundle are compiled: it's exported from the entry point %s, so it can be called from outside the bundle",
+				definer.ast.Symbols[param.fn.InnerIndex].OriginalName, c.files[entry].Source.PrettyPath))
+		}
+	}
+
+	c.tracing[paramRef] = true
+	defer delete(c.tracing, paramRef)
+
+	var queries queriesByWhitelistOrder
+	for _, site := range sites {
+		var q queriesByWhitelistOrder
+		if param.index < len(site.call.Args) {
+			arg := &site.call.Args[param.index]
+			for _, a := range site.call.Args[:param.index+1] {
+				if _, ok := a.Data.(*js_ast.ESpread); ok {
+					return nil
+				}
+			}
+			q = c.findQueryForExpr(site.analyzer, allQueries, arg)
+		} else if param.defaultValue != nil {
+			q = c.findQueryForExpr(definer, allQueries, param.defaultValue)
+		}
+		if len(q) == 0 {
+			// Recursive calls are skipped, the queries they pass on are found at the other call sites
+			if param.index < len(site.call.Args) && c.isRecursiveArg(site.analyzer, &site.call.Args[param.index], paramRef) {
+				continue
+			}
+			return nil
+		}
+		queries = mergeQueries(queries, q)
+	}
+	return dedupeQueries(queries)
 }
 
-func (c *FlowStateCompiler) replaceStmt(stmt *js_ast.Stmt, old, new js_ast.S, undo bool) bool {
-	if stmt.Data != old {
+// isRecursiveArg returns true if arg is the parameter paramRef, or an alias of it
+func (c *FlowStateCompiler) isRecursiveArg(analyzer *FlowStateAnalyzer, arg *js_ast.Expr, paramRef js_ast.Ref) bool {
+	ref, prop := getRefForIdentifierOrPropertyAccess(analyzer, arg)
+	return ref != js_ast.InvalidRef && prop == "" && c.findOriginalRef(analyzer, ref, prop) == paramRef
+}
+
+// indexCallSites finds the function each call in each module refers to, the functions that are
+// used as values, and the imports of each ref. It's only done once, the first time it's needed.
+func (c *FlowStateCompiler) indexCallSites() {
+	if c.callSites != nil {
+		return
+	}
+	c.callSites = map[js_ast.Ref][]callSite{}
+	c.escapedFunctions = map[js_ast.Ref]bool{}
+	c.importedRefs = map[js_ast.Ref][]moduleRef{}
+	for _, analyzer := range c.analyzers {
+		if analyzer == nil {
+			continue
+		}
+		for importRef := range analyzer.ast.NamedImports {
+			ref := c.findOriginalRef(analyzer, importRef, "")
+			c.importedRefs[ref] = append(c.importedRefs[ref], moduleRef{analyzer: analyzer, ref: importRef})
+		}
+		for _, call := range analyzer.calls {
+			ref, prop := getRefForIdentifierOrPropertyAccess(analyzer, &call.Target)
+			if ref == js_ast.InvalidRef {
+				continue
+			}
+			ref = c.findOriginalRef(analyzer, ref, prop)
+			c.callSites[ref] = append(c.callSites[ref], callSite{analyzer: analyzer, call: call})
+		}
+		for ref := range analyzer.escapedRefs {
+			c.escapedFunctions[c.findOriginalRef(analyzer, ref, "")] = true
+		}
+	}
+
+	c.exportedFunctions = map[js_ast.Ref]uint32{}
+	for _, entryPoint := range c.entryPoints {
+		analyzer := c.analyzers[entryPoint.SourceIndex]
+		if analyzer == nil {
+			continue
+		}
+		for _, export := range analyzer.ast.NamedExports {
+			c.exportedFunctions[c.findOriginalRef(analyzer, export.Ref, "")] = entryPoint.SourceIndex
+		}
+	}
+}
+
+// dedupeQueries removes the duplicates from a sorted list of queries, which
+// happens when the same query is found on more than one path. The duplicates
+// aren't always adjacent, the queries defined on the same line compare equal.
+func dedupeQueries(queries queriesByWhitelistOrder) queriesByWhitelistOrder {
+	deduped := make(queriesByWhitelistOrder, 0, len(queries))
+	seen := make(map[*query]bool, len(queries))
+	for _, q := range queries {
+		if !seen[q] {
+			seen[q] = true
+			deduped = append(deduped, q)
+		}
+	}
+	return deduped
+}
+
+// mergeQueries combines two sorted lists of queries into a single sorted list
+func mergeQueries(q1, q2 queriesByWhitelistOrder) queriesByWhitelistOrder {
+	if len(q2) == 0 {
+		return q1
+	} else if len(q1) == 0 {
+		return q2
+	}
+
+	// Mergesort q1 and q2 into a single array. This is overkill to optimize this, but it was fun!
+	a := make(queriesByWhitelistOrder, len(q1)+len(q2))
+	i := 0
+	j := 0
+	for k := range a {
+		if i < len(q1) {
+			if j < len(q2) {
+				// Add both elements to the array, compare them using a.Less
+				// And if they're in the wrong order, Swap them with.
+				// But only advance the source array for the element we keep, and only advance
+				// the length of a by 1.
+				a[k] = q1[i]
+				a[k+1] = q2[j]
+				if a.Less(k+1, k) {
+					a.Swap(k+1, k)
+					j++
+				} else {
+					i++
+				}
+			} else {
+				a[k] = q1[i]
+				i++
+			}
+		} else {
+			a[k] = q2[j]
+			j++
+		}
+	}
+	return a
+}
+
+// replaceExpr replaces old with new at expr (which belongs to part) in the builds given by targets.
+// The ASTs aren't modified, the replacement is recorded in the edits for each build.
+// The current value is checked against the client build (or the server build if it's the only target).
+func (c *FlowStateCompiler) replaceExpr(part *js_ast.Part, expr *js_ast.Expr, old, new js_ast.E, targets buildTarget) bool {
+	current := &c.clientEdits
+	if targets == targetServer {
+		current = &c.serverEdits
+	}
+	if current.exprData(expr) != old {
 		return false
 	}
 
-	stmt.Data = new
-	if undo {
-		c.undoReplaceStmt = append(c.undoReplaceStmt, undoReplaceStmt{stmt, old})
+	if targets&targetClient != 0 {
+		c.clientEdits.replaceExpr(part, expr, new)
+	}
+	if targets&targetServer != 0 {
+		c.serverEdits.replaceExpr(part, expr, new)
 	}
 	return true
 }
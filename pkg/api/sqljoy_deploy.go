@@ -0,0 +1,191 @@
+package api
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	deployManifestName = "manifest.json"
+	deployTimeout      = 2 * time.Minute
+
+	DeployAccountHeader   = "X-SQLJoy-Account"
+	DeployVersionHeader   = "X-SQLJoy-Version"
+	DeployTimestampHeader = "X-SQLJoy-Timestamp"
+	DeploySignatureHeader = "X-SQLJoy-Signature"
+)
+
+type DeployResult struct {
+	// Version is the version reported by the deployment endpoint,
+	// or the version of the artifact if the endpoint didn't report one.
+	Version string
+	// ArtifactVersion is the content hash of the uploaded artifact
+	ArtifactVersion string
+}
+
+type deployManifest struct {
+	AccountId string            `json:"accountId"`
+	Version   string            `json:"version"`
+	Files     map[string]string `json:"files"` // file name -> sha256 hex digest
+}
+
+// DeployFlowState packages the server bundle and the query whitelists from a successful
+// build into a versioned artifact and uploads it to opts.DeployEndpoint, signed with the account secret.
+func DeployFlowState(opts *SQLJoyOptions, result BuildResult) (DeployResult, error) {
+	if opts.DeployEndpoint == "" {
+		return DeployResult{}, errors.New("deployEndpoint is required in the config to deploy")
+	}
+	if opts.AccountId == "" || opts.AccountSecret == "" {
+		return DeployResult{}, errors.New("accountId and accountSecret are required in the config to deploy")
+	}
+	if len(result.Errors) != 0 {
+		return DeployResult{}, errors.New("cannot deploy a build with errors")
+	}
+
+	artifact, version, err := packageDeployArtifact(opts, result.OutputFiles)
+	if err != nil {
+		return DeployResult{}, err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req, err := http.NewRequest("POST", opts.DeployEndpoint, bytes.NewReader(artifact))
+	if err != nil {
+		return DeployResult{}, fmt.Errorf("invalid deployEndpoint: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/gzip")
+	req.Header.Set(DeployAccountHeader, opts.AccountId)
+	req.Header.Set(DeployVersionHeader, version)
+	req.Header.Set(DeployTimestampHeader, timestamp)
+	req.Header.Set(DeploySignatureHeader, SignDeployArtifact(opts.AccountSecret, timestamp, artifact))
+
+	client := http.Client{Timeout: deployTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return DeployResult{}, fmt.Errorf("upload failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return DeployResult{}, fmt.Errorf("upload failed: %v", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return DeployResult{}, fmt.Errorf("upload failed: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	deployed := DeployResult{Version: version, ArtifactVersion: version}
+	if len(body) != 0 {
+		var data struct {
+			Version string `json:"version"`
+		}
+		if err := json.Unmarshal(body, &data); err != nil {
+			return DeployResult{}, fmt.Errorf("invalid response from deployment endpoint: %v", err)
+		}
+		if data.Version != "" {
+			deployed.Version = data.Version
+		}
+	}
+	return deployed, nil
+}
+
+// SignDeployArtifact returns the base64 encoded HMAC-SHA256 of the timestamp and artifact
+// using the account secret. The deployment endpoint recomputes this to authenticate the upload.
+func SignDeployArtifact(secret, timestamp string, artifact []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte{'\n'})
+	mac.Write(artifact)
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// packageDeployArtifact creates a gzipped tar archive of the server bundle, the query whitelists
+// and a manifest listing their digests. The version is derived from the contents only,
+// so deploying an identical build produces an identical artifact and version.
+func packageDeployArtifact(opts *SQLJoyOptions, outputFiles []OutputFile) ([]byte, string, error) {
+	serverBundle := path.Base(opts.Server.Outfile)
+	deployed := map[string][]byte{}
+	for _, file := range outputFiles {
+		name := path.Base(file.Path)
+		switch name {
+		case serverBundle, "client-queries.json", "server-queries.json":
+			deployed[name] = file.Contents
+		}
+	}
+	if _, ok := deployed[serverBundle]; !ok {
+		return nil, "", fmt.Errorf("build did not produce %s", serverBundle)
+	}
+
+	names := make([]string, 0, len(deployed))
+	for name := range deployed {
+		names = append(names, name)
+	}
+	sort.Strings(names) // to make it deterministic
+
+	manifest := deployManifest{
+		AccountId: opts.AccountId,
+		Files:     make(map[string]string, len(names)),
+	}
+	h := sha256.New()
+	for _, name := range names {
+		sum := sha256.Sum256(deployed[name])
+		manifest.Files[name] = hex.EncodeToString(sum[:])
+		h.Write([]byte(name))
+		h.Write(sum[:])
+	}
+	var digest [sha256.Size]byte
+	sum := h.Sum(digest[:0])
+	manifest.Version = base64.RawURLEncoding.EncodeToString(sum[:30])
+
+	manifestJSON, err := json.MarshalIndent(&manifest, "", "\t")
+	if err != nil {
+		return nil, "", err
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	writeFile := func(name string, contents []byte) error {
+		err := tw.WriteHeader(&tar.Header{
+			Name:     name,
+			Mode:     0644,
+			Size:     int64(len(contents)),
+			Typeflag: tar.TypeReg,
+		})
+		if err == nil {
+			_, err = tw.Write(contents)
+		}
+		return err
+	}
+
+	if err := writeFile(deployManifestName, manifestJSON); err != nil {
+		return nil, "", err
+	}
+	for _, name := range names {
+		if err := writeFile(name, deployed[name]); err != nil {
+			return nil, "", err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, "", err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, "", err
+	}
+
+	return buf.Bytes(), manifest.Version, nil
+}
@@ -0,0 +1,236 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"syscall"
+
+	"github.com/evanw/esbuild/internal/logger"
+)
+
+// whitelistDiff is the change report written to queries-diff.json when opts.PreviousBuild is set.
+// Queries are matched by id first, then by where they're defined, so an edited query shows up
+// as changed rather than as a removed query and an added one.
+type whitelistDiff struct {
+	Added     []*whitelistEntry `json:"added"`
+	Removed   []*whitelistEntry `json:"removed"`
+	Changed   []queryChange     `json:"changed"`
+	Moved     []queryChange     `json:"moved"` // same query, defined somewhere else
+	Unchanged int               `json:"unchanged"`
+}
+
+type queryChange struct {
+	Previous   *whitelistEntry `json:"previous"`
+	Current    *whitelistEntry `json:"current"`
+	MadePublic bool            `json:"madePublic,omitempty"`
+}
+
+// whitelistEntry is the part of a whitelist query that's compared between builds
+type whitelistEntry struct {
+	Id        string         `json:"id"`
+	Query     string         `json:"query"`
+	IsPublic  bool           `json:"isPublic"`
+	Policy    *queryPolicy   `json:"policy,omitempty"`
+	Limits    *queryLimits   `json:"limits,omitempty"`
+	DefinedAt sourceLocation `json:"definedAt"`
+	Whitelist string         `json:"whitelist"` // client or server
+	q         *query         // nil for the previous build
+}
+
+// loadPreviousWhitelists reads the client and server whitelists from opts.PreviousBuild.
+// It must be called before the new whitelists are written, in case they're in the same directory.
+func (c *FlowStateCompiler) loadPreviousWhitelists() []*whitelistEntry {
+	dir := c.opts.PreviousBuild
+	if !c.fs.IsAbs(dir) {
+		dir = c.fs.Join(c.fs.Cwd(), dir)
+	}
+
+	var entries []*whitelistEntry
+	found := false
+	for _, whitelist := range []string{"client", "server"} {
+		fileName := whitelist + "-queries.json"
+		contents, err, _ := c.fs.ReadFile(c.fs.Join(dir, fileName))
+		if err == syscall.ENOENT {
+			continue
+		}
+		if err != nil {
+			c.log.AddError(nil, logger.Loc{}, fmt.Sprintf("Could not read previous whitelist %s: %s", fileName, err.Error()))
+			return nil
+		}
+		found = true
+
+		var previous []*whitelistEntry
+		if err := json.Unmarshal([]byte(contents), &previous); err != nil {
+			c.log.AddError(nil, logger.Loc{}, fmt.Sprintf("Invalid previous whitelist %s: %s", fileName, err.Error()))
+			return nil
+		}
+		for _, entry := range previous {
+			entry.Whitelist = whitelist
+		}
+		entries = append(entries, previous...)
+	}
+
+	if !found {
+		c.log.AddError(nil, logger.Loc{}, fmt.Sprintf("No query whitelists found in previous build %q", c.opts.PreviousBuild))
+	}
+	return entries
+}
+
+// diffWhitelists compares the new whitelists to the previous build and writes the change report.
+// It's an error for a private query to become public unless opts.AllowMadePublic is set,
+// because that exposes a query to unauthenticated clients that was reviewed as server-only.
+func (c *FlowStateCompiler) diffWhitelists(previous []*whitelistEntry, clientWhitelist, serverWhitelist queriesByWhitelistOrder) {
+	current := make([]*whitelistEntry, 0, len(clientWhitelist)+len(serverWhitelist))
+	for _, wl := range []struct {
+		name    string
+		queries queriesByWhitelistOrder
+	}{{"client", clientWhitelist}, {"server", serverWhitelist}} {
+		for _, q := range wl.queries {
+			current = append(current, &whitelistEntry{
+				Id:        q.Hash,
+				Query:     q.QueryText,
+				IsPublic:  q.IsPublic,
+				Policy:    q.Policy,
+				Limits:    q.Limits,
+				DefinedAt: q.DefinedAt,
+				Whitelist: wl.name,
+				q:         q,
+			})
+		}
+	}
+
+	diff := whitelistDiff{
+		Added:   []*whitelistEntry{},
+		Removed: []*whitelistEntry{},
+		Changed: []queryChange{},
+		Moved:   []queryChange{},
+	}
+
+	// Match by id, then by the location of the remaining queries
+	matched := map[*whitelistEntry]*whitelistEntry{}
+	previousById := map[string]*whitelistEntry{}
+	for _, entry := range previous {
+		previousById[entry.Id] = entry
+	}
+	for _, entry := range current {
+		if prev := previousById[entry.Id]; prev != nil && matched[prev] == nil {
+			matched[prev] = entry
+			matched[entry] = prev
+		}
+	}
+	previousByLocation := map[sourceLocation][]*whitelistEntry{}
+	for _, entry := range previous {
+		if matched[entry] == nil {
+			previousByLocation[entry.DefinedAt] = append(previousByLocation[entry.DefinedAt], entry)
+		}
+	}
+	for _, entry := range current {
+		if matched[entry] != nil {
+			continue
+		}
+		if candidates := previousByLocation[entry.DefinedAt]; len(candidates) != 0 {
+			matched[candidates[0]] = entry
+			matched[entry] = candidates[0]
+			previousByLocation[entry.DefinedAt] = candidates[1:]
+		}
+	}
+
+	// A public query that wasn't matched may still have been private in the previous build, if it was
+	// moved as well as edited. It's matched to a private query with the same id or text, or the same
+	// text once the params and server vars are blanked out, as a server var is replaced by a param
+	// to make a query public.
+	previousPrivate := map[string]*whitelistEntry{}
+	for _, entry := range previous {
+		if !entry.IsPublic {
+			previousPrivate["id:"+entry.Id] = entry
+			previousPrivate["text:"+entry.Query] = entry
+			previousPrivate["shape:"+queryShape(entry.Query)] = entry
+		}
+	}
+	madePublic := map[*whitelistEntry]*whitelistEntry{}
+	for _, entry := range current {
+		if matched[entry] != nil || !entry.IsPublic {
+			continue
+		}
+		prev := previousPrivate["id:"+entry.Id]
+		if prev == nil {
+			prev = previousPrivate["text:"+entry.Query]
+		}
+		if prev == nil {
+			prev = previousPrivate["shape:"+queryShape(entry.Query)]
+		}
+		if prev != nil {
+			madePublic[entry] = prev
+			if matched[prev] == nil {
+				matched[prev] = entry
+			}
+		}
+	}
+
+	for _, entry := range previous {
+		if matched[entry] == nil {
+			diff.Removed = append(diff.Removed, entry)
+		}
+	}
+	for _, entry := range current {
+		prev := matched[entry]
+		if prev == nil {
+			if prev = madePublic[entry]; prev == nil {
+				diff.Added = append(diff.Added, entry)
+				continue
+			}
+		}
+
+		change := queryChange{Previous: prev, Current: entry, MadePublic: !prev.IsPublic && entry.IsPublic}
+		switch {
+		case prev.Id != entry.Id || prev.IsPublic != entry.IsPublic || prev.Whitelist != entry.Whitelist ||
+			!reflect.DeepEqual(prev.Policy, entry.Policy) || !reflect.DeepEqual(prev.Limits, entry.Limits):
+			diff.Changed = append(diff.Changed, change)
+		case prev.DefinedAt != entry.DefinedAt:
+			diff.Moved = append(diff.Moved, change)
+		default:
+			diff.Unchanged++
+		}
+
+		if change.MadePublic && !c.opts.AllowMadePublic {
+			c.log.AddError(entry.q.definedSource, entry.q.parent.Loc, fmt.Sprintf(
+				"This query was private in the previous build (%s:%d) and is now public, set allowMadePublic to allow this",
+				prev.DefinedAt.File, prev.DefinedAt.Line))
+		}
+	}
+
+	sortWhitelistEntries(diff.Added)
+	sortWhitelistEntries(diff.Removed)
+
+	c.diffFile.Path = path.Join(c.outDir, "queries-diff.json")
+	contents, err := json.MarshalIndent(&diff, "", "\t")
+	if err != nil {
+		c.log.AddError(nil, logger.Loc{}, fmt.Sprintf("json.Marshal queries-diff.json: %v", err.Error()))
+		return
+	}
+	c.diffFile.Contents = contents
+	if err := c.fs.WriteFile(c.diffFile.Path, contents, 0644); err != nil {
+		c.log.AddError(nil, logger.Loc{}, fmt.Sprintf("write query diff queries-diff.json: %v", err))
+	}
+}
+
+var reQueryShapeParam = regexp.MustCompile(`\$\{[^}]*\}|\$[0-9]+`)
+
+// queryShape is the query text with its $n params and ${NAMESPACE.key} server vars replaced by $
+func queryShape(text string) string {
+	return reQueryShapeParam.ReplaceAllString(text, "$$")
+}
+
+func sortWhitelistEntries(entries []*whitelistEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i].DefinedAt, entries[j].DefinedAt
+		if a.File == b.File {
+			return a.Line < b.Line
+		}
+		return a.File < b.File
+	})
+}
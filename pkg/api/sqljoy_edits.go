@@ -0,0 +1,224 @@
+package api
+
+import (
+	"reflect"
+
+	"github.com/evanw/esbuild/internal/graph"
+	"github.com/evanw/esbuild/internal/js_ast"
+)
+
+type buildTarget uint8
+
+const (
+	targetClient buildTarget = 1 << iota
+	targetServer
+	targetBoth = targetClient | targetServer
+)
+
+// astEdits records the changes the compiler makes to the ASTs for one of the builds.
+//
+// The ASTs belong to the cache set, so they're shared with the other build and with any
+// later rebuilds. Instead of changing them in place, the edits are applied to copy-on-write
+// clones of the affected parts just before the bundle is linked (see applyTo).
+type astEdits struct {
+	exprs     map[*js_ast.Expr]js_ast.E
+	parts     map[*js_ast.Part]bool // parts containing an edited expression
+	deadParts map[*js_ast.Part]bool
+	useCounts map[js_ast.Ref]uint32
+}
+
+func newAstEdits() astEdits {
+	return astEdits{
+		exprs:     map[*js_ast.Expr]js_ast.E{},
+		parts:     map[*js_ast.Part]bool{},
+		deadParts: map[*js_ast.Part]bool{},
+		useCounts: map[js_ast.Ref]uint32{},
+	}
+}
+
+// exprData returns the current value of expr with the edits applied
+func (e *astEdits) exprData(expr *js_ast.Expr) js_ast.E {
+	if data, ok := e.exprs[expr]; ok {
+		return data
+	}
+	return expr.Data
+}
+
+func (e *astEdits) replaceExpr(part *js_ast.Part, expr *js_ast.Expr, data js_ast.E) {
+	if part == nil {
+		panic("edited expression must belong to a part")
+	}
+	e.exprs[expr] = data
+	e.parts[part] = true
+}
+
+// decUseCount decrements the use count estimate of symbol (which is the symbol for ref) and returns the new count
+func (e *astEdits) decUseCount(ref js_ast.Ref, symbol *js_ast.Symbol) uint32 {
+	count, ok := e.useCounts[ref]
+	if !ok {
+		count = symbol.UseCountEstimate
+	}
+	if count != 0 {
+		count--
+	}
+	e.useCounts[ref] = count
+	return count
+}
+
+// applyTo replaces the representation of every file in files that has edits with a clone
+// containing the edits. Only the edited parts are deep cloned, the rest of the AST is shared.
+// This relies on the source indices (and therefore the cached ASTs) being the same for
+// the client and server builds, which is guaranteed by the shared cache set.
+func (e *astEdits) applyTo(files []graph.InputFile) {
+	if len(e.parts) == 0 && len(e.deadParts) == 0 && len(e.useCounts) == 0 {
+		return
+	}
+
+	useCountsBySource := map[uint32][]js_ast.Ref{}
+	for ref := range e.useCounts {
+		useCountsBySource[ref.SourceIndex] = append(useCountsBySource[ref.SourceIndex], ref)
+	}
+
+	for i := range files {
+		file := &files[i]
+		repr, ok := file.Repr.(*graph.JSRepr)
+		if !ok {
+			continue
+		}
+
+		var clone *graph.JSRepr
+		cloneRepr := func() {
+			if clone == nil {
+				r := *repr
+				r.AST.Parts = append([]js_ast.Part{}, repr.AST.Parts...)
+				clone = &r
+			}
+		}
+
+		for j := range repr.AST.Parts {
+			part := &repr.AST.Parts[j]
+			isEdited := e.parts[part]
+			isDead := e.deadParts[part]
+			if !isEdited && !isDead {
+				continue
+			}
+			cloneRepr()
+			if isEdited {
+				clone.AST.Parts[j].Stmts = e.cloneStmts(part.Stmts)
+			}
+			if isDead {
+				clone.AST.Parts[j].IsDead = true
+			}
+		}
+
+		if refs := useCountsBySource[file.Source.Index]; len(refs) != 0 {
+			cloneRepr()
+			clone.AST.Symbols = append([]js_ast.Symbol{}, repr.AST.Symbols...)
+			for _, ref := range refs {
+				clone.AST.Symbols[ref.InnerIndex].UseCountEstimate = e.useCounts[ref]
+			}
+		}
+
+		if clone != nil {
+			file.Repr = clone
+		}
+	}
+}
+
+func (e *astEdits) cloneStmts(stmts []js_ast.Stmt) []js_ast.Stmt {
+	cloner := astCloner{edits: e.exprs, pointers: map[clonedPointer]reflect.Value{}}
+	result := make([]js_ast.Stmt, len(stmts))
+	cloner.clone(reflect.ValueOf(result), reflect.ValueOf(stmts))
+	return result
+}
+
+var exprType = reflect.TypeOf(js_ast.Expr{})
+
+// astCloner deep clones statements, substituting the edited expressions as it goes.
+//
+// Replacement expressions created by the compiler can refer to expressions in the original
+// AST by pointer (e.g. query params), and those can have edits of their own. Cloning the
+// replacements with the same cloner maps them to the edited clones. Shared pointers are
+// cloned once, so any aliasing in the original AST is preserved.
+type astCloner struct {
+	edits    map[*js_ast.Expr]js_ast.E
+	pointers map[clonedPointer]reflect.Value
+}
+
+// clonedPointer includes the type because pointers to zero-sized values (e.g. &js_ast.EThis{})
+// and pointers to the first field of a struct can have the same address as something else.
+type clonedPointer struct {
+	ty      reflect.Type
+	address uintptr
+}
+
+func (c *astCloner) clone(dst, src reflect.Value) {
+	switch src.Kind() {
+	case reflect.Ptr:
+		if src.IsNil() {
+			return
+		}
+		key := clonedPointer{src.Type(), src.Pointer()}
+		if p, ok := c.pointers[key]; ok {
+			dst.Set(p)
+			return
+		}
+		p := reflect.New(src.Type().Elem())
+		c.pointers[key] = p
+		c.clone(p.Elem(), src.Elem())
+		dst.Set(p)
+
+	case reflect.Interface:
+		if src.IsNil() {
+			return
+		}
+		elem := src.Elem()
+		v := reflect.New(elem.Type()).Elem()
+		c.clone(v, elem)
+		dst.Set(v)
+
+	case reflect.Slice:
+		if src.IsNil() {
+			return
+		}
+		if dst.IsNil() {
+			dst.Set(reflect.MakeSlice(src.Type(), src.Len(), src.Len()))
+		}
+		for i := 0; i < src.Len(); i++ {
+			c.clone(dst.Index(i), src.Index(i))
+		}
+
+	case reflect.Array:
+		for i := 0; i < src.Len(); i++ {
+			c.clone(dst.Index(i), src.Index(i))
+		}
+
+	case reflect.Struct:
+		if src.Type() == exprType && src.CanAddr() {
+			if data, ok := c.edits[src.Addr().Interface().(*js_ast.Expr)]; ok {
+				expr := js_ast.Expr{Loc: src.Interface().(js_ast.Expr).Loc, Data: data}
+				src = reflect.ValueOf(&expr).Elem()
+			}
+		}
+		for i := 0; i < src.NumField(); i++ {
+			c.clone(dst.Field(i), src.Field(i))
+		}
+
+	case reflect.Map:
+		// There are no maps in statements or expressions, but handle them anyway
+		if src.IsNil() {
+			return
+		}
+		m := reflect.MakeMapWithSize(src.Type(), src.Len())
+		iter := src.MapRange()
+		for iter.Next() {
+			v := reflect.New(src.Type().Elem()).Elem()
+			c.clone(v, iter.Value())
+			m.SetMapIndex(iter.Key(), v)
+		}
+		dst.Set(m)
+
+	default:
+		dst.Set(src)
+	}
+}
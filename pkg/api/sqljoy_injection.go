@@ -0,0 +1,252 @@
+package api
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/evanw/esbuild/internal/js_ast"
+	"github.com/evanw/esbuild/internal/js_lexer"
+	"github.com/evanw/esbuild/internal/logger"
+)
+
+// stringSQL is SQL built as a plain string at runtime, which can't be compiled into the whitelist,
+// and may allow SQL injection if any of the values inserted into it come from the user
+type stringSQL struct {
+	rule    *lintRule // lintConcatenatedSQL or lintUntaggedSQL
+	how     string    // how the string is built, for the message
+	tainted int       // the number of values inserted into the string that aren't constants
+	notes   []logger.MsgData
+}
+
+// maxStringSQLDepth is how many variables traceStringSQL follows, a string isn't usually copied around much
+const maxStringSQLDepth = 8
+
+// traceStringSQL traces expr through the variables initialized with a plain string, to a string
+// concatenation, a template literal without the sql tag or a .replace() call. The notes show the data
+// flow: the values inserted into the string, then each variable it's assigned to. It returns nil if expr
+// isn't a plain string built one of these ways. source is the file containing expr.
+func (c *FlowStateCompiler) traceStringSQL(source *logger.Source, expr *js_ast.Expr) *stringSQL {
+	var assignments []logger.MsgData
+	for depth := 0; depth < maxStringSQLDepth; depth++ {
+		id, ok := expr.Data.(*js_ast.EIdentifier)
+		if !ok {
+			break
+		}
+		analyzer := c.analyzerForRef(id.Ref)
+		if analyzer == nil || analyzer.stringValues[id.Ref] == nil {
+			return nil
+		}
+		expr = analyzer.stringValues[id.Ref]
+		source = &analyzer.file.Source
+		name := analyzer.ast.Symbols[id.Ref.InnerIndex].OriginalName
+		assignments = append([]logger.MsgData{noteAt(source, expr.Loc,
+			fmt.Sprintf("The SQL string is assigned to %s here", name))}, assignments...)
+	}
+
+	s := &stringSQL{}
+	if !c.traceStringParts(source, expr, s) || s.rule == nil {
+		return nil // a string constant isn't SQL injection, the caller reports it can't be compiled
+	}
+	s.notes = append(s.notes, assignments...)
+	return s
+}
+
+// traceStringParts records how expr is built and adds a note for each value inserted into it
+func (c *FlowStateCompiler) traceStringParts(source *logger.Source, expr *js_ast.Expr, s *stringSQL) bool {
+	switch e := expr.Data.(type) {
+	case *js_ast.EBinary:
+		if !isStringConcatenation(expr) {
+			return false
+		}
+		if s.rule == nil {
+			s.rule, s.how = lintConcatenatedSQL, "string concatenation"
+		}
+		for _, operand := range []*js_ast.Expr{&e.Left, &e.Right} {
+			if !c.traceStringParts(source, operand, s) {
+				c.addTaintedValue(source, operand, s)
+			}
+		}
+		return true
+	case *js_ast.ETemplate:
+		if e.Tag != nil {
+			return false
+		}
+		if s.rule == nil {
+			s.rule, s.how = lintUntaggedSQL, "a template literal without the sql tag"
+		}
+		for i := range e.Parts {
+			if !c.traceStringParts(source, &e.Parts[i].Value, s) {
+				c.addTaintedValue(source, &e.Parts[i].Value, s)
+			}
+		}
+		return true
+	case *js_ast.ECall:
+		dot, ok := e.Target.Data.(*js_ast.EDot)
+		if !ok || (dot.Name != "replace" && dot.Name != "replaceAll") || len(e.Args) < 2 {
+			return false
+		}
+		if s.rule == nil {
+			s.rule, s.how = lintUntaggedSQL, "String."+dot.Name+"()"
+		}
+		c.traceStringParts(source, &dot.Target, s)
+		c.addTaintedValue(source, &e.Args[1], s)
+		return true
+	case *js_ast.EString:
+		return true // a constant part of the string
+	}
+	return false
+}
+
+func (c *FlowStateCompiler) addTaintedValue(source *logger.Source, expr *js_ast.Expr, s *stringSQL) {
+	switch expr.Data.(type) {
+	case *js_ast.EString, *js_ast.ENumber, *js_ast.EBoolean, *js_ast.ENull, *js_ast.EUndefined:
+		return
+	}
+	s.tainted++
+	s.notes = append(s.notes, noteAt(source, expr.Loc, fmt.Sprintf("%s is inserted into the SQL string here", c.describeExpr(expr))))
+}
+
+// lintStringSQL reports the concatenated-sql or untagged-sql rule if expr is SQL built as a plain string.
+// what is "query" or "query part", and fix is how to build it instead. It returns the severity it was reported at.
+func (c *FlowStateCompiler) lintStringSQL(source *logger.Source, expr *js_ast.Expr, what, fix string) LintSeverity {
+	s := c.traceStringSQL(source, expr)
+	if s == nil {
+		return LintOff
+	}
+	text := fmt.Sprintf("%s built by %s, which may allow SQL injection: %s", what, s.how, fix)
+	if s.tainted == 0 {
+		text = fmt.Sprintf("%s built by %s: %s", what, s.how, fix)
+	}
+	return c.lintWithNotes(s.rule, source, expr.Loc, text, s.notes)
+}
+
+// reSQLText matches string constants that look like SQL rather than data, like a LIKE pattern
+var reSQLText = regexp.MustCompile(`(?i)\b(select|insert|update|delete|from|where|and|or|order\s+by|group\s+by|join|limit|values|set)\b|['=<>]`)
+
+// lintStringSQLParams reports the string-sql-param rule for the values interpolated in a sql template that
+// are SQL strings built at runtime. They're sent as one parameter value, not as part of the query.
+func (c *FlowStateCompiler) lintStringSQLParams(source *logger.Source, template *js_ast.ETemplate) {
+	for i := range template.Parts {
+		value := &template.Parts[i].Value
+		if _, call := c.sqlPartCall(value); call != nil {
+			continue // checked as a query part by replaceQuery
+		}
+		s := c.traceStringSQL(source, value)
+		if s == nil || s.tainted == 0 || !reSQLText.MatchString(c.stringConstants(value)) {
+			continue
+		}
+		c.lintWithNotes(lintStringSQLParam, source, value.Loc, fmt.Sprintf(
+			"interpolated value is SQL built by %s, it's sent as a single parameter value and not as part of the query: use sql.p`` to build a query part", s.how), s.notes)
+	}
+}
+
+// stringConstants returns the constant parts of a string built by traceStringSQL, joined by spaces
+func (c *FlowStateCompiler) stringConstants(expr *js_ast.Expr) string {
+	for depth := 0; depth < maxStringSQLDepth; depth++ {
+		id, ok := expr.Data.(*js_ast.EIdentifier)
+		if !ok {
+			break
+		}
+		analyzer := c.analyzerForRef(id.Ref)
+		if analyzer == nil || analyzer.stringValues[id.Ref] == nil {
+			return ""
+		}
+		expr = analyzer.stringValues[id.Ref]
+	}
+
+	var sb strings.Builder
+	var visit func(expr *js_ast.Expr)
+	visit = func(expr *js_ast.Expr) {
+		switch e := expr.Data.(type) {
+		case *js_ast.EString:
+			sb.WriteString(js_lexer.UTF16ToString(e.Value) + " ")
+		case *js_ast.EBinary:
+			visit(&e.Left)
+			visit(&e.Right)
+		case *js_ast.ETemplate:
+			sb.WriteString(js_lexer.UTF16ToString(e.Head) + " ")
+			for i := range e.Parts {
+				visit(&e.Parts[i].Value)
+				sb.WriteString(js_lexer.UTF16ToString(e.Parts[i].Tail) + " ")
+			}
+		case *js_ast.ECall:
+			if dot, ok := e.Target.Data.(*js_ast.EDot); ok {
+				visit(&dot.Target)
+			}
+		}
+	}
+	visit(expr)
+	return sb.String()
+}
+
+// isStringConcatenation returns true if expr adds a string literal or an untagged template to something
+func isStringConcatenation(expr *js_ast.Expr) bool {
+	binary, ok := expr.Data.(*js_ast.EBinary)
+	if !ok || (binary.Op != js_ast.BinOpAdd && binary.Op != js_ast.BinOpAddAssign) {
+		return false
+	}
+	return isStringOperand(&binary.Left) || isStringOperand(&binary.Right)
+}
+
+func isStringOperand(expr *js_ast.Expr) bool {
+	switch e := expr.Data.(type) {
+	case *js_ast.EString:
+		return true
+	case *js_ast.ETemplate:
+		return e.Tag == nil
+	case *js_ast.EBinary:
+		return isStringConcatenation(expr)
+	}
+	return false
+}
+
+// isStringSQL returns true if expr builds a plain string that traceStringSQL can follow
+func isStringSQL(expr *js_ast.Expr) bool {
+	switch e := expr.Data.(type) {
+	case *js_ast.EBinary:
+		return isStringConcatenation(expr)
+	case *js_ast.ETemplate:
+		return e.Tag == nil
+	case *js_ast.ECall:
+		dot, ok := e.Target.Data.(*js_ast.EDot)
+		return ok && (dot.Name == "replace" || dot.Name == "replaceAll")
+	}
+	return false
+}
+
+func (c *FlowStateCompiler) analyzerForRef(ref js_ast.Ref) *FlowStateAnalyzer {
+	if ref == js_ast.InvalidRef || int(ref.SourceIndex) >= len(c.analyzers) {
+		return nil
+	}
+	analyzer := c.analyzers[ref.SourceIndex]
+	if analyzer == nil || int(ref.InnerIndex) >= len(analyzer.ast.Symbols) {
+		return nil
+	}
+	return analyzer
+}
+
+// describeExpr returns a short description of expr for messages, like window.name or getName()
+func (c *FlowStateCompiler) describeExpr(expr *js_ast.Expr) string {
+	switch e := expr.Data.(type) {
+	case *js_ast.EIdentifier:
+		if analyzer := c.analyzerForRef(e.Ref); analyzer != nil {
+			return analyzer.ast.Symbols[e.Ref.InnerIndex].OriginalName
+		}
+	case *js_ast.EImportIdentifier:
+		if analyzer := c.analyzerForRef(e.Ref); analyzer != nil {
+			return analyzer.ast.Symbols[e.Ref.InnerIndex].OriginalName
+		}
+	case *js_ast.EDot:
+		return c.describeExpr(&e.Target) + "." + e.Name
+	case *js_ast.EIndex:
+		return c.describeExpr(&e.Target) + "[...]"
+	case *js_ast.ECall:
+		return c.describeExpr(&e.Target) + "()"
+	}
+	return "a value"
+}
+
+func noteAt(source *logger.Source, loc logger.Loc, text string) logger.MsgData {
+	return logger.RangeData(source, logger.Range{Loc: loc}, text)
+}
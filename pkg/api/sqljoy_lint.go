@@ -0,0 +1,173 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/evanw/esbuild/internal/logger"
+	"github.com/evanw/esbuild/internal/sql_ast"
+)
+
+// LintSeverity is how a lint rule is reported, set per rule with "lint": {"<rule>": "off" | "warning" | "error"}
+type LintSeverity uint8
+
+const (
+	LintOff LintSeverity = iota
+	LintWarning
+	LintError
+)
+
+type lintRule struct {
+	id       string
+	severity LintSeverity // the default severity
+}
+
+var (
+	lintUnusedQuery     = &lintRule{"unused-query", LintWarning}     // a query that's never executed
+	lintUnusedFragment  = &lintRule{"unused-fragment", LintWarning}  // a fragment that's never part of an executed query
+	lintSelectStar      = &lintRule{"select-star", LintWarning}      // SELECT * in a public query, which exposes columns added later
+	lintUnfilteredWrite = &lintRule{"unfiltered-write", LintWarning} // UPDATE or DELETE without a WHERE clause
+	lintConcatenatedSQL = &lintRule{"concatenated-sql", LintError}   // SQL built with + passed to executeQuery, directly or through sql.p()
+	lintUntaggedSQL     = &lintRule{"untagged-sql", LintError}       // SQL built with a template literal without the sql tag or .replace(), passed the same way
+	lintStringSQLParam  = &lintRule{"string-sql-param", LintWarning} // a value interpolated in a sql template that's a SQL string built at runtime
+	lintUncheckedArg    = &lintRule{"unchecked-arg", LintWarning}    // a server function parameter with a type that can't be checked at runtime
+)
+
+var lintRules = map[string]*lintRule{}
+
+func init() {
+	for _, rule := range []*lintRule{lintUnusedQuery, lintUnusedFragment, lintSelectStar, lintUnfilteredWrite, lintConcatenatedSQL, lintUntaggedSQL, lintStringSQLParam, lintUncheckedArg} {
+		lintRules[rule.id] = rule
+	}
+}
+
+// parseLintSeverity parses the severity of a rule in the lint section of fsconfig.json
+func parseLintSeverity(id, severity string) (LintSeverity, error) {
+	if lintRules[id] == nil {
+		return LintOff, fmt.Errorf("Invalid lint rule: %q", id)
+	}
+	switch severity {
+	case "off":
+		return LintOff, nil
+	case "warning":
+		return LintWarning, nil
+	case "error":
+		return LintError, nil
+	}
+	return LintOff, fmt.Errorf("Invalid severity for lint rule %s: %q (valid: off, warning, error)", id, severity)
+}
+
+// lint reports a rule violation at its configured severity, unless it's suppressed with a
+// "// sjc-ignore <rule>" comment on the same line or the line before. It returns the severity it was reported at.
+func (c *FlowStateCompiler) lint(rule *lintRule, source *logger.Source, loc logger.Loc, text string) LintSeverity {
+	return c.lintWithNotes(rule, source, loc, text, nil)
+}
+
+func (c *FlowStateCompiler) lintWithNotes(rule *lintRule, source *logger.Source, loc logger.Loc, text string, notes []logger.MsgData) LintSeverity {
+	severity, ok := c.opts.Lint[rule.id]
+	if !ok {
+		severity = rule.severity
+	}
+	if severity == LintOff {
+		return LintOff
+	}
+	if isLintIgnored(source, loc, rule.id) {
+		c.trace(traceBuild, source, loc, "ignored lint rule %s", rule.id)
+		return LintOff
+	}
+
+	text = fmt.Sprintf("%s [%s]", text, rule.id)
+	kind := logger.Warning
+	if severity == LintError {
+		kind = logger.Error
+	}
+	c.log.AddMsg(logger.Msg{Kind: kind, Data: logger.RangeData(source, logger.Range{Loc: loc}, text), Notes: notes})
+	return severity
+}
+
+const lintIgnoreComment = "sjc-ignore"
+
+// isLintIgnored returns true if the line at loc, or the comment line before it, has a "// sjc-ignore" comment naming the rule.
+// Several rules can be named, separated by spaces or commas.
+func isLintIgnored(source *logger.Source, loc logger.Loc, id string) bool {
+	if source == nil || int(loc.Start) > len(source.Contents) {
+		return false
+	}
+	contents := source.Contents
+	lineStart := strings.LastIndexByte(contents[:loc.Start], '\n') + 1
+	lineEnd := strings.IndexByte(contents[loc.Start:], '\n')
+	if lineEnd == -1 {
+		lineEnd = len(contents)
+	} else {
+		lineEnd += int(loc.Start)
+	}
+	if ignoresRule(contents[lineStart:lineEnd], id) {
+		return true
+	}
+	if lineStart == 0 {
+		return false
+	}
+	prevStart := strings.LastIndexByte(contents[:lineStart-1], '\n') + 1
+	prev := strings.TrimSpace(contents[prevStart : lineStart-1])
+	return strings.HasPrefix(prev, "//") && ignoresRule(prev, id)
+}
+
+func ignoresRule(line, id string) bool {
+	comment := strings.Index(line, "//")
+	for comment != -1 {
+		line = line[comment+2:]
+		text := strings.TrimSpace(line)
+		if strings.HasPrefix(text, lintIgnoreComment) {
+			rules := strings.FieldsFunc(text[len(lintIgnoreComment):], func(r rune) bool {
+				return r == ' ' || r == '\t' || r == ','
+			})
+			return containsString(rules, id)
+		}
+		comment = strings.Index(line, "//")
+	}
+	return false
+}
+
+// lintSQL checks the rules on the parsed query, after checkSyntax
+func (q *query) lintSQL(c *FlowStateCompiler) {
+	if q.ast == nil {
+		return
+	}
+	for _, stmt := range q.ast.Stmts {
+		switch s := stmt.Data.(type) {
+		case *sql_ast.SQuery:
+			if q.IsPublic && selectsStar(s.Query) {
+				c.lint(lintSelectStar, q.definedSource, q.parent.Loc,
+					"public query selects *, list the columns so columns added to the table later aren't exposed to the client")
+			}
+		case *sql_ast.SUpdate:
+			// A fragment slot could add the WHERE clause
+			if s.Where.Data == nil && !s.Fragments {
+				c.lint(lintUnfilteredWrite, q.definedSource, q.parent.Loc, fmt.Sprintf("UPDATE of %s has no WHERE clause, every row is updated", s.Table.Name))
+			}
+		case *sql_ast.SDelete:
+			if s.Where.Data == nil && !s.Fragments {
+				c.lint(lintUnfilteredWrite, q.definedSource, q.parent.Loc, fmt.Sprintf("DELETE from %s has no WHERE clause, every row is deleted", s.Table.Name))
+			}
+		}
+	}
+}
+
+// selectsStar returns true if the result columns of the query include a * or table.*
+func selectsStar(query *sql_ast.Query) bool {
+	switch body := query.Body.(type) {
+	case *sql_ast.QSelect:
+		for _, item := range body.Items {
+			if column, ok := item.Value.Data.(*sql_ast.EColumn); ok && column.Star {
+				return true
+			}
+		}
+	case *sql_ast.QTable:
+		return true
+	case *sql_ast.QSetOp:
+		return selectsStar(body.Left) || selectsStar(body.Right)
+	case *sql_ast.QParens:
+		return selectsStar(body.Query)
+	}
+	return false
+}
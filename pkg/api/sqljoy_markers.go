@@ -0,0 +1,160 @@
+package api
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/evanw/esbuild/internal/graph"
+)
+
+// moduleMarker restricts a module to one of the bundles
+type moduleMarker uint8
+
+const (
+	markerNone       moduleMarker = iota
+	markerServerOnly              // "use server", it can only be used by the client through server calls
+	markerClientOnly              // "use client", it must not be in the server bundle
+)
+
+const useServerDirective = "use server"
+const useClientDirective = "use client"
+
+// moduleMarker returns the marker of the file, from a "use server" or "use client" directive
+// at the top of the file, or from the serverOnly and clientOnly globs in the options
+func (c *FlowStateCompiler) moduleMarker(file *graph.InputFile) moduleMarker {
+	if file.Source.KeyPath.Namespace != "file" {
+		return markerNone
+	}
+	for _, directive := range moduleDirectives(file.Source.Contents) {
+		switch directive {
+		case useServerDirective:
+			return markerServerOnly
+		case useClientDirective:
+			return markerClientOnly
+		}
+	}
+
+	relPath, ok := c.fs.Rel(c.fs.Cwd(), file.Source.KeyPath.Text)
+	if !ok {
+		return markerNone
+	}
+	relPath = strings.ReplaceAll(relPath, "\\", "/")
+	for _, glob := range c.serverOnlyGlobs {
+		if glob.MatchString(relPath) {
+			return markerServerOnly
+		}
+	}
+	for _, glob := range c.clientOnlyGlobs {
+		if glob.MatchString(relPath) {
+			return markerClientOnly
+		}
+	}
+	return markerNone
+}
+
+// moduleDirectives returns the directives in the prologue of the module source, e.g. "use strict".
+// The source is scanned directly, because the parser drops the directives it doesn't support when minifying.
+func moduleDirectives(contents string) []string {
+	var directives []string
+	s := strings.TrimPrefix(contents, "\uFEFF")
+	if strings.HasPrefix(s, "#!") {
+		end := strings.IndexByte(s, '\n')
+		if end == -1 {
+			return nil
+		}
+		s = s[end:]
+	}
+
+	for {
+		s = skipSpaceAndComments(s)
+		if len(s) == 0 || (s[0] != '"' && s[0] != '\'') {
+			return directives
+		}
+		quote := s[0]
+		end := 1
+		for end < len(s) && s[end] != quote && s[end] != '\n' {
+			if s[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end >= len(s) || s[end] != quote {
+			return directives
+		}
+		value := s[1:end]
+
+		// The string must be a statement on its own, not the start of an expression
+		s = strings.TrimLeft(s[end+1:], " \t")
+		switch {
+		case strings.HasPrefix(s, ";"):
+			s = s[1:]
+		case len(s) == 0, s[0] == '\n', s[0] == '\r', strings.HasPrefix(s, "//"), strings.HasPrefix(s, "/*"):
+		default:
+			return directives
+		}
+		directives = append(directives, value)
+	}
+}
+
+func skipSpaceAndComments(s string) string {
+	for len(s) != 0 {
+		switch {
+		case s[0] == ' ' || s[0] == '\t' || s[0] == '\n' || s[0] == '\r':
+			s = s[1:]
+		case strings.HasPrefix(s, "//"):
+			end := strings.IndexByte(s, '\n')
+			if end == -1 {
+				return ""
+			}
+			s = s[end+1:]
+		case strings.HasPrefix(s, "/*"):
+			end := strings.Index(s[2:], "*/")
+			if end == -1 {
+				return ""
+			}
+			s = s[end+4:]
+		default:
+			return s
+		}
+	}
+	return s
+}
+
+// globToRegexp converts a glob relative to the working directory to a regexp, where
+// ** matches any number of directories, * matches anything but a / and ? matches one character
+func globToRegexp(glob string) *regexp.Regexp {
+	var sb strings.Builder
+	sb.WriteString("^")
+	glob = strings.TrimPrefix(glob, "./")
+	for i := 0; i < len(glob); i++ {
+		switch glob[i] {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				i++
+				if i+1 < len(glob) && glob[i+1] == '/' {
+					// **/ matches zero or more directories
+					i++
+					sb.WriteString("(?:.*/)?")
+				} else {
+					sb.WriteString(".*")
+				}
+			} else {
+				sb.WriteString("[^/]*")
+			}
+		case '?':
+			sb.WriteString("[^/]")
+		default:
+			sb.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+	sb.WriteString("$")
+	return regexp.MustCompile(sb.String())
+}
+
+func globsToRegexps(globs []string) []*regexp.Regexp {
+	regexps := make([]*regexp.Regexp, len(globs))
+	for i, glob := range globs {
+		regexps[i] = globToRegexp(glob)
+	}
+	return regexps
+}
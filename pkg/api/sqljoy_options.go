@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"regexp"
 
 	"github.com/evanw/esbuild/internal/fs"
 )
@@ -16,11 +17,38 @@ type SQLJoyOptions struct {
 	Exclude []string
 	AccountId string
 	AccountSecret string
-	Watch bool
+	DeployEndpoint string
+	SQLDialect SQLDialect
+	Schema string // a SQL DDL or JSON file the queries are checked against
+	SchemaWarnings bool // report schema mismatches as warnings instead of errors
+	TypeScript bool // write client-queries.d.ts with the types of the client queries
+	NormalizeQueryIds bool // hash the normalized SQL, so formatting and comment changes don't change the query ids
+	StrictPolicies bool // error if a client query has no access policy
+	ServerVars map[string][]string // server variable namespaces in addition to SESSION and ENV, and their declared keys (nil allows any key)
+	PreviousBuild string // the output directory of the previous release, the whitelists are diffed against it
+	AllowMadePublic bool // don't fail the diff when a private query becomes public
+	ServerOnly []string // globs of the modules that can only be used by the client through server calls, like "use server"
+	ClientOnly []string // globs of the modules that must not be in the server bundle, like "use client"
+	Verbose bool // log the compiler's tracing, at the debug log level
+	Production bool // leave the query text out of the client bundle and its source map, the queries are only identified by their ids
+	Report bool // write flowstate-report.json, the graph of the queries and the code that can execute them
+	Lint map[string]LintSeverity // the severity of each lint rule by id, the rules not in the map have their default severity
+	Watch bool // rebuild when the source files change, until BuildResult.Stop is called
+	Incremental bool // keep the parsed files between builds and return BuildResult.Rebuild, which is how tools embedding the compiler rebuild without a watcher
 	NoSummary bool
 	FS fs.FS
 }
 
+// SQLDialect selects the grammar the compiled queries are checked against
+type SQLDialect uint8
+
+const (
+	SQLDialectPostgres SQLDialect = iota
+	SQLDialectNone // don't check the query syntax
+)
+
+var reServerVarNamespace = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
 type OnloadOptionsCallback func(opts* BuildOptions, conf map[string]interface{}, server bool) error
 
 func NewSQLJoyOptions(jsonOpts []byte, onLoadOptions OnloadOptionsCallback, cmd string) (*SQLJoyOptions, error) {
@@ -41,11 +69,27 @@ func (opts *SQLJoyOptions) UnmarshalJSON(jsonOpts []byte, onLoadOptions OnloadOp
 		Client map[string]interface{} `json:"client"`
 		Server map[string]interface{} `json:"server"`
 		Watch bool `json:"watch"`
+		Verbose bool `json:"verbose"`
+		Production bool `json:"production"`
+		Report bool `json:"report"`
+		Lint map[string]string `json:"lint"`
 		Color      string `json:"color"`
 		ErrorLimit int `json:"errorLimit"`
 		LogLevel   string `json:"logLevel"`
 		AccountId string `json:"accountId"`
 		AccountSecret string `json:"accountSecret"`
+		DeployEndpoint string `json:"deployEndpoint"`
+		SQLDialect string `json:"sqlDialect"`
+		Schema string `json:"schema"`
+		SchemaWarnings bool `json:"schemaWarnings"`
+		TypeScript bool `json:"typescript"`
+		NormalizeQueryIds bool `json:"normalizeQueryIds"`
+		ServerVars map[string][]string `json:"serverVars"`
+		StrictPolicies bool `json:"strictPolicies"`
+		PreviousBuild string `json:"previousBuild"`
+		AllowMadePublic bool `json:"allowMadePublic"`
+		ServerOnly []string `json:"serverOnly"`
+		ClientOnly []string `json:"clientOnly"`
 		Env map[string]json.RawMessage `json:"environment"`
 	}{}
 
@@ -70,11 +114,52 @@ func (opts *SQLJoyOptions) UnmarshalJSON(jsonOpts []byte, onLoadOptions OnloadOp
 	opts.Server.Define = env
 
 	opts.Watch = data.Watch
+	opts.Verbose = data.Verbose
+	opts.Production = data.Production
+	opts.Report = data.Report
+	opts.AccountId = data.AccountId
 	opts.AccountSecret = data.AccountSecret
+	opts.DeployEndpoint = data.DeployEndpoint
+	opts.Schema = data.Schema
+	opts.SchemaWarnings = data.SchemaWarnings
+	opts.TypeScript = data.TypeScript
+	opts.NormalizeQueryIds = data.NormalizeQueryIds
+	opts.ServerVars = data.ServerVars
+	opts.StrictPolicies = data.StrictPolicies
+
+	for namespace := range opts.ServerVars {
+		if !reServerVarNamespace.MatchString(namespace) {
+			return fmt.Errorf("Invalid serverVars namespace: %q", namespace)
+		}
+	}
+	opts.PreviousBuild = data.PreviousBuild
+	opts.AllowMadePublic = data.AllowMadePublic
+	opts.ServerOnly = data.ServerOnly
+	opts.ClientOnly = data.ClientOnly
+
+	if data.Lint != nil {
+		opts.Lint = make(map[string]LintSeverity, len(data.Lint))
+		for id, severity := range data.Lint {
+			if opts.Lint[id], err = parseLintSeverity(id, severity); err != nil {
+				return err
+			}
+		}
+	}
+
+	switch data.SQLDialect {
+	case "", "postgres":
+		opts.SQLDialect = SQLDialectPostgres
+	case "none":
+		opts.SQLDialect = SQLDialectNone
+	default:
+		return fmt.Errorf("Invalid sqlDialect: %q (valid: postgres, none)", data.SQLDialect)
+	}
 
 	var logLevel LogLevel
 	switch data.LogLevel {
 	case "":
+	case "debug":
+		logLevel = LogLevelDebug
 	case "info":
 		logLevel = LogLevelInfo
 	case "warning":
@@ -84,7 +169,7 @@ func (opts *SQLJoyOptions) UnmarshalJSON(jsonOpts []byte, onLoadOptions OnloadOp
 	case "silent":
 		logLevel = LogLevelSilent
 	default:
-		return fmt.Errorf("Invalid log level: %q (valid: info, warning, error, silent)", data.LogLevel)
+		return fmt.Errorf("Invalid log level: %q (valid: debug, info, warning, error, silent)", data.LogLevel)
 	}
 
 	// Setup client/server specific default options
@@ -223,6 +308,22 @@ func unmarshalBuildOpts(opts *BuildOptions, conf map[string]interface{}, server
 		return fmt.Errorf("invalid type %T for write", conf["write"])
 	}
 
+	// Linked source maps by default, so stack traces from production can be mapped back to the source
+	switch conf["sourcemap"] {
+	case nil, true, "linked":
+		opts.Sourcemap = SourceMapLinked
+	case false:
+		opts.Sourcemap = SourceMapNone
+	case "inline":
+		opts.Sourcemap = SourceMapInline
+	case "external":
+		opts.Sourcemap = SourceMapExternal
+	case "both":
+		opts.Sourcemap = SourceMapInlineAndExternal
+	default:
+		return fmt.Errorf("invalid argument %v for sourcemap (valid: true, false, linked, inline, external, both)", conf["sourcemap"])
+	}
+
 	switch conf["format"] {
 	case "cjs":
 		return errors.New("CommonJS output format is not supported")
@@ -4,11 +4,15 @@ import (
 	"crypto/sha256"
 	"encoding/base64"
 	"errors"
+	"fmt"
 	"regexp"
 	"strings"
 
 	"github.com/evanw/esbuild/internal/js_ast"
+	"github.com/evanw/esbuild/internal/js_lexer"
 	"github.com/evanw/esbuild/internal/logger"
+	"github.com/evanw/esbuild/internal/sql_ast"
+	"github.com/evanw/esbuild/internal/sql_parser"
 )
 
 type queryType uint8
@@ -72,8 +76,10 @@ const (
 
 var reQueryType = regexp.MustCompile(`(?ms)(?:\s*(?:--.*?$|/*.*?\*/))*\s*(\w+)`)
 var rePercentParams = regexp.MustCompile(`%\{([a-zA-Z0-9_.-]+?)\}`)
+var reDollarQuoteTag = regexp.MustCompile(`^\$([A-Za-z_][A-Za-z0-9_]*)?\$`)
 
-var serverVars = []string{"SESSION.","ENV."}
+// defaultServerVars are the server variable namespaces if opts.ServerVars isn't set, with any keys allowed
+var defaultServerVars = map[string][]string{"SESSION": nil, "ENV": nil}
 
 func getQueryType(query string) queryType {
 	submatches := reQueryType.FindStringSubmatch(query)
@@ -105,6 +111,8 @@ type query struct {
 	QueryText string     `json:"query"`
 	Type      queryType  `json:"type,omitempty"`
 	IsPublic  bool       `json:"isPublic,omitempty"`
+	Policy    *queryPolicy `json:"policy,omitempty"` // from the -- @roles annotation, see parseAnnotations
+	Limits    *queryLimits `json:"limits,omitempty"` // from the -- @maxRows, @timeout and @rate annotations
 	isFragment bool
 	// ServerReferences and ClientReferences are only tracked if isFragment=false
 	ServerReferences uint16 `json:"serverReferences,omitempty"`
@@ -114,10 +122,20 @@ type query struct {
 	DefinedAt        sourceLocation `json:"definedAt"`
 	Usages           []sourceLocation `json:"usages,omitempty"`
 	Params           []string   `json:"params,omitempty"` // to assist with reading whitelist file only
+	ServerVars       []string   `json:"serverVars,omitempty"` // the server vars the query needs, including those of its fragments
 	Fragments        [][]*query  `json:"fragments,omitempty"`
+	Slots            [][]string `json:"slots,omitempty"` // the ids of the fragments allowed in each %{} slot
+	Combinations     map[string][]string `json:"combinations,omitempty"` // see buildFragmentTables
 	parts            []string
 	vars             []queryVar
 	binHash          [sha256.Size]byte
+	ast              *sql_ast.AST // nil until checkSyntax parses QueryText, or if it's invalid
+	syntaxChecked    bool
+	annotationsParsed bool
+	fragmentParams   []int // the $n params in QueryText bound to sql.p() calls
+	params           []queryParam // the $n params in QueryText, in order
+	result           *relation // the columns of the result rows, nil if there's no schema
+	paramTypes       map[int]dbColumn // the columns the $n params are used with, if there's a schema
 }
 
 // queriesByWhitelistOrder sort queries by (isPublic, type, fileName, line)
@@ -139,6 +157,12 @@ func (a queriesByWhitelistOrder) Less(i, j int) bool {
 	}
 }
 
+// queryParam is a $n param in the compiled query, named after its key in the params object
+type queryParam struct {
+	name      string
+	lateBound bool // a %{name} param, passed to executeQuery
+}
+
 type queryVar struct {
 	ref       js_ast.Ref
 	ty        queryVarType
@@ -147,6 +171,9 @@ type queryVar struct {
 	expr      *js_ast.Expr
 }
 
+// newQuery splits the template into parts and vars. A %{NAMESPACE.key} placeholder is a server var,
+// the namespace and key are checked against serverVars by replaceQuery. A typo in the namespace must not
+// turn it into a param supplied by the client.
 func newQuery(qp queryPart) (*query, error) {
 	parts := make([]string, 0, len(qp.template.Parts)+1)
 	vars := make([]queryVar, 0, len(qp.template.Parts))
@@ -161,11 +188,8 @@ func newQuery(qp queryPart) (*query, error) {
 			parts = append(parts, head)
 			varName := s[start+2:end-1]
 			ty := queryVarTypeParam
-			for _, serverVar := range serverVars {
-				if strings.HasPrefix(varName, serverVar) {
-					ty = queryVarTypeServer
-					break
-				}
+			if strings.IndexByte(varName, '.') > 0 {
+				ty = queryVarTypeServer
 			}
 			vars = append(vars, queryVar{ref: js_ast.InvalidRef, name: varName, ty: ty})
 			i = end
@@ -260,36 +284,164 @@ Outer:
 			q.IsPublic = false
 			h.Write([]byte(v.name))
 		}
-		h.Write([]byte(q.parts[i]))
+		h.Write(c.hashedText(q.parts[i]))
 	}
-	h.Write([]byte(q.parts[len(q.parts)-1]))
+	h.Write(c.hashedText(q.parts[len(q.parts)-1]))
 	sum := h.Sum(q.binHash[:0])
 
 	q.Hash = base64.RawURLEncoding.EncodeToString(sum[:30])
 	return true
 }
 
-func (q *query) insert(index int, fragment *query) {
-	// Insert vars from fragment, replacing q.vars[index] with fragment.vars
-	// Note if fragment.vars is empty, we're removing it and reducing len(vars) by 1.
-	// Replacing a var means the parts before and after the var should be combined.
-	// Additionally we're adding N vars, and N+1 parts, which would mean we go from M-1 vars and M parts
-	// to N+M-1 vars and N+1+M parts, which now means there's two more parts than vara, breaking the invariant.
-	// So we also have to append N.parts[0] to M.parts[index].
-	q.parts[index] += fragment.parts[0] + q.parts[index+1]
-
-	tVars := q.vars[index+1:]
-	tParts := q.parts[index+2:]
-
-	if len(fragment.vars) != 0 {
-		q.vars = append(q.vars[:index], fragment.vars...)
-	} else {
-		// Concatenate parts[index] with parts[index+1]
-		q.vars = q.vars[:index]
+// hashedText returns the part of the query text that's included in the query hash.
+// With opts.NormalizeQueryIds it's normalized, so that reformatting a query doesn't change its id.
+func (c *FlowStateCompiler) hashedText(part string) []byte {
+	if c.opts.NormalizeQueryIds {
+		return []byte(normalizeSQL(part))
+	}
+	return []byte(part)
+}
+
+// normalizeSQL strips the comments other than annotations from the SQL text, replaces runs of whitespace with a single space
+// and lower cases everything outside of string literals and quoted identifiers. Unquoted identifiers
+// are case-insensitive in SQL, so this doesn't change the meaning of the query.
+// The text may be a part of a query between two vars, so leading and trailing whitespace is dropped.
+func normalizeSQL(text string) string {
+	var sb strings.Builder
+	sb.Grow(len(text))
+	space := false
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f':
+			space = true
+			i++
+			continue
+
+		case c == '-' && strings.HasPrefix(text[i:], "--"):
+			start := i
+			for i < len(text) && text[i] != '\n' {
+				i++
+			}
+			// The annotations set the query's access policy and limits, so they're part of its id
+			if match := reAnnotation.FindStringSubmatch(strings.TrimSpace(text[start:i])); match != nil {
+				if sb.Len() != 0 {
+					sb.WriteByte(' ')
+				}
+				sb.WriteString("--@" + match[1])
+				for _, field := range strings.Fields(match[2]) {
+					sb.WriteString(" " + field)
+				}
+				sb.WriteByte('\n')
+			}
+			space = true
+			continue
+
+		case c == '/' && strings.HasPrefix(text[i:], "/*"):
+			// Block comments nest in Postgres
+			depth := 0
+			for i < len(text) {
+				if strings.HasPrefix(text[i:], "/*") {
+					depth++
+					i += 2
+				} else if strings.HasPrefix(text[i:], "*/") {
+					depth--
+					i += 2
+					if depth == 0 {
+						break
+					}
+				} else {
+					i++
+				}
+			}
+			space = true
+			continue
+		}
+
+		if space && sb.Len() != 0 {
+			sb.WriteByte(' ')
+		}
+		space = false
+
+		start := i
+		switch {
+		case c == '\'' || c == '"':
+			// Strings and quoted identifiers, the quote is escaped by doubling it.
+			// E'' strings also allow backslash escapes, the E must be a token of its own, not the end of a keyword like LIKE.
+			backslashes := c == '\'' && i > 0 && (text[i-1] == 'e' || text[i-1] == 'E') && (i < 2 || !isSQLIdentifierChar(text[i-2]))
+			i++
+			for i < len(text) {
+				if backslashes && text[i] == '\\' {
+					i += 2
+				} else if text[i] == c {
+					i++
+					if i == len(text) || text[i] != c {
+						break
+					}
+					i++
+				} else {
+					i++
+				}
+			}
+			if i > len(text) {
+				i = len(text)
+			}
+			sb.WriteString(text[start:i])
+
+		case c == '$' && reDollarQuoteTag.MatchString(text[i:]):
+			tag := reDollarQuoteTag.FindString(text[i:])
+			end := strings.Index(text[i+len(tag):], tag)
+			if end < 0 {
+				i = len(text)
+			} else {
+				i += len(tag) + end + len(tag)
+			}
+			sb.WriteString(text[start:i])
+
+		default:
+			if c >= 'A' && c <= 'Z' {
+				c += 'a' - 'A'
+			}
+			sb.WriteByte(c)
+			i++
+		}
 	}
-	q.vars = append(q.vars, tVars...)
-	q.parts = append(q.parts[:index+1], fragment.parts[1:]...)
-	q.parts = append(q.parts, tParts...)
+	return sb.String()
+}
+
+func isSQLIdentifierChar(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '_' || c == '$' || c >= 0x80
+}
+
+func (q *query) addServerVars(names ...string) {
+	for _, name := range names {
+		if !containsString(q.ServerVars, name) {
+			q.ServerVars = append(q.ServerVars, name)
+		}
+	}
+}
+
+func (q *query) insert(index int, fragment *query) {
+	// Replace q.vars[index] with fragment.vars, and the var's place in the text with fragment.parts.
+	// The first and last parts of the fragment are joined to the query parts on either side of the var,
+	// so inserting N vars adds N parts, which keeps the invariant that there's one more part than vars.
+	// Note if fragment.vars is empty, we're removing the var and joining the parts on either side of it.
+	// The slices are copied because fragment.parts may be inlined into other queries.
+	vars := make([]queryVar, 0, len(q.vars)-1+len(fragment.vars))
+	vars = append(vars, q.vars[:index]...)
+	vars = append(vars, fragment.vars...)
+	vars = append(vars, q.vars[index+1:]...)
+
+	inserted := append([]string{}, fragment.parts...)
+	inserted[0] = q.parts[index] + inserted[0]
+	inserted[len(inserted)-1] += q.parts[index+1]
+	parts := make([]string, 0, len(q.parts)+len(fragment.vars))
+	parts = append(parts, q.parts[:index]...)
+	parts = append(parts, inserted...)
+	parts = append(parts, q.parts[index+2:]...)
+
+	q.vars = vars
+	q.parts = parts
 
 	if !fragment.IsPublic {
 		q.IsPublic = false
@@ -300,6 +452,162 @@ func (q *query) insert(index int, fragment *query) {
 	}
 }
 
+// checkSyntax parses the compiled query text, reporting any syntax errors at the query template.
+// It returns true the first time it's called for a valid query, which is then kept in q.ast.
+func (q *query) checkSyntax(c *FlowStateCompiler) bool {
+	if q.syntaxChecked || q.isFragment || c.opts.SQLDialect == SQLDialectNone {
+		return false // fragments are only checked as part of the queries that use them
+	}
+	q.syntaxChecked = true
+
+	log := logger.NewDeferLog()
+	source := q.compiledSource()
+	ast := sql_parser.Parse(log, source, sql_parser.Options{FragmentParams: q.fragmentParams})
+
+	msgs := log.Done()
+	for _, msg := range msgs {
+		c.log.AddErrorWithNotes(q.definedSource, q.parent.Loc, "invalid SQL: "+msg.Data.Text, []logger.MsgData{
+			{Text: "The compiled query is invalid here", Location: msg.Data.Location},
+		})
+	}
+	if len(msgs) != 0 {
+		return false
+	}
+	q.ast = &ast
+	return true
+}
+
+// checkLateBoundParams reports the %{name} params of the query that are missing from the params
+// object passed to executeQuery, when the types are generated (opts.TypeScript). The params of fragments chosen at runtime are optional, and a params
+// argument that isn't an object literal, or has a spread or computed key, can't be checked.
+func (q *query) checkLateBoundParams(c *FlowStateCompiler, source *logger.Source, call *js_ast.ECall) {
+	passed := map[string]bool{}
+	loc := call.Target.Loc
+	if len(call.Args) > 1 {
+		params, ok := call.Args[1].Data.(*js_ast.EObject)
+		if !ok {
+			return
+		}
+		loc = call.Args[1].Loc
+		for _, prop := range params.Properties {
+			key, ok := prop.Key.Data.(*js_ast.EString)
+			if prop.Kind == js_ast.PropertySpread || prop.IsComputed || !ok {
+				return
+			}
+			passed[js_lexer.UTF16ToString(key.Value)] = true
+		}
+	}
+
+	for _, param := range q.params {
+		if param.lateBound && !passed[param.name] {
+			passed[param.name] = true // only report each name once
+			c.log.AddError(source, loc, fmt.Sprintf("missing param %q for %%{%s} in the query passed to executeQuery", param.name, param.name))
+		}
+	}
+}
+
+// compiledSource is the compiled query text as a source, for the locations in notes
+func (q *query) compiledSource() logger.Source {
+	return logger.Source{
+		KeyPath:    logger.Path{Text: "<query>"},
+		PrettyPath: "<compiled query>",
+		Contents:   q.QueryText,
+	}
+}
+
+// The most fragment combinations that are precomputed for a query, beyond this
+// the server has to check the fragments in each slot with the Slots table.
+const maxFragmentCombinations = 4096
+
+// buildFragmentTables fills in the tables the server uses to check the fragments passed to
+// sql.merge() for a query. Slots lists the fragments allowed in each "%{}" slot of the query.
+// Combinations maps the id of every full combination of fragments to the fragment ids, in the
+// order they're merged: depth first, so a fragment is followed by the fragments in its own slots.
+// The id of a combination is the first 30 bytes of the SHA-256 of the query's hash followed by
+// the fragment hashes, base64url encoded like the query ids.
+func (q *query) buildFragmentTables(c *FlowStateCompiler) {
+	if len(q.Fragments) == 0 || q.Slots != nil {
+		return
+	}
+
+	q.Slots = make([][]string, len(q.Fragments))
+	for i, group := range q.Fragments {
+		for _, fragment := range uniqueFragments(group) {
+			q.Slots[i] = append(q.Slots[i], fragment.Hash)
+			fragment.buildFragmentTables(c)
+		}
+	}
+
+	if q.isFragment {
+		return // the combinations of nested fragments are included in the query's combinations
+	}
+
+	combinations, ok := fragmentCombinations(q.Fragments, maxFragmentCombinations)
+	if !ok {
+		c.log.AddWarning(q.definedSource, q.parent.Loc, fmt.Sprintf(
+			"query has more than %d combinations of fragments, so they aren't listed in the whitelist: the server only checks the fragments allowed in each slot",
+			maxFragmentCombinations))
+		return
+	}
+	q.Combinations = make(map[string][]string, len(combinations))
+	for _, combination := range combinations {
+		h := sha256.New()
+		h.Write(q.binHash[:])
+		ids := make([]string, len(combination))
+		for i, fragment := range combination {
+			h.Write(fragment.binHash[:])
+			ids[i] = fragment.Hash
+		}
+		var sum [sha256.Size]byte
+		q.Combinations[base64.RawURLEncoding.EncodeToString(h.Sum(sum[:0])[:30])] = ids
+	}
+}
+
+// fragmentCombinations returns every choice of one fragment for each slot, expanded with the
+// choices for the slots of the chosen fragments. It returns false if there are more than limit.
+func fragmentCombinations(slots [][]*query, limit int) ([][]*query, bool) {
+	combinations := [][]*query{nil}
+	for _, group := range slots {
+		var choices [][]*query
+		for _, fragment := range uniqueFragments(group) {
+			nested, ok := fragmentCombinations(fragment.Fragments, limit)
+			if !ok {
+				return nil, false
+			}
+			for _, n := range nested {
+				choices = append(choices, append([]*query{fragment}, n...))
+			}
+		}
+
+		if len(combinations)*len(choices) > limit {
+			return nil, false
+		}
+		next := make([][]*query, 0, len(combinations)*len(choices))
+		for _, prefix := range combinations {
+			for _, choice := range choices {
+				combination := make([]*query, 0, len(prefix)+len(choice))
+				combination = append(combination, prefix...)
+				next = append(next, append(combination, choice...))
+			}
+		}
+		combinations = next
+	}
+	return combinations, true
+}
+
+// uniqueFragments removes the fragments with the same hash as an earlier fragment in the group
+func uniqueFragments(group []*query) []*query {
+	seen := map[string]bool{}
+	unique := make([]*query, 0, len(group))
+	for _, fragment := range group {
+		if !seen[fragment.Hash] {
+			seen[fragment.Hash] = true
+			unique = append(unique, fragment)
+		}
+	}
+	return unique
+}
+
 func (q *query) isReachable() bool {
 	return q.ClientReferences != 0 || q.ServerReferences != 0
 }
@@ -315,3 +623,12 @@ func (a queriesByType) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
 func (a queriesByType) Less(i, j int) bool {
 	return a[i].Type < a[j].Type || (a[i].Type == a[j].Type && a[i].Hash < a[j].Hash)
 }
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
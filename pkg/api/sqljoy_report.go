@@ -0,0 +1,288 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+
+	"github.com/evanw/esbuild/internal/js_ast"
+	"github.com/evanw/esbuild/internal/logger"
+)
+
+// flowStateReport is the query usage graph written to flowstate-report.json when opts.Report is set.
+// It lists who can run each query: the client code and server functions that execute it, the validators
+// checking it, and the queries its fragments are composed into, so it can be audited without the source.
+type flowStateReport struct {
+	Queries         []*reportQuery    `json:"queries"`
+	Fragments       []*reportFragment `json:"fragments"`
+	ServerFunctions []*reportFunction `json:"serverFunctions"`
+	Validators      []*reportFunction `json:"validators"`
+	Modules         []*reportModule   `json:"modules"`
+}
+
+type reportQuery struct {
+	Id         string            `json:"id"`
+	Type       queryType         `json:"type,omitempty"`
+	IsPublic   bool              `json:"isPublic"`
+	Policy     *queryPolicy      `json:"policy,omitempty"`
+	Whitelist  string            `json:"whitelist"` // client or server
+	DefinedAt  sourceLocation    `json:"definedAt"`
+	ExecutedBy []reportExecution `json:"executedBy"`
+	Fragments  [][]string        `json:"fragments,omitempty"`  // the ids of the fragments that can fill each %{} slot
+	Validators []string          `json:"validators,omitempty"` // the ids of the validators passed to executeQuery
+}
+
+// reportExecution is an executeQuery call, on the client or in a server function
+type reportExecution struct {
+	sourceLocation
+	ServerFunction string `json:"serverFunction,omitempty"` // the name of the server function, empty on the client
+}
+
+type reportFragment struct {
+	Id        string         `json:"id"`
+	DefinedAt sourceLocation `json:"definedAt"`
+	UsedBy    []string       `json:"usedBy"` // the ids of the queries composed from this fragment
+}
+
+// reportFunction is a server function or a validator
+type reportFunction struct {
+	Name      string         `json:"name"`
+	DefinedAt sourceLocation `json:"definedAt"`
+	Ids       []string       `json:"ids,omitempty"` // the hashes the client calls the function by
+	Queries   []string       `json:"queries"`       // the ids of the queries it executes, or validates
+}
+
+type reportModule struct {
+	File            string   `json:"fileName"`
+	Queries         []string `json:"queries,omitempty"`
+	Fragments       []string `json:"fragments,omitempty"`
+	ServerFunctions []string `json:"serverFunctions,omitempty"`
+	Validators      []string `json:"validators,omitempty"`
+}
+
+// declaration identifies a function by where it's declared, which is how the server calls and
+// the executeQuery calls in server functions are matched up
+type declaration struct {
+	source *logger.Source
+	loc    logger.Loc
+}
+
+func newSourceLocation(source *logger.Source, loc logger.Loc) sourceLocation {
+	var result sourceLocation
+	if location := logger.LocationOrNil(source, logger.Range{Loc: loc}); location != nil {
+		result.Line = uint32(location.Line)
+		result.File = location.File
+	}
+	return result
+}
+
+// outputReport writes flowstate-report.json from the compiled queries, the server functions called
+// by the client and the validators, as returned by generateServerFile and collected by generateOutputs
+func (c *FlowStateCompiler) outputReport(allQueries map[js_ast.Ref]queriesByWhitelistOrder, calls, validators map[string]importsByName, validatorsByQuery map[string][]string) {
+	report := flowStateReport{
+		Queries:         []*reportQuery{},
+		Fragments:       []*reportFragment{},
+		ServerFunctions: []*reportFunction{},
+		Validators:      []*reportFunction{},
+		Modules:         []*reportModule{},
+	}
+
+	// The declared names of the server functions, which may be exported under another name
+	names := map[declaration]string{}
+	for _, analyzer := range c.analyzers {
+		if analyzer == nil {
+			continue
+		}
+		for ref, f := range analyzer.serverFunctions {
+			names[declaration{&analyzer.file.Source, f.loc}] = analyzer.ast.Symbols[ref.InnerIndex].OriginalName
+		}
+		for ref, methods := range analyzer.serverMethods {
+			for method, f := range methods {
+				names[declaration{&analyzer.file.Source, f.loc}] = analyzer.ast.Symbols[ref.InnerIndex].OriginalName + "." + method
+			}
+		}
+	}
+
+	functions := map[declaration]*reportFunction{}
+	addFunction := func(functions map[declaration]*reportFunction, decl declaration, name string) *reportFunction {
+		f := functions[decl]
+		if f == nil {
+			if declared, ok := names[decl]; ok {
+				name = declared
+			}
+			f = &reportFunction{Name: name, DefinedAt: newSourceLocation(decl.source, decl.loc), Queries: []string{}}
+			functions[decl] = f
+		}
+		return f
+	}
+	for _, imports := range calls {
+		for _, imp := range imports {
+			f := addFunction(functions, declaration{imp.source, imp.loc}, imp.name+propSuffix(imp.method))
+			f.Ids = appendUnique(f.Ids, imp.hash)
+		}
+	}
+
+	validatorFunctions := map[declaration]*reportFunction{}
+	validatorsByHash := map[string]*reportFunction{}
+	for _, imports := range validators {
+		for _, imp := range imports {
+			f := addFunction(validatorFunctions, declaration{imp.source, imp.loc}, imp.name+propSuffix(imp.method))
+			f.Ids = appendUnique(f.Ids, imp.hash)
+			validatorsByHash[imp.hash] = f
+		}
+	}
+
+	fragments := map[*query]*reportFragment{}
+	for _, queries := range allQueries {
+		for _, q := range queries {
+			if q.isFragment || q.Hash == "" {
+				continue // fragments are added by the queries they're composed into, and the queries without a hash were never executed
+			}
+
+			rq := &reportQuery{
+				Id:         q.Hash,
+				Type:       q.Type,
+				IsPublic:   q.IsPublic,
+				Policy:     q.Policy,
+				Whitelist:  "server",
+				DefinedAt:  q.DefinedAt,
+				ExecutedBy: []reportExecution{},
+			}
+			if q.ClientReferences != 0 {
+				rq.Whitelist = "client"
+			}
+
+			for _, call := range q.calls {
+				source := &c.files[call.sourceIndex].Source
+				execution := reportExecution{sourceLocation: newSourceLocation(source, call.call.Target.Loc)}
+				if call.fn != nil {
+					f := addFunction(functions, declaration{source, call.fn.loc}, "<anonymous>")
+					f.Queries = appendUnique(f.Queries, q.Hash)
+					execution.ServerFunction = f.Name
+				}
+				rq.ExecutedBy = append(rq.ExecutedBy, execution)
+			}
+
+			for _, hash := range validatorsByQuery[q.Hash] {
+				rq.Validators = appendUnique(rq.Validators, hash)
+				if f := validatorsByHash[hash]; f != nil {
+					f.Queries = appendUnique(f.Queries, q.Hash)
+				}
+			}
+
+			for _, slot := range q.Fragments {
+				ids := []string{}
+				for _, fragment := range slot {
+					ids = append(ids, fragment.Hash)
+					rf := fragments[fragment]
+					if rf == nil {
+						rf = &reportFragment{Id: fragment.Hash, DefinedAt: fragment.DefinedAt, UsedBy: []string{}}
+						fragments[fragment] = rf
+					}
+					rf.UsedBy = appendUnique(rf.UsedBy, q.Hash)
+				}
+				rq.Fragments = append(rq.Fragments, ids)
+			}
+
+			report.Queries = append(report.Queries, rq)
+		}
+	}
+
+	modules := map[string]*reportModule{}
+	module := func(file string) *reportModule {
+		m := modules[file]
+		if m == nil {
+			m = &reportModule{File: file}
+			modules[file] = m
+			report.Modules = append(report.Modules, m)
+		}
+		return m
+	}
+	for _, q := range report.Queries {
+		m := module(q.DefinedAt.File)
+		m.Queries = appendUnique(m.Queries, q.Id)
+	}
+	for _, f := range fragments {
+		report.Fragments = append(report.Fragments, f)
+		m := module(f.DefinedAt.File)
+		m.Fragments = appendUnique(m.Fragments, f.Id)
+	}
+	for _, f := range functions {
+		report.ServerFunctions = append(report.ServerFunctions, f)
+		m := module(f.DefinedAt.File)
+		m.ServerFunctions = appendUnique(m.ServerFunctions, f.Name)
+	}
+	for _, f := range validatorFunctions {
+		report.Validators = append(report.Validators, f)
+		m := module(f.DefinedAt.File)
+		m.Validators = appendUnique(m.Validators, f.Name)
+	}
+	report.sort()
+
+	c.reportFile.Path = path.Join(c.outDir, "flowstate-report.json")
+	contents, err := json.MarshalIndent(&report, "", "\t")
+	if err != nil {
+		c.log.AddError(nil, logger.Loc{}, fmt.Sprintf("json.Marshal flowstate-report.json: %v", err.Error()))
+		return
+	}
+	c.reportFile.Contents = contents
+	if err := c.fs.WriteFile(c.reportFile.Path, contents, 0644); err != nil {
+		c.log.AddError(nil, logger.Loc{}, fmt.Sprintf("write report flowstate-report.json: %v", err))
+	}
+}
+
+// sort orders everything by where it's defined, so the report only changes when the code does
+func (r *flowStateReport) sort() {
+	before := func(a, b sourceLocation, aId, bId string) bool {
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return aId < bId
+	}
+	sort.Slice(r.Queries, func(i, j int) bool {
+		return before(r.Queries[i].DefinedAt, r.Queries[j].DefinedAt, r.Queries[i].Id, r.Queries[j].Id)
+	})
+	for _, q := range r.Queries {
+		sort.Slice(q.ExecutedBy, func(i, j int) bool {
+			return before(q.ExecutedBy[i].sourceLocation, q.ExecutedBy[j].sourceLocation, q.ExecutedBy[i].ServerFunction, q.ExecutedBy[j].ServerFunction)
+		})
+		sort.Strings(q.Validators)
+	}
+	sort.Slice(r.Fragments, func(i, j int) bool {
+		return before(r.Fragments[i].DefinedAt, r.Fragments[j].DefinedAt, r.Fragments[i].Id, r.Fragments[j].Id)
+	})
+	for _, f := range r.Fragments {
+		sort.Strings(f.UsedBy)
+	}
+	for _, functions := range [][]*reportFunction{r.ServerFunctions, r.Validators} {
+		sort.Slice(functions, func(i, j int) bool {
+			return before(functions[i].DefinedAt, functions[j].DefinedAt, functions[i].Name, functions[j].Name)
+		})
+		for _, f := range functions {
+			sort.Strings(f.Ids)
+			sort.Strings(f.Queries)
+		}
+	}
+	sort.Slice(r.Modules, func(i, j int) bool {
+		return r.Modules[i].File < r.Modules[j].File
+	})
+	for _, m := range r.Modules {
+		sort.Strings(m.Queries)
+		sort.Strings(m.Fragments)
+		sort.Strings(m.ServerFunctions)
+		sort.Strings(m.Validators)
+	}
+}
+
+func appendUnique(list []string, value string) []string {
+	for _, v := range list {
+		if v == value {
+			return list
+		}
+	}
+	return append(list, value)
+}
@@ -0,0 +1,957 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/evanw/esbuild/internal/logger"
+	"github.com/evanw/esbuild/internal/sql_ast"
+	"github.com/evanw/esbuild/internal/sql_lexer"
+	"github.com/evanw/esbuild/internal/sql_parser"
+)
+
+// The database schema is loaded from the file named by the "schema" config
+// setting, which is either SQL DDL (CREATE TABLE, ALTER TABLE, CREATE VIEW
+// and DROP statements, applied in order) or JSON in this format:
+//
+//   {"tables": [{"name": "users", "columns": [{"name": "id", "type": "integer", "notNull": true}]}]}
+//
+// A table name can be qualified with its schema ("app.users"), otherwise it's
+// in "public". Names in the JSON format are used as-is, while unquoted names
+// in the SQL format are folded to lower case like PostgreSQL does.
+
+type dbSchema struct {
+	tables map[string][]*dbTable // by table name, since a name can be used in more than one schema
+}
+
+type dbTable struct {
+	schema  string
+	name    string
+	columns []dbColumn
+}
+
+type dbColumn struct {
+	name    string
+	typ     sql_ast.Type // Name is empty if the type isn't known
+	notNull bool
+}
+
+const defaultSchema = "public"
+
+func newDBSchema() *dbSchema {
+	return &dbSchema{tables: map[string][]*dbTable{}}
+}
+
+// lookup finds a table by name. An unqualified name is looked up in "public"
+// first, and otherwise must be unique across the schemas.
+func (s *dbSchema) lookup(schema, name string) *dbTable {
+	tables := s.tables[name]
+	if schema == "" {
+		for _, t := range tables {
+			if t.schema == defaultSchema {
+				return t
+			}
+		}
+		if len(tables) == 1 {
+			return tables[0]
+		}
+		return nil
+	}
+	for _, t := range tables {
+		if t.schema == schema {
+			return t
+		}
+	}
+	return nil
+}
+
+func (s *dbSchema) add(table *dbTable) {
+	s.remove(table.schema, table.name)
+	s.tables[table.name] = append(s.tables[table.name], table)
+}
+
+func (s *dbSchema) remove(schema, name string) {
+	tables := s.tables[name]
+	for i, t := range tables {
+		if t.schema == schema {
+			s.tables[name] = append(tables[:i:i], tables[i+1:]...)
+			return
+		}
+	}
+}
+
+func (t *dbTable) column(name string) *dbColumn {
+	for i := range t.columns {
+		if t.columns[i].name == name {
+			return &t.columns[i]
+		}
+	}
+	return nil
+}
+
+// loadSchema reads the schema file from the config, if there is one. Problems
+// with the file are logged and result in a nil schema.
+func (c *FlowStateCompiler) loadSchema() *dbSchema {
+	if c.opts.Schema == "" || c.opts.SQLDialect == SQLDialectNone {
+		return nil
+	}
+
+	path := c.opts.Schema
+	if !c.fs.IsAbs(path) {
+		path = c.fs.Join(c.fs.Cwd(), path)
+	}
+	contents, err, _ := c.fs.ReadFile(path)
+	if err != nil {
+		c.log.AddError(nil, logger.Loc{}, fmt.Sprintf("Could not read schema file %q: %s", c.opts.Schema, err.Error()))
+		return nil
+	}
+
+	prettyPath := path
+	if rel, ok := c.fs.Rel(c.fs.Cwd(), path); ok {
+		prettyPath = rel
+	}
+	source := logger.Source{
+		KeyPath:    logger.Path{Text: path, Namespace: "file"},
+		PrettyPath: prettyPath,
+		Contents:   contents,
+	}
+
+	if strings.HasSuffix(path, ".json") {
+		schema, err := parseJSONSchema(contents)
+		if err != nil {
+			c.log.AddError(&source, logger.Loc{}, fmt.Sprintf("Invalid schema file: %s", err.Error()))
+			return nil
+		}
+		return schema
+	}
+
+	hasErrors := c.log.HasErrors()
+	ast := sql_parser.Parse(c.log, source, sql_parser.Options{})
+	if !hasErrors && c.log.HasErrors() {
+		return nil
+	}
+	return schemaFromDDL(ast)
+}
+
+func parseJSONSchema(contents string) (*dbSchema, error) {
+	data := struct {
+		Tables []struct {
+			Schema  string `json:"schema"`
+			Name    string `json:"name"`
+			Columns []struct {
+				Name    string `json:"name"`
+				Type    string `json:"type"`
+				NotNull bool   `json:"notNull"`
+			} `json:"columns"`
+		} `json:"tables"`
+	}{}
+	if err := json.Unmarshal([]byte(contents), &data); err != nil {
+		return nil, err
+	}
+
+	schema := newDBSchema()
+	for _, t := range data.Tables {
+		table := &dbTable{schema: t.Schema, name: t.Name}
+		if table.schema == "" {
+			if dot := strings.LastIndexByte(t.Name, '.'); dot != -1 {
+				table.schema = t.Name[:dot]
+				table.name = t.Name[dot+1:]
+			} else {
+				table.schema = defaultSchema
+			}
+		}
+		if table.name == "" {
+			return nil, fmt.Errorf("table is missing a name")
+		}
+		for _, col := range t.Columns {
+			if col.Name == "" {
+				return nil, fmt.Errorf("column in table %q is missing a name", t.Name)
+			}
+			column := dbColumn{name: col.Name, notNull: col.NotNull}
+			column.typ.Name = strings.ToLower(strings.TrimSpace(col.Type))
+			for strings.HasSuffix(column.typ.Name, "[]") {
+				column.typ.Name = strings.TrimSpace(column.typ.Name[:len(column.typ.Name)-2])
+				column.typ.Array = true
+			}
+			table.columns = append(table.columns, column)
+		}
+		schema.add(table)
+	}
+	return schema, nil
+}
+
+// schemaFromDDL applies the table and view definitions in order. Other statements are ignored.
+func schemaFromDDL(ast sql_ast.AST) *dbSchema {
+	schema := newDBSchema()
+	sc := &schemaChecker{schema: schema}
+
+	for _, stmt := range ast.Stmts {
+		switch s := stmt.Data.(type) {
+		case *sql_ast.SCreateTable:
+			table := &dbTable{schema: schemaOrDefault(s.Table.Schema), name: s.Table.Name}
+			if s.Query != nil {
+				table.columns = sc.checkQuery(s.Query, nil).columns
+			}
+			for _, col := range s.Columns {
+				table.columns = append(table.columns, dbColumn{name: col.Name.Text, typ: col.Type, notNull: col.NotNull})
+			}
+			if !s.IfNotExists || schema.lookup(table.schema, table.name) == nil {
+				schema.add(table)
+			}
+
+		case *sql_ast.SCreateView:
+			rel := sc.checkQuery(s.Query, nil).rename(s.Columns)
+			schema.add(&dbTable{schema: schemaOrDefault(s.Table.Schema), name: s.Table.Name, columns: rel.columns})
+
+		case *sql_ast.SAlterTable:
+			table := schema.lookup(schemaOrDefault(s.Table.Schema), s.Table.Name)
+			if table == nil {
+				continue
+			}
+			for _, action := range s.Actions {
+				column := table.column(action.Column.Name.Text)
+				switch action.Kind {
+				case sql_ast.AlterAddColumn:
+					if column == nil {
+						table.columns = append(table.columns, dbColumn{name: action.Column.Name.Text, typ: action.Column.Type, notNull: action.Column.NotNull})
+					}
+
+				case sql_ast.AlterDropColumn:
+					for i := range table.columns {
+						if table.columns[i].name == action.Column.Name.Text {
+							table.columns = append(table.columns[:i:i], table.columns[i+1:]...)
+							break
+						}
+					}
+
+				case sql_ast.AlterRenameColumn:
+					if column != nil {
+						column.name = action.NewName.Text
+					}
+
+				case sql_ast.AlterRenameTable:
+					schema.remove(table.schema, table.name)
+					table.name = action.NewName.Text
+					schema.add(table)
+
+				case sql_ast.AlterColumnType:
+					if column != nil {
+						column.typ = action.Column.Type
+					}
+
+				case sql_ast.AlterSetNotNull, sql_ast.AlterDropNotNull:
+					if column != nil {
+						column.notNull = action.Kind == sql_ast.AlterSetNotNull
+					}
+				}
+			}
+
+		case *sql_ast.SDropTable:
+			for _, table := range s.Tables {
+				schema.remove(schemaOrDefault(table.Schema), table.Name)
+			}
+		}
+	}
+
+	return schema
+}
+
+func schemaOrDefault(schema string) string {
+	if schema == "" {
+		return defaultSchema
+	}
+	return schema
+}
+
+// checkSchema reports the tables and columns in the query that aren't in the schema, and
+// INSERT statements with a different number of values than target columns. It also records
+// the columns of the result rows and the types of the params, which are used for the types file.
+func (q *query) checkSchema(c *FlowStateCompiler, schema *dbSchema) {
+	source := q.compiledSource()
+	kind := logger.Error
+	if c.opts.SchemaWarnings {
+		kind = logger.Warning
+	}
+	seen := map[string]bool{}
+
+	sc := &schemaChecker{
+		schema:   schema,
+		compiled: source,
+		params:   map[int]dbColumn{},
+		report: func(r logger.Range, text string) {
+			if seen[text] {
+				return
+			}
+			seen[text] = true
+			c.log.AddMsg(logger.Msg{
+				Kind: kind,
+				Data: logger.RangeData(q.definedSource, logger.Range{Loc: q.parent.Loc}, text),
+				Notes: []logger.MsgData{
+					logger.RangeData(&source, r, "The compiled query doesn't match the schema here"),
+				},
+			})
+		},
+	}
+
+	// Only the result of the last statement is returned
+	var result relation
+	for _, stmt := range q.ast.Stmts {
+		result = sc.checkStmt(stmt, nil)
+	}
+	q.result = &result
+	q.paramTypes = sc.params
+}
+
+// relation is the set of columns produced by a table, view, subquery or CTE
+type relation struct {
+	columns []dbColumn
+	known   bool // false if the columns can't be determined, e.g. because of a fragment
+}
+
+func (r relation) column(name string) *dbColumn {
+	for i := range r.columns {
+		if r.columns[i].name == name {
+			return &r.columns[i]
+		}
+	}
+	return nil
+}
+
+// rename applies a column alias list, e.g. "AS t(a, b)"
+func (r relation) rename(names []sql_ast.Name) relation {
+	if len(names) == 0 {
+		return r
+	}
+	columns := append([]dbColumn{}, r.columns...)
+	for i, name := range names {
+		if i < len(columns) {
+			columns[i].name = name.Text
+		} else {
+			columns = append(columns, dbColumn{name: name.Text})
+		}
+	}
+	return relation{columns: columns, known: true}
+}
+
+func relationOfTable(table *dbTable) relation {
+	return relation{columns: table.columns, known: true}
+}
+
+// These columns exist in every table
+var systemColumns = map[string]bool{
+	"cmax": true, "cmin": true, "ctid": true, "tableoid": true, "xmax": true, "xmin": true,
+}
+
+type scopeTable struct {
+	name   string // the alias, or the table name
+	schema string // the schema of an unaliased table, which can also qualify its columns
+	rel    relation
+}
+
+// sqlScope holds the tables that column references can refer to. Subqueries
+// have a child scope so they can refer to the tables of the outer query.
+type sqlScope struct {
+	parent  *sqlScope
+	tables  []scopeTable
+	ctes    map[string]relation
+	outputs []dbColumn // the select list, which ORDER BY and GROUP BY can refer to
+	unknown bool       // a fragment could add more tables to this scope
+}
+
+type schemaChecker struct {
+	schema   *dbSchema
+	compiled logger.Source // the compiled query, for the ranges of names
+
+	// This is nil when the checker is only used to find the shape of a query
+	report func(r logger.Range, text string)
+
+	// The column each "$n" param is compared to or assigned to, for its type
+	params map[int]dbColumn
+}
+
+// bindParam records the column a "$n" param is used with, unless it's already known
+func (sc *schemaChecker) bindParam(expr sql_ast.Expr, column *dbColumn) {
+	if param, ok := expr.Data.(*sql_ast.EParam); ok && column != nil && sc.params != nil {
+		if _, ok := sc.params[param.Index]; !ok {
+			sc.params[param.Index] = *column
+		}
+	}
+}
+
+func (sc *schemaChecker) addError(loc logger.Loc, text string) {
+	if sc.report != nil {
+		sc.report(logger.Range{Loc: loc}, text)
+	}
+}
+
+func (sc *schemaChecker) addNameError(name sql_ast.Name, text string) {
+	if sc.report != nil {
+		sc.report(sql_lexer.RangeOfIdentifier(sc.compiled, name.Loc), text)
+	}
+}
+
+// withCTEs returns a scope with the common table expressions in a WITH clause
+func (sc *schemaChecker) withCTEs(s *sqlScope, ctes []sql_ast.CTE) *sqlScope {
+	if len(ctes) == 0 {
+		return s
+	}
+	s = &sqlScope{parent: s, ctes: map[string]relation{}}
+	for _, cte := range ctes {
+		// A recursive CTE can refer to itself, so add it before checking its body
+		s.ctes[cte.Name.Text] = relation{}.rename(cte.Columns)
+		s.ctes[cte.Name.Text] = sc.checkStmt(cte.Stmt, s).rename(cte.Columns)
+	}
+	return s
+}
+
+func (sc *schemaChecker) checkStmt(stmt sql_ast.Stmt, s *sqlScope) relation {
+	switch st := stmt.Data.(type) {
+	case *sql_ast.SQuery:
+		return sc.checkQuery(st.Query, s)
+
+	case *sql_ast.SInsert:
+		s = sc.withCTEs(s, st.With)
+		target, ok := sc.lookupTable(s, st.Table)
+		var source relation
+		if st.Query != nil {
+			source = sc.checkQuery(st.Query, s)
+		}
+		if ok && target.known {
+			for _, name := range st.Columns {
+				if target.column(name.Text) == nil && !systemColumns[name.Text] {
+					sc.addNameError(name, fmt.Sprintf("column %q of relation %q does not exist", name.Text, st.Table.Name))
+				}
+			}
+			if st.Query != nil {
+				sc.checkInsertCount(st, target, source)
+				if values, ok := st.Query.Body.(*sql_ast.QValues); ok {
+					sc.bindInsertValues(st, target, values)
+				}
+			}
+		}
+
+		is := &sqlScope{parent: s, tables: []scopeTable{tableEntry(st.Table, target)}}
+		if st.OnConflict != nil {
+			cs := &sqlScope{parent: is, tables: []scopeTable{{name: "excluded", rel: target}}}
+			sc.checkAssignments(cs, st.Table, target, st.OnConflict.Set)
+			sc.checkExpr(cs, st.OnConflict.Where)
+		}
+		return sc.checkSelectItems(is, st.Returning)
+
+	case *sql_ast.SUpdate:
+		s = sc.withCTEs(s, st.With)
+		target, _ := sc.lookupTable(s, st.Table)
+		us := &sqlScope{parent: s, tables: []scopeTable{tableEntry(st.Table, target)}, unknown: st.Fragments}
+		for _, te := range st.From {
+			sc.addTableExpr(us, te)
+		}
+		sc.checkAssignments(us, st.Table, target, st.Set)
+		sc.checkExpr(us, st.Where)
+		return sc.checkSelectItems(us, st.Returning)
+
+	case *sql_ast.SDelete:
+		s = sc.withCTEs(s, st.With)
+		target, _ := sc.lookupTable(s, st.Table)
+		ds := &sqlScope{parent: s, tables: []scopeTable{tableEntry(st.Table, target)}, unknown: st.Fragments}
+		for _, te := range st.Using {
+			sc.addTableExpr(ds, te)
+		}
+		sc.checkExpr(ds, st.Where)
+		return sc.checkSelectItems(ds, st.Returning)
+	}
+
+	// Other statements aren't checked
+	return relation{}
+}
+
+func tableEntry(table sql_ast.TableName, rel relation) scopeTable {
+	if table.Alias.Text != "" {
+		return scopeTable{name: table.Alias.Text, rel: rel}
+	}
+	return scopeTable{name: table.Name, schema: table.Schema, rel: rel}
+}
+
+// checkInsertCount compares the number of values in each row to the number of target columns
+func (sc *schemaChecker) checkInsertCount(st *sql_ast.SInsert, target relation, source relation) {
+	count := len(target.columns)
+	if len(st.Columns) != 0 {
+		count = len(st.Columns)
+	}
+
+	check := func(n int, locOfValue func(i int) logger.Loc) {
+		if n > count {
+			sc.addError(locOfValue(count), "INSERT has more expressions than target columns")
+		} else if n < len(st.Columns) {
+			sc.addNameError(st.Columns[n], "INSERT has more target columns than expressions")
+		}
+	}
+
+	switch body := st.Query.Body.(type) {
+	case *sql_ast.QValues:
+		for _, row := range body.Rows {
+			hasFragment := false
+			for _, value := range row {
+				if _, ok := value.Data.(*sql_ast.EFragment); ok {
+					hasFragment = true
+				}
+			}
+			if !hasFragment {
+				check(len(row), func(i int) logger.Loc { return row[i].Loc })
+			}
+		}
+
+	case *sql_ast.QSelect:
+		if source.known && len(source.columns) == len(body.Items) {
+			check(len(source.columns), func(i int) logger.Loc { return body.Items[i].Value.Loc })
+		}
+	}
+}
+
+// bindInsertValues binds the params in a VALUES list to their target columns
+func (sc *schemaChecker) bindInsertValues(st *sql_ast.SInsert, target relation, values *sql_ast.QValues) {
+	for _, row := range values.Rows {
+		for i, value := range row {
+			if len(st.Columns) == 0 {
+				if i < len(target.columns) {
+					sc.bindParam(value, &target.columns[i])
+				}
+			} else if i < len(st.Columns) {
+				sc.bindParam(value, target.column(st.Columns[i].Text))
+			}
+		}
+	}
+}
+
+func (sc *schemaChecker) checkAssignments(s *sqlScope, table sql_ast.TableName, target relation, assignments []sql_ast.Assignment) {
+	for _, assignment := range assignments {
+		if target.known {
+			for _, name := range assignment.Columns {
+				if target.column(name.Text) == nil {
+					sc.addNameError(name, fmt.Sprintf("column %q of relation %q does not exist", name.Text, table.Name))
+				}
+			}
+		}
+		sc.checkExpr(s, assignment.Value)
+		if len(assignment.Columns) == 1 {
+			sc.bindParam(assignment.Value, target.column(assignment.Columns[0].Text))
+		} else if row, ok := assignment.Value.Data.(*sql_ast.ERow); ok {
+			for i, value := range row.Items {
+				if i < len(assignment.Columns) {
+					sc.bindParam(value, target.column(assignment.Columns[i].Text))
+				}
+			}
+		}
+	}
+}
+
+// lookupTable finds a CTE or table, reporting an error if there is neither
+func (sc *schemaChecker) lookupTable(s *sqlScope, table sql_ast.TableName) (relation, bool) {
+	if table.Schema == "" {
+		for scope := s; scope != nil; scope = scope.parent {
+			if rel, ok := scope.ctes[table.Name]; ok {
+				return rel, true
+			}
+		}
+	}
+	if t := sc.schema.lookup(table.Schema, table.Name); t != nil {
+		return relationOfTable(t), true
+	}
+
+	name := table.Name
+	if table.Schema != "" {
+		name = table.Schema + "." + name
+	}
+	sc.addNameError(sql_ast.Name{Loc: table.Loc}, fmt.Sprintf("relation %q does not exist", name))
+	return relation{}, false
+}
+
+func (sc *schemaChecker) checkQuery(q *sql_ast.Query, s *sqlScope) relation {
+	s = sc.withCTEs(s, q.With)
+	rel, bodyScope := sc.checkQueryBody(q.Body, s)
+
+	if len(q.OrderBy) != 0 {
+		if bodyScope == nil {
+			// The ORDER BY of a set operation can only refer to the output columns
+			bodyScope = &sqlScope{parent: s, tables: []scopeTable{{rel: rel}}}
+		}
+		for _, item := range q.OrderBy {
+			sc.checkExpr(bodyScope, item.Value)
+		}
+	}
+	sc.checkExpr(s, q.Limit)
+	sc.checkExpr(s, q.Offset)
+	return rel
+}
+
+// checkQueryBody returns the output columns and, for a SELECT, the scope of its FROM clause
+func (sc *schemaChecker) checkQueryBody(body sql_ast.Q, s *sqlScope) (relation, *sqlScope) {
+	switch b := body.(type) {
+	case *sql_ast.QSelect:
+		ss := &sqlScope{parent: s, unknown: b.Fragments}
+		for _, te := range b.From {
+			sc.addTableExpr(ss, te)
+		}
+		rel := sc.checkSelectItems(ss, b.Items)
+		sc.checkExpr(ss, b.Where)
+		ss.outputs = rel.columns
+		for _, expr := range b.GroupBy {
+			sc.checkExpr(ss, expr)
+		}
+		sc.checkExpr(ss, b.Having)
+		return rel, ss
+
+	case *sql_ast.QValues:
+		rel := relation{known: true}
+		for i, row := range b.Rows {
+			for j, value := range row {
+				sc.checkExpr(s, value)
+				if i == 0 {
+					rel.columns = append(rel.columns, dbColumn{name: fmt.Sprintf("column%d", j+1)})
+				}
+			}
+		}
+		return rel, nil
+
+	case *sql_ast.QTable:
+		rel, _ := sc.lookupTable(s, b.Table)
+		return rel, nil
+
+	case *sql_ast.QSetOp:
+		left := sc.checkQuery(b.Left, s)
+		sc.checkQuery(b.Right, s)
+		return left, nil
+
+	case *sql_ast.QParens:
+		return sc.checkQuery(b.Query, s), nil
+	}
+
+	// A fragment
+	return relation{}, nil
+}
+
+func (sc *schemaChecker) addTableExpr(s *sqlScope, te sql_ast.TableExpr) {
+	switch t := te.Data.(type) {
+	case *sql_ast.TTable:
+		rel, _ := sc.lookupTable(s, t.Table)
+		entry := tableEntry(t.Table, rel.rename(t.ColumnAliases))
+		s.tables = append(s.tables, entry)
+
+	case *sql_ast.TSubquery:
+		rel := sc.checkQuery(t.Query, s)
+		s.tables = append(s.tables, scopeTable{name: t.Alias.Text, rel: rel.rename(t.ColumnAliases)})
+
+	case *sql_ast.TFunction:
+		sc.checkExpr(s, sql_ast.Expr{Loc: te.Loc, Data: t.Call})
+		for _, call := range t.RowsFrom {
+			sc.checkExpr(s, sql_ast.Expr{Loc: te.Loc, Data: call})
+		}
+		name := t.Alias.Text
+		if name == "" {
+			name = t.Call.Name[len(t.Call.Name)-1].Text
+		}
+
+		// The columns of a set returning function are only known if they're listed
+		rel := relation{}.rename(t.ColumnAliases)
+		for i, typ := range t.ColumnTypes {
+			rel.columns[i].typ = typ
+		}
+		s.tables = append(s.tables, scopeTable{name: name, rel: rel})
+
+	case *sql_ast.TJoin:
+		sc.addTableExpr(s, t.Left)
+		sc.addTableExpr(s, t.Right)
+		sc.checkExpr(s, t.On)
+
+	case *sql_ast.TFragment:
+		s.unknown = true
+	}
+}
+
+func (sc *schemaChecker) checkSelectItems(s *sqlScope, items []sql_ast.SelectItem) relation {
+	rel := relation{known: true}
+	for _, item := range items {
+		if col, ok := item.Value.Data.(*sql_ast.EColumn); ok && col.Star {
+			// Expand "*" or "table.*"
+			if len(col.Path) == 0 {
+				if s.unknown {
+					rel.known = false
+				}
+				for _, t := range s.tables {
+					rel.columns = append(rel.columns, t.rel.columns...)
+					rel.known = rel.known && t.rel.known
+				}
+			} else if t, _ := sc.findTable(s, col.Path); t != nil {
+				rel.columns = append(rel.columns, t.rel.columns...)
+				rel.known = rel.known && t.rel.known
+			} else {
+				sc.resolveColumn(s, col)
+				rel.known = false
+			}
+			continue
+		}
+
+		column := sc.checkValue(s, item.Value)
+		if item.Alias.Text != "" {
+			column.name = item.Alias.Text
+		}
+		rel.columns = append(rel.columns, column)
+	}
+	return rel
+}
+
+// checkValue checks a select list item and returns its name and, for a column reference or a
+// cast, its type
+func (sc *schemaChecker) checkValue(s *sqlScope, value sql_ast.Expr) dbColumn {
+	switch e := value.Data.(type) {
+	case *sql_ast.EColumn:
+		if column := sc.resolveColumn(s, e); column != nil {
+			return *column
+		}
+		return dbColumn{name: e.Column()}
+
+	case *sql_ast.ECast:
+		column := sc.checkValue(s, e.Value)
+		if _, ok := e.Value.Data.(*sql_ast.EColumn); !ok {
+			column.name = e.Type.Name
+		}
+		column.typ = e.Type
+		sc.bindParam(e.Value, &column)
+		return column
+
+	case *sql_ast.ECall:
+		sc.checkExpr(s, value)
+		return dbColumn{name: e.Name[len(e.Name)-1].Text}
+
+	case *sql_ast.ECase:
+		sc.checkExpr(s, value)
+		return dbColumn{name: "case"}
+
+	case *sql_ast.EExists:
+		sc.checkExpr(s, value)
+		return dbColumn{name: "exists", typ: sql_ast.Type{Name: "boolean"}, notNull: true}
+
+	case *sql_ast.EKeyword:
+		return dbColumn{name: strings.ToLower(e.Name)}
+	}
+
+	sc.checkExpr(s, value)
+	return dbColumn{name: "?column?"}
+}
+
+// findTable finds the table named by the qualifiers of a column reference, e.g. "app.users" in
+// "app.users.id". If there is no such table, it returns true if the table could be in a fragment.
+func (sc *schemaChecker) findTable(s *sqlScope, path []sql_ast.Name) (*scopeTable, bool) {
+	n := len(path)
+	name := path[n-1].Text
+	schema := ""
+	if n > 1 {
+		schema = path[n-2].Text
+	}
+
+	unknown := false
+	for scope := s; scope != nil; scope = scope.parent {
+		for i := range scope.tables {
+			t := &scope.tables[i]
+			if t.name == name && (schema == "" || t.schema == schema || (t.schema == "" && schema == defaultSchema)) {
+				return t, false
+			}
+		}
+		unknown = unknown || scope.unknown
+	}
+	return nil, unknown
+}
+
+// resolveColumn finds the column for a column reference, reporting an error if it doesn't
+// exist. It returns nil if the column isn't known.
+func (sc *schemaChecker) resolveColumn(s *sqlScope, e *sql_ast.EColumn) *dbColumn {
+	if e.Star {
+		if _, unknown := sc.findTable(s, e.Path); !unknown {
+			last := e.Path[len(e.Path)-1]
+			sc.addNameError(last, fmt.Sprintf("missing FROM-clause entry for table %q", last.Text))
+		}
+		return nil
+	}
+
+	name := e.Column()
+	if systemColumns[name] {
+		return nil
+	}
+
+	if len(e.Path) == 1 {
+		unknown := false
+		for scope := s; scope != nil; scope = scope.parent {
+			for _, t := range scope.tables {
+				if column := t.rel.column(name); column != nil {
+					return column
+				}
+				unknown = unknown || !t.rel.known
+			}
+			for _, t := range scope.tables {
+				if t.name == name {
+					// A reference to the whole row
+					return nil
+				}
+			}
+			for i := range scope.outputs {
+				if scope.outputs[i].name == name {
+					return &scope.outputs[i]
+				}
+			}
+			unknown = unknown || scope.unknown
+		}
+		if !unknown {
+			sc.addNameError(e.Path[0], fmt.Sprintf("column %q does not exist", name))
+		}
+		return nil
+	}
+
+	qualifier := e.Path[:len(e.Path)-1]
+	t, unknown := sc.findTable(s, qualifier)
+	if t == nil {
+		// This could be a field of a composite column, e.g. "address.city"
+		if len(e.Path) == 2 && !unknown && sc.resolveColumnSilently(s, e.Path[0].Text) {
+			return nil
+		}
+		if !unknown {
+			last := qualifier[len(qualifier)-1]
+			sc.addNameError(last, fmt.Sprintf("missing FROM-clause entry for table %q", last.Text))
+		}
+		return nil
+	}
+	if !t.rel.known {
+		return nil
+	}
+	column := t.rel.column(name)
+	if column == nil {
+		sc.addNameError(e.Path[len(e.Path)-1], fmt.Sprintf("column %s.%s does not exist", t.name, name))
+	}
+	return column
+}
+
+func (sc *schemaChecker) resolveColumnSilently(s *sqlScope, name string) bool {
+	for scope := s; scope != nil; scope = scope.parent {
+		for _, t := range scope.tables {
+			if t.rel.column(name) != nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (sc *schemaChecker) checkExprs(s *sqlScope, exprs []sql_ast.Expr) {
+	for _, expr := range exprs {
+		sc.checkExpr(s, expr)
+	}
+}
+
+func (sc *schemaChecker) checkOrderItems(s *sqlScope, items []sql_ast.OrderItem) {
+	for _, item := range items {
+		sc.checkExpr(s, item.Value)
+	}
+}
+
+// These operators compare their operands, so a param on one side has the type of the other
+var comparisonOps = map[string]bool{
+	"=": true, "<>": true, "!=": true, "<": true, ">": true, "<=": true, ">=": true,
+	"IS DISTINCT FROM": true, "IS NOT DISTINCT FROM": true, "LIKE": true, "NOT LIKE": true,
+	"ILIKE": true, "NOT ILIKE": true, "SIMILAR TO": true, "NOT SIMILAR TO": true,
+}
+
+// checkExpr checks the column references in an expression. For a column reference or a cast,
+// it returns the column (or just the type) the expression refers to, otherwise nil.
+func (sc *schemaChecker) checkExpr(s *sqlScope, expr sql_ast.Expr) *dbColumn {
+	switch e := expr.Data.(type) {
+	case *sql_ast.EColumn:
+		return sc.resolveColumn(s, e)
+
+	case *sql_ast.EUnary:
+		sc.checkExpr(s, e.Value)
+
+	case *sql_ast.EBinary:
+		left := sc.checkExpr(s, e.Left)
+		right := sc.checkExpr(s, e.Right)
+		if comparisonOps[e.Op] {
+			sc.bindParam(e.Right, left)
+			sc.bindParam(e.Left, right)
+		}
+
+	case *sql_ast.ESubqueryOp:
+		left := sc.checkExpr(s, e.Left)
+		sc.checkExpr(s, e.Right)
+		if left != nil && comparisonOps[e.Op] {
+			// "id = ANY($1)" takes an array
+			array := *left
+			array.typ.Array = true
+			sc.bindParam(e.Right, &array)
+		}
+
+	case *sql_ast.EIs:
+		sc.checkExpr(s, e.Value)
+
+	case *sql_ast.EIn:
+		value := sc.checkExpr(s, e.Value)
+		for _, item := range e.List {
+			sc.checkExpr(s, item)
+			sc.bindParam(item, value)
+		}
+		if e.Query != nil {
+			sc.checkQuery(e.Query, s)
+		}
+
+	case *sql_ast.EBetween:
+		value := sc.checkExpr(s, e.Value)
+		sc.checkExpr(s, e.Low)
+		sc.checkExpr(s, e.High)
+		sc.bindParam(e.Low, value)
+		sc.bindParam(e.High, value)
+
+	case *sql_ast.ECall:
+		sc.checkExprs(s, e.Args)
+		sc.checkOrderItems(s, e.OrderBy)
+		sc.checkExpr(s, e.Filter)
+		if e.Over != nil {
+			sc.checkExprs(s, e.Over.PartitionBy)
+			sc.checkOrderItems(s, e.Over.OrderBy)
+		}
+
+	case *sql_ast.ECast:
+		sc.checkExpr(s, e.Value)
+		column := &dbColumn{typ: e.Type}
+		sc.bindParam(e.Value, column)
+		return column
+
+	case *sql_ast.ECase:
+		sc.checkExpr(s, e.Value)
+		for _, when := range e.Whens {
+			sc.checkExpr(s, when.Cond)
+			sc.checkExpr(s, when.Value)
+		}
+		sc.checkExpr(s, e.Else)
+
+	case *sql_ast.EExists:
+		sc.checkQuery(e.Query, s)
+
+	case *sql_ast.ESubquery:
+		sc.checkQuery(e.Query, s)
+
+	case *sql_ast.EArray:
+		sc.checkExprs(s, e.Items)
+		if e.Query != nil {
+			sc.checkQuery(e.Query, s)
+		}
+
+	case *sql_ast.ERow:
+		sc.checkExprs(s, e.Items)
+
+	case *sql_ast.EIndex:
+		sc.checkExpr(s, e.Target)
+		sc.checkExpr(s, e.Index)
+		sc.checkExpr(s, e.End)
+
+	case *sql_ast.EField:
+		sc.checkExpr(s, e.Target)
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package api
+
+import (
+	"encoding/base64"
+	"strings"
+
+	"github.com/evanw/esbuild/internal/fs"
+	"github.com/evanw/esbuild/internal/helpers"
+	"github.com/evanw/esbuild/internal/js_printer"
+	"github.com/evanw/esbuild/internal/logger"
+	"github.com/evanw/esbuild/internal/sourcemap"
+)
+
+// serverEntryMap maps the generated server entry back to the declarations of the server functions
+// and validators it imports. It's appended to the entry as an inline source map, which esbuild
+// composes with the source map of the server bundle, so the bundle doesn't map to <stdin>.
+type serverEntryMap struct {
+	mappings []entryMapping
+
+	// The position of the end of the entry, so far
+	scanned   int
+	line      int
+	lineStart int
+}
+
+type entryMapping struct {
+	line   int
+	column int // in UTF-16 code units, like the source map columns
+	source *logger.Source
+	loc    logger.Loc
+}
+
+// add maps the position at the end of sb to loc in source
+func (m *serverEntryMap) add(sb *strings.Builder, source *logger.Source, loc logger.Loc) {
+	if source == nil {
+		return
+	}
+	contents := sb.String()
+	for i := m.scanned; i < len(contents); i++ {
+		if contents[i] == '\n' {
+			m.line++
+			m.lineStart = i + 1
+		}
+	}
+	m.scanned = len(contents)
+	m.mappings = append(m.mappings, entryMapping{
+		line:   m.line,
+		column: utf16Len(contents[m.lineStart:]),
+		source: source,
+		loc:    loc,
+	})
+}
+
+func utf16Len(text string) int {
+	n := 0
+	for _, c := range text {
+		if c >= 0x10000 {
+			n += 2 // a surrogate pair
+		} else {
+			n++
+		}
+	}
+	return n
+}
+
+// sourceMappingURL returns the inline source map comment for the entry. The sources are relative
+// to outDir, the directory of the server bundle, since esbuild copies them from the input source map.
+func (m *serverEntryMap) sourceMappingURL(fs fs.FS, outDir string) string {
+	sourceIndices := map[*logger.Source]int{}
+	var sources []*logger.Source
+	for _, mapping := range m.mappings {
+		if _, ok := sourceIndices[mapping.source]; !ok {
+			sourceIndices[mapping.source] = len(sources)
+			sources = append(sources, mapping.source)
+		}
+	}
+
+	j := helpers.Joiner{}
+	j.AddString("{\"version\":3,\"sources\":[")
+	for i, source := range sources {
+		if i != 0 {
+			j.AddString(",")
+		}
+		path := source.KeyPath.Text
+		if source.KeyPath.Namespace == "file" {
+			if relPath, ok := fs.Rel(outDir, path); ok {
+				path = strings.ReplaceAll(relPath, "\\", "/")
+			}
+		}
+		j.AddBytes(js_printer.QuoteForJSON(path, false))
+	}
+	j.AddString("],\"sourcesContent\":[")
+	for i, source := range sources {
+		if i != 0 {
+			j.AddString(",")
+		}
+		j.AddBytes(js_printer.QuoteForJSON(source.Contents, false))
+	}
+
+	// The mappings are in order, because the entry is only appended to
+	j.AddString("],\"names\":[],\"mappings\":\"")
+	var prevLine, prevColumn, prevSource, prevOriginalLine, prevOriginalColumn int
+	isFirst := true
+	for _, mapping := range m.mappings {
+		location := logger.LocationOrNil(mapping.source, logger.Range{Loc: mapping.loc})
+		if location == nil || (!isFirst && mapping.line == prevLine && mapping.column == prevColumn) {
+			continue // keep the first mapping of a position
+		}
+		if mapping.line != prevLine {
+			j.AddString(strings.Repeat(";", mapping.line-prevLine))
+			prevLine = mapping.line
+			prevColumn = 0
+		} else if !isFirst {
+			j.AddString(",")
+		}
+		isFirst = false
+		source := sourceIndices[mapping.source]
+		originalLine := location.Line - 1
+		originalColumn := utf16Len(location.LineText[:location.Column])
+		j.AddBytes(sourcemap.EncodeVLQ(mapping.column - prevColumn))
+		j.AddBytes(sourcemap.EncodeVLQ(source - prevSource))
+		j.AddBytes(sourcemap.EncodeVLQ(originalLine - prevOriginalLine))
+		j.AddBytes(sourcemap.EncodeVLQ(originalColumn - prevOriginalColumn))
+		prevColumn, prevSource, prevOriginalLine, prevOriginalColumn = mapping.column, source, originalLine, originalColumn
+	}
+	j.AddString("\"}")
+
+	return "\n//# sourceMappingURL=data:application/json;base64," + base64.StdEncoding.EncodeToString(j.Done()) + "\n"
+}
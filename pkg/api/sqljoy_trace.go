@@ -0,0 +1,57 @@
+package api
+
+import (
+	"fmt"
+
+	"github.com/evanw/esbuild/internal/js_ast"
+	"github.com/evanw/esbuild/internal/logger"
+)
+
+// traceEvent is the kind of a compiler trace message. The messages are logged at the debug level,
+// with "verbose": true in fsconfig.json or sjc --verbose, and start with the event so they can be filtered.
+type traceEvent string
+
+const (
+	traceBuild      traceEvent = "build"       // the steps of the build
+	traceScan       traceEvent = "scan"        // files scanned, and the exports and aliases they declare
+	traceQuery      traceEvent = "query"       // queries found, executed and replaced
+	traceResolve    traceEvent = "resolve"     // refs resolved to their original declaration by findOriginalRef
+	traceServerCall traceEvent = "server-call" // server calls found and rewritten
+	traceValidator  traceEvent = "validator"   // argument validators generated from TypeScript types
+)
+
+// trace logs a compiler event. The message isn't formatted unless the log level is debug.
+func trace(log logger.Log, event traceEvent, source *logger.Source, loc logger.Loc, format string, args ...interface{}) {
+	if !log.Debug {
+		return
+	}
+	log.AddDebug(source, loc, fmt.Sprintf("[%s] %s", event, fmt.Sprintf(format, args...)))
+}
+
+func (c *FlowStateCompiler) trace(event traceEvent, source *logger.Source, loc logger.Loc, format string, args ...interface{}) {
+	trace(c.log, event, source, loc, format, args...)
+}
+
+func (a *FlowStateAnalyzer) trace(event traceEvent, loc logger.Loc, format string, args ...interface{}) {
+	trace(a.compiler.log, event, &a.file.Source, loc, format, args...)
+}
+
+// refName returns the name of the symbol and the file declaring it, for trace messages
+func (c *FlowStateCompiler) refName(ref js_ast.Ref) string {
+	if ref == js_ast.InvalidRef || int(ref.SourceIndex) >= len(c.analyzers) || c.analyzers[ref.SourceIndex] == nil {
+		return "<unknown>"
+	}
+	analyzer := c.analyzers[ref.SourceIndex]
+	if int(ref.InnerIndex) >= len(analyzer.ast.Symbols) {
+		// The made up ref of a query that isn't assigned to a variable
+		return fmt.Sprintf("<inline> (%s)", analyzer.file.Source.PrettyPath)
+	}
+	return fmt.Sprintf("%s (%s)", analyzer.ast.Symbols[ref.InnerIndex].OriginalName, analyzer.file.Source.PrettyPath)
+}
+
+func propSuffix(prop string) string {
+	if prop == "" {
+		return ""
+	}
+	return "." + prop
+}
@@ -0,0 +1,192 @@
+package api
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/evanw/esbuild/internal/logger"
+	"github.com/evanw/esbuild/internal/sql_ast"
+)
+
+// The types file declares the late-bound params and the result rows of each
+// client query by its hash. Its interfaces are global so they merge with the
+// ones declared by the runtime:
+//
+//   interface FlowStateQueries {
+//     "<hash>": {lateBound: {minTotal: number | null}; row: {id: number; name: string}};
+//   }
+//
+// fs.executeQuery type checks its params argument, and types its result rows,
+// for a query typed as FlowStateQuery<"<hash>">. TypeScript can't infer the
+// hash of a sql`` template, since a tag only sees the strings as a
+// TemplateStringsArray, so a query is typed by annotating the variable it's
+// assigned to with the hash listed above its text in the types file:
+//
+//   const getOrder: FlowStateQuery<"<hash>"> = sql`SELECT ...`;
+//
+// The annotation must be updated when the query changes, which tsc reports
+// since the old hash is no longer in the types file. The queries that aren't
+// annotated aren't type checked, so the missing late-bound params of the
+// templates are also reported by the compiler, see checkLateBoundParams.
+//
+// Without a schema the params are "unknown" and the rows are untyped.
+
+const typesFileHeader = `// Generated by sjc from the queries in client-queries.json, do not edit.
+// Type a query by annotating it with its id, e.g.: const q: FlowStateQuery<"<id>"> = sql` + "`...`" + `;
+
+interface FlowStateQueries {
+`
+
+const typesFileFooter = `}
+
+interface FlowStateQuery<Id extends keyof FlowStateQueries> {
+	query: Id;
+	text?: string;
+	params: Record<string, unknown>;
+}
+
+interface FlowState {
+	executeQuery<Id extends keyof FlowStateQueries>(
+		query: FlowStateQuery<Id>,
+		...args: {} extends FlowStateQueries[Id]["lateBound"]
+			? [FlowStateQueries[Id]["lateBound"]?, ...Function[]]
+			: [FlowStateQueries[Id]["lateBound"], ...Function[]]
+	): Promise<FlowStateQueries[Id]["row"][]>;
+}
+`
+
+func (c *FlowStateCompiler) outputTypes(typesFile *OutputFile, fileName string, queries queriesByWhitelistOrder) {
+	typesFile.Path = path.Join(c.outDir, fileName)
+
+	sb := strings.Builder{}
+	sb.WriteString(typesFileHeader)
+	for _, q := range queries {
+		fmt.Fprintf(&sb, "\t/** %s */\n", strings.Replace(q.QueryText, "*/", "*\\/", -1))
+		fmt.Fprintf(&sb, "\t%s: {\n", strconv.Quote(q.Hash))
+		fmt.Fprintf(&sb, "\t\tlateBound: %s;\n", lateBoundParamsType(q))
+		fmt.Fprintf(&sb, "\t\trow: %s;\n", resultRowType(q))
+		sb.WriteString("\t};\n")
+	}
+	sb.WriteString(typesFileFooter)
+
+	typesFile.Contents = []byte(sb.String())
+	err := c.fs.WriteFile(typesFile.Path, typesFile.Contents, 0644)
+	if err != nil {
+		c.log.AddError(nil, logger.Loc{}, fmt.Sprintf("write query types %s: %v", fileName, err))
+	}
+}
+
+// lateBoundParamsType is the type of the params argument to executeQuery. The late-bound params
+// of fragments that are chosen at runtime are optional, since the fragment may not be used.
+func lateBoundParamsType(q *query) string {
+	var props []string
+	seen := map[string]bool{}
+	for i, param := range q.params {
+		if param.lateBound && !seen[param.name] {
+			seen[param.name] = true
+			props = append(props, fmt.Sprintf("%s: %s", tsPropertyName(param.name), paramType(q, i+1)))
+		}
+	}
+
+	var visit func(fragments [][]*query)
+	visit = func(fragments [][]*query) {
+		for _, group := range fragments {
+			for _, fragment := range group {
+				for _, param := range fragment.params {
+					if param.lateBound && !seen[param.name] {
+						seen[param.name] = true
+						props = append(props, fmt.Sprintf("%s?: unknown", tsPropertyName(param.name)))
+					}
+				}
+				visit(fragment.Fragments)
+			}
+		}
+	}
+	visit(q.Fragments)
+
+	if len(props) == 0 {
+		return "{}"
+	}
+	return "{" + strings.Join(props, "; ") + "}"
+}
+
+func paramType(q *query, index int) string {
+	if column, ok := q.paramTypes[index]; ok {
+		return tsColumnType(column)
+	}
+	return "unknown"
+}
+
+func resultRowType(q *query) string {
+	if q.result == nil || !q.result.known {
+		return "Record<string, unknown>"
+	}
+
+	// Later columns with the same name replace earlier ones in the row object
+	columns := q.result.columns
+	last := map[string]int{}
+	for i, column := range columns {
+		last[column.name] = i
+	}
+	var props []string
+	for i, column := range columns {
+		if last[column.name] == i {
+			props = append(props, fmt.Sprintf("%s: %s", tsPropertyName(column.name), tsColumnType(column)))
+		}
+	}
+	return "{" + strings.Join(props, "; ") + "}"
+}
+
+var reTSIdentifier = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*$`)
+
+func tsPropertyName(name string) string {
+	if reTSIdentifier.MatchString(name) {
+		return name
+	}
+	return strconv.Quote(name)
+}
+
+func tsColumnType(column dbColumn) string {
+	ty := tsType(column.typ)
+	if ty != "unknown" && !column.notNull {
+		ty += " | null"
+	}
+	return ty
+}
+
+// tsType maps a PostgreSQL type to the type its values have once they're returned as JSON.
+// Values that don't fit in a JavaScript number, such as bigint and numeric, are strings.
+func tsType(ty sql_ast.Type) string {
+	var base string
+	switch strings.TrimPrefix(ty.Name, "pg_catalog.") {
+	case "":
+		return "unknown"
+
+	case "smallint", "integer", "int", "int2", "int4", "real", "float", "float4", "float8",
+		"double precision", "serial", "serial2", "serial4", "smallserial", "oid":
+		base = "number"
+
+	case "boolean", "bool":
+		base = "boolean"
+
+	case "bigint", "int8", "bigserial", "serial8", "numeric", "decimal", "money",
+		"text", "varchar", "character varying", "char", "character", "bpchar", "name", "citext",
+		"uuid", "date", "time", "timetz", "time with time zone", "timestamp", "timestamptz",
+		"timestamp with time zone", "interval", "inet", "cidr", "macaddr", "bytea", "xml",
+		"bit", "bit varying", "varbit", "tsvector":
+		base = "string"
+
+	default:
+		base = "unknown"
+	}
+	if ty.Array {
+		if base == "unknown" {
+			return "unknown[]"
+		}
+		return base + "[]"
+	}
+	return base
+}
@@ -0,0 +1,381 @@
+package api
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/evanw/esbuild/internal/graph"
+	"github.com/evanw/esbuild/internal/js_ast"
+	"github.com/evanw/esbuild/internal/js_lexer"
+	"github.com/evanw/esbuild/internal/logger"
+)
+
+// partRef identifies a part by index, since the linker works on clones of the parts
+type partRef struct {
+	sourceIndex uint32
+	partIndex   uint32
+}
+
+// serverOnlyCode is the code that must not be in the client bundle: the parts declaring
+// the server functions, the bodies of the server methods, and the modules that are only
+// imported by server functions. It's found when the client is compiled, and checked after
+// the client bundle is linked.
+type serverOnlyCode struct {
+	parts   map[partRef]string            // the name of the server function declared by each part
+	methods map[partRef]map[string]string // the name of each server method by method, for the parts declaring them
+	files   map[uint32]bool
+}
+
+// findServerOnlyCode finds the server only code in the client files, given the server functions and methods called by server calls.
+// The parts that were removed from the client are server only too, even if they're the class or object of a server method.
+// The class or object of a server method can still be used by the client, so only the method's body is server only.
+func (c *FlowStateCompiler) findServerOnlyCode(serverFunctions map[js_ast.Ref]bool, serverMethods map[js_ast.Ref]map[string]bool) {
+	code := serverOnlyCode{parts: map[partRef]string{}, methods: map[partRef]map[string]string{}, files: map[uint32]bool{}}
+	isServerPart := map[*js_ast.Part]bool{}
+	findPart := func(analyzer *FlowStateAnalyzer, part *js_ast.Part) (partRef, bool) {
+		for i := range analyzer.ast.Parts {
+			if &analyzer.ast.Parts[i] == part {
+				return partRef{sourceIndex: analyzer.file.Source.Index, partIndex: uint32(i)}, true
+			}
+		}
+		return partRef{}, false
+	}
+	addPart := func(analyzer *FlowStateAnalyzer, part *js_ast.Part, name string) {
+		if ref, ok := findPart(analyzer, part); ok {
+			code.parts[ref] = name
+			isServerPart[part] = true
+		}
+	}
+	for _, analyzer := range c.analyzers {
+		if analyzer == nil {
+			continue
+		}
+		for ref, f := range analyzer.serverFunctions {
+			if serverFunctions[ref] || c.clientEdits.deadParts[f.part] {
+				addPart(analyzer, f.part, analyzer.ast.Symbols[ref.InnerIndex].OriginalName)
+			}
+		}
+		for ref, methods := range analyzer.serverMethods {
+			name := analyzer.ast.Symbols[ref.InnerIndex].OriginalName
+			for method, f := range methods {
+				if c.clientEdits.deadParts[f.part] {
+					addPart(analyzer, f.part, name)
+				}
+				if !serverMethods[ref][method] {
+					continue
+				}
+				if part, ok := findPart(analyzer, f.part); ok {
+					if code.methods[part] == nil {
+						code.methods[part] = map[string]string{}
+					}
+					code.methods[part][method] = name + "." + method
+				}
+			}
+		}
+	}
+
+	// An import is server only if the names it imports are only used by server functions.
+	// A module is server only if it can't be reached from the entry points without one.
+	// The imports that are only used by server calls are skipped too when checking the "use server" modules.
+	serverOnlyImports := importSet{}
+	serverCallImports := importSet{}
+	for _, analyzer := range c.analyzers {
+		if analyzer == nil {
+			continue
+		}
+		ast := analyzer.ast
+		sourceIndex := analyzer.file.Source.Index
+		usedByClient := map[uint32]bool{}
+		usedByServer := map[uint32]bool{}
+		for i := range ast.Parts {
+			part := &ast.Parts[i]
+			for ref := range part.SymbolUses {
+				if imported, ok := ast.NamedImports[ref]; ok {
+					if isServerPart[part] {
+						usedByServer[imported.ImportRecordIndex] = true
+					} else {
+						usedByClient[imported.ImportRecordIndex] = true
+					}
+				}
+			}
+		}
+		for record := range usedByServer {
+			if !usedByClient[record] {
+				serverOnlyImports.add(sourceIndex, record)
+				serverCallImports.add(sourceIndex, record)
+			}
+		}
+
+		// The uses of an import that were replaced by server calls are removed from its use count
+		stillUsed := map[uint32]bool{}
+		replaced := map[uint32]bool{}
+		for ref, imported := range ast.NamedImports {
+			if count, ok := c.clientEdits.useCounts[ref]; ok {
+				replaced[imported.ImportRecordIndex] = true
+				stillUsed[imported.ImportRecordIndex] = stillUsed[imported.ImportRecordIndex] || count != 0
+			} else if ast.Symbols[ref.InnerIndex].UseCountEstimate != 0 {
+				stillUsed[imported.ImportRecordIndex] = true
+			}
+		}
+		for record := range replaced {
+			if !stillUsed[record] {
+				serverCallImports.add(sourceIndex, record)
+			}
+		}
+	}
+
+	clientFile := func(sourceIndex uint32) *graph.InputFile { return &c.files[sourceIndex] }
+	reachable := findImporters(clientFile, c.entryPoints, serverOnlyImports)
+	for sourceIndex := range findImporters(clientFile, c.entryPoints, nil) {
+		if _, ok := reachable[sourceIndex]; !ok {
+			code.files[sourceIndex] = true
+		}
+	}
+
+	// The "use server" modules the client only imports through server calls are server only too
+	clientImporters := findImporters(clientFile, c.entryPoints, serverCallImports)
+	for sourceIndex := range reachable {
+		if _, ok := clientImporters[sourceIndex]; !ok && c.moduleMarker(&c.files[sourceIndex]) == markerServerOnly {
+			code.files[sourceIndex] = true
+		}
+	}
+	c.serverOnly = code
+
+	c.checkServerOnlyModules(clientImporters)
+}
+
+// checkServerOnlyModules reports the "use server" modules that are imported by the client other than
+// through server calls, given the importers of the files reachable from the client without them
+func (c *FlowStateCompiler) checkServerOnlyModules(importers map[uint32]uint32) {
+	clientFile := func(sourceIndex uint32) *graph.InputFile { return &c.files[sourceIndex] }
+	for _, sourceIndex := range sortedSourceIndices(importers) {
+		if c.moduleMarker(&c.files[sourceIndex]) == markerServerOnly {
+			c.log.AddError(nil, logger.Loc{}, fmt.Sprintf("server only module %s is imported by client code other than through server calls (imported by %s)",
+				c.files[sourceIndex].Source.PrettyPath, importChain(clientFile, importers, sourceIndex)))
+		}
+	}
+}
+
+// importSet is a set of import records, by source index and import record index
+type importSet map[uint32]map[uint32]bool
+
+func (s importSet) add(sourceIndex, record uint32) {
+	if s[sourceIndex] == nil {
+		s[sourceIndex] = map[uint32]bool{}
+	}
+	s[sourceIndex][record] = true
+}
+
+// findImporters finds the files that can be reached from the entry points without following the skipped imports.
+// It returns the file each one is first imported by, on the shortest chain of imports from an entry point.
+// The entry points are imported by themselves.
+func findImporters(file func(sourceIndex uint32) *graph.InputFile, entryPoints []graph.EntryPoint, skipped importSet) map[uint32]uint32 {
+	importers := map[uint32]uint32{}
+	var queue []uint32
+	for _, entryPoint := range entryPoints {
+		if _, ok := importers[entryPoint.SourceIndex]; !ok {
+			importers[entryPoint.SourceIndex] = entryPoint.SourceIndex
+			queue = append(queue, entryPoint.SourceIndex)
+		}
+	}
+	for len(queue) != 0 {
+		next := queue[0]
+		queue = queue[1:]
+		repr, ok := file(next).Repr.(*graph.JSRepr)
+		if !ok {
+			continue
+		}
+		for i, record := range repr.AST.ImportRecords {
+			if !record.SourceIndex.IsValid() || skipped[next][uint32(i)] {
+				continue
+			}
+			other := record.SourceIndex.GetIndex()
+			if _, ok := importers[other]; !ok {
+				importers[other] = next
+				queue = append(queue, other)
+			}
+		}
+	}
+	return importers
+}
+
+// importChain returns the chain of imports from an entry point to the file, e.g.: app.js -> actions.js -> db.js
+func importChain(file func(sourceIndex uint32) *graph.InputFile, importers map[uint32]uint32, sourceIndex uint32) string {
+	chain := []string{file(sourceIndex).Source.PrettyPath}
+	for i := sourceIndex; ; {
+		next, ok := importers[i]
+		if !ok || next == i {
+			break
+		}
+		chain = append(chain, file(next).Source.PrettyPath)
+		i = next
+	}
+	for i, j := 0, len(chain)-1; i < j; i, j = i+1, j-1 {
+		chain[i], chain[j] = chain[j], chain[i]
+	}
+	return strings.Join(chain, " -> ")
+}
+
+func sortedSourceIndices(files map[uint32]uint32) []uint32 {
+	sourceIndices := make([]uint32, 0, len(files))
+	for sourceIndex := range files {
+		sourceIndices = append(sourceIndices, sourceIndex)
+	}
+	sort.Slice(sourceIndices, func(i, j int) bool { return sourceIndices[i] < sourceIndices[j] })
+	return sourceIndices
+}
+
+// verifyClientBundle is called after tree shaking the client bundle. It's an error if any of the server
+// only code is still in it, which happens if a server function is referenced in a way the compiler
+// didn't replace, or if a module imported by a server function has side effects.
+func (c *FlowStateCompiler) verifyClientBundle(log logger.Log, files []graph.LinkerFile, entryPoints []graph.EntryPoint) {
+	if c.log.HasErrors() {
+		return // the compiler already reported why
+	}
+	linkedFile := func(sourceIndex uint32) *graph.InputFile { return &files[sourceIndex].InputFile }
+	importers := findImporters(linkedFile, entryPoints, nil)
+
+	// Report the errors in a deterministic order
+	parts := make([]partRef, 0, len(c.serverOnly.parts))
+	for ref := range c.serverOnly.parts {
+		parts = append(parts, ref)
+	}
+	sort.Slice(parts, func(i, j int) bool {
+		if parts[i].sourceIndex == parts[j].sourceIndex {
+			return parts[i].partIndex < parts[j].partIndex
+		}
+		return parts[i].sourceIndex < parts[j].sourceIndex
+	})
+
+	for _, ref := range parts {
+		file := &files[ref.sourceIndex]
+		repr, ok := file.InputFile.Repr.(*graph.JSRepr)
+		if !ok || !file.IsLive || !repr.AST.Parts[ref.partIndex].IsLive {
+			continue
+		}
+		var loc logger.Loc
+		if stmts := repr.AST.Parts[ref.partIndex].Stmts; len(stmts) != 0 {
+			loc = stmts[0].Loc
+		}
+		log.AddError(&file.InputFile.Source, loc, fmt.Sprintf("server function %s is included in the client bundle (imported by %s)",
+			c.serverOnly.parts[ref], importChain(linkedFile, importers, ref.sourceIndex)))
+	}
+
+	// The server methods in the live parts must have been replaced with empty functions
+	methodParts := make([]partRef, 0, len(c.serverOnly.methods))
+	for ref := range c.serverOnly.methods {
+		if _, ok := c.serverOnly.parts[ref]; !ok {
+			methodParts = append(methodParts, ref)
+		}
+	}
+	sort.Slice(methodParts, func(i, j int) bool {
+		if methodParts[i].sourceIndex == methodParts[j].sourceIndex {
+			return methodParts[i].partIndex < methodParts[j].partIndex
+		}
+		return methodParts[i].sourceIndex < methodParts[j].sourceIndex
+	})
+	for _, ref := range methodParts {
+		file := &files[ref.sourceIndex]
+		repr, ok := file.InputFile.Repr.(*graph.JSRepr)
+		if !ok || !file.IsLive || !repr.AST.Parts[ref.partIndex].IsLive {
+			continue
+		}
+		methods := c.serverOnly.methods[ref]
+		for _, prop := range partProperties(repr.AST.Parts[ref.partIndex].Stmts) {
+			key, ok := prop.Key.Data.(*js_ast.EString)
+			if !ok || prop.Value == nil || !hasFunctionBody(prop.Value) {
+				continue
+			}
+			if name, ok := methods[js_lexer.UTF16ToString(key.Value)]; ok {
+				log.AddError(&file.InputFile.Source, prop.Key.Loc, fmt.Sprintf("server function %s is included in the client bundle (imported by %s)",
+					name, importChain(linkedFile, importers, ref.sourceIndex)))
+			}
+		}
+	}
+
+	for _, sourceIndex := range sortedSourceIndices(importers) {
+		if c.serverOnly.files[sourceIndex] && isLinked(&files[sourceIndex]) {
+			log.AddError(nil, logger.Loc{}, fmt.Sprintf("server only module %s is included in the client bundle (imported by %s)",
+				files[sourceIndex].InputFile.Source.PrettyPath, importChain(linkedFile, importers, sourceIndex)))
+		}
+	}
+}
+
+// verifyServerBundle is called after tree shaking the server bundle. It's an error if any of the "use client" modules are in it.
+func (c *FlowStateCompiler) verifyServerBundle(log logger.Log, files []graph.LinkerFile, entryPoints []graph.EntryPoint) {
+	linkedFile := func(sourceIndex uint32) *graph.InputFile { return &files[sourceIndex].InputFile }
+	importers := findImporters(linkedFile, entryPoints, nil)
+	for _, sourceIndex := range sortedSourceIndices(importers) {
+		if isLinked(&files[sourceIndex]) && c.moduleMarker(&files[sourceIndex].InputFile) == markerClientOnly {
+			log.AddError(nil, logger.Loc{}, fmt.Sprintf("client only module %s is included in the server bundle (imported by %s)",
+				files[sourceIndex].InputFile.Source.PrettyPath, importChain(linkedFile, importers, sourceIndex)))
+		}
+	}
+}
+
+// partProperties returns the properties of the classes and object literals declared by the statements of a part
+func partProperties(stmts []js_ast.Stmt) []js_ast.Property {
+	var props []js_ast.Property
+	addValue := func(expr js_ast.Expr) {
+		switch e := expr.Data.(type) {
+		case *js_ast.EClass:
+			props = append(props, e.Class.Properties...)
+		case *js_ast.EObject:
+			props = append(props, e.Properties...)
+		}
+	}
+	for _, stmt := range stmts {
+		switch s := stmt.Data.(type) {
+		case *js_ast.SClass:
+			props = append(props, s.Class.Properties...)
+		case *js_ast.SExportDefault:
+			if s.Value.Stmt != nil {
+				if class, ok := s.Value.Stmt.Data.(*js_ast.SClass); ok {
+					props = append(props, class.Class.Properties...)
+				}
+			} else if s.Value.Expr != nil {
+				addValue(*s.Value.Expr)
+			}
+		case *js_ast.SLocal:
+			for _, decl := range s.Decls {
+				if decl.Value != nil {
+					addValue(*decl.Value)
+				}
+			}
+		}
+	}
+	return props
+}
+
+// hasFunctionBody returns true if expr is a function with any statements in its body
+func hasFunctionBody(expr *js_ast.Expr) bool {
+	switch e := expr.Data.(type) {
+	case *js_ast.EFunction:
+		return len(e.Fn.Body.Stmts) != 0
+	case *js_ast.EArrow:
+		return len(e.Body.Stmts) != 0
+	}
+	return false
+}
+
+// isLinked returns true if any of the code in the file is in the bundle
+func isLinked(file *graph.LinkerFile) bool {
+	if !file.IsLive {
+		return false
+	}
+	if repr, ok := file.InputFile.Repr.(*graph.JSRepr); ok {
+		for _, part := range repr.AST.Parts {
+			if !part.IsLive {
+				continue
+			}
+			for _, stmt := range part.Stmts {
+				// A "use server" or "use client" directive doesn't make the rest of the module live
+				if _, ok := stmt.Data.(*js_ast.SDirective); !ok {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
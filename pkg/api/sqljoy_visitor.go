@@ -84,7 +84,6 @@ func (v *FlowStateVisitor) visitStmt(stmt *js_ast.Stmt, part *js_ast.Part) {
 		return
 	}
 
-	log.Printf("visit %T\n", stmt.Data)
 	v.stmtVisitor = v.stmtVisitor.VisitStmt(stmt, part)
 	if v.stmtVisitor == nil {
 		return
@@ -114,6 +113,13 @@ func (v *FlowStateVisitor) visitStmt(stmt *js_ast.Stmt, part *js_ast.Part) {
 		for i := range s.Fn.Body.Stmts {
 			v.visitStmt(&s.Fn.Body.Stmts[i], nil)
 		}
+		exprs := make([]*js_ast.Expr, 0, len(s.Fn.Args))
+		for i := range s.Fn.Args {
+			if p := s.Fn.Args[len(s.Fn.Args)-1-i].Default; p != nil {
+				exprs = append(exprs, p)
+			}
+		}
+		v.visitExprs(stmt, part, exprs...)
 	case *js_ast.SClass:
 		exprs := make([]*js_ast.Expr, 0, len(s.Class.Properties)*2)
 		for _, prop := range s.Class.Properties {
@@ -191,7 +197,7 @@ func (v *FlowStateVisitor) visitStmt(stmt *js_ast.Stmt, part *js_ast.Part) {
 		v.visitExprs(stmt, part, exprs...)
 	case *js_ast.SExportDefault:
 		if s.Value.Stmt != nil {
-			v.visitStmt(stmt, nil)
+			v.visitStmt(s.Value.Stmt, nil)
 		}	else if s.Value.Expr != nil {
 			v.visitExprs(stmt, part, s.Value.Expr)
 		}
@@ -235,7 +241,6 @@ func (v *FlowStateVisitor) visitExprs(stmt *js_ast.Stmt, part *js_ast.Part, expr
 			continue
 		}
 
-		log.Printf("visit %T\n", expr.Data)
 		v.visitor = v.visitor.Visit(stmt, expr, decl, parents, part)
 		if v.visitor == nil {
 			return
@@ -345,12 +350,10 @@ func (v *FlowStateVisitor) visitExprs(stmt *js_ast.Stmt, part *js_ast.Part, expr
 
 		newTail := len(exprs) - 1
 		if newTail < tail {
-			// we didn't add any child expressions to the exprs stack, check if we can pop the top parent
+			// we didn't add any child expressions to the exprs stack, check if we can pop the top parents
 			// the first child is at childrenStartIndex, so if newTail is below that, we've visited all
-			// the children and can pop this parent.
-			i := len(popParents)-1
-			if i >= 0 && newTail < int(popParents[i]) {
-				log.Printf("done with tree at %T", parents[i].Data)
+			// the children and can pop this parent. This can finish more than one level of the tree.
+			for i := len(popParents)-1; i >= 0 && newTail < int(popParents[i]); i-- {
 				parents = parents[:i] // pop parent
 				popParents = popParents[:i]
 			}
@@ -0,0 +1,88 @@
+package integration_tests
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestArgValidators(t *testing.T) {
+	const prog = `
+	export async function save(ctx, id: string, count?: number, tags: string[] = [], opts: {name: string; age?: number | null}, mode: "a" | 'b', ...rest: Array<[number, string]>) {
+		return id;
+	}
+	export const toggle = async (ctx, flags: Record<string, boolean>) => flags;
+
+	window.doStuff = async function(a) {
+		await save(window.fs.beginTx(), a);
+		await toggle(window.fs.beginTx(), a);
+	};
+	`
+
+	result := build(map[string]string{
+		"/app.ts": prog,
+	}, nil)
+
+	assert.Empty(t, result.Errors)
+
+	client := string(getOutFile(&result, "client.bundle.js"))
+	assert.NotContains(t, client, "argValidators")
+
+	server := string(getOutFile(&result, "server.bundle.js"))
+	assert.Contains(t, server, `if (!(typeof args[0] === "string"))
+        _invalidArg("id", "string");`)
+	assert.Contains(t, server, `if (!(args[1] === void 0 || typeof args[1] === "number"))
+        _invalidArg("count", "number");`)
+	assert.Contains(t, server, `if (!(args[2] === void 0 || Array.isArray(args[2]) && args[2].every((v0) => typeof v0 === "string")))
+        _invalidArg("tags", "string[]");`)
+	assert.Contains(t, server, `if (!(typeof args[3] === "object" && args[3] !== null && !Array.isArray(args[3]) && typeof args[3].name === "string" && (args[3].age === void 0 || (typeof args[3].age === "number" || args[3].age === null))))`)
+	assert.Contains(t, server, `if (!(args[4] === "a" || args[4] === "b"))`)
+	assert.Contains(t, server, `if (!(Array.isArray(args.slice(5)) && args.slice(5).every((v0) => Array.isArray(v0) && v0.length === 2 && typeof v0[0] === "number" && typeof v0[1] === "string")))
+        _invalidArg("rest", "Array<[number, string]>");`)
+	assert.Contains(t, server, `if (args.length > 1)
+        throw new TypeError("expected at most 1 arguments");
+      if (!(typeof args[0] === "object" && args[0] !== null && !Array.isArray(args[0]) && Object.values(args[0]).every((v0) => typeof v0 === "boolean")))
+        _invalidArg("flags", "Record<string, boolean>");`)
+	assert.Contains(t, server, `function _invalidArg(name, type) {`)
+}
+
+func TestArgValidatorsUnsupportedTypes(t *testing.T) {
+	const prog = `
+	interface User { name: string }
+
+	export class Api {
+		static async rename(ctx, user: User, name: string, cb: () => void) {
+			return name;
+		}
+	}
+	export const untyped = async (ctx, x) => x;
+
+	window.doStuff = async function(a) {
+		await Api.rename(window.fs.beginTx(), a, a);
+		await untyped(window.fs.beginTx(), a);
+	};
+	`
+
+	result := build(map[string]string{
+		"/app.ts": prog,
+	}, nil)
+
+	assert.Empty(t, result.Errors)
+	assert.Equal(t, []string{
+		"argument user of server function Api.rename isn't validated, its type can't be checked at runtime: only JSON compatible types declared inline are supported [unchecked-arg]",
+		"argument cb of server function Api.rename isn't validated, its type can't be checked at runtime: only JSON compatible types declared inline are supported [unchecked-arg]",
+	}, errorTexts(result.Warnings))
+	assert.Equal(t, 5, result.Warnings[0].Location.Line)
+	assert.Equal(t, 5, result.Warnings[1].Location.Line)
+
+	// Only the types that can be checked are, and functions without types aren't
+	server := string(getOutFile(&result, "server.bundle.js"))
+	assert.NotContains(t, server, `_invalidArg("user"`)
+	assert.NotContains(t, server, `_invalidArg("cb"`)
+	assert.Contains(t, server, `if (args.length > 3)
+        throw new TypeError("expected at most 3 arguments");
+      if (!(typeof args[1] === "string"))
+        _invalidArg("name", "string");
+    }
+  };`)
+}
@@ -0,0 +1,136 @@
+package integration_tests
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const serverOnlyApp = `
+import { deleteUser } from "./actions";
+import { format } from "./util";
+
+window.doStuff = (id) => deleteUser(window.fs.beginTx(), format(id));
+`
+
+const serverOnlyActions = `
+import { pool } from "./db";
+import { format } from "./util";
+
+export async function deleteUser(ctx, id) {
+	return pool.query(format(id));
+}
+`
+
+func TestServerOnlyModuleInClientBundle(t *testing.T) {
+	result := build(map[string]string{
+		"/app.js":     serverOnlyApp,
+		"/actions.js": serverOnlyActions,
+		"/db.js":      "console.log('connecting');\nexport const pool = { query: (q) => q };\n",
+		"/util.js":    "console.log('loading util');\nexport const format = (id) => '' + id;\n",
+	}, nil, "/app.js")
+
+	assert.Equal(t, []string{
+		"server only module db.js is included in the client bundle (imported by app.js -> actions.js -> db.js)",
+	}, errorTexts(result.Errors))
+}
+
+func TestServerOnlyModuleRemoved(t *testing.T) {
+	result := build(map[string]string{
+		"/app.js":     serverOnlyApp,
+		"/actions.js": serverOnlyActions,
+		"/db.js":      "export const pool = { query: (q) => q };\n",
+		"/util.js":    "console.log('loading util');\nexport const format = (id) => '' + id;\n",
+	}, nil, "/app.js")
+
+	assert.Empty(t, result.Errors)
+
+	client := string(getOutFile(&result, "client.bundle.js"))
+	assert.Contains(t, client, `loading util`)
+	assert.NotContains(t, client, `pool`)
+}
+
+func TestServerFunctionInClientBundle(t *testing.T) {
+	// Exporting the server function from the entry point keeps it in the client bundle
+	result := build(map[string]string{
+		"/app.js": `
+import { deleteUser } from "./actions";
+export { deleteUser };
+
+window.doStuff = (id) => deleteUser(window.fs.beginTx(), id);
+`,
+		"/actions.js": `
+export async function deleteUser(ctx, id) {
+	return id;
+}
+`,
+	}, nil, "/app.js")
+
+	assert.Equal(t, []string{
+		"server function deleteUser is included in the client bundle (imported by app.js -> actions.js)",
+	}, errorTexts(result.Errors))
+	assert.Equal(t, 2, result.Errors[0].Location.Line)
+}
+
+func TestServerMethodsRemovedFromClientBundle(t *testing.T) {
+	// The class and object exported from the entry point stay in the client bundle, without the server methods
+	result := build(map[string]string{
+		"/app.js": `
+import { Api, api } from "./api";
+export { Api, api };
+
+window.getUser = (id) => Api.getUser(window.fs.beginTx(), id);
+window.save = (id) => api.save(window.fs.beginTx(), id);
+window.load = (id) => api.load(window.fs.beginTx(), id);
+`,
+		"/api.js": `
+export class Api {
+	static async getUser(ctx, id) {
+		return "user " + id;
+	}
+}
+
+export const api = {
+	async save(ctx, id) {
+		return "saved " + id;
+	},
+	load: async (ctx, id) => {
+		return "loaded " + id;
+	},
+};
+`,
+	}, nil, "/app.js")
+
+	assert.Empty(t, result.Errors)
+
+	client := string(getOutFile(&result, "client.bundle.js"))
+	assert.Contains(t, client, `var Api = class`)
+	assert.Contains(t, client, `var api = {`)
+	assert.NotContains(t, client, `"user "`)
+	assert.NotContains(t, client, `"saved "`)
+	assert.NotContains(t, client, `"loaded "`)
+}
+
+func TestServerMethodCalledOnClient(t *testing.T) {
+	// The client also calls the method directly, so its body can't be removed from the client bundle
+	result := build(map[string]string{
+		"/app.js": `
+import { Api } from "./api";
+
+window.getUser = (id) => Api.getUser(window.fs.beginTx(), id);
+window.getLocalUser = (id) => Api.getUser(window.localCtx, id);
+`,
+		"/api.js": `
+export class Api {
+	static async getUser(ctx, id) {
+		return "user " + id;
+	}
+}
+`,
+	}, nil, "/app.js")
+
+	assert.Equal(t, []string{
+		"server function Api.getUser is included in the client bundle (imported by app.js -> api.js)",
+	}, errorTexts(result.Errors))
+	assert.Equal(t, 3, result.Errors[0].Location.Line)
+}
@@ -1,6 +1,8 @@
 package integration_tests
 
 import (
+	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -49,6 +51,19 @@ func TestConditionalFragments(t *testing.T) {
 	whitelist := getClientWhitelist(&result)
 	assert.NotEmpty(t, whitelist)
 
+	// There's a combination for each choice of filter, sort column and sort direction
+	assert.Equal(t, []interface{}{
+		[]interface{}{"-0tcmJGPljHBFkS6G1jCyU0J5_ozJxAUCLhX3MDS", "IFA8xH25nGEvbvo-y74kExORI9WfiWVT1LpPmz_w", "2Oxe6Mct_J91HL4F3hgya17FI9iO_F4pJ4GTPJYb", "IW9pTVDZwdhBGSzYH_70oCO0AWw2TsksiGVS18CZ"},
+		[]interface{}{"w62tT4GzRhVydnLmwscqIFmd1PgNfuKTnbDuPbDr", "yhOmssllGzhB_J_-Jaek_M6jCiLK8eiBKhBjG5WF", "HjjWfb6PR9JE4Lqf8nCzTmi4fFu16shs62RH9rSg", "ESOYctF4cprMnlrq9_wAGg_9h4ySIzC-Q3Ermzvg", "6Hip2aFyGRkHcxVQTAGgiPI2D8uywInKZfqsBfoE"},
+		[]interface{}{"mE2k_mkwrNk4b252CyCYu2qUSfFfDyDVElTXquM_", "MjsIfgr8vMR3KZ-uu6SLDGKWJvrVuasRvNtHRcBk"},
+	}, whitelist[0]["slots"])
+	combinations := whitelist[0]["combinations"].(map[string]interface{})
+	assert.Len(t, combinations, 40)
+	assert.Contains(t, combinations, "814Iv538r_OnurYpii7tCNbieZRYaoNaraGW06GN")
+	assert.Equal(t, []interface{}{"IW9pTVDZwdhBGSzYH_70oCO0AWw2TsksiGVS18CZ", "6Hip2aFyGRkHcxVQTAGgiPI2D8uywInKZfqsBfoE", "MjsIfgr8vMR3KZ-uu6SLDGKWJvrVuasRvNtHRcBk"}, combinations["814Iv538r_OnurYpii7tCNbieZRYaoNaraGW06GN"])
+	delete(whitelist[0], "slots")
+	delete(whitelist[0], "combinations")
+
 	assert.Equal(t, map[string]interface{}{
 		"query": "SELECT * FROM orders AS o WHERE %{} ORDER BY o.%{} %{}",
 		"type": "select",
@@ -202,3 +217,95 @@ func TestConditionalFragments(t *testing.T) {
 	assert.Contains(t, code, `sql.merge({query: "7CsLDp-W4FRhLjxyFRuJYQ-0sqt1RvnyFvHc9MMG", text: "SELECT * FROM orders AS o WHERE %{} ORDER BY o.%{} %{}", params: {}}, filter, orderBy, orderByDir);`)
 	assert.Contains(t, code, `return fs.executeQuery(query, {lateBound});`)
 }
+
+func TestNestedFragmentCombinations(t *testing.T) {
+	result := build(map[string]string{
+		"/app.js": `
+		const dir = window.asc ? sql.p` + "`ASC`" + ` : sql.p` + "`DESC`" + `;
+		const order = window.byName ? sql.p` + "`ORDER BY name ${dir}`" + ` : sql.p` + "`ORDER BY id`" + `;
+		fs.executeQuery(sql` + "`SELECT * FROM users ${order}`" + `);
+		`,
+	}, nil)
+
+	assert.Empty(t, result.Errors)
+	whitelist := getClientWhitelist(&result)
+	assert.Len(t, whitelist, 1)
+
+	ids := map[string]string{}
+	var visit func(fragments []interface{})
+	visit = func(fragments []interface{}) {
+		for _, group := range fragments {
+			for _, f := range group.([]interface{}) {
+				fragment := f.(map[string]interface{})
+				ids[fragment["query"].(string)] = fragment["id"].(string)
+				if nested, ok := fragment["fragments"]; ok {
+					visit(nested.([]interface{}))
+				}
+			}
+		}
+	}
+	visit(whitelist[0]["fragments"].([]interface{}))
+	byName, byID, asc, desc := ids["ORDER BY name %{}"], ids["ORDER BY id"], ids["ASC"], ids["DESC"]
+
+	assert.Equal(t, []interface{}{[]interface{}{byName, byID}}, whitelist[0]["slots"])
+	nested := whitelist[0]["fragments"].([]interface{})[0].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, []interface{}{[]interface{}{asc, desc}}, nested["slots"])
+	assert.NotContains(t, nested, "combinations")
+
+	// The fragments in the slots of a chosen fragment follow it
+	combinations := []interface{}{}
+	for _, c := range whitelist[0]["combinations"].(map[string]interface{}) {
+		combinations = append(combinations, c)
+	}
+	assert.ElementsMatch(t, []interface{}{
+		[]interface{}{byName, asc},
+		[]interface{}{byName, desc},
+		[]interface{}{byID},
+	}, combinations)
+}
+
+func TestConditionalAfterNestedExpression(t *testing.T) {
+	// The tests are nested several levels deep, every level must be popped before the queries are visited
+	result := build(map[string]string{
+		"/app.js": `
+		fs.executeQuery(window.f([[window.a]]) ? sql` + "`select 1`" + ` : sql` + "`select 2`" + `);
+		function getQuery() {
+			return window.g(1, [window.b]) ? sql` + "`select 3`" + ` : sql` + "`select 4`" + `;
+		}
+		fs.executeQuery(getQuery());
+		`,
+	}, nil)
+
+	assert.Empty(t, result.Errors)
+	assert.Len(t, getClientWhitelist(&result), 4)
+}
+
+func TestTooManyFragmentCombinations(t *testing.T) {
+	// 13 slots of 2 fragments each is 8192 combinations, 12 is 4096
+	code := func(slots int) string {
+		var sb strings.Builder
+		var query strings.Builder
+		query.WriteString("SELECT id FROM users WHERE true")
+		for i := 0; i < slots; i++ {
+			fmt.Fprintf(&sb, "const f%d = window.f%d ? sql.p`AND a%d = 1` : sql.p`AND b%d = 1`;\n", i, i, i, i)
+			fmt.Fprintf(&query, " ${f%d}", i)
+		}
+		fmt.Fprintf(&sb, "fs.executeQuery(sql`%s`);\n", query.String())
+		return sb.String()
+	}
+
+	result := build(map[string]string{"/app.js": code(12)}, nil)
+	assert.Empty(t, result.Errors)
+	assert.Empty(t, result.Warnings)
+	assert.Len(t, getClientWhitelist(&result)[0]["combinations"], 4096)
+
+	result = build(map[string]string{"/app.js": code(13)}, nil)
+	assert.Empty(t, result.Errors)
+	assert.Equal(t, []string{
+		"query has more than 4096 combinations of fragments, so they aren't listed in the whitelist: the server only checks the fragments allowed in each slot",
+	}, errorTexts(result.Warnings))
+	assert.Equal(t, 14, result.Warnings[0].Location.Line)
+	whitelist := getClientWhitelist(&result)
+	assert.NotContains(t, whitelist[0], "combinations")
+	assert.Len(t, whitelist[0]["slots"], 13)
+}
@@ -14,7 +14,7 @@ func TestClientProcessEnvAdded(t *testing.T) {
 	assert.Empty(t, result.Errors)
 	whitelist := getClientWhitelist(&result)
 	assert.NotEmpty(t, whitelist)
-	assert.Len(t, result.OutputFiles, 3)
+	assert.Len(t, result.OutputFiles, 5) // including the source maps of both bundles
 
 	client := string(getOutFile(&result, "client.bundle.js"))
 	assert.Contains(t, client, `{query: "HbSIDVYUZouRpNqTjEqMHpRhuaDCgzscWVYbO5Nd", text: "select * from users where id = $1", params: {$1: "account-id"}}`)
@@ -36,7 +36,7 @@ func TestServerProcessEnvAdded(t *testing.T) {
 	serverWhitelist := getServerWhitelist(&result)
 	assert.NotEmpty(t, serverWhitelist)
 
-	assert.Len(t, result.OutputFiles, 3)
+	assert.Len(t, result.OutputFiles, 5)
 
 
 	client := string(getOutFile(&result, "client.bundle.js"))
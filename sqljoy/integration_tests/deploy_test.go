@@ -0,0 +1,125 @@
+package integration_tests
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/evanw/esbuild/pkg/api"
+	"github.com/stretchr/testify/assert"
+)
+
+const deployProg = `
+	const query = sql` + "`select * from users where id = ${window.id}`" + `;
+	export async function server(ctx) {
+		return ctx.executeQuery(sql` + "`select 1`" + `);
+	}
+	fs.executeQuery(query);
+	server(fs.beginTx());
+	`
+
+func readArtifact(t *testing.T, artifact []byte) map[string][]byte {
+	gz, err := gzip.NewReader(bytes.NewReader(artifact))
+	if !assert.NoError(t, err) {
+		return nil
+	}
+	files := map[string][]byte{}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if !assert.NoError(t, err) {
+			return nil
+		}
+		contents, _ := ioutil.ReadAll(tr)
+		files[header.Name] = contents
+	}
+	return files
+}
+
+func TestDeploy(t *testing.T) {
+	var opts *api.SQLJoyOptions
+	result := build(map[string]string{
+		"/app.js": deployProg,
+	}, func(o *api.SQLJoyOptions) {
+		opts = o
+	})
+	assert.Empty(t, result.Errors)
+
+	var artifact []byte
+	var headers http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = r.Header
+		artifact, _ = ioutil.ReadAll(r.Body)
+		signature := api.SignDeployArtifact("keepitsecretkeepitsafe", r.Header.Get(api.DeployTimestampHeader), artifact)
+		if signature != r.Header.Get(api.DeploySignatureHeader) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"version": "v42"}`))
+	}))
+	defer server.Close()
+
+	opts.DeployEndpoint = server.URL
+	deployed, err := api.DeployFlowState(opts, result)
+	assert.NoError(t, err)
+	assert.Equal(t, "v42", deployed.Version)
+	assert.NotEmpty(t, deployed.ArtifactVersion)
+
+	assert.Equal(t, "account-id", headers.Get(api.DeployAccountHeader))
+	assert.Equal(t, deployed.ArtifactVersion, headers.Get(api.DeployVersionHeader))
+
+	files := readArtifact(t, artifact)
+	assert.Equal(t, getOutFile(&result, "server.bundle.js"), files["server.bundle.js"])
+	assert.Equal(t, getOutFile(&result, "client-queries.json"), files["client-queries.json"])
+	assert.Equal(t, getOutFile(&result, "server-queries.json"), files["server-queries.json"])
+	assert.NotContains(t, files, "client.bundle.js")
+
+	manifest := map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal(files["manifest.json"], &manifest))
+	assert.Equal(t, "account-id", manifest["accountId"])
+	assert.Equal(t, deployed.ArtifactVersion, manifest["version"])
+	assert.Len(t, manifest["files"], 3)
+
+	// Deploying the same build again produces the same version
+	again, err := api.DeployFlowState(opts, result)
+	assert.NoError(t, err)
+	assert.Equal(t, deployed.ArtifactVersion, again.ArtifactVersion)
+}
+
+func TestDeployBadSecret(t *testing.T) {
+	var opts *api.SQLJoyOptions
+	result := build(map[string]string{
+		"/app.js": deployProg,
+	}, func(o *api.SQLJoyOptions) {
+		opts = o
+	})
+	assert.Empty(t, result.Errors)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		artifact, _ := ioutil.ReadAll(r.Body)
+		signature := api.SignDeployArtifact("keepitsecretkeepitsafe", r.Header.Get(api.DeployTimestampHeader), artifact)
+		if signature != r.Header.Get(api.DeploySignatureHeader) {
+			http.Error(w, "bad signature", http.StatusUnauthorized)
+			return
+		}
+	}))
+	defer server.Close()
+
+	opts.DeployEndpoint = server.URL
+	opts.AccountSecret = "wrong"
+	_, err := api.DeployFlowState(opts, result)
+	assert.EqualError(t, err, "upload failed: 401 Unauthorized: bad signature")
+
+	opts.DeployEndpoint = ""
+	_, err = api.DeployFlowState(opts, result)
+	assert.EqualError(t, err, "deployEndpoint is required in the config to deploy")
+}
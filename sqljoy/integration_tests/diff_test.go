@@ -0,0 +1,171 @@
+package integration_tests
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/evanw/esbuild/pkg/api"
+	"github.com/stretchr/testify/assert"
+)
+
+const previousApp = "fs.executeQuery(sql`SELECT * FROM users WHERE id = %{SESSION.user_id}`);\n" +
+	"fs.executeQuery(sql`SELECT 1`);\n" +
+	"fs.executeQuery(sql`SELECT 2`);\n"
+
+const currentApp = "fs.executeQuery(sql`SELECT * FROM users WHERE id = %{userId}`, {userId: 1});\n" +
+	"fs.executeQuery(sql`SELECT 3`);\n" +
+	"fs.executeQuery(sql`SELECT 1`);\n"
+
+// buildWithPrevious builds previousApp, then builds currentApp with the first build's whitelists in /previous
+func buildWithPrevious(modifyOpts func(opts *api.SQLJoyOptions)) api.BuildResult {
+	previous := build(map[string]string{"/app.js": previousApp}, nil)
+	if len(previous.Errors) != 0 {
+		panic(previous.Errors[0].Text)
+	}
+
+	return build(map[string]string{
+		"/app.js":                       currentApp,
+		"/previous/client-queries.json": string(getOutFile(&previous, "client-queries.json")),
+	}, func(opts *api.SQLJoyOptions) {
+		opts.PreviousBuild = "previous"
+		if modifyOpts != nil {
+			modifyOpts(opts)
+		}
+	}, "/app.js")
+}
+
+func getDiff(result *api.BuildResult) map[string]interface{} {
+	contents := getOutFile(result, "queries-diff.json")
+	if contents == nil {
+		return nil
+	}
+	data := map[string]interface{}{}
+	err := json.Unmarshal(contents, &data)
+	if err != nil {
+		panic(err.Error())
+	}
+	return data
+}
+
+func TestDiffNotEnabled(t *testing.T) {
+	result := build(map[string]string{
+		"/app.js": "fs.executeQuery(sql`SELECT 1`);\n",
+	}, nil)
+
+	assert.Empty(t, result.Errors)
+	assert.Nil(t, getOutFile(&result, "queries-diff.json"))
+}
+
+func TestDiffPreviousBuild(t *testing.T) {
+	result := buildWithPrevious(func(opts *api.SQLJoyOptions) {
+		opts.AllowMadePublic = true
+	})
+
+	assert.Empty(t, result.Errors)
+	diff := getDiff(&result)
+	assert.NotNil(t, diff)
+
+	added := diff["added"].([]interface{})
+	assert.Len(t, added, 1)
+	assert.Equal(t, "SELECT 3", added[0].(map[string]interface{})["query"])
+	assert.Equal(t, "client", added[0].(map[string]interface{})["whitelist"])
+
+	removed := diff["removed"].([]interface{})
+	assert.Len(t, removed, 1)
+	assert.Equal(t, "SELECT 2", removed[0].(map[string]interface{})["query"])
+
+	changed := diff["changed"].([]interface{})
+	assert.Len(t, changed, 1)
+	change := changed[0].(map[string]interface{})
+	assert.Equal(t, true, change["madePublic"])
+	assert.Equal(t, false, change["previous"].(map[string]interface{})["isPublic"])
+	assert.Equal(t, "SELECT * FROM users WHERE id = $1", change["current"].(map[string]interface{})["query"])
+	assert.Equal(t, map[string]interface{}{"line": 1.0, "fileName": "app.js"}, change["current"].(map[string]interface{})["definedAt"])
+
+	moved := diff["moved"].([]interface{})
+	assert.Len(t, moved, 1)
+	move := moved[0].(map[string]interface{})
+	assert.Equal(t, 2.0, move["previous"].(map[string]interface{})["definedAt"].(map[string]interface{})["line"])
+	assert.Equal(t, 3.0, move["current"].(map[string]interface{})["definedAt"].(map[string]interface{})["line"])
+
+	assert.Equal(t, 0.0, diff["unchanged"])
+}
+
+func TestDiffMadePublic(t *testing.T) {
+	result := buildWithPrevious(nil)
+
+	assert.Equal(t, []string{
+		"This query was private in the previous build (app.js:1) and is now public, set allowMadePublic to allow this",
+	}, errorTexts(result.Errors))
+}
+
+func TestDiffMadePublicAndMoved(t *testing.T) {
+	previous := build(map[string]string{
+		"/app.js": "fs.executeQuery(sql`SELECT name FROM users WHERE id = %{SESSION.user_id}`);\n" +
+			"fs.executeQuery(sql`SELECT 1`);\n",
+	}, nil)
+	assert.Empty(t, previous.Errors)
+
+	// The private query is made public by replacing the server var with a param, and moved
+	// below another query, so it isn't matched by its id or where it's defined
+	current := func(allowMadePublic bool) api.BuildResult {
+		return build(map[string]string{
+			"/app.js": "fs.executeQuery(sql`SELECT 5 WHERE 1 = %{SESSION.x}`);\n" +
+				"fs.executeQuery(sql`SELECT 1`);\n" +
+				"fs.executeQuery(sql`SELECT name FROM users WHERE id = %{userId}`, {userId: 1});\n",
+			"/previous/client-queries.json": string(getOutFile(&previous, "client-queries.json")),
+		}, func(opts *api.SQLJoyOptions) {
+			opts.PreviousBuild = "previous"
+			opts.AllowMadePublic = allowMadePublic
+		}, "/app.js")
+	}
+
+	result := current(false)
+	assert.Equal(t, []string{
+		"This query was private in the previous build (app.js:1) and is now public, set allowMadePublic to allow this",
+	}, errorTexts(result.Errors))
+	assert.Equal(t, 3, result.Errors[0].Location.Line)
+
+	result = current(true)
+	assert.Empty(t, result.Errors)
+	diff := getDiff(&result)
+	assert.Empty(t, diff["added"])
+	madePublic := 0
+	for _, c := range diff["changed"].([]interface{}) {
+		change := c.(map[string]interface{})
+		if change["madePublic"] == true {
+			madePublic++
+			assert.Equal(t, "SELECT name FROM users WHERE id = $1", change["current"].(map[string]interface{})["query"])
+		}
+	}
+	assert.Equal(t, 1, madePublic)
+}
+
+func TestDiffUnchanged(t *testing.T) {
+	previous := build(map[string]string{"/app.js": previousApp}, nil)
+	result := build(map[string]string{
+		"/app.js":                       previousApp,
+		"/previous/client-queries.json": string(getOutFile(&previous, "client-queries.json")),
+	}, func(opts *api.SQLJoyOptions) {
+		opts.PreviousBuild = "/previous"
+	}, "/app.js")
+
+	assert.Empty(t, result.Errors)
+	assert.Equal(t, map[string]interface{}{
+		"added":     []interface{}{},
+		"removed":   []interface{}{},
+		"changed":   []interface{}{},
+		"moved":     []interface{}{},
+		"unchanged": 3.0,
+	}, getDiff(&result))
+}
+
+func TestDiffMissingPreviousBuild(t *testing.T) {
+	result := build(map[string]string{
+		"/app.js": "fs.executeQuery(sql`SELECT 1`);\n",
+	}, func(opts *api.SQLJoyOptions) {
+		opts.PreviousBuild = "previous"
+	})
+
+	assert.Equal(t, []string{`No query whitelists found in previous build "previous"`}, errorTexts(result.Errors))
+}
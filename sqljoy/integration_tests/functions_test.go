@@ -0,0 +1,286 @@
+package integration_tests
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueryFromFunction(t *testing.T) {
+	result := build(map[string]string{
+		"/app.js": `
+		function makeQuery() {
+			return sql` + "`-- knowable at compile time`" + `;
+		}
+
+		window.foo = function(){
+			const query = makeQuery();
+			fs.executeQuery(query);
+		}
+		`,
+	}, nil)
+
+	assert.Empty(t, result.Errors)
+	whitelist := getClientWhitelist(&result)
+	assert.Len(t, whitelist, 1)
+	assert.Equal(t, "-- knowable at compile time", whitelist[0]["query"])
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{
+			"line": 8.0,
+			"fileName": "app.js",
+		},
+	}, whitelist[0]["usages"])
+
+	code := string(getOutFile(&result, "client.bundle.js"))
+	assert.Contains(t, code, `return {query: "`+whitelist[0]["id"].(string)+`", text: "-- knowable at compile time", params: {}};`)
+}
+
+func TestQueriesFromFunctionBranches(t *testing.T) {
+	result := build(map[string]string{
+		"/app.js": `
+		const byId = sql` + "`select * from users where id = ${window.id}`" + `;
+		const makeQuery = (all) => all ? sql` + "`select * from users`" + ` : byId;
+		function pickQuery(kind) {
+			if (kind === 1) {
+				return makeQuery(true);
+			}
+			switch (kind) {
+			case 2:
+				return sql` + "`select * from orders`" + `;
+			}
+			return byId;
+		}
+		fs.executeQuery(pickQuery(window.kind));
+		`,
+	}, nil)
+
+	assert.Empty(t, result.Errors)
+	whitelist := getClientWhitelist(&result)
+	assert.Len(t, whitelist, 3)
+	queries := []string{}
+	for _, q := range whitelist {
+		queries = append(queries, q["query"].(string))
+	}
+	assert.ElementsMatch(t, []string{
+		"select * from users where id = $1",
+		"select * from users",
+		"select * from orders",
+	}, queries)
+}
+
+func TestQueryFromImportedFunction(t *testing.T) {
+	result := build(map[string]string{
+		"/app.js": "import {makeQuery} from \"./queries\";\nimport * as ns from \"./queries\";\nfs.executeQuery(makeQuery(1));\nfs.executeQuery(ns.makeQuery(2));\n",
+		"/queries.js": "export function makeQuery(id) {\n\treturn sql`select * from users where id = ${id}`;\n}\n",
+	}, nil, "/app.js")
+
+	assert.Empty(t, result.Errors)
+	whitelist := getClientWhitelist(&result)
+	assert.Len(t, whitelist, 1)
+	assert.Equal(t, "select * from users where id = $1", whitelist[0]["query"])
+	assert.Equal(t, 2.0, whitelist[0]["clientReferences"])
+
+	code := string(getOutFile(&result, "client.bundle.js"))
+	assert.Contains(t, code, `params: {id}};`)
+}
+
+func TestFunctionNotReturningQuery(t *testing.T) {
+	result := build(map[string]string{
+		"/app.js": `
+		function makeQuery(raw) {
+			if (raw) {
+				return raw;
+			}
+			return sql` + "`select 1`" + `;
+		}
+		async function asyncQuery() {
+			return sql` + "`select 3`" + `;
+		}
+		fs.executeQuery(makeQuery(window.raw));
+		fs.executeQuery(asyncQuery());
+		`,
+	}, nil)
+
+	assert.Len(t, result.Errors, 2)
+	for _, msg := range result.Errors {
+		assert.Equal(t, "could not identify query for first argument to executeQuery", msg.Text)
+	}
+}
+
+func TestRecursiveQueryFunction(t *testing.T) {
+	// A recursive call returns one of the queries returned by the other branches
+	result := build(map[string]string{
+		"/app.js": `
+		function recursive(n) {
+			return n ? recursive(n - 1) : sql` + "`select 2`" + `;
+		}
+		fs.executeQuery(recursive(3));
+		`,
+	}, nil)
+
+	assert.Empty(t, result.Errors)
+	whitelist := getClientWhitelist(&result)
+	assert.Len(t, whitelist, 1)
+	assert.Equal(t, "select 2", whitelist[0]["query"])
+}
+
+func TestRecursiveQueryFunctionReturns(t *testing.T) {
+	// A return statement that only calls the function itself, or a function calling it back, is skipped
+	result := build(map[string]string{
+		"/app.js": `
+		function recursive(n) {
+			if (n > 0) {
+				return recursive(n - 1);
+			}
+			return sql` + "`select 1`" + `;
+		}
+		function even(n) {
+			if (n > 0) {
+				return odd(n - 1);
+			}
+			return sql` + "`select 2`" + `;
+		}
+		function odd(n) {
+			if (n > 0) {
+				return even(n - 1);
+			}
+			return sql` + "`select 3`" + `;
+		}
+		fs.executeQuery(recursive(3));
+		fs.executeQuery(even(3));
+		`,
+	}, nil)
+
+	assert.Empty(t, result.Errors)
+	queries := []string{}
+	for _, q := range getClientWhitelist(&result) {
+		queries = append(queries, q["query"].(string))
+	}
+	assert.ElementsMatch(t, []string{"select 1", "select 2", "select 3"}, queries)
+}
+
+func TestQueryFromFunctionParam(t *testing.T) {
+	result := build(map[string]string{
+		"/app.js": "import {run} from \"./db\";\nimport \"./other\";\nconst byId = sql`select * from users where id = ${window.id}`;\nrun(byId);\nrun(sql`select 1`, {});\n",
+		"/other.js": "import * as db from \"./db\";\ndb.run(window.all ? sql`select * from users` : sql`select 1`);\n",
+		"/db.js": "export function run(q, params) {\n\treturn fs.executeQuery(q, params);\n}\n",
+	}, nil, "/app.js")
+
+	assert.Empty(t, result.Errors)
+	whitelist := getClientWhitelist(&result)
+	queries := []string{}
+	for _, q := range whitelist {
+		queries = append(queries, q["query"].(string))
+		assert.Equal(t, "db.js", q["usages"].([]interface{})[0].(map[string]interface{})["fileName"])
+	}
+	assert.ElementsMatch(t, []string{
+		"select * from users where id = $1",
+		"select 1",
+		"select 1",
+		"select * from users",
+	}, queries)
+}
+
+func TestQueryFromImmediatelyCalledFunction(t *testing.T) {
+	result := build(map[string]string{
+		"/app.js": `
+		(function(query){
+			return fs.executeQuery(query);
+		})(sql` + "`select 1`" + `);
+		((query = sql` + "`select 2`" + `) => fs.executeQuery(query))();
+		`,
+	}, nil)
+
+	assert.Empty(t, result.Errors)
+	whitelist := getClientWhitelist(&result)
+	assert.Len(t, whitelist, 2)
+}
+
+func TestQueryFromRecursiveFunctionParam(t *testing.T) {
+	result := build(map[string]string{
+		"/app.js": `
+		function retry(query, n) {
+			return fs.executeQuery(query).catch(() => n > 0 && retry(query, n - 1));
+		}
+		retry(sql` + "`select 1`" + `, 3);
+		`,
+	}, nil)
+
+	assert.Empty(t, result.Errors)
+	assert.Len(t, getClientWhitelist(&result), 1)
+}
+
+func TestUntraceableFunctionParam(t *testing.T) {
+	result := build(map[string]string{
+		"/app.js": `
+		function run(query) {
+			return fs.executeQuery(query);
+		}
+		run(sql` + "`select 1`" + `);
+		run(window.query);
+
+		function runEach(query) {
+			return fs.executeQuery(query);
+		}
+		runEach(sql` + "`select 2`" + `);
+		[sql` + "`select 3`" + `].forEach(runEach);
+		`,
+	}, nil)
+
+	assert.Equal(t, []string{
+		"could not identify query for first argument to executeQuery",
+		"could not identify query for first argument to executeQuery",
+	}, errorTexts(result.Errors))
+	assert.Equal(t, 3, result.Errors[0].Location.Line)
+	assert.Equal(t, 9, result.Errors[1].Location.Line)
+}
+
+func TestQueryFromFunctionParamDuplicates(t *testing.T) {
+	// The queries are defined on the same line, so they're not sorted by where they're defined
+	result := build(map[string]string{
+		"/app.js": `
+		const a = sql` + "`select 1`" + `, b = sql` + "`select 2`" + `, c = sql` + "`select 3`" + `;
+		function run(query) {
+			return fs.executeQuery(query);
+		}
+		run(window.x ? a : b);
+		run(window.y ? c : a);
+		run(window.z ? b : c);
+		`,
+	}, nil)
+
+	assert.Empty(t, result.Errors)
+	whitelist := getClientWhitelist(&result)
+	assert.Len(t, whitelist, 3)
+	for _, q := range whitelist {
+		assert.Equal(t, 1.0, q["clientReferences"], q["query"])
+	}
+}
+
+func TestQueryFromExportedFunctionParam(t *testing.T) {
+	result := build(map[string]string{
+		"/app.js": "export {run} from \"./db\";\nimport {run} from \"./db\";\nrun(sql`select 1`);\nexport function local(q) {\n\treturn fs.executeQuery(q);\n}\nlocal(sql`select 2`);\n",
+		"/db.js": "export function run(query) {\n\treturn fs.executeQuery(query);\n}\n",
+	}, nil, "/app.js")
+
+	// The queries passed inside the bundle are still compiled
+	assert.Empty(t, result.Errors)
+	assert.Len(t, getClientWhitelist(&result), 2)
+	assert.Equal(t, []string{
+		"only the queries passed to local inside the bundle are compiled: it's exported from the entry point app.js, so it can be called from outside the bundle",
+		"only the queries passed to run inside the bundle are compiled: it's exported from the entry point app.js, so it can be called from outside the bundle",
+	}, errorTexts(result.Warnings))
+	assert.Equal(t, "db.js", result.Warnings[1].Location.File)
+	assert.Equal(t, 1, result.Warnings[1].Location.Line)
+}
+
+func TestInterpolatedExportedFunctionParam(t *testing.T) {
+	result := build(map[string]string{
+		"/app.js": "export function getUser(id) {\n\treturn fs.executeQuery(sql`select name from users where id = ${id}`);\n}\ngetUser(1);\n",
+	}, nil, "/app.js")
+
+	// Interpolated params are bound as values, so they don't need a warning
+	assert.Empty(t, result.Errors)
+	assert.Empty(t, result.Warnings)
+	assert.Len(t, getClientWhitelist(&result), 1)
+}
@@ -0,0 +1,101 @@
+package integration_tests
+
+import (
+	"testing"
+
+	"github.com/evanw/esbuild/pkg/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func withNormalizedIds(opts *api.SQLJoyOptions) {
+	opts.NormalizeQueryIds = true
+}
+
+func buildQueryId(t *testing.T, query string, modifyOpts func(opts *api.SQLJoyOptions)) (string, string) {
+	result := build(map[string]string{
+		"/app.js": "fs.executeQuery(sql`" + query + "`);\n",
+	}, modifyOpts)
+
+	assert.Empty(t, result.Errors)
+	whitelist := getClientWhitelist(&result)
+	if !assert.Len(t, whitelist, 1) {
+		return "", ""
+	}
+	return whitelist[0]["id"].(string), whitelist[0]["query"].(string)
+}
+
+const formattedQuery = "SELECT id, name\n\tFROM users -- all users\n\tWHERE id = ${window.id} AND name = 'Bob'"
+const reformattedQuery = "select id, name from Users /* the /* nested */ comment */ where id = ${window.id}   and NAME = 'Bob'"
+
+func TestQueryIdsNotNormalized(t *testing.T) {
+	id, _ := buildQueryId(t, formattedQuery, nil)
+	otherId, _ := buildQueryId(t, reformattedQuery, nil)
+	assert.NotEqual(t, id, otherId)
+}
+
+func TestNormalizedQueryIds(t *testing.T) {
+	id, text := buildQueryId(t, formattedQuery, withNormalizedIds)
+	otherId, otherText := buildQueryId(t, reformattedQuery, withNormalizedIds)
+	assert.Equal(t, id, otherId)
+
+	// The whitelist keeps the literal text
+	assert.Equal(t, "SELECT id, name\n\tFROM users -- all users\n\tWHERE id = $1 AND name = 'Bob'", text)
+	assert.NotEqual(t, text, otherText)
+}
+
+func TestNormalizedQueryIdsKeepLiterals(t *testing.T) {
+	id, _ := buildQueryId(t, formattedQuery, withNormalizedIds)
+
+	for _, query := range []string{
+		"SELECT id, name FROM users WHERE id = ${window.id} AND name = 'bob'",
+		"SELECT id, name FROM users WHERE id = ${window.id} AND name = 'Bob  '",
+		"SELECT id, name FROM \"Users\" WHERE id = ${window.id} AND name = 'Bob'",
+		"SELECT id, name FROM users WHERE id = ${window.id} AND name = $$Bob$$",
+		"SELECT id, name FROM users WHERE id = ${window.id} AND name = '-- Bob'",
+	} {
+		otherId, _ := buildQueryId(t, query, withNormalizedIds)
+		assert.NotEqual(t, id, otherId, query)
+	}
+}
+
+func TestNormalizedFragmentIds(t *testing.T) {
+	whitelist := func(fragment string) interface{} {
+		result := build(map[string]string{
+			"/app.js": "const filter = window.f ? sql.p`" + fragment + "` : sql.p`AND true`;\nfs.executeQuery(sql`SELECT * FROM users WHERE id > 0 ${filter}`);\n",
+		}, withNormalizedIds)
+		assert.Empty(t, result.Errors)
+		return getClientWhitelist(&result)[0]["combinations"]
+	}
+
+	combinations := whitelist("AND active AND age > 18")
+	assert.NotEmpty(t, combinations)
+	assert.Equal(t, combinations, whitelist("and ACTIVE and\n  age > 18 -- adults"))
+	assert.NotEqual(t, combinations, whitelist("AND active AND age > 21"))
+}
+
+func TestNormalizedQueryIdsKeepAnnotations(t *testing.T) {
+	id, _ := buildQueryId(t, "-- @roles admin\nSELECT id FROM users WHERE id = ${window.id}", withNormalizedIds)
+	for _, query := range []string{
+		"-- @roles user\nSELECT id FROM users WHERE id = ${window.id}",
+		"-- @roles admin\n-- @maxRows 10\nSELECT id FROM users WHERE id = ${window.id}",
+		"SELECT id FROM users WHERE id = ${window.id}",
+	} {
+		otherId, _ := buildQueryId(t, query, withNormalizedIds)
+		assert.NotEqual(t, id, otherId, query)
+	}
+
+	// The annotations are normalized too
+	otherId, _ := buildQueryId(t, "--  @roles   admin\n  -- a comment\nselect ID from USERS where id = ${window.id}", withNormalizedIds)
+	assert.Equal(t, id, otherId)
+}
+
+func TestNormalizedQueryIdsEscapeStrings(t *testing.T) {
+	// The backslash only escapes the quote in an E'' string, not after a keyword ending in E
+	id, _ := buildQueryId(t, "SELECT id FROM users WHERE name LIKE'a\\' AND ID = ${window.id}", withNormalizedIds)
+	otherId, _ := buildQueryId(t, "select id from users where name like'a\\' and id = ${window.id}", withNormalizedIds)
+	assert.Equal(t, id, otherId)
+
+	id, _ = buildQueryId(t, "SELECT id FROM users WHERE name = E'it\\'s' AND ID = ${window.id}", withNormalizedIds)
+	otherId, _ = buildQueryId(t, "select id from users where name = e'it\\'s' and id = ${window.id}", withNormalizedIds)
+	assert.Equal(t, id, otherId)
+}
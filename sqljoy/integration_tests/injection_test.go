@@ -0,0 +1,141 @@
+package integration_tests
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/evanw/esbuild/pkg/api"
+	"github.com/stretchr/testify/assert"
+)
+
+// noteTexts returns the notes of a message as "line: text"
+func noteTexts(msg api.Message) []string {
+	texts := []string{}
+	for _, note := range msg.Notes {
+		line := 0
+		if note.Location != nil {
+			line = note.Location.Line
+		}
+		texts = append(texts, fmt.Sprintf("%d: %s", line, note.Text))
+	}
+	return texts
+}
+
+func TestInjectionUntaggedTemplate(t *testing.T) {
+	result := build(map[string]string{
+		"/app.js": `
+fs.executeQuery(` + "`select * from users where name = '${window.name}' and id = ${window.id}`" + `);
+`,
+	}, nil)
+
+	if assert.Len(t, result.Errors, 1) {
+		msg := result.Errors[0]
+		assert.Equal(t, "query built by a template literal without the sql tag, which may allow SQL injection: use the sql`` template tag with ${} params instead [untagged-sql]", msg.Text)
+		assert.Equal(t, 2, msg.Location.Line)
+		assert.Equal(t, []string{
+			"2: window.name is inserted into the SQL string here",
+			"2: window.id is inserted into the SQL string here",
+		}, noteTexts(msg))
+	}
+}
+
+func TestInjectionReplace(t *testing.T) {
+	result := build(map[string]string{
+		"/app.js": `
+fs.executeQuery("select * from users where name = ':name'".replace(":name", getName()));
+`,
+	}, nil)
+
+	if assert.Len(t, result.Errors, 1) {
+		msg := result.Errors[0]
+		assert.Equal(t, "query built by String.replace(), which may allow SQL injection: use the sql`` template tag with ${} params instead [untagged-sql]", msg.Text)
+		assert.Equal(t, []string{"2: getName() is inserted into the SQL string here"}, noteTexts(msg))
+	}
+}
+
+func TestInjectionDataFlow(t *testing.T) {
+	result := build(map[string]string{
+		"/app.js": `
+const name = window.name;
+const where = "where name = '" + name + "'";
+const query = ` + "`select * from users ${where}`" + `;
+
+fs.executeQuery(query);
+`,
+	}, nil)
+
+	// The notes follow the string from the tainted values to the query
+	if assert.Len(t, result.Errors, 1) {
+		msg := result.Errors[0]
+		assert.Equal(t, "query built by a template literal without the sql tag, which may allow SQL injection: use the sql`` template tag with ${} params instead [untagged-sql]", msg.Text)
+		assert.Equal(t, 6, msg.Location.Line)
+		assert.Equal(t, []string{
+			"4: where is inserted into the SQL string here",
+			"4: The SQL string is assigned to query here",
+		}, noteTexts(msg))
+	}
+}
+
+func TestInjectionQueryPart(t *testing.T) {
+	result := build(map[string]string{
+		"/app.js": `
+fs.executeQuery(sql` + "`select id from users ${sql.p(`order by ${window.column}`)}`" + `);
+`,
+	}, nil)
+
+	if assert.Len(t, result.Errors, 1) {
+		msg := result.Errors[0]
+		assert.Equal(t, "query part built by a template literal without the sql tag, which may allow SQL injection: use sql.p`` with ${} params instead [untagged-sql]", msg.Text)
+		assert.Equal(t, []string{"2: window.column is inserted into the SQL string here"}, noteTexts(msg))
+	}
+}
+
+func TestInjectionConstantString(t *testing.T) {
+	result := build(map[string]string{
+		"/app.js": `
+fs.executeQuery("select 1");
+`,
+	}, nil)
+
+	// There's no injection, but the query can't be compiled either
+	assert.Equal(t, []string{"could not identify query for first argument to executeQuery"}, errorTexts(result.Errors))
+}
+
+func TestInjectionStringParam(t *testing.T) {
+	result := build(map[string]string{
+		"/app.js": `
+const filter = ` + "`name = '${window.name}'`" + `;
+fs.executeQuery(sql` + "`select id from users where ${filter}`" + `);
+fs.executeQuery(sql` + "`select id from users where ${`id = ${window.id}`}`" + `);
+fs.executeQuery(sql` + "`select id from users where name like ${`%${window.search}%`}`" + `);
+fs.executeQuery(sql` + "`select id from users where name = ${window.name + ''}`" + `);
+`,
+	}, nil)
+
+	// A LIKE pattern is data, not SQL
+	assert.Empty(t, result.Errors)
+	msg := "interpolated value is SQL built by a template literal without the sql tag, it's sent as a single parameter value and not as part of the query: use sql.p`` to build a query part [string-sql-param]"
+	if assert.Equal(t, []string{msg, msg}, errorTexts(result.Warnings)) {
+		assert.Equal(t, 3, result.Warnings[0].Location.Line)
+		assert.Equal(t, []string{
+			"2: window.name is inserted into the SQL string here",
+			"2: The SQL string is assigned to filter here",
+		}, noteTexts(result.Warnings[0]))
+		assert.Equal(t, 4, result.Warnings[1].Location.Line)
+	}
+}
+
+func TestInjectionLintConfig(t *testing.T) {
+	result := build(map[string]string{
+		"/app.js": `
+fs.executeQuery(` + "`select * from users where name = '${window.name}'`" + `);
+fs.executeQuery(sql` + "`select id from users where ${`id = ${window.id}`}`" + `);
+`,
+	}, lintRules(map[string]api.LintSeverity{"untagged-sql": api.LintWarning, "string-sql-param": api.LintOff}))
+
+	// The query still can't be compiled
+	assert.Equal(t, []string{"could not identify query for first argument to executeQuery"}, errorTexts(result.Errors))
+	assert.Equal(t, []string{
+		"query built by a template literal without the sql tag, which may allow SQL injection: use the sql`` template tag with ${} params instead [untagged-sql]",
+	}, errorTexts(result.Warnings))
+}
@@ -30,7 +30,7 @@ func TestBareBeginTx(t *testing.T) {
 	}, nil)
 
 	assert.Empty(t, result.Errors)
-	assert.Len(t, result.OutputFiles, 2)
+	assert.Len(t, result.OutputFiles, 4)
 
 	server := string(getOutFile(&result, "server.bundle.js"))
 	assert.NotContains(t, server, "return 42;")
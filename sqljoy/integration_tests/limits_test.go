@@ -0,0 +1,56 @@
+package integration_tests
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueryLimits(t *testing.T) {
+	result := build(map[string]string{
+		"/app.js": `
+		fs.executeQuery(sql` + "`-- @roles admin\n-- @maxRows 100\n-- @timeout 2.5s\n-- @rate 10/min\nSELECT * FROM users`" + `);
+		fs.executeQuery(sql` + "`-- @rate 3 / 30s\nSELECT 1`" + `);
+		fs.executeQuery(sql` + "`SELECT 2`" + `);
+		`,
+	}, nil)
+
+	assert.Empty(t, result.Errors)
+	whitelist := getClientWhitelist(&result)
+	assert.Len(t, whitelist, 3)
+	assert.Equal(t, map[string]interface{}{
+		"maxRows":   100.0,
+		"timeoutMs": 2500.0,
+		"ratePerSession": map[string]interface{}{
+			"count":    10.0,
+			"periodMs": 60000.0,
+		},
+	}, whitelist[0]["limits"])
+	assert.Equal(t, map[string]interface{}{
+		"ratePerSession": map[string]interface{}{
+			"count":    3.0,
+			"periodMs": 30000.0,
+		},
+	}, whitelist[1]["limits"])
+	assert.Nil(t, whitelist[2]["limits"])
+}
+
+func TestInvalidQueryLimits(t *testing.T) {
+	result := build(map[string]string{
+		"/app.js": `
+		fs.executeQuery(sql` + "`-- @maxRows lots\nSELECT 1`" + `);
+		fs.executeQuery(sql` + "`-- @timeout 0s\nSELECT 2`" + `);
+		fs.executeQuery(sql` + "`-- @rate 10\nSELECT 3`" + `);
+		fs.executeQuery(sql` + "`-- @rate 10/fortnight\nSELECT 4`" + `);
+		fs.executeQuery(sql` + "`-- @maxRows 10\n-- @maxRows 20\nSELECT 5`" + `);
+		`,
+	}, nil)
+
+	assert.Equal(t, []string{
+		`invalid @maxRows annotation: "lots" is not a positive integer`,
+		`invalid @timeout annotation: "0s" is not a duration of at least 1ms, e.g. 5s`,
+		`invalid @rate annotation: "10" is not a rate, e.g. 10/min`,
+		`invalid @rate annotation: "fortnight" is not a period, e.g. min or 30s`,
+		`invalid @maxRows annotation: duplicate annotation`,
+	}, errorTexts(result.Errors))
+}
@@ -0,0 +1,157 @@
+package integration_tests
+
+import (
+	"testing"
+
+	"github.com/evanw/esbuild/pkg/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func lintRules(rules map[string]api.LintSeverity) func(opts *api.SQLJoyOptions) {
+	return func(opts *api.SQLJoyOptions) {
+		opts.Lint = rules
+	}
+}
+
+const unusedQueries = `
+const unused = sql` + "`select id from users`" + `;
+const part = sql.p` + "`where id = 1`" + `;
+`
+
+func TestLintUnused(t *testing.T) {
+	result := build(map[string]string{
+		"/app.js": unusedQueries,
+	}, nil)
+
+	assert.Empty(t, result.Errors)
+	assert.Equal(t, []string{
+		"query is unused [unused-query]",
+		"fragment is unused [unused-fragment]",
+	}, errorTexts(result.Warnings))
+}
+
+func TestLintSeverity(t *testing.T) {
+	result := build(map[string]string{
+		"/app.js": unusedQueries,
+	}, lintRules(map[string]api.LintSeverity{
+		"unused-query":    api.LintError,
+		"unused-fragment": api.LintOff,
+	}))
+
+	assert.Equal(t, []string{"query is unused [unused-query]"}, errorTexts(result.Errors))
+	assert.Empty(t, result.Warnings)
+}
+
+func TestLintIgnoreComments(t *testing.T) {
+	result := build(map[string]string{
+		"/app.js": `
+const unused = sql` + "`select id from users`" + `; // sjc-ignore unused-query
+// sjc-ignore unused-fragment, unused-query
+const part = sql.p` + "`where id = 1`" + `;
+// sjc-ignore unused-query
+window.x = 1;
+const unused2 = sql` + "`select name from users`" + `;
+const unused3 = sql` + "`select email from users`" + `; // sjc-ignore select-star
+`,
+	}, nil)
+
+	// The comment must be on the line, or a comment line right before it, and name the rule
+	assert.Empty(t, result.Errors)
+	assert.Equal(t, []string{
+		"query is unused [unused-query]",
+		"query is unused [unused-query]",
+	}, errorTexts(result.Warnings))
+	assert.Equal(t, 7, result.Warnings[0].Location.Line)
+	assert.Equal(t, 8, result.Warnings[1].Location.Line)
+}
+
+func TestLintSelectStar(t *testing.T) {
+	result := build(map[string]string{
+		"/app.js": `
+fs.executeQuery(sql` + "`select * from users`" + `);
+fs.executeQuery(sql` + "`select u.* from users u union select 1`" + `);
+fs.executeQuery(sql` + "`table users`" + `);
+fs.executeQuery(sql` + "`select id from users where exists (select * from orders)`" + `);
+fs.executeQuery(sql` + "`select * from users where id = %{SESSION.userId}`" + `);
+
+export async function getUsers(ctx) {
+	return ctx.executeQuery(sql` + "`select * from users where id = %{SESSION.userId}`" + `);
+}
+`,
+	}, nil)
+
+	// Private queries can select * since they're only run with the server's variables
+	assert.Empty(t, result.Errors)
+	msg := "public query selects *, list the columns so columns added to the table later aren't exposed to the client [select-star]"
+	assert.Equal(t, []string{msg, msg, msg}, errorTexts(result.Warnings))
+	assert.Equal(t, 2, result.Warnings[0].Location.Line)
+	assert.Equal(t, 3, result.Warnings[1].Location.Line)
+	assert.Equal(t, 4, result.Warnings[2].Location.Line)
+}
+
+func TestLintUnfilteredWrite(t *testing.T) {
+	result := build(map[string]string{
+		"/app.js": `
+fs.executeQuery(sql` + "`update users set name = ${window.name}`" + `);
+fs.executeQuery(sql` + "`delete from users`" + `);
+fs.executeQuery(sql` + "`update users set name = ${window.name} where id = ${window.id}`" + `);
+fs.executeQuery(sql` + "`delete from users where id = ${window.id}`" + `);
+const byId = sql.p` + "`where id = ${window.id}`" + `;
+const byName = sql.p` + "`where name = ${window.name}`" + `;
+fs.executeQuery(sql` + "`delete from users ${window.byId ? byId : byName}`" + `);
+`,
+	}, nil)
+
+	// A fragment slot could hold the WHERE clause
+	assert.Empty(t, result.Errors)
+	assert.Equal(t, []string{
+		"UPDATE of users has no WHERE clause, every row is updated [unfiltered-write]",
+		"DELETE from users has no WHERE clause, every row is deleted [unfiltered-write]",
+	}, errorTexts(result.Warnings))
+}
+
+func TestLintConcatenatedSQL(t *testing.T) {
+	result := build(map[string]string{
+		"/app.js": `
+fs.executeQuery("select * from users where name = '" + window.name + "'");
+const query = "select * from " + window.table;
+fs.executeQuery(query);
+fs.executeQuery(sql` + "`select id from users ${sql.p(\"order by \" + window.column)}`" + `);
+fs.executeQuery(sql` + "`select id from users ${sql.p(window.orderBy)}`" + `);
+`,
+	}, nil)
+
+	assert.Equal(t, []string{
+		"query built by string concatenation, which may allow SQL injection: use the sql`` template tag with ${} params instead [concatenated-sql]",
+		"query built by string concatenation, which may allow SQL injection: use the sql`` template tag with ${} params instead [concatenated-sql]",
+		"query part built by string concatenation, which may allow SQL injection: use sql.p`` with ${} params instead [concatenated-sql]",
+	}, errorTexts(result.Errors))
+	assert.Equal(t, 2, result.Errors[0].Location.Line)
+	assert.Equal(t, 4, result.Errors[1].Location.Line)
+	assert.Equal(t, 5, result.Errors[2].Location.Line)
+}
+
+func TestLintConcatenatedSQLOff(t *testing.T) {
+	result := build(map[string]string{
+		"/app.js": `
+fs.executeQuery("select * from users where name = '" + window.name + "'");
+fs.executeQuery(sql` + "`select id from users ${sql.p(\"order by \" + window.column)}`" + `);
+`,
+	}, lintRules(map[string]api.LintSeverity{"concatenated-sql": api.LintOff}))
+
+	// The query still can't be compiled
+	assert.Equal(t, []string{"could not identify query for first argument to executeQuery"}, errorTexts(result.Errors))
+	assert.Empty(t, result.Warnings)
+}
+
+func TestLintConfig(t *testing.T) {
+	opts, err := api.NewSQLJoyOptions([]byte(`{"lint": {"select-star": "off", "unfiltered-write": "error"}, "client": {"entryPoints": ["app.js"]}}`), nil, "build")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]api.LintSeverity{"select-star": api.LintOff, "unfiltered-write": api.LintError}, opts.Lint)
+
+	_, err = api.NewSQLJoyOptions([]byte(`{"lint": {"no-such-rule": "error"}}`), nil, "build")
+	assert.EqualError(t, err, `Invalid lint rule: "no-such-rule"`)
+
+	_, err = api.NewSQLJoyOptions([]byte(`{"lint": {"select-star": "warn"}}`), nil, "build")
+	assert.EqualError(t, err, `Invalid severity for lint rule select-star: "warn" (valid: off, warning, error)`)
+}
@@ -0,0 +1,130 @@
+package integration_tests
+
+import (
+	"testing"
+
+	"github.com/evanw/esbuild/pkg/api"
+	"github.com/stretchr/testify/assert"
+)
+
+const markedActions = `
+export async function deleteUser(ctx, id) {
+	return "deleted " + id;
+}
+
+export function formatId(id) {
+	return "user " + id;
+}
+`
+
+func TestUseServerThroughServerCalls(t *testing.T) {
+	result := build(map[string]string{
+		"/actions.js": "'use strict';\n\"use server\";\n" + markedActions,
+		"/app.js": `
+		import { deleteUser } from "./actions";
+
+		window.doStuff = (id) => deleteUser(window.fs.beginTx(), id);
+		`,
+	}, nil, "/app.js")
+
+	assert.Empty(t, result.Errors)
+	assert.NotContains(t, string(getOutFile(&result, "client.bundle.js")), "deleted ")
+}
+
+func TestUseServerImportedByClient(t *testing.T) {
+	result := build(map[string]string{
+		"/actions.js": "// User actions\n\"use server\"\n" + markedActions,
+		"/ui.js": `
+		import { formatId } from "./actions";
+
+		export const label = (id) => formatId(id);
+		`,
+		"/app.js": `
+		import { deleteUser } from "./actions";
+		import { label } from "./ui";
+
+		window.doStuff = (id) => deleteUser(window.fs.beginTx(), label(id));
+		`,
+	}, nil, "/app.js")
+
+	assert.Equal(t, []string{
+		"server only module actions.js is imported by client code other than through server calls (imported by app.js -> ui.js -> actions.js)",
+	}, errorTexts(result.Errors))
+}
+
+func TestServerOnlyGlob(t *testing.T) {
+	result := build(map[string]string{
+		"/server/actions.js": markedActions,
+		"/app.js": `
+		import { formatId } from "./server/actions";
+
+		window.doStuff = (id) => formatId(id);
+		`,
+	}, func(opts *api.SQLJoyOptions) {
+		opts.ServerOnly = []string{"server/**"}
+	}, "/app.js")
+
+	assert.Equal(t, []string{
+		"server only module server/actions.js is imported by client code other than through server calls (imported by app.js -> server/actions.js)",
+	}, errorTexts(result.Errors))
+}
+
+func TestUseServerNotADirective(t *testing.T) {
+	result := build(map[string]string{
+		"/actions.js": "\"use server\" + window.x;\n" + markedActions,
+		"/app.js": `
+		import { formatId } from "./actions";
+
+		window.doStuff = (id) => formatId(id);
+		`,
+	}, nil, "/app.js")
+
+	assert.Empty(t, result.Errors)
+}
+
+func TestUseClientInServerBundle(t *testing.T) {
+	result := build(map[string]string{
+		"/ui.js": "\"use client\";\nexport const label = (id) => document.title + id;\n",
+		"/actions.js": `
+		import { label } from "./ui";
+
+		export async function deleteUser(ctx, id) {
+			return label(id);
+		}
+		`,
+		"/app.js": `
+		import { deleteUser } from "./actions";
+
+		window.doStuff = (id) => deleteUser(window.fs.beginTx(), id);
+		`,
+	}, nil, "/app.js")
+
+	assert.Equal(t, []string{
+		"client only module ui.js is included in the server bundle (imported by <stdin> -> actions.js -> ui.js)",
+	}, errorTexts(result.Errors))
+}
+
+func TestClientOnlyGlob(t *testing.T) {
+	result := build(map[string]string{
+		"/components/label.js": "export const label = (id) => document.title + id;\n",
+		"/actions.js": `
+		import { label } from "./components/label";
+
+		export async function deleteUser(ctx, id) {
+			return label(id);
+		}
+		`,
+		"/app.js": `
+		import { deleteUser } from "./actions";
+		import { label } from "./components/label";
+
+		window.doStuff = (id) => deleteUser(window.fs.beginTx(), label(id));
+		`,
+	}, func(opts *api.SQLJoyOptions) {
+		opts.ClientOnly = []string{"components/*.js"}
+	}, "/app.js")
+
+	assert.Equal(t, []string{
+		"client only module components/label.js is included in the server bundle (imported by <stdin> -> actions.js -> components/label.js)",
+	}, errorTexts(result.Errors))
+}
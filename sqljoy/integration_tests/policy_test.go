@@ -0,0 +1,74 @@
+package integration_tests
+
+import (
+	"testing"
+
+	"github.com/evanw/esbuild/pkg/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func withStrictPolicies(opts *api.SQLJoyOptions) {
+	opts.StrictPolicies = true
+}
+
+func TestQueryPolicy(t *testing.T) {
+	result := build(map[string]string{
+		"/app.js": `
+		fs.executeQuery(sql` + "`-- @roles admin, support\n-- a regular comment\n-- @roles auditor\nSELECT * FROM users WHERE id = ${window.id}`" + `);
+		fs.executeQuery(sql` + "`SELECT 1 -- @roles admin`" + `);
+		`,
+	}, nil)
+
+	assert.Empty(t, result.Errors)
+	whitelist := getClientWhitelist(&result)
+	assert.Len(t, whitelist, 2)
+	assert.Equal(t, map[string]interface{}{"roles": []interface{}{"admin", "support", "auditor"}}, whitelist[0]["policy"])
+	assert.Nil(t, whitelist[1]["policy"])
+}
+
+func TestQueryPolicySharedQuery(t *testing.T) {
+	result := build(map[string]string{
+		"/app.js": `
+		const query = sql` + "`-- @roles admin\nSELECT 1`" + `;
+		fs.executeQuery(query);
+		fs.executeQuery(query);
+		`,
+	}, withStrictPolicies)
+
+	assert.Empty(t, result.Errors)
+	whitelist := getClientWhitelist(&result)
+	assert.Len(t, whitelist, 1)
+	assert.Equal(t, map[string]interface{}{"roles": []interface{}{"admin"}}, whitelist[0]["policy"])
+}
+
+func TestInvalidQueryAnnotations(t *testing.T) {
+	result := build(map[string]string{
+		"/app.js": `
+		fs.executeQuery(sql` + "`-- @roles\nSELECT 1`" + `);
+		fs.executeQuery(sql` + "`-- @role admin\nSELECT 2`" + `);
+		`,
+	}, nil)
+
+	assert.Equal(t, []string{
+		"@roles requires at least one role",
+		"unknown query annotation @role",
+	}, errorTexts(result.Errors))
+}
+
+func TestStrictPolicies(t *testing.T) {
+	result := build(map[string]string{
+		"/app.js": `
+		export async function serverOnly(ctx) {
+			return ctx.executeQuery(sql` + "`SELECT 3`" + `);
+		}
+
+		fs.executeQuery(sql` + "`-- @roles admin\nSELECT 1`" + `);
+		fs.executeQuery(sql` + "`SELECT 2`" + `);
+		window.run = () => serverOnly(fs.beginTx());
+		`,
+	}, withStrictPolicies)
+
+	assert.Equal(t, []string{
+		"client query has no access policy, add a -- @roles comment to the query (strictPolicies is enabled)",
+	}, errorTexts(result.Errors))
+}
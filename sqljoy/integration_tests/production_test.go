@@ -0,0 +1,121 @@
+package integration_tests
+
+import (
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/evanw/esbuild/pkg/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func production(opts *api.SQLJoyOptions) {
+	opts.Production = true
+}
+
+const sharedQueries = `
+const getOrder = sql` + "`select * from orders where id = ${window.orderId}`" + `;
+window.promise = fs.executeQuery(getOrder);
+
+export async function cancelOrder(ctx, id) {
+	await ctx.executeQuery(getOrder);
+	return ctx.executeQuery(sql` + "`update orders set status = 'cancelled' where id = ${id}`" + `);
+}
+window.cancel = (id) => cancelOrder(fs.beginTx(), id);
+`
+
+func TestDevelopmentQueryText(t *testing.T) {
+	result := build(map[string]string{
+		"/app.js": sharedQueries,
+	}, nil)
+
+	assert.Empty(t, result.Errors)
+
+	client := string(getOutFile(&result, "client.bundle.js"))
+	assert.Contains(t, client, `getOrder = {query: "lggxLuOiCnF28rXg8pES_NCerDZZhEuGKt_dVJfr", text: "select * from orders where id = $1", params: {orderId: window.orderId}};`)
+
+	server := string(getOutFile(&result, "server.bundle.js"))
+	assert.Contains(t, server, `getOrder = {query: "lggxLuOiCnF28rXg8pES_NCerDZZhEuGKt_dVJfr", text: "select * from orders where id = $1", params: {orderId: window.orderId}};`)
+}
+
+func TestProductionQueryText(t *testing.T) {
+	result := build(map[string]string{
+		"/app.js": sharedQueries,
+	}, production)
+
+	assert.Empty(t, result.Errors)
+
+	// The client only identifies queries by their ids
+	client := string(getOutFile(&result, "client.bundle.js"))
+	assert.Contains(t, client, `getOrder = {query: "lggxLuOiCnF28rXg8pES_NCerDZZhEuGKt_dVJfr", params: {orderId: window.orderId}};`)
+	assert.NotContains(t, client, "text:")
+
+	// None of the files served to the client have the query text, the source map has no sourcesContent
+	clientFiles := 0
+	for _, file := range result.OutputFiles {
+		if !strings.HasPrefix(path.Base(file.Path), "client.") {
+			continue
+		}
+		clientFiles++
+		assert.NotContains(t, string(file.Contents), "select * from orders", file.Path)
+		assert.NotContains(t, string(file.Contents), "update orders", file.Path)
+	}
+	assert.Equal(t, 2, clientFiles)
+	assert.NotContains(t, string(getOutFile(&result, "client.bundle.js.map")), "sourcesContent")
+	assert.Contains(t, string(getOutFile(&result, "server.bundle.js.map")), "sourcesContent")
+
+	// The server keeps the text of the queries it executes
+	server := string(getOutFile(&result, "server.bundle.js"))
+	assert.Contains(t, server, `getOrder = {query: "lggxLuOiCnF28rXg8pES_NCerDZZhEuGKt_dVJfr", text: "select * from orders where id = $1", params: {orderId: window.orderId}};`)
+	assert.Contains(t, server, `text: "update orders set status = 'cancelled' where id = $1"`)
+
+	// And the whitelist still has the text of the client queries
+	whitelist := getClientWhitelist(&result)
+	if assert.Len(t, whitelist, 1) {
+		assert.Equal(t, "select * from orders where id = $1", whitelist[0]["query"])
+	}
+}
+
+const clientFragments = `
+const byId = sql.p` + "`id = ${window.id}`" + `;
+const byName = sql.p` + "`name = ${window.name}`" + `;
+const query = sql` + "`select * from users where ${window.useId ? byId : byName}`" + `;
+window.promise = fs.executeQuery(query);
+`
+
+func TestDevelopmentFragmentText(t *testing.T) {
+	result := build(map[string]string{
+		"/app.js": clientFragments,
+	}, nil)
+
+	assert.Empty(t, result.Errors)
+
+	client := string(getOutFile(&result, "client.bundle.js"))
+	assert.Contains(t, client, `text: "id = $1"`)
+	assert.Contains(t, client, `sql.merge({query: "`)
+	assert.Contains(t, client, `text: "select * from users where %{}", params: {}}, window.useId ? byId : byName)`)
+}
+
+func TestProductionFragmentText(t *testing.T) {
+	result := build(map[string]string{
+		"/app.js": clientFragments,
+	}, production)
+
+	assert.Empty(t, result.Errors)
+
+	client := string(getOutFile(&result, "client.bundle.js"))
+	assert.NotContains(t, client, "text:")
+	assert.Contains(t, client, `params: {}}, window.useId ? byId : byName)`)
+	assert.Contains(t, client, `sql.merge({query: "`)
+
+	whitelist := getClientWhitelist(&result)
+	if assert.Len(t, whitelist, 1) {
+		assert.NotEmpty(t, whitelist[0]["fragments"])
+	}
+}
+
+func TestProductionConfig(t *testing.T) {
+	opts, err := api.NewSQLJoyOptions([]byte(`{"production": true, "client": {"entryPoints": ["app.js"]}}`), nil, "build")
+	assert.NoError(t, err)
+	assert.True(t, opts.Production)
+}
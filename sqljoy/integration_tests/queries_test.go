@@ -65,6 +65,13 @@ func TestInlineQueryWithFragment(t *testing.T) {
 			},
 		},
 		"id": "HoVHYay37wWiR5AQ7lDnUF3FiGZAVW0VxayxAfth",
+		"slots": []interface{}{
+			[]interface{}{"LCa0a2j_xo_5m0U8HTBBNBNCLXBkg7-g-YpeiGJm", "XH7iB0tlhT9x_FoBzhlP8m3u322qzbcVxr7v39Pz"},
+		},
+		"combinations": map[string]interface{}{
+			"nQ1e3r1XvqeUIEMPQGjkad0pvn9sqEGUJ82TrpQl": []interface{}{"LCa0a2j_xo_5m0U8HTBBNBNCLXBkg7-g-YpeiGJm"},
+			"CWGNGYDA-6QZdbw7NLOvzqRV3SMYwY39mXRDdGE7": []interface{}{"XH7iB0tlhT9x_FoBzhlP8m3u322qzbcVxr7v39Pz"},
+		},
 		"fragments": []interface{}{
 			[]interface{}{
 				map[string]interface{}{
@@ -298,11 +305,20 @@ func TestPrivateQuery(t *testing.T) {
 			},
 		},
 		"id": "7NpJOlclOBksJP_OqcNhxpmJJJLv7xzyOB7U3AO1",
+		"serverVars": []interface{}{"SESSION.user_id", "SESSION.roles"},
+		"slots": []interface{}{
+			[]interface{}{"mo_YXFbQvk1YV1_MdJJk2fcJziqIydnlk0-EEwdc", "KmyrotC7fWU_BAuR_cM5nOT-uYxz1k-u8LZXCa10"},
+		},
+		"combinations": map[string]interface{}{
+			"lX_i6FT_6_7GqrUtgXXC6a9dbP1mEHu2aD6xmO6n": []interface{}{"mo_YXFbQvk1YV1_MdJJk2fcJziqIydnlk0-EEwdc"},
+			"gFRpoeGGUjRaVTGr4MsFxgQkC7bK9muvGn8NIfdg": []interface{}{"KmyrotC7fWU_BAuR_cM5nOT-uYxz1k-u8LZXCa10"},
+		},
 		"fragments": []interface{}{
 			[]interface{}{
 				map[string]interface{}{
 					"id": "mo_YXFbQvk1YV1_MdJJk2fcJziqIydnlk0-EEwdc",
 					"query":    "user_id = ${SESSION.user_id}",
+					"serverVars": []interface{}{"SESSION.user_id"},
 					"clientReferences": 1.0,
 					"definedAt": map[string]interface{}{
 						"line":     1.0,
@@ -312,6 +328,7 @@ func TestPrivateQuery(t *testing.T) {
 				map[string]interface{}{
 					"id": "KmyrotC7fWU_BAuR_cM5nOT-uYxz1k-u8LZXCa10",
 					"query":    "${SESSION.roles}::jsonb ? role",
+					"serverVars": []interface{}{"SESSION.roles"},
 					"clientReferences": 1.0,
 					"definedAt": map[string]interface{}{
 						"line":     1.0,
@@ -356,11 +373,20 @@ func TestNotPrivateQuery(t *testing.T) {
 			},
 		},
 		"id": "TpZkx2L5A-YVHmYJJRi8u-ejjAeXOLuNq1Gw3TXv",
+		"serverVars": []interface{}{"SESSION.user_id"},
+		"slots": []interface{}{
+			[]interface{}{"mo_YXFbQvk1YV1_MdJJk2fcJziqIydnlk0-EEwdc", "JFAYk5QGdzmjTvsNnguUqMZJNEmliJM9kwSI2KRO"},
+		},
+		"combinations": map[string]interface{}{
+			"VswYq68zLXqJBdJk9J84BLLk0l_HNGUZteN5WusS": []interface{}{"mo_YXFbQvk1YV1_MdJJk2fcJziqIydnlk0-EEwdc"},
+			"N06X97grpoTWID6o22ILgv-bNaECrDcKjSTeW2x0": []interface{}{"JFAYk5QGdzmjTvsNnguUqMZJNEmliJM9kwSI2KRO"},
+		},
 		"fragments": []interface{}{
 			[]interface{}{
 				map[string]interface{}{
 					"id": "mo_YXFbQvk1YV1_MdJJk2fcJziqIydnlk0-EEwdc",
 					"query":    "user_id = ${SESSION.user_id}",
+					"serverVars": []interface{}{"SESSION.user_id"},
 					"clientReferences": 1.0,
 					"definedAt": map[string]interface{}{
 						"line":     1.0,
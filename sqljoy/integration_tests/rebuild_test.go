@@ -0,0 +1,144 @@
+package integration_tests
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/evanw/esbuild/internal/fs"
+	"github.com/evanw/esbuild/pkg/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRebuildUnchanged(t *testing.T) {
+	result := build(map[string]string{
+		"/app.js": `
+		const query = sql` + "`select * from users where id = ${window.id}`" + `;
+		export async function server(ctx) {
+			return ctx.executeQuery(query);
+		}
+		fs.executeQuery(query);
+		server(fs.beginTx());
+		`,
+	}, func(opts *api.SQLJoyOptions) {
+		opts.Incremental = true
+	})
+
+	assert.Empty(t, result.Errors)
+	assert.NotNil(t, result.Rebuild)
+
+	// The cached ASTs must not have been modified by the first build
+	rebuilt := result.Rebuild()
+	assert.Empty(t, rebuilt.Errors)
+	assert.Equal(t, getClientWhitelist(&result), getClientWhitelist(&rebuilt))
+	assert.Equal(t, string(getOutFile(&result, "client.bundle.js")), string(getOutFile(&rebuilt, "client.bundle.js")))
+	assert.Equal(t, string(getOutFile(&result, "server.bundle.js")), string(getOutFile(&rebuilt, "server.bundle.js")))
+}
+
+func TestRebuildChanged(t *testing.T) {
+	var mockFS fs.FS
+	result := build(map[string]string{
+		"/app.js": "import {query} from \"./query\";\nfs.executeQuery(query);\n",
+		"/query.js": "export const query = sql`select 1`;\n",
+	}, func(opts *api.SQLJoyOptions) {
+		opts.Incremental = true
+		mockFS = opts.FS
+	}, "/app.js")
+
+	assert.Empty(t, result.Errors)
+	whitelist := getClientWhitelist(&result)
+	assert.Len(t, whitelist, 1)
+	assert.Equal(t, "select 1", whitelist[0]["query"])
+
+	err := mockFS.WriteFile("/query.js", []byte("export const query = sql`select 2`;\n"), 0644)
+	assert.NoError(t, err)
+
+	result = result.Rebuild()
+	assert.Empty(t, result.Errors)
+	whitelist = getClientWhitelist(&result)
+	assert.Len(t, whitelist, 1)
+	assert.Equal(t, "select 2", whitelist[0]["query"])
+
+	client := string(getOutFile(&result, "client.bundle.js"))
+	assert.Contains(t, client, `text: "select 2"`)
+	assert.NotContains(t, client, `text: "select 1"`)
+}
+
+func TestRebuildAfterError(t *testing.T) {
+	var mockFS fs.FS
+	result := build(map[string]string{
+		"/app.js": "import {query, part} from \"./query\";\nfs.executeQuery(query);\nfs.executeQuery(part);\n",
+		"/query.js": "export const part = sql.p`id = 1`;\nexport const query = sql`select * from users where ${part}`;\n",
+	}, func(opts *api.SQLJoyOptions) {
+		opts.Incremental = true
+		mockFS = opts.FS
+	}, "/app.js")
+
+	assert.Len(t, result.Errors, 1)
+
+	err := mockFS.WriteFile("/app.js", []byte("import {query} from \"./query\";\nfs.executeQuery(query);\n"), 0644)
+	assert.NoError(t, err)
+
+	// The failed build must not leave the unchanged query module half compiled
+	result = result.Rebuild()
+	assert.Empty(t, result.Errors)
+	whitelist := getClientWhitelist(&result)
+	assert.Len(t, whitelist, 1)
+	assert.Equal(t, "select * from users where id = 1", whitelist[0]["query"])
+
+	client := string(getOutFile(&result, "client.bundle.js"))
+	assert.Contains(t, client, `text: "select * from users where id = 1"`)
+}
+
+func TestWatchRebuildsOnChange(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sjc-watch")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	write := func(name, contents string) {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0644))
+	}
+	write("app.js", "import {query} from \"./query\";\nfs.executeQuery(query);\n")
+	write("query.js", "export const query = sql`select 1`;\n")
+
+	opts, err := api.NewSQLJoyOptions([]byte(`{
+		"client": {"minify": false, "entryPoints": ["app.js"], "external": ["sqljoy"]},
+		"server": {"minify": false},
+		"logLevel": "silent"
+	}`), nil, "watch")
+	if !assert.NoError(t, err) {
+		return
+	}
+	opts.Include = nil
+	opts.Watch = true
+	opts.Client.AbsWorkingDir = dir
+	opts.Server.AbsWorkingDir = dir
+
+	result := api.BuildFlowState(opts)
+	assert.Empty(t, result.Errors)
+	if !assert.NotNil(t, result.Stop) {
+		return
+	}
+	defer result.Stop()
+
+	readWhitelist := func() string {
+		contents, _ := ioutil.ReadFile(filepath.Join(dir, "client-queries.json"))
+		return string(contents)
+	}
+	assert.Contains(t, readWhitelist(), `"query": "select 1"`)
+
+	// The watcher checks every file at least once every 2 seconds
+	write("query.js", "export const query = sql`select 2`;\n")
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) && !strings.Contains(readWhitelist(), `"query": "select 2"`) {
+		time.Sleep(100 * time.Millisecond)
+	}
+	whitelist := readWhitelist()
+	assert.Contains(t, whitelist, `"query": "select 2"`)
+	assert.NotContains(t, whitelist, `"query": "select 1"`)
+}
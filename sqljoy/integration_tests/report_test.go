@@ -0,0 +1,108 @@
+package integration_tests
+
+import (
+	"testing"
+
+	"github.com/evanw/esbuild/pkg/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func report(opts *api.SQLJoyOptions) {
+	opts.Report = true
+}
+
+func TestReport(t *testing.T) {
+	result := build(map[string]string{
+		"/app.js": `
+import {renameUser, checkName} from "./users";
+
+const byId = sql.p` + "`id = ${window.id}`" + `;
+const byName = sql.p` + "`name = ${window.name}`" + `;
+window.promise = fs.executeQuery(sql` + "`select * from users where ${window.useId ? byId : byName}`" + `, {}, checkName);
+window.rename = (id, name) => renameUser(fs.beginTx(), id, name);
+`,
+		"/users.js": `
+export function checkName(data, errors) {}
+
+export async function renameUser(ctx, id, name) {
+	return ctx.executeQuery(sql` + "`update users set name = ${name} where id = ${id}`" + `);
+}
+`,
+	}, report, "/app.js")
+
+	assert.Empty(t, result.Errors)
+	assert.JSONEq(t, `{
+		"queries": [
+			{
+				"id": "i-r5z38ecsg46luNmzx4n8tOCBnWBAdYLRlG-ag1",
+				"type": "select",
+				"isPublic": true,
+				"whitelist": "client",
+				"definedAt": {"fileName": "app.js", "line": 6},
+				"executedBy": [{"fileName": "app.js", "line": 6}],
+				"fragments": [["rJyZs95YPtgHo_rrjTGsD7wH7L01T-Kk1USySSry", "wiowXAG2rc3OYZ3cMGmUszdM9pdRJo-I8gNdxswC"]],
+				"validators": ["9t7Kw2zchC1RKisqADSWhAeV0ogDnszeFsi6XYP7"]
+			},
+			{
+				"id": "D08LcdKaSFUBY8rP_RtHXArE8GryOYriU2QdFcGC",
+				"type": "update",
+				"isPublic": true,
+				"whitelist": "server",
+				"definedAt": {"fileName": "users.js", "line": 5},
+				"executedBy": [{"fileName": "users.js", "line": 5, "serverFunction": "renameUser"}]
+			}
+		],
+		"fragments": [
+			{
+				"id": "rJyZs95YPtgHo_rrjTGsD7wH7L01T-Kk1USySSry",
+				"definedAt": {"fileName": "app.js", "line": 4},
+				"usedBy": ["i-r5z38ecsg46luNmzx4n8tOCBnWBAdYLRlG-ag1"]
+			},
+			{
+				"id": "wiowXAG2rc3OYZ3cMGmUszdM9pdRJo-I8gNdxswC",
+				"definedAt": {"fileName": "app.js", "line": 5},
+				"usedBy": ["i-r5z38ecsg46luNmzx4n8tOCBnWBAdYLRlG-ag1"]
+			}
+		],
+		"serverFunctions": [
+			{
+				"name": "renameUser",
+				"definedAt": {"fileName": "users.js", "line": 4},
+				"ids": ["1ZXU_4vusTEJ6XxT9shxdOB1mjWzcNJ-jjsR8eBM"],
+				"queries": ["D08LcdKaSFUBY8rP_RtHXArE8GryOYriU2QdFcGC"]
+			}
+		],
+		"validators": [
+			{
+				"name": "checkName",
+				"definedAt": {"fileName": "users.js", "line": 2},
+				"ids": ["9t7Kw2zchC1RKisqADSWhAeV0ogDnszeFsi6XYP7"],
+				"queries": ["i-r5z38ecsg46luNmzx4n8tOCBnWBAdYLRlG-ag1"]
+			}
+		],
+		"modules": [
+			{
+				"fileName": "app.js",
+				"queries": ["i-r5z38ecsg46luNmzx4n8tOCBnWBAdYLRlG-ag1"],
+				"fragments": ["rJyZs95YPtgHo_rrjTGsD7wH7L01T-Kk1USySSry", "wiowXAG2rc3OYZ3cMGmUszdM9pdRJo-I8gNdxswC"]
+			},
+			{
+				"fileName": "users.js",
+				"queries": ["D08LcdKaSFUBY8rP_RtHXArE8GryOYriU2QdFcGC"],
+				"serverFunctions": ["renameUser"],
+				"validators": ["checkName"]
+			}
+		]
+	}`, string(getOutFile(&result, "flowstate-report.json")))
+}
+
+func TestNoReport(t *testing.T) {
+	result := build(map[string]string{
+		"/app.js": sharedQueries,
+	}, nil)
+
+	assert.Empty(t, result.Errors)
+	for _, file := range result.OutputFiles {
+		assert.NotContains(t, file.Path, "flowstate-report.json")
+	}
+}
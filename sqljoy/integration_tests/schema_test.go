@@ -0,0 +1,178 @@
+package integration_tests
+
+import (
+	"testing"
+
+	"github.com/evanw/esbuild/pkg/api"
+	"github.com/stretchr/testify/assert"
+)
+
+const testSchemaSQL = `
+CREATE TABLE users (
+	id serial PRIMARY KEY,
+	name text NOT NULL,
+	email varchar(255) NOT NULL UNIQUE,
+	nickname text
+);
+
+CREATE TABLE app.orders (
+	id serial PRIMARY KEY,
+	user_id int NOT NULL REFERENCES users (id),
+	total numeric(10, 2),
+	CONSTRAINT positive_total CHECK (total > 0)
+);
+
+ALTER TABLE users RENAME COLUMN nickname TO display_name;
+ALTER TABLE users ADD COLUMN created_at timestamptz DEFAULT now();
+
+CREATE VIEW big_orders AS SELECT o.id, o.total AS amount FROM app.orders o WHERE o.total > 100;
+CREATE INDEX users_email ON users (email);
+`
+
+func withSchema(path string) func(opts *api.SQLJoyOptions) {
+	return func(opts *api.SQLJoyOptions) {
+		opts.Schema = path
+	}
+}
+
+func buildWithSchema(code string) api.BuildResult {
+	return build(map[string]string{
+		"/app.js":     code,
+		"/schema.sql": testSchemaSQL,
+	}, withSchema("schema.sql"), "/app.js")
+}
+
+func errorTexts(msgs []api.Message) []string {
+	texts := []string{}
+	for _, msg := range msgs {
+		texts = append(texts, msg.Text)
+	}
+	return texts
+}
+
+func TestSchemaValidQueries(t *testing.T) {
+	result := buildWithSchema(`
+	fs.executeQuery(sql` + "`SELECT u.id, u.name, o.total FROM users u JOIN app.orders o ON o.user_id = u.id WHERE u.email = ${window.email}`" + `);
+	fs.executeQuery(sql` + "`SELECT display_name, created_at FROM users ORDER BY created_at DESC`" + `);
+	fs.executeQuery(sql` + "`SELECT name AS n FROM users ORDER BY n`" + `);
+	fs.executeQuery(sql` + "`SELECT amount FROM big_orders WHERE id = ${window.id}`" + `);
+	fs.executeQuery(sql` + "`WITH totals AS (SELECT user_id, sum(total) AS sum FROM app.orders GROUP BY user_id) SELECT u.name, t.sum FROM users u JOIN totals t ON t.user_id = u.id`" + `);
+	fs.executeQuery(sql` + "`SELECT * FROM users u WHERE EXISTS (SELECT 1 FROM app.orders o WHERE o.user_id = u.id)`" + `); // sjc-ignore select-star
+	fs.executeQuery(sql` + "`SELECT x.n FROM (SELECT name FROM users) AS x(n)`" + `);
+	fs.executeQuery(sql` + "`INSERT INTO users (name, email) VALUES (${window.name}, ${window.email}) RETURNING id`" + `);
+	fs.executeQuery(sql` + "`INSERT INTO users (name, email) SELECT name, email FROM users WHERE id = 1`" + `);
+	fs.executeQuery(sql` + "`INSERT INTO users AS u (id, name, email) VALUES (1, 'a', 'b') ON CONFLICT (id) DO UPDATE SET name = excluded.name WHERE u.id = 1`" + `);
+	fs.executeQuery(sql` + "`UPDATE users SET name = ${window.name} WHERE id = ${window.id}`" + `);
+	fs.executeQuery(sql` + "`DELETE FROM app.orders o USING users u WHERE o.user_id = u.id AND u.email = ${window.email}`" + `);
+	`)
+
+	assert.Empty(t, result.Errors)
+	assert.Empty(t, result.Warnings)
+}
+
+func TestSchemaUnknownTable(t *testing.T) {
+	result := buildWithSchema("fs.executeQuery(sql`SELECT * FROM usr WHERE id = ${window.id}`);\n")
+
+	assert.Equal(t, []string{`relation "usr" does not exist`}, errorTexts(result.Errors))
+	assert.Equal(t, "app.js", result.Errors[0].Location.File)
+	assert.Equal(t, 1, result.Errors[0].Location.Line)
+	assert.Equal(t, 16, result.Errors[0].Location.Column)
+
+	// The note points at the table in the compiled query
+	assert.Len(t, result.Errors[0].Notes, 1)
+	note := result.Errors[0].Notes[0].Location
+	assert.Equal(t, "SELECT * FROM usr WHERE id = $1", note.LineText)
+	assert.Equal(t, 14, note.Column)
+	assert.Equal(t, 3, note.Length)
+}
+
+func TestSchemaUnknownColumns(t *testing.T) {
+	result := buildWithSchema(`
+	fs.executeQuery(sql` + "`SELECT nickname FROM users`" + `);
+	fs.executeQuery(sql` + "`SELECT u.nam FROM users u`" + `);
+	fs.executeQuery(sql` + "`SELECT o.id FROM users u`" + `);
+	fs.executeQuery(sql` + "`SELECT id FROM order_items`" + `);
+	fs.executeQuery(sql` + "`UPDATE users SET mail = ${window.email}`" + `);
+	fs.executeQuery(sql` + "`SELECT name FROM users WHERE id IN (SELECT user_id FROM app.orders WHERE amount > 1)`" + `);
+	`)
+
+	assert.ElementsMatch(t, []string{
+		`column "nickname" does not exist`,
+		`column u.nam does not exist`,
+		`missing FROM-clause entry for table "o"`,
+		`relation "order_items" does not exist`,
+		`column "mail" of relation "users" does not exist`,
+		`column "amount" does not exist`,
+	}, errorTexts(result.Errors))
+}
+
+func TestSchemaInsertCounts(t *testing.T) {
+	result := buildWithSchema(`
+	fs.executeQuery(sql` + "`INSERT INTO users (name, email) VALUES (${window.name}, ${window.email}, 1)`" + `);
+	fs.executeQuery(sql` + "`INSERT INTO users (name, email) VALUES (${window.name})`" + `);
+	fs.executeQuery(sql` + "`INSERT INTO users VALUES (1, 'a', 'b', 'c', now(), 'extra')`" + `);
+	fs.executeQuery(sql` + "`INSERT INTO users (name, emial) VALUES ('a', 'b')`" + `);
+	`)
+
+	assert.ElementsMatch(t, []string{
+		"INSERT has more expressions than target columns",
+		"INSERT has more target columns than expressions",
+		"INSERT has more expressions than target columns",
+		`column "emial" of relation "users" does not exist`,
+	}, errorTexts(result.Errors))
+}
+
+func TestSchemaFragments(t *testing.T) {
+	// A fragment could add tables to the query, so columns that may come from it aren't reported
+	result := buildWithSchema(`
+	const join = sql.p` + "`JOIN app.orders o ON o.user_id = users.id`" + `;
+	const filter = window.f ? sql.p` + "`total > 10`" + ` : sql.p` + "`true`" + `;
+	fs.executeQuery(sql` + "`SELECT o.total FROM users ${join}`" + `);
+	fs.executeQuery(sql` + "`SELECT name FROM users WHERE %{SESSION.user_id} = id AND ${filter}`" + `);
+	fs.executeQuery(sql` + "`SELECT name FROM users WHERE ${filter} AND bogus = 1`" + `);
+	`)
+
+	assert.Equal(t, []string{`column "bogus" does not exist`}, errorTexts(result.Errors))
+}
+
+func TestSchemaWarnings(t *testing.T) {
+	result := build(map[string]string{
+		"/app.js":     "fs.executeQuery(sql`SELECT bogus FROM users`);\n",
+		"/schema.sql": testSchemaSQL,
+	}, func(opts *api.SQLJoyOptions) {
+		opts.Schema = "schema.sql"
+		opts.SchemaWarnings = true
+	}, "/app.js")
+
+	assert.Empty(t, result.Errors)
+	assert.Equal(t, []string{`column "bogus" does not exist`}, errorTexts(result.Warnings))
+	assert.Len(t, getClientWhitelist(&result), 1)
+}
+
+func TestSchemaJSON(t *testing.T) {
+	result := build(map[string]string{
+		"/app.js": "fs.executeQuery(sql`SELECT \"userId\", name FROM app.accounts`);\nfs.executeQuery(sql`SELECT userid FROM app.accounts`);\n",
+		"/schema.json": `{"tables": [
+			{"name": "app.accounts", "columns": [{"name": "userId", "type": "integer", "notNull": true}, {"name": "name", "type": "text"}]}
+		]}`,
+	}, withSchema("/schema.json"), "/app.js")
+
+	assert.Equal(t, []string{`column "userid" does not exist`}, errorTexts(result.Errors))
+}
+
+func TestSchemaFileErrors(t *testing.T) {
+	result := build(map[string]string{
+		"/app.js":     "fs.executeQuery(sql`SELECT 1`);\n",
+		"/schema.sql": "CREATE TABLE users (id int",
+	}, withSchema("schema.sql"), "/app.js")
+
+	assert.Equal(t, []string{"syntax error at end of input"}, errorTexts(result.Errors))
+	assert.Equal(t, "schema.sql", result.Errors[0].Location.File)
+
+	result = build(map[string]string{
+		"/app.js": "fs.executeQuery(sql`SELECT 1`);\n",
+	}, withSchema("missing.sql"), "/app.js")
+
+	assert.Len(t, result.Errors, 1)
+	assert.Contains(t, result.Errors[0].Text, `Could not read schema file "missing.sql"`)
+}
@@ -108,4 +108,302 @@ func TestServerFunctionExpr(t *testing.T) {
 	assert.Contains(t, server, `var functions = {
     yWBgZK8_NCnEk1wxyV500RDaGDZhquVUWYLaBn0e: assignFunc
   };`)
-}
\ No newline at end of file
+}
+func TestStaticMethodServerFunc(t *testing.T) {
+	const prog = `
+	export class Api {
+		static async getUser(ctx, id) {
+			return ctx.executeQuery(sql` + "`SELECT * FROM users WHERE id = ${id}`" + `);
+		}
+		static async remove(ctx, id) {
+			return id;
+		}
+		static version() {
+			return 2;
+		}
+	}
+
+	window.doStuff = async function(id) {
+		await Api.getUser(window.fs.beginTx(), id);
+		return Api.remove(window.fs.beginTx(), id);
+	};
+	`
+
+	result := build(map[string]string{
+		"/app.js": prog,
+	}, nil)
+
+	assert.Empty(t, result.Errors)
+
+	client := string(getOutFile(&result, "client.bundle.js"))
+	assert.Contains(t, client, `await window.fs.serverCall("`)
+	assert.NotContains(t, client, `getUser`)
+	assert.NotContains(t, client, `class Api`)
+
+	server := string(getOutFile(&result, "server.bundle.js"))
+	assert.Contains(t, server, `static async getUser(ctx, id)`)
+	assert.Contains(t, server, `: Api.getUser.bind(Api)`)
+	assert.Contains(t, server, `: Api.remove.bind(Api)`)
+	assert.Len(t, getServerWhitelist(&result), 1)
+}
+
+func TestObjectMethodServerFunc(t *testing.T) {
+	result := build(map[string]string{
+		"/api.js": `
+		export const api = {
+			async getUser(ctx, id) {
+				return ctx.executeQuery(sql` + "`SELECT * FROM users WHERE id = ${id}`" + `);
+			},
+			remove: async function(ctx, id) {
+				return id;
+			},
+			version: 2,
+		};
+		`,
+		"/app.js": `
+		import {api} from "./api";
+
+		window.doStuff = async function(id) {
+			await api.getUser(window.fs.beginTx(), id);
+			return api.remove(window.fs.beginTx(), id);
+		};
+		`,
+	}, nil, "/app.js")
+
+	assert.Empty(t, result.Errors)
+
+	client := string(getOutFile(&result, "client.bundle.js"))
+	assert.Contains(t, client, `await window.fs.serverCall("`)
+	assert.NotContains(t, client, `getUser`)
+
+	server := string(getOutFile(&result, "server.bundle.js"))
+	assert.Contains(t, server, `: api.getUser.bind(api)`)
+	assert.Contains(t, server, `: api.remove.bind(api)`)
+	assert.Len(t, getServerWhitelist(&result), 1)
+}
+
+func TestServerMethodClassUsedByAnotherModule(t *testing.T) {
+	result := build(map[string]string{
+		"/api.js": `
+		export class Api {
+			static async getUser(ctx, id) {
+				return ctx.executeQuery(sql` + "`SELECT name FROM users WHERE id = ${id}`" + `);
+			}
+			static format(x) {
+				return "#" + x;
+			}
+		}
+		`,
+		"/a.js": `
+		import {Api} from "./api";
+		window.doStuff = id => Api.getUser(window.fs.beginTx(), id);
+		`,
+		"/b.js": `
+		import {Api} from "./api";
+		window.b = Api.format(1);
+		`,
+		"/app.js": `
+		import "./a";
+		import "./b";
+		`,
+	}, nil, "/app.js")
+
+	assert.Empty(t, result.Errors)
+
+	// The class is still used by b.js, but the server method's body isn't in the client bundle
+	client := string(getOutFile(&result, "client.bundle.js"))
+	assert.Contains(t, client, `var Api = class`)
+	assert.Contains(t, client, `Api.format(1)`)
+	assert.NotContains(t, client, `SELECT name FROM users`)
+	assert.NotContains(t, client, `executeQuery`)
+
+	server := string(getOutFile(&result, "server.bundle.js"))
+	assert.Contains(t, server, `static async getUser(ctx, id)`)
+	assert.Contains(t, server, `SELECT name FROM users`)
+}
+
+func TestDefaultExportServerFunc(t *testing.T) {
+	result := build(map[string]string{
+		"/save.js": `
+		export default async function save(ctx, id) {
+			return ctx.executeQuery(sql` + "`UPDATE users SET saved = true WHERE id = ${id}`" + `);
+		}
+		`,
+		"/app.js": `
+		import save from "./save";
+
+		export default async function load(ctx, id) {
+			return id;
+		}
+
+		window.doStuff = async function(id) {
+			await save(window.fs.beginTx(), id);
+			return load(window.fs.beginTx(), id);
+		};
+		`,
+	}, nil, "/app.js")
+
+	assert.Empty(t, result.Errors)
+
+	client := string(getOutFile(&result, "client.bundle.js"))
+	assert.NotContains(t, client, `function save`)
+	assert.NotContains(t, client, `function load`)
+
+	server := string(getOutFile(&result, "server.bundle.js"))
+	assert.Contains(t, server, `async function save(ctx, id)`)
+	assert.Contains(t, server, `async function load(ctx, id)`)
+	assert.Len(t, getServerWhitelist(&result), 1)
+}
+
+func TestNotExportedServerMethod(t *testing.T) {
+	result := build(map[string]string{
+		"/app.js": `
+		class Api {
+			static async getUser(ctx, id) {
+				return id;
+			}
+		}
+
+		window.doStuff = (id) => Api.getUser(window.fs.beginTx(), id);
+		`,
+	}, nil)
+
+	assert.Equal(t, []string{"function Api.getUser must be exported"}, errorTexts(result.Errors))
+}
+
+func TestDefaultExportClassServerFunc(t *testing.T) {
+	result := build(map[string]string{
+		"/api.js": `
+		export default class Api {
+			static async getUser(ctx, id) {
+				return id;
+			}
+		}
+		`,
+		"/app.js": `
+		import Users from "./api";
+
+		window.doStuff = (id) => Users.getUser(window.fs.beginTx(), id);
+		`,
+	}, nil, "/app.js")
+
+	assert.Empty(t, result.Errors)
+
+	client := string(getOutFile(&result, "client.bundle.js"))
+	assert.NotContains(t, client, `getUser`)
+
+	server := string(getOutFile(&result, "server.bundle.js"))
+	assert.Contains(t, server, `static async getUser(ctx, id)`)
+	assert.Contains(t, server, `: api_default.getUser.bind(api_default)`)
+}
+
+const userActions = `
+export async function deleteUser(ctx, id) {
+	return "deleted " + id;
+}
+
+export async function archiveUser(ctx, id) {
+	return "archived " + id;
+}
+`
+
+func TestConditionalServerFunc(t *testing.T) {
+	result := build(map[string]string{
+		"/actions.js": userActions,
+		"/app.js": `
+		import { deleteUser, archiveUser } from "./actions";
+
+		const action = window.isAdmin ? deleteUser : archiveUser;
+		const alias = action;
+		window.doStuff = (id) => alias(window.fs.beginTx(), id);
+		`,
+	}, nil, "/app.js")
+
+	assert.Empty(t, result.Errors)
+
+	client := string(getOutFile(&result, "client.bundle.js"))
+	assert.Regexp(t, `action = window.isAdmin \? "[\w-]{40}" : "[\w-]{40}";`, client)
+	assert.Contains(t, client, `window.fs.serverCall(alias, id)`)
+	assert.NotContains(t, client, `deleted `)
+	assert.NotContains(t, client, `archived `)
+
+	server := string(getOutFile(&result, "server.bundle.js"))
+	assert.Contains(t, server, `: deleteUser`)
+	assert.Contains(t, server, `: archiveUser`)
+}
+
+func TestArrayCallbackServerFunc(t *testing.T) {
+	result := build(map[string]string{
+		"/actions.js": userActions,
+		"/app.js": `
+		import { deleteUser, archiveUser } from "./actions";
+
+		window.doStuff = (id) => [deleteUser, archiveUser].map(f => f(window.fs.beginTx(), id));
+		`,
+	}, nil, "/app.js")
+
+	assert.Empty(t, result.Errors)
+
+	client := string(getOutFile(&result, "client.bundle.js"))
+	assert.Regexp(t, `\["[\w-]{40}", "[\w-]{40}"\]\.map\(\(f\) => window\.fs\.serverCall\(f, id\)\)`, client)
+	assert.NotContains(t, client, `deleted `)
+	assert.NotContains(t, client, `archived `)
+}
+
+func TestUntraceableServerCall(t *testing.T) {
+	result := build(map[string]string{
+		"/app.js": `
+		window.doStuff = (id) => window.actions[id](window.fs.beginTx(), id);
+		`,
+	}, nil)
+
+	assert.Equal(t, []string{
+		"server call can't be traced to a server function, it must be called directly or through a variable, condition or array of server functions",
+	}, errorTexts(result.Errors))
+}
+
+func TestEscapedServerFunc(t *testing.T) {
+	result := build(map[string]string{
+		"/actions.js": userActions,
+		"/app.js": `
+		import { deleteUser } from "./actions";
+
+		window.doStuff = (id) => deleteUser(window.fs.beginTx(), id);
+		window.actions = { deleteUser };
+		`,
+	}, nil, "/app.js")
+
+	assert.Equal(t, []string{
+		"server function deleteUser is used in a way that can't be traced to a server call, which would include it in the client bundle",
+	}, errorTexts(result.Errors))
+}
+
+func TestEscapedServerMethod(t *testing.T) {
+	result := build(map[string]string{
+		"/api.js": `
+		export class Api {
+			static async getUser(ctx, id) {
+				return id;
+			}
+			static format(x) {
+				return "#" + x;
+			}
+		}
+		`,
+		"/app.js": `
+		import { Api } from "./api";
+
+		window.doStuff = (id) => Api.getUser(window.fs.beginTx(), id);
+		window.leak = Api.getUser;
+		window.alsoLeak = Api["getUser"];
+		window.format = Api.format;
+		window.pick = (admin) => (admin ? Api.getUser : Api.getUser)(window.fs.beginTx(), 1);
+		`,
+	}, nil, "/app.js")
+
+	// format isn't called as a server function, so it can be used on the client
+	msg := "server function Api.getUser is used in a way that can't be traced to a server call, which would include it in the client bundle"
+	assert.Equal(t, []string{msg, msg}, errorTexts(result.Errors))
+	assert.Equal(t, 5, result.Errors[0].Location.Line)
+	assert.Equal(t, 6, result.Errors[1].Location.Line)
+}
@@ -0,0 +1,81 @@
+package integration_tests
+
+import (
+	"testing"
+
+	"github.com/evanw/esbuild/pkg/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func withServerVars(opts *api.SQLJoyOptions) {
+	opts.ServerVars = map[string][]string{
+		"SESSION": {"user_id", "roles"},
+		"TENANT":  {"id", "plan"},
+		"REQUEST": nil,
+	}
+}
+
+func TestDefaultServerVars(t *testing.T) {
+	result := build(map[string]string{
+		"/app.js": "fs.executeQuery(sql`SELECT * FROM orders WHERE user_id = %{SESSION.usrId} AND region = %{ENV.region} AND tenant_id = %{tenantId}`);\n",
+	}, nil)
+
+	assert.Empty(t, result.Errors)
+	whitelist := getClientWhitelist(&result)
+	assert.Len(t, whitelist, 1)
+	assert.Equal(t, "SELECT * FROM orders WHERE user_id = ${SESSION.usrId} AND region = ${ENV.region} AND tenant_id = $1", whitelist[0]["query"])
+	assert.Equal(t, []interface{}{"SESSION.usrId", "ENV.region"}, whitelist[0]["serverVars"])
+	assert.Nil(t, whitelist[0]["isPublic"])
+}
+
+func TestConfiguredServerVars(t *testing.T) {
+	result := build(map[string]string{
+		"/app.js": `
+		const filter = window.f ? sql.p` + "`AND plan = %{TENANT.plan}`" + ` : sql.p` + "`AND trace = %{REQUEST.trace_id}`" + `;
+		fs.executeQuery(sql` + "`SELECT * FROM orders WHERE user_id = %{SESSION.user_id} AND tenant_id = %{TENANT.id} AND region = %{ENV.region} ${filter}`" + `);
+		fs.executeQuery(sql` + "`SELECT * FROM plans WHERE tenant_id = %{TENANT.id} AND id = %{TENANT.id}`" + `);
+		`,
+	}, withServerVars)
+
+	assert.Empty(t, result.Errors)
+	whitelist := getClientWhitelist(&result)
+	assert.Len(t, whitelist, 2)
+	assert.Equal(t, "SELECT * FROM orders WHERE user_id = ${SESSION.user_id} AND tenant_id = ${TENANT.id} AND region = ${ENV.region} %{}", whitelist[0]["query"])
+	assert.Equal(t, []interface{}{"SESSION.user_id", "TENANT.id", "ENV.region", "TENANT.plan", "REQUEST.trace_id"}, whitelist[0]["serverVars"])
+	assert.Equal(t, []interface{}{"TENANT.id"}, whitelist[1]["serverVars"])
+}
+
+func TestUnknownServerVarKey(t *testing.T) {
+	result := build(map[string]string{
+		"/app.js": `
+		fs.executeQuery(sql` + "`SELECT * FROM orders WHERE user_id = %{SESSION.usrId}`" + `);
+		fs.executeQuery(sql` + "`SELECT * FROM orders WHERE tenant_id = %{TENANT.name}`" + `);
+		fs.executeQuery(sql` + "`SELECT * FROM orders WHERE trace = %{REQUEST.anything}`" + `);
+		`,
+	}, withServerVars)
+
+	assert.Equal(t, []string{
+		"unknown server variable SESSION.usrId: SESSION declares user_id, roles",
+		"unknown server variable TENANT.name: TENANT declares id, plan",
+	}, errorTexts(result.Errors))
+}
+
+func TestUnknownServerVarNamespace(t *testing.T) {
+	result := build(map[string]string{
+		"/app.js": `
+		fs.executeQuery(sql` + "`SELECT * FROM orders WHERE user_id = %{SESSON.user_id}`" + `);
+		fs.executeQuery(sql` + "`SELECT * FROM orders WHERE tenant_id = %{TENANT.id}`" + `);
+		`,
+	}, nil)
+
+	// A typo in the namespace doesn't make the query public with a param supplied by the client
+	assert.Equal(t, []string{
+		"unknown server variable namespace SESSON in %{SESSON.user_id}: the namespaces are ENV, SESSION",
+		"unknown server variable namespace TENANT in %{TENANT.id}: the namespaces are ENV, SESSION",
+	}, errorTexts(result.Errors))
+}
+
+func TestInvalidServerVarNamespace(t *testing.T) {
+	_, err := api.NewSQLJoyOptions([]byte(`{"serverVars": {"TENANT.": ["id"]}}`), nil, "build")
+	assert.EqualError(t, err, `Invalid serverVars namespace: "TENANT."`)
+}
@@ -0,0 +1,100 @@
+package integration_tests
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/evanw/esbuild/internal/js_parser"
+	"github.com/evanw/esbuild/internal/logger"
+	"github.com/evanw/esbuild/internal/sourcemap"
+	"github.com/evanw/esbuild/pkg/api"
+	"github.com/stretchr/testify/assert"
+)
+
+// findOriginal returns the original location of the first mapping on the line of the bundle containing text
+func findOriginal(t *testing.T, bundle string, sm *sourcemap.SourceMap, text string) (string, int32, int32) {
+	index := strings.Index(bundle, text)
+	if !assert.NotEqual(t, -1, index, text) {
+		return "", -1, -1
+	}
+	line := int32(strings.Count(bundle[:index], "\n"))
+	for _, mapping := range sm.Mappings {
+		if mapping.GeneratedLine == line {
+			return sm.Sources[mapping.SourceIndex], mapping.OriginalLine, mapping.OriginalColumn
+		}
+	}
+	t.Errorf("no mapping for %q", text)
+	return "", -1, -1
+}
+
+func TestServerEntrySourceMap(t *testing.T) {
+	const actions = `
+export async function save(ctx, id: string) {
+	return id;
+}
+
+export function checkOrder(e, s) {
+}
+`
+	const app = `import {save, checkOrder} from "./actions";
+
+window.doStuff = async function(a) {
+	await save(window.fs.beginTx(), a);
+	fs.executeQuery(sql` + "`select * from orders where id = ${a}`" + `, {}, checkOrder);
+};
+`
+
+	result := build(map[string]string{
+		"/src/actions.ts": actions,
+		"/src/app.ts":     app,
+	}, nil, "/src/app.ts")
+
+	assert.Empty(t, result.Errors)
+
+	server := string(getOutFile(&result, "server.bundle.js"))
+	assert.Contains(t, server, "//# sourceMappingURL=server.bundle.js.map")
+	assert.Contains(t, string(getOutFile(&result, "client.bundle.js")), "//# sourceMappingURL=client.bundle.js.map")
+
+	contents := string(getOutFile(&result, "server.bundle.js.map"))
+	assert.NotContains(t, contents, "<stdin>")
+	sm := js_parser.ParseSourceMap(logger.NewDeferLog(), logger.Source{Contents: contents})
+	if !assert.NotNil(t, sm) {
+		return
+	}
+
+	// The functions map and the argument validators map to the server function declaration
+	source, line, column := findOriginal(t, server, sm, `": save`)
+	assert.Equal(t, "src/actions.ts", source)
+	assert.Equal(t, int32(1), line)
+	assert.Equal(t, int32(22), column)
+
+	source, line, column = findOriginal(t, server, sm, `_invalidArg("id", "string")`)
+	assert.Equal(t, "src/actions.ts", source)
+	assert.Equal(t, int32(1), line)
+	assert.Equal(t, int32(36), column)
+
+	// The query validators map to the validator declaration
+	source, line, _ = findOriginal(t, server, sm, `checkOrder(e, s);`)
+	assert.Equal(t, "src/actions.ts", source)
+	assert.Equal(t, int32(5), line)
+}
+
+func TestNoSourceMaps(t *testing.T) {
+	result := build(map[string]string{
+		"/app.js": `
+		export async function save(ctx, id) {
+			return id;
+		}
+		window.doStuff = async (a) => save(window.fs.beginTx(), a);
+		`,
+	}, func(opts *api.SQLJoyOptions) {
+		opts.Client.Sourcemap = api.SourceMapNone
+		opts.Server.Sourcemap = api.SourceMapNone
+	})
+
+	assert.Empty(t, result.Errors)
+	for _, file := range result.OutputFiles {
+		assert.False(t, strings.HasSuffix(file.Path, ".map"), file.Path)
+	}
+	assert.NotContains(t, string(getOutFile(&result, "server.bundle.js")), "sourceMappingURL")
+}
@@ -0,0 +1,100 @@
+package integration_tests
+
+import (
+	"testing"
+
+	"github.com/evanw/esbuild/pkg/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSyntaxError(t *testing.T) {
+	result := build(map[string]string{
+		"/app.js": "const query = sql`SELECT * FORM users WHERE id = ${window.id}`;\nfs.executeQuery(query);\n",
+	}, nil)
+
+	assert.Len(t, result.Errors, 1)
+	assert.Equal(t, `invalid SQL: syntax error at or near "FORM"`, result.Errors[0].Text)
+	assert.Equal(t, "app.js", result.Errors[0].Location.File)
+	assert.Equal(t, 1, result.Errors[0].Location.Line)
+	assert.Equal(t, 14, result.Errors[0].Location.Column)
+
+	// The note points at the error in the compiled query
+	assert.Len(t, result.Errors[0].Notes, 1)
+	note := result.Errors[0].Notes[0].Location
+	assert.Equal(t, "SELECT * FORM users WHERE id = $1", note.LineText)
+	assert.Equal(t, 9, note.Column)
+	assert.Equal(t, 4, note.Length)
+}
+
+func TestSyntaxErrorInInlinedFragment(t *testing.T) {
+	result := build(map[string]string{
+		"/app.js": "const part = sql.p`id = = 1`;\nconst query = sql`select * from users where ${part}`;\nfs.executeQuery(query);\n",
+	}, nil)
+
+	assert.Len(t, result.Errors, 1)
+	assert.Equal(t, `invalid SQL: syntax error at or near "="`, result.Errors[0].Text)
+	assert.Equal(t, 2, result.Errors[0].Location.Line)
+	assert.Equal(t, "select * from users where id = = 1", result.Errors[0].Notes[0].Location.LineText)
+}
+
+func TestSyntaxErrorAtEnd(t *testing.T) {
+	result := build(map[string]string{
+		"/app.js": "fs.executeQuery(sql`update users set name = ${window.name} where`);\n",
+	}, nil)
+
+	assert.Len(t, result.Errors, 1)
+	assert.Equal(t, "invalid SQL: syntax error at end of input", result.Errors[0].Text)
+}
+
+func TestSyntaxPlaceholders(t *testing.T) {
+	result := build(map[string]string{
+		"/app.js": `
+		const role = sql.p` + "`role = ANY(%{SESSION.roles}::text[])`" + `;
+		const dir = window.asc ? sql.p` + "`ASC`" + ` : sql.p` + "`DESC`" + `;
+		const query = sql` + "`SELECT * FROM orders AS o WHERE o.user_id = %{SESSION.user_id} AND ${role} AND o.total > %{minTotal} ORDER BY o.created_at ${dir}`" + `;
+		fs.executeQuery(query, {minTotal: 10});
+		fs.executeQuery(sql` + "`SELECT * FROM users ORDER BY name ${sql.p(window.dir)}`" + `);
+		`,
+	}, nil)
+
+	assert.Empty(t, result.Errors)
+	whitelist := getClientWhitelist(&result)
+	assert.Len(t, whitelist, 2)
+	assert.Equal(t, "SELECT * FROM users ORDER BY name $1", whitelist[0]["query"])
+	assert.Equal(t, "SELECT * FROM orders AS o WHERE o.user_id = ${SESSION.user_id} AND role = ANY(${SESSION.roles}::text[]) AND o.total > $1 ORDER BY o.created_at %{}", whitelist[1]["query"])
+}
+
+func TestSyntaxOtherStatements(t *testing.T) {
+	result := build(map[string]string{
+		"/app.js": "fs.executeQuery(sql`-- only a comment`);\nfs.executeQuery(sql`ALTER TABLE distributors RENAME COLUMN address TO city`);\nfs.executeQuery(sql`select 1; select 2`);\n",
+	}, nil)
+
+	assert.Empty(t, result.Errors)
+}
+
+func TestSyntaxDialectNone(t *testing.T) {
+	result := build(map[string]string{
+		"/app.js": "fs.executeQuery(sql`SELECT * FORM users`);\n",
+	}, func(opts *api.SQLJoyOptions) {
+		opts.SQLDialect = api.SQLDialectNone
+	})
+
+	assert.Empty(t, result.Errors)
+}
+
+func TestSyntaxValidPostgres(t *testing.T) {
+	result := build(map[string]string{
+		"/app.js": `
+		fs.executeQuery(sql` + "`SELECT x.a, x.b FROM json_to_recordset(${window.rows}) AS x(a int, b text)`" + `);
+		fs.executeQuery(sql` + "`SELECT id FROM users WHERE name LIKE ANY (ARRAY['a%'])`" + `);
+		fs.executeQuery(sql` + "`SELECT 'a'\n'b'`" + `);
+		fs.executeQuery(sql` + "`SELECT ${window.doc} IS JSON OBJECT, ${window.name} IS NOT NFC NORMALIZED`" + `);
+		fs.executeQuery(sql` + "`SELECT 1 OPERATOR(pg_catalog.+) 2`" + `);
+		fs.executeQuery(sql` + "`SELECT x.a FROM ROWS FROM (json_to_record(${window.a}) AS (a int), generate_series(1, 2)) AS x`" + `);
+		fs.executeQuery(sql` + "`SELECT xmlelement(name foo, xmlattributes(${window.bar} AS bar))`" + `);
+		`,
+	}, nil)
+
+	assert.Empty(t, result.Errors)
+	assert.Empty(t, result.Warnings)
+}
@@ -0,0 +1,83 @@
+package integration_tests
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/evanw/esbuild/pkg/api"
+	"github.com/stretchr/testify/assert"
+)
+
+// buildWithStderr runs the build and returns what it logged to stderr
+func buildWithStderr(code map[string]string, modifyOpts func(opts *api.SQLJoyOptions), entryPoints ...string) (api.BuildResult, string) {
+	stderr := os.Stderr
+	r, w, err := os.Pipe()
+	if err != nil {
+		panic(err)
+	}
+	os.Stderr = w
+	output := make(chan string)
+	go func() {
+		var buf bytes.Buffer
+		io.Copy(&buf, r)
+		output <- buf.String()
+	}()
+
+	result := build(code, modifyOpts, entryPoints...)
+
+	os.Stderr = stderr
+	w.Close()
+	return result, <-output
+}
+
+const tracedProg = `
+import {getUser} from "./queries";
+
+export async function rename(ctx, id) {
+	await ctx.executeQuery(sql` + "`update users set name = 'x' where id = ${id}`" + `);
+}
+
+window.doStuff = async function(id) {
+	await fs.executeQuery(getUser, {id});
+	await rename(fs.beginTx(), id);
+};
+`
+
+func TestVerboseTracing(t *testing.T) {
+	result, stderr := buildWithStderr(map[string]string{
+		"/app.js":     tracedProg,
+		"/queries.js": "export const getUser = sql`select * from users where id = %{id}`;",
+	}, func(opts *api.SQLJoyOptions) {
+		opts.Verbose = true
+	}, "/app.js")
+
+	assert.Empty(t, result.Errors)
+	assert.Contains(t, stderr, "[query] found query getUser (queries.js)")
+	assert.Contains(t, stderr, "[resolve] resolved getUser (app.js) to getUser (queries.js)")
+	assert.Contains(t, stderr, "[server-call] found server call")
+	assert.Contains(t, stderr, "[server-call] rewrote server call to serverCall")
+	assert.Contains(t, stderr, "app.js:10:7")
+}
+
+func TestNoTracingByDefault(t *testing.T) {
+	result, stderr := buildWithStderr(map[string]string{
+		"/app.js":     tracedProg,
+		"/queries.js": "export const getUser = sql`select * from users where id = %{id}`;",
+	}, nil, "/app.js")
+
+	assert.Empty(t, result.Errors)
+	assert.NotContains(t, stderr, "[query]")
+	assert.NotContains(t, stderr, "[server-call]")
+}
+
+func TestVerboseConfig(t *testing.T) {
+	opts, err := api.NewSQLJoyOptions([]byte(`{"verbose": true, "client": {"entryPoints": ["app.js"]}}`), nil, "build")
+	assert.NoError(t, err)
+	assert.True(t, opts.Verbose)
+
+	opts, err = api.NewSQLJoyOptions([]byte(`{"logLevel": "debug", "client": {"entryPoints": ["app.js"]}}`), nil, "build")
+	assert.NoError(t, err)
+	assert.Equal(t, api.LogLevelDebug, opts.Client.LogLevel)
+}
@@ -0,0 +1,136 @@
+package integration_tests
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/evanw/esbuild/pkg/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func withTypes(opts *api.SQLJoyOptions) {
+	opts.TypeScript = true
+}
+
+func TestTypesNotEnabled(t *testing.T) {
+	result := build(map[string]string{
+		"/app.js": "fs.executeQuery(sql`SELECT 1`);\n",
+	}, nil)
+
+	assert.Empty(t, result.Errors)
+	assert.Nil(t, getOutFile(&result, "client-queries.d.ts"))
+}
+
+func TestTypesWithoutSchema(t *testing.T) {
+	result := build(map[string]string{
+		"/app.js": `
+		const query = sql` + "`SELECT * FROM orders WHERE user_id = %{SESSION.user_id} AND total > %{minTotal} AND id = ${window.id}`" + `;
+		fs.executeQuery(query, {minTotal: 10});
+		`,
+	}, withTypes)
+
+	assert.Empty(t, result.Errors)
+	whitelist := getClientWhitelist(&result)
+	assert.Len(t, whitelist, 1)
+
+	types := string(getOutFile(&result, "client-queries.d.ts"))
+	assert.Contains(t, types, `"`+whitelist[0]["id"].(string)+`": {
+		lateBound: {minTotal: unknown};
+		row: Record<string, unknown>;
+	};`)
+	assert.Contains(t, types, "executeQuery<Id extends keyof FlowStateQueries>(")
+}
+
+func TestTypesWithSchema(t *testing.T) {
+	result := build(map[string]string{
+		"/app.js": `
+		const filter = window.f ? sql.p` + "`AND total > %{minTotal}`" + ` : sql.p` + "`AND true`" + `;
+		fs.executeQuery(sql` + "`SELECT o.id, o.total, u.display_name FROM app.orders o JOIN users u ON u.id = o.user_id WHERE u.email = %{email} ${filter}`" + `, {email: "a@b.c"});
+		fs.executeQuery(sql` + "`UPDATE users SET name = %{name} WHERE id = ANY(%{ids}) RETURNING id, created_at`" + `, {name: "a", ids: [1]});
+		fs.executeQuery(sql` + "`DELETE FROM users WHERE id = ${window.id}`" + `);
+		`,
+		"/schema.sql": testSchemaSQL,
+	}, func(opts *api.SQLJoyOptions) {
+		opts.Schema = "schema.sql"
+		opts.TypeScript = true
+	}, "/app.js")
+
+	assert.Empty(t, result.Errors)
+	types := string(getOutFile(&result, "client-queries.d.ts"))
+	assert.Contains(t, types, "lateBound: {email: string; minTotal?: unknown};\n\t\trow: {id: number; total: string | null; display_name: string | null};")
+	assert.Contains(t, types, "lateBound: {name: string; ids: number[]};\n\t\trow: {id: number; created_at: string | null};")
+	assert.Contains(t, types, "lateBound: {};\n\t\trow: {};")
+}
+
+func TestTypesMissingParam(t *testing.T) {
+	result := build(map[string]string{
+		"/app.js": `
+		const query = sql` + "`SELECT * FROM orders WHERE total > %{minTotal} AND status = %{status}`" + `;
+		fs.executeQuery(query, {minTotal: 10});
+		fs.executeQuery(query);
+		fs.executeQuery(query, {...window.params});
+		fs.executeQuery(query, {minTotal: null, status: "paid"});
+		`,
+	}, withTypes)
+
+	assert.Equal(t, []string{
+		`missing param "status" for %{status} in the query passed to executeQuery`,
+		`missing param "minTotal" for %{minTotal} in the query passed to executeQuery`,
+		`missing param "status" for %{status} in the query passed to executeQuery`,
+	}, errorTexts(result.Errors))
+	assert.Equal(t, 3, result.Errors[0].Location.Line)
+	assert.Equal(t, 4, result.Errors[1].Location.Line)
+}
+
+func TestMissingParamWithoutTypes(t *testing.T) {
+	result := build(map[string]string{
+		"/app.js": "fs.executeQuery(sql`SELECT * FROM orders WHERE total > %{minTotal}`);\n",
+	}, nil)
+
+	// The params are only checked with the types, they may be added by code the compiler can't see
+	assert.Empty(t, result.Errors)
+}
+
+// TestTypesCheckedByTypeScript runs tsc on the types file, with a query annotated with its hash
+func TestTypesCheckedByTypeScript(t *testing.T) {
+	tsc, err := exec.LookPath("tsc")
+	if err != nil {
+		t.Skip("tsc is not installed")
+	}
+
+	result := build(map[string]string{
+		"/app.js": "fs.executeQuery(sql`SELECT * FROM orders WHERE total > %{minTotal}`, {minTotal: 10});\n",
+	}, withTypes)
+	assert.Empty(t, result.Errors)
+	id := getClientWhitelist(&result)[0]["id"].(string)
+
+	dir, err := ioutil.TempDir("", "sjc-types")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "client-queries.d.ts"), getOutFile(&result, "client-queries.d.ts"), 0644))
+
+	check := func(call string) (string, error) {
+		fixture := "declare const fs: FlowState;\ndeclare function sql(strings: TemplateStringsArray, ...values: unknown[]): any;\n" +
+			"const query: FlowStateQuery<" + strconv.Quote(id) + "> = sql`SELECT * FROM orders WHERE total > %{minTotal}`;\n" + call + "\n"
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "app.ts"), []byte(fixture), 0644))
+		out, err := exec.Command(tsc, "--noEmit", "--strict", "--target", "es2017",
+			filepath.Join(dir, "client-queries.d.ts"), filepath.Join(dir, "app.ts")).CombinedOutput()
+		return string(out), err
+	}
+
+	out, err := check("fs.executeQuery(query, {minTotal: 10});")
+	assert.NoError(t, err, out)
+
+	out, err = check("fs.executeQuery(query, {});")
+	assert.Error(t, err)
+	assert.Contains(t, out, "minTotal")
+
+	out, err = check("fs.executeQuery(query);")
+	assert.Error(t, err, out)
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"os"
+	"path"
 	"runtime/debug"
 	"strings"
 	"time"
@@ -26,11 +27,26 @@ func main() {
 		configFile = "fsconfig.debug.json"
 	}
 
+	previousBuild := ""
+	allowMadePublic := false
+	verbose := false
+	production := false
+	report := false
 	for i := range args {
 		arg := args[i]
 		switch {
 		case strings.HasPrefix(arg, "--config="):
 			configFile = arg[len("--config="):]
+		case strings.HasPrefix(arg, "--previous="):
+			previousBuild = arg[len("--previous="):]
+		case arg == "--allow-made-public":
+			allowMadePublic = true
+		case arg == "--verbose":
+			verbose = true
+		case arg == "--production":
+			production = true
+		case arg == "--report":
+			report = true
 		default:
 			fmt.Printf("unknown argument %q for %s: \n", arg, cmd)
 			os.Exit(1)
@@ -43,8 +59,31 @@ func main() {
 		os.Exit(1)
 	}
 
+	if previousBuild != "" {
+		opts.PreviousBuild = previousBuild
+	}
+	if allowMadePublic {
+		opts.AllowMadePublic = true
+	}
+	if verbose {
+		opts.Verbose = true
+	}
+	if production {
+		opts.Production = true
+	}
+	if report {
+		opts.Report = true
+	}
+
 	switch cmd {
 	case "build":
+	case "diff":
+		if opts.PreviousBuild == "" {
+			fmt.Println("diff requires --previous=<dir> or previousBuild in the config")
+			os.Exit(1)
+		}
+		// The report is printed to stdout, keep it machine-readable
+		opts.NoSummary = true
 	case "deploy":
 	case "watch":
 		opts.Watch = true
@@ -65,16 +104,34 @@ func run(opts *api.SQLJoyOptions, cmd string) {
 	start := time.Now()
 
 	result := api.BuildFlowState(opts)
-	if len(result.Errors) != 0 {
+	if len(result.Errors) != 0 && !opts.Watch {
 		os.Exit(1)
 	}
 
-	// Do not exit if we're in watch mode
-	if opts.Watch {
-		<-make(chan bool)
-	}
-
 	if !opts.NoSummary {
 		api.PrintSummary(logger.OutputOptions{}, result.OutputFiles, start)
 	}
+
+	if cmd == "diff" {
+		for _, file := range result.OutputFiles {
+			if path.Base(file.Path) == "queries-diff.json" {
+				os.Stdout.Write(file.Contents)
+				fmt.Println()
+			}
+		}
+	}
+
+	if cmd == "deploy" {
+		deployed, err := api.DeployFlowState(opts, result)
+		if err != nil {
+			fmt.Printf("deploy error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("deployed version %s\n", deployed.Version)
+	}
+
+	// Do not exit if we're in watch mode, the watcher rebuilds in the background
+	if opts.Watch {
+		<-make(chan bool)
+	}
 }